@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/NicoNex/echotron"
+	"golang.org/x/time/rate"
+	"html"
+	"log"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const broadcastCallbackPrefix = "broadcast "
+
+// Telegram chat ids where the admin commands can be used, comma separated. The id of a private chat
+// is the one of its user, while a group id lets every member of the group use all the admin commands,
+// /reload and /broadcast with its confirmation button included
+var botAdmins = parseBotAdmins(getEnv("CovidBotAdmins", ""))
+
+// Messages sent per second by a broadcast, lower than the global limit to leave room for the other requests
+var broadcastPerSecond = getEnvInt("CovidBotBroadcastPerSecond", 10)
+
+var startTime = time.Now()
+
+var adminMutex = &sync.Mutex{}
+var reloadRunning bool    // A data update requested with the reload command is running
+var broadcastRunning bool // A broadcast is being sent
+
+// Returns the set of chat ids in the comma separated list
+func parseBotAdmins(list string) map[int64]bool {
+	admins := make(map[int64]bool)
+	for _, v := range strings.Split(list, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			log.Println("invalid admin chat id " + v + " in CovidBotAdmins")
+			continue
+		}
+		admins[id] = true
+	}
+	return admins
+}
+
+// Checks if the chat is one of the admin chats of the bot. The id of a private chat is the one of its user
+func isBotAdmin(chat *echotron.Chat) bool {
+	return chat != nil && botAdmins[chat.ID]
+}
+
+// Sets a running flag of the admin commands, returns false if it was already set
+func startAdminJob(running *bool) bool {
+	adminMutex.Lock()
+	defer adminMutex.Unlock()
+
+	if *running {
+		return false
+	}
+	*running = true
+	return true
+}
+
+// Clears a running flag of the admin commands
+func endAdminJob(running *bool) {
+	adminMutex.Lock()
+	*running = false
+	adminMutex.Unlock()
+}
+
+// Handles "reload" textual command, reserved to the bot administrators
+func (b *bot) textReload(update *echotron.Update) {
+	chatId := update.Message.Chat.ID
+	if !isBotAdmin(update.Message.Chat) {
+		b.SendMessage("Comando riservato agli amministratori del bot.", chatId)
+		return
+	}
+	if !startAdminJob(&reloadRunning) {
+		b.SendMessage("Un aggiornamento dei dati è già in corso.", chatId)
+		return
+	}
+
+	b.SendMessage("🔄 Aggiornamento dei dati avviato.", chatId)
+	go func() {
+		defer endAdminJob(&reloadRunning)
+		updateData(shutdownStarted)
+
+		healthMutex.RLock()
+		err := lastUpdateError
+		healthMutex.RUnlock()
+		if err != nil {
+			b.SendMessage("❌ Aggiornamento fallito, restano i dati precedenti:\n"+err.Error(), chatId)
+			return
+		}
+		d := getData()
+		date := formatDataDate(d.nation[len(d.nation)-1].Data)
+		b.SendMessage("✅ Dati aggiornati, ultimo bollettino del "+date+".", chatId)
+	}()
+}
+
+// Returns the date of a pcm-dpc record as day/month/year
+func formatDataDate(date string) string {
+	t, err := time.Parse("2006-01-02T15:04:05", date)
+	if err != nil {
+		return date
+	}
+	return t.Format("02/01/2006")
+}
+
+// Returns a size in megabytes with one decimal
+func formatMegabytes(size uint64) string {
+	return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+}
+
+// Returns a duration as days, hours and minutes
+func formatUptime(d time.Duration) string {
+	minutes := int(d.Minutes())
+	return fmt.Sprintf("%dg %dh %dm", minutes/(24*60), minutes/60%24, minutes%60)
+}
+
+// Returns the status of the bot: data, last update, uptime, plot cache and memory
+func getBotStatusText(d *dataSnapshot) string {
+	var text strings.Builder
+	text.WriteString("🛠 <b>Stato del bot</b>\n\n")
+
+	if len(d.nation) > 0 {
+		text.WriteString("Dati del " + formatDataDate(d.nation[len(d.nation)-1].Data) + " (versione <code>" + d.versionKey + "</code>)\n")
+	} else {
+		text.WriteString("Dati non ancora caricati\n")
+	}
+
+	healthMutex.RLock()
+	if !lastUpdateSuccess.IsZero() {
+		text.WriteString("Ultimo aggiornamento: " + lastUpdateSuccess.In(romeLocation()).Format("02/01/2006 15:04") + "\n")
+	}
+	if lastUpdateError != nil {
+		text.WriteString("⚠️ Ultimo tentativo fallito il " + lastUpdateAttempt.In(romeLocation()).Format("02/01/2006 15:04") + ": " + html.EscapeString(lastUpdateError.Error()) + "\n")
+	}
+	healthMutex.RUnlock()
+	text.WriteString("Attivo da: " + formatUptime(time.Since(startTime)) + "\n\n")
+
+	files, size, fileIds := getPlotCacheStats()
+	text.WriteString("Grafici in cache: " + strconv.Itoa(files) + " (" + formatMegabytes(uint64(size)) + "), già caricati su Telegram: " + strconv.Itoa(fileIds) + "\n")
+
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+	text.WriteString("Memoria: " + formatMegabytes(memory.HeapAlloc) + " in uso, " + formatMegabytes(memory.Sys) + " dal sistema\n")
+	text.WriteString("Goroutine: " + strconv.Itoa(runtime.NumGoroutine()) + "\n")
+	text.WriteString("Chat conosciute: " + strconv.Itoa(len(getKnownChats())))
+	return text.String()
+}
+
+// Handles "status" textual command, reserved to the bot administrators
+func (b *bot) textStatus(d *dataSnapshot, update *echotron.Update) {
+	chatId := update.Message.Chat.ID
+	if !isBotAdmin(update.Message.Chat) {
+		b.SendMessage("Comando riservato agli amministratori del bot.", chatId)
+		return
+	}
+	b.SendMessage(getBotStatusText(d), chatId, echotron.PARSE_HTML)
+}
+
+// Handles "broadcast" textual command, reserved to the bot administrators.
+// The message is first sent only to the administrator as a preview, and is sent to every known chat after confirmation
+func (b *bot) textBroadcast(update *echotron.Update) {
+	chatId := update.Message.Chat.ID
+	if !isBotAdmin(update.Message.Chat) {
+		b.SendMessage("Comando riservato agli amministratori del bot.", chatId)
+		return
+	}
+
+	text := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, strings.Split(update.Message.Text, " ")[0]))
+	if text == "" {
+		b.SendMessage("Scrivi il messaggio dopo il comando, ad esempio:\n/broadcast <code>Il bot sarà in manutenzione stasera alle 22.</code>\nPuoi usare la formattazione HTML di Telegram.", chatId, echotron.PARSE_HTML)
+		return
+	}
+
+	// The preview is the message exactly as recipients will receive it, and checks that its formatting is valid
+	response := b.SendMessage(text, chatId, echotron.PARSE_HTML)
+	if !response.Ok {
+		b.SendMessage("Messaggio non valido: "+response.Description, chatId)
+		return
+	}
+
+	b.broadcastDraft = text
+	recipients := strconv.Itoa(len(getKnownChats()))
+	buttons, err := b.makeButtons([]string{"Invia a " + recipients + " chat", "Annulla"}, []string{broadcastCallbackPrefix + "invia", broadcastCallbackPrefix + "annulla"}, 2)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	b.SendMessageWithKeyboard("👆 Anteprima del messaggio, verrà inviato a "+recipients+" chat.", chatId, buttons)
+}
+
+// Recognizes the callbacks of the broadcast command
+func (b *bot) caseBroadcast(cq *echotron.CallbackQuery) error {
+	data := strings.ToLower(cq.Data)
+	if !strings.HasPrefix(data, broadcastCallbackPrefix) {
+		return fmt.Errorf("not a broadcast case")
+	}
+	chatId := cq.Message.Chat.ID
+	if !isBotAdmin(cq.Message.Chat) {
+		b.AnswerCallbackQuery(cq.ID, "Riservato agli amministratori del bot", true)
+		return nil
+	}
+
+	switch strings.TrimPrefix(data, broadcastCallbackPrefix) {
+	case "annulla":
+		b.broadcastDraft = ""
+		b.EditMessageText(chatId, cq.Message.ID, "Invio annullato.")
+		b.AnswerCallbackQuery(cq.ID, "Annullato", false)
+	case "invia":
+		if b.broadcastDraft == "" {
+			b.AnswerCallbackQuery(cq.ID, "Nessun messaggio da inviare", false)
+			return nil
+		}
+		if !startAdminJob(&broadcastRunning) {
+			b.AnswerCallbackQuery(cq.ID, "Un altro messaggio è in corso di invio", true)
+			return nil
+		}
+		text := b.broadcastDraft
+		b.broadcastDraft = ""
+		b.EditMessageText(chatId, cq.Message.ID, "📣 Invio in corso...")
+		b.AnswerCallbackQuery(cq.ID, "Invio avviato", false)
+		go func() {
+			defer endAdminJob(&broadcastRunning)
+			b.sendBroadcast(text, chatId)
+		}()
+	default:
+		return fmt.Errorf("not a broadcast case")
+	}
+	return nil
+}
+
+// Sends the message to every known chat and reports the outcome to the administrator.
+// Chats that blocked the bot or don't exist anymore are forgotten
+func (b *bot) sendBroadcast(text string, adminChatId int64) {
+	limiter := rate.NewLimiter(rate.Inf, 1)
+	if broadcastPerSecond > 0 {
+		limiter = rate.NewLimiter(rate.Limit(broadcastPerSecond), 1)
+	}
+
+	chats := getKnownChats()
+	sent, failed, removed := 0, 0, 0
+	log.Println("broadcasting to " + strconv.Itoa(len(chats)) + " chats")
+	for _, chatId := range chats {
+		err := limiter.Wait(context.Background())
+		if err != nil {
+			log.Println(err)
+			break
+		}
+
+		response := b.SendMessage(text, chatId, echotron.PARSE_HTML)
+		if response.Ok {
+			sent++
+			continue
+		}
+		failed++
+		if response.ErrorCode == 403 || (response.ErrorCode == 400 && strings.Contains(response.Description, "chat not found")) {
+			err = forgetChat(chatId)
+			if err != nil {
+				log.Println(err)
+			}
+			removed++
+		}
+	}
+
+	log.Println("broadcast sent to " + strconv.Itoa(sent) + " chats, " + strconv.Itoa(failed) + " failed")
+	msg := "📣 Messaggio inviato a " + strconv.Itoa(sent) + " chat su " + strconv.Itoa(len(chats)) + "."
+	if failed > 0 {
+		msg += "\nInvio fallito per " + strconv.Itoa(failed) + " chat"
+		if removed > 0 {
+			msg += ", di cui " + strconv.Itoa(removed) + " rimosse perché hanno bloccato il bot o non esistono più"
+		}
+		msg += "."
+	}
+	b.SendMessage(msg, adminChatId)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"github.com/NicoNex/echotron"
+	"strings"
+	"testing"
+)
+
+// Makes the given chats the admin chats of the bot for the duration of the test
+func setBotAdmins(t *testing.T, chats ...int64) {
+	previous := botAdmins
+	botAdmins = make(map[int64]bool)
+	for _, v := range chats {
+		botAdmins[v] = true
+	}
+	t.Cleanup(func() { botAdmins = previous })
+}
+
+// Waits for a request with the given method and text, failing the test if it isn't made
+func waitForRequest(t *testing.T, method, text string) apiCall {
+	t.Helper()
+	var request apiCall
+	waitFor(t, func() bool {
+		for _, v := range telegram.requests() {
+			if v.Method == method && strings.Contains(v.Text, text) {
+				request = v
+				return true
+			}
+		}
+		return false
+	}, method+" "+text)
+	return request
+}
+
+func TestParseBotAdmins(t *testing.T) {
+	admins := parseBotAdmins(" 1234, -1001234,abc,,")
+	if len(admins) != 2 || !admins[1234] || !admins[-1001234] {
+		t.Errorf("admins %v", admins)
+	}
+	if admins = parseBotAdmins(""); len(admins) != 0 {
+		t.Errorf("admins %v without configuration", admins)
+	}
+}
+
+func TestIsBotAdmin(t *testing.T) {
+	setBotAdmins(t, testGroupId)
+	for chat, admin := range map[*echotron.Chat]bool{
+		testChat(testGroupId): true,
+		testChat(testChatId):  false,
+		nil:                   false,
+	} {
+		if isBotAdmin(chat) != admin {
+			t.Errorf("chat %+v admin: %v, want %v", chat, !admin, admin)
+		}
+	}
+}
+
+func TestAdminCommandsRefused(t *testing.T) {
+	setBotAdmins(t, testGroupId)
+	b := newTestBot(testChatId)
+	for _, command := range []string{"/status", "/reload", "/broadcast Prova", "/stats"} {
+		calls := b.send(command)
+		expectMethods(t, calls, "sendMessage")
+		expectText(t, calls[0], "Comando riservato agli amministratori del bot.")
+	}
+
+	calls := b.press("broadcast invia")
+	expectMethods(t, calls, "answerCallbackQuery")
+	if calls[0].Params.Get("text") != "Riservato agli amministratori del bot" {
+		t.Errorf("answer %q, want the admin check", calls[0].Params.Get("text"))
+	}
+}
+
+func TestStatus(t *testing.T) {
+	// Every member of an admin group can use the admin commands
+	setBotAdmins(t, testGroupId)
+	b := newTestBot(testGroupId)
+	calls := b.sendAs(5678, "/status")
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "Stato del bot", "Dati del 05/03/2022 (versione <code>"+getData().versionKey+"</code>)", "Grafici in cache: ", "Chat conosciute: ")
+}
+
+func TestReload(t *testing.T) {
+	setBotAdmins(t, testChatId)
+	b := newTestBot(testChatId)
+	calls := b.send("/reload")
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "Aggiornamento dei dati avviato.")
+	waitForRequest(t, "sendMessage", "Dati aggiornati, ultimo bollettino del 05/03/2022.")
+}
+
+func TestBroadcast(t *testing.T) {
+	setBotAdmins(t, testChatId)
+	const blockedChatId = 5555
+	knownChatsMutex.Lock()
+	previous := knownChats
+	knownChats = map[int64]bool{testChatId: true, blockedChatId: true}
+	knownChatsMutex.Unlock()
+	defer func() {
+		knownChatsMutex.Lock()
+		knownChats = previous
+		knownChatsMutex.Unlock()
+	}()
+	telegram.blockChat(t, blockedChatId)
+
+	b := newTestBot(testChatId)
+	calls := b.send("/broadcast")
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "Scrivi il messaggio dopo il comando")
+
+	calls = b.send("/broadcast <b>Manutenzione</b> stasera")
+	expectMethods(t, calls, "sendMessage", "sendMessage")
+	expectText(t, calls[0], "<b>Manutenzione</b> stasera")
+	expectText(t, calls[1], "verrà inviato a 2 chat")
+	expectKeyboard(t, calls[1], "broadcast invia", "broadcast annulla")
+
+	calls = b.press("broadcast invia")
+	expectMethods(t, calls, "editMessageText", "answerCallbackQuery")
+	report := waitForRequest(t, "sendMessage", "Messaggio inviato a 1 chat su 2.")
+	expectText(t, report, "Invio fallito per 1 chat, di cui 1 rimosse")
+	if chats := getKnownChats(); len(chats) != 1 || chats[0] != testChatId {
+		t.Errorf("known chats %v, want the blocked chat removed", chats)
+	}
+
+	// The draft is sent once
+	waitFor(t, func() bool {
+		adminMutex.Lock()
+		defer adminMutex.Unlock()
+		return !broadcastRunning
+	}, "the end of the broadcast")
+	calls = b.press("broadcast invia")
+	expectMethods(t, calls, "answerCallbackQuery")
+	if calls[0].Params.Get("text") != "Nessun messaggio da inviare" {
+		t.Errorf("answer %q, want no draft", calls[0].Params.Get("text"))
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"fmt"
+	"github.com/DarkFighterLuke/covidgraphs"
+	"github.com/NicoNex/echotron"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	alertsFile        = "/alerts.json"
+	alertAreaNation   = "nazione"
+	alertAbove        = "sopra"
+	alertBelow        = "sotto"
+	alertRising       = "crescita"
+	alertFalling      = "calo"
+	alertSeriesLength = 15 // Days of data needed to evaluate any rule
+)
+
+var alertMetrics = []string{"incidenza", "nuovi_positivi", "terapia_intensiva", "ricoverati_con_sintomi", "deceduti"}
+
+var alertMetricsLabels = map[string]string{
+	"incidenza":              "Incidenza settimanale",
+	"nuovi_positivi":         "Nuovi positivi",
+	"terapia_intensiva":      "Terapia intensiva",
+	"ricoverati_con_sintomi": "Ricoverati con sintomi",
+	"deceduti":               "Deceduti giornalieri",
+}
+
+var alertConditions = []string{alertAbove, alertBelow, alertRising, alertFalling}
+
+var alertConditionsLabels = map[string]string{
+	alertAbove:   "Supera la soglia ⬆️",
+	alertBelow:   "Scende sotto la soglia ⬇️",
+	alertRising:  "In crescita 📈",
+	alertFalling: "In calo 📉",
+}
+
+// Thresholds proposed by the wizard for each metric
+var alertThresholds = map[string][]int{
+	"incidenza":              {50, 150, 250, 500},
+	"nuovi_positivi":         {500, 1000, 5000, 10000},
+	"terapia_intensiva":      {100, 500, 1000, 2000},
+	"ricoverati_con_sintomi": {1000, 5000, 10000, 20000},
+	"deceduti":               {10, 50, 100, 500},
+}
+
+var alertTrendDays = []int{2, 3, 5, 7}
+
+// Notification rule set by a chat
+type alertRule struct {
+	ID        int     `json:"id"`
+	ChatId    int64   `json:"chat_id"`
+	Area      string  `json:"area"` // "nazione" or a region name
+	Metric    string  `json:"metric"`
+	Condition string  `json:"condition"`
+	Value     float64 `json:"value"`  // Threshold, or consecutive days for trend conditions
+	Active    bool    `json:"active"` // Whether the condition held at the last evaluation
+}
+
+var alertRules = make([]alertRule, 0)
+var alertsMutex = &sync.Mutex{}
+
+// Loads alert rules from the bot data folder
+func loadAlerts() {
+	alertsMutex.Lock()
+	defer alertsMutex.Unlock()
+
+	err := loadJSON(alertsFile, &alertRules)
+	if err != nil {
+		log.Println("errore nel caricamento degli avvisi:", err)
+	}
+}
+
+// Saves a new rule assigning it an id
+func addAlert(rule alertRule) (alertRule, error) {
+	alertsMutex.Lock()
+	defer alertsMutex.Unlock()
+
+	for _, v := range alertRules {
+		if v.ID >= rule.ID {
+			rule.ID = v.ID + 1
+		}
+	}
+	alertRules = append(alertRules, rule)
+
+	return rule, saveJSON(alertsFile, alertRules)
+}
+
+// Deletes a rule of the given chat
+func deleteAlert(chatId int64, id int) error {
+	alertsMutex.Lock()
+	defer alertsMutex.Unlock()
+
+	for i, v := range alertRules {
+		if v.ID == id && v.ChatId == chatId {
+			alertRules = append(alertRules[:i], alertRules[i+1:]...)
+			return saveJSON(alertsFile, alertRules)
+		}
+	}
+
+	return fmt.Errorf("alert not found")
+}
+
+// Returns the rules of the given chat
+func getChatAlerts(chatId int64) []alertRule {
+	alertsMutex.Lock()
+	defer alertsMutex.Unlock()
+
+	rules := make([]alertRule, 0)
+	for _, v := range alertRules {
+		if v.ChatId == chatId {
+			rules = append(rules, v)
+		}
+	}
+
+	return rules
+}
+
+// Checks that the rule fields are valid and normalizes the area name
+func validateAlert(d *dataSnapshot, rule *alertRule) error {
+	if _, ok := alertMetricsLabels[rule.Metric]; !ok {
+		return fmt.Errorf("metrica non valida")
+	}
+	if _, ok := alertConditionsLabels[rule.Condition]; !ok {
+		return fmt.Errorf("condizione non valida")
+	}
+	if rule.Value < 0 || ((rule.Condition == alertRising || rule.Condition == alertFalling) && (rule.Value < 1 || rule.Value > alertSeriesLength-1)) {
+		return fmt.Errorf("valore non valido")
+	}
+
+	if strings.ToLower(rule.Area) == alertAreaNation {
+		rule.Area = alertAreaNation
+		return nil
+	}
+	regionIndex, err := covidgraphs.FindLastOccurrenceRegion(&d.regions, "denominazione_regione", rule.Area)
+	if err != nil {
+		return fmt.Errorf("regione non trovata")
+	}
+	rule.Area = d.regions[regionIndex].Denominazione_regione
+
+	return nil
+}
+
+// Returns the area name as shown to users
+func getAlertAreaLabel(area string) string {
+	if area == alertAreaNation {
+		return "Italia"
+	}
+	return area
+}
+
+// Formats a metric value
+func formatAlertValue(metric string, value float64) string {
+	if metric == "incidenza" {
+		return strconv.FormatFloat(value, 'f', 1, 64) + "/100k"
+	}
+	return strconv.FormatFloat(value, 'f', 0, 64)
+}
+
+// Returns a human readable description of the rule
+func (r alertRule) describe() string {
+	msg := getAlertAreaLabel(r.Area) + ", " + strings.ToLower(alertMetricsLabels[r.Metric]) + " "
+	switch r.Condition {
+	case alertAbove:
+		msg += "sopra " + formatAlertValue(r.Metric, r.Value)
+	case alertBelow:
+		msg += "sotto " + formatAlertValue(r.Metric, r.Value)
+	case alertRising:
+		msg += "in crescita per " + strconv.Itoa(int(r.Value)) + " giorni consecutivi"
+	case alertFalling:
+		msg += "in calo per " + strconv.Itoa(int(r.Value)) + " giorni consecutivi"
+	}
+
+	return msg
+}
+
+// Checks whether the rule condition holds for the given series
+func (r alertRule) isMet(series []float64) bool {
+	last := series[len(series)-1]
+	switch r.Condition {
+	case alertAbove:
+		return last > r.Value
+	case alertBelow:
+		return last < r.Value
+	case alertRising, alertFalling:
+		days := int(r.Value)
+		if len(series) < days+1 {
+			return false
+		}
+		for i := len(series) - days; i < len(series); i++ {
+			if r.Condition == alertRising && series[i] <= series[i-1] {
+				return false
+			} else if r.Condition == alertFalling && series[i] >= series[i-1] {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+// Returns the last values of a metric for the given area, oldest first
+func (d *dataSnapshot) getAlertSeries(area, metric string) ([]float64, error) {
+	var population int
+	rows := make([]covidgraphs.RegionData, 0)
+
+	if area == alertAreaNation {
+		for _, v := range regionsParameters {
+			population += v.population
+		}
+		start := len(d.nation) - alertSeriesLength - 7
+		if start < 0 {
+			start = 0
+		}
+		for _, v := range d.nation[start:] {
+			rows = append(rows, covidgraphs.RegionData{
+				Terapia_intensiva:      v.Terapia_intensiva,
+				Ricoverati_con_sintomi: v.Ricoverati_con_sintomi,
+				Nuovi_positivi:         v.Nuovi_positivi,
+				Deceduti:               v.Deceduti,
+				Totale_casi:            v.Totale_casi,
+			})
+		}
+	} else {
+		regionIndex, err := covidgraphs.FindLastOccurrenceRegion(&d.regions, "denominazione_regione", area)
+		if err != nil {
+			return nil, err
+		}
+		population = regionsParameters[d.regions[regionIndex].Codice_regione].population
+		for i := regionIndex; i >= 0 && len(rows) < alertSeriesLength+7; i -= 21 {
+			if d.regions[i].Codice_regione == d.regions[regionIndex].Codice_regione {
+				rows = append([]covidgraphs.RegionData{d.regions[i]}, rows...)
+			}
+		}
+	}
+
+	series := make([]float64, 0)
+	for i := 7; i < len(rows); i++ {
+		switch metric {
+		case "incidenza":
+			if population == 0 {
+				return nil, fmt.Errorf("missing population")
+			}
+			series = append(series, float64(rows[i].Totale_casi-rows[i-7].Totale_casi)*100000/float64(population))
+		case "nuovi_positivi":
+			series = append(series, float64(rows[i].Nuovi_positivi))
+		case "terapia_intensiva":
+			series = append(series, float64(rows[i].Terapia_intensiva))
+		case "ricoverati_con_sintomi":
+			series = append(series, float64(rows[i].Ricoverati_con_sintomi))
+		case "deceduti":
+			series = append(series, float64(rows[i].Deceduti-rows[i-1].Deceduti))
+		default:
+			return nil, fmt.Errorf("wrong metric name passed")
+		}
+	}
+	if len(series) == 0 {
+		return nil, fmt.Errorf("not enough data")
+	}
+
+	return series, nil
+}
+
+// Evaluates all the rules and notifies the chats whose rules have just been met
+func evaluateAlerts(d *dataSnapshot) {
+	if len(d.nation) == 0 || len(d.regions) == 0 {
+		return
+	}
+	data, err := time.Parse("2006-01-02T15:04:05", d.nation[len(d.nation)-1].Data)
+	if err != nil {
+		log.Println("error parsing data in evaluateAlerts()")
+	}
+
+	alertsMutex.Lock()
+	notifications := make([]alertRule, 0)
+	series := make(map[string][]float64)
+	for i, rule := range alertRules {
+		key := rule.Area + "/" + rule.Metric
+		if _, ok := series[key]; !ok {
+			series[key], err = d.getAlertSeries(rule.Area, rule.Metric)
+			if err != nil {
+				log.Println("errore nella valutazione dell'avviso:", err)
+			}
+		}
+		if series[key] == nil {
+			continue
+		}
+
+		met := rule.isMet(series[key])
+		if met && !rule.Active {
+			// Activated once the notification is delivered, so that a failed one is sent again with the next data
+			notifications = append(notifications, rule)
+			continue
+		}
+		alertRules[i].Active = met
+	}
+	err = saveJSON(alertsFile, alertRules)
+	if err != nil {
+		log.Println("errore nel salvataggio degli avvisi:", err)
+	}
+	alertsMutex.Unlock()
+
+	api := echotron.NewApi(TOKEN)
+	sent := 0
+	for _, rule := range notifications {
+		values := series[rule.Area+"/"+rule.Metric]
+		msg := "🔔 <b>Avviso " + data.Format("2006-01-02") + "</b>\n\n" + rule.describe() +
+			"\n<b>Valore attuale: </b>" + formatAlertValue(rule.Metric, values[len(values)-1]) +
+			"\n\n<i>Gestisci i tuoi avvisi con /avviso</i>"
+		response := api.SendMessage(msg, rule.ChatId, echotron.PARSE_HTML)
+		if !response.Ok {
+			log.Println("errore nell'invio dell'avviso:", response.Description)
+			continue
+		}
+		activateAlert(rule.ChatId, rule.ID)
+		sent++
+	}
+	log.Println("Alerts evaluated, notifications sent: " + strconv.Itoa(sent) + "/" + strconv.Itoa(len(notifications)))
+}
+
+// Marks the rule as active after its notification has been delivered, if it still exists
+func activateAlert(chatId int64, id int) {
+	alertsMutex.Lock()
+	defer alertsMutex.Unlock()
+
+	for i, v := range alertRules {
+		if v.ID == id && v.ChatId == chatId {
+			alertRules[i].Active = true
+			err := saveJSON(alertsFile, alertRules)
+			if err != nil {
+				log.Println("errore nel salvataggio degli avvisi:", err)
+			}
+			return
+		}
+	}
+}
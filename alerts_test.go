@@ -0,0 +1,178 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestAlertRuleIsMet(t *testing.T) {
+	series := []float64{10, 12, 11, 13, 15, 18}
+	for _, v := range []struct {
+		condition string
+		value     float64
+		want      bool
+	}{
+		{alertAbove, 17, true},
+		{alertAbove, 18, false},
+		{alertBelow, 20, true},
+		{alertBelow, 18, false},
+		{alertRising, 3, true},
+		{alertRising, 4, false},
+		{alertRising, 6, false},
+		{alertFalling, 1, false},
+	} {
+		rule := alertRule{Metric: "nuovi_positivi", Condition: v.condition, Value: v.value}
+		if got := rule.isMet(series); got != v.want {
+			t.Errorf("%s %v: met %v, want %v", v.condition, v.value, got, v.want)
+		}
+	}
+
+	rule := alertRule{Metric: "nuovi_positivi", Condition: alertFalling, Value: 2}
+	if !rule.isMet([]float64{30, 20, 10}) {
+		t.Error("falling series not detected")
+	}
+}
+
+func TestValidateAlert(t *testing.T) {
+	d := getData()
+	rule := alertRule{Area: "puglia", Metric: "incidenza", Condition: alertAbove, Value: 150}
+	if err := validateAlert(d, &rule); err != nil || rule.Area != "Puglia" {
+		t.Errorf("area %q, error %v: want the region name normalized", rule.Area, err)
+	}
+	rule = alertRule{Area: "Nazione", Metric: "deceduti", Condition: alertRising, Value: 3}
+	if err := validateAlert(d, &rule); err != nil || rule.Area != alertAreaNation {
+		t.Errorf("area %q, error %v: want the nation", rule.Area, err)
+	}
+
+	for _, rule := range []alertRule{
+		{Area: "nazione", Metric: "positivi", Condition: alertAbove, Value: 1},
+		{Area: "nazione", Metric: "deceduti", Condition: "uguale", Value: 1},
+		{Area: "nazione", Metric: "deceduti", Condition: alertAbove, Value: -1},
+		{Area: "nazione", Metric: "deceduti", Condition: alertFalling, Value: alertSeriesLength},
+		{Area: "atlantide", Metric: "deceduti", Condition: alertAbove, Value: 1},
+	} {
+		if err := validateAlert(d, &rule); err == nil {
+			t.Errorf("invalid rule %+v accepted", rule)
+		}
+	}
+}
+
+func TestGetAlertSeries(t *testing.T) {
+	d := getData()
+	series, err := d.getAlertSeries(alertAreaNation, "terapia_intensiva")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := series[len(series)-1]; last != float64(d.nation[len(d.nation)-1].Terapia_intensiva) {
+		t.Errorf("last value %v, want %d", last, d.nation[len(d.nation)-1].Terapia_intensiva)
+	}
+	if _, err = d.getAlertSeries("Puglia", "incidenza"); err != nil {
+		t.Error(err)
+	}
+	if _, err = d.getAlertSeries("Puglia", "positivi"); err == nil {
+		t.Error("series of an unknown metric returned")
+	}
+}
+
+func TestEvaluateAlerts(t *testing.T) {
+	const alertChatId = 6543
+	d := getData()
+	rule, err := addAlert(alertRule{ChatId: alertChatId, Area: alertAreaNation, Metric: "terapia_intensiva", Condition: alertAbove, Value: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer deleteAlert(alertChatId, rule.ID)
+	notifications := func() int {
+		n := 0
+		for _, v := range telegram.requests() {
+			if v.Method == "sendMessage" && v.ChatId == alertChatId {
+				expectText(t, v, "Avviso 2022-03-05", "Italia, terapia intensiva sopra 100", "Valore attuale: </b>617")
+				n++
+			}
+		}
+		return n
+	}
+
+	// A notification that can't be delivered is sent again with the next data
+	t.Run("undelivered", func(t *testing.T) {
+		telegram.blockChat(t, alertChatId)
+		evaluateAlerts(d)
+		if rules := getChatAlerts(alertChatId); rules[0].Active {
+			t.Error("alert activated without delivering the notification")
+		}
+	})
+
+	telegram.reset()
+	evaluateAlerts(d)
+	if n := notifications(); n != 1 {
+		t.Errorf("%d notifications, want one", n)
+	}
+	if rules := getChatAlerts(alertChatId); !rules[0].Active {
+		t.Error("alert not activated by the notification")
+	}
+	telegram.reset()
+	evaluateAlerts(d)
+	if n := notifications(); n != 0 {
+		t.Errorf("%d notifications while the condition persists", n)
+	}
+}
+
+func TestGroupAlertWizard(t *testing.T) {
+	const otherUserId = 5678
+	g := newTestBot(testGroupId)
+
+	calls := g.press("avviso nuovo")
+	expectMethods(t, calls, "getChatMember", "answerCallbackQuery")
+	expectText(t, calls[1], "Solo gli amministratori")
+
+	telegram.setChatMemberStatus(t, "administrator")
+	calls = g.press("avviso nuovo")
+	expectMethods(t, calls, "getChatMember", "editMessageText", "answerCallbackQuery")
+	g.press("avviso area puglia")
+	g.press("avviso metrica nuovi_positivi")
+	calls = g.press("avviso condizione sopra")
+	expectText(t, calls[0], "scrivi il valore")
+
+	// Other members can't complete the alert
+	if calls = g.sendAs(otherUserId, "500"); len(calls) != 0 {
+		t.Errorf("message of another member handled: %+v", calls)
+	}
+	calls = g.pressAs(otherUserId, "avviso valore 500")
+	expectMethods(t, calls, "answerCallbackQuery")
+	expectText(t, calls[0], "Solo chi ha iniziato")
+	calls = g.pressAs(otherUserId, "avviso annulla")
+	expectMethods(t, calls, "answerCallbackQuery")
+	if len(getChatAlerts(testGroupId)) != 0 {
+		t.Fatal("alert created by another member")
+	}
+
+	calls = g.send("500")
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "Avviso creato", "Puglia, nuovi positivi sopra 500")
+	rules := getChatAlerts(testGroupId)
+	if len(rules) != 1 {
+		t.Fatalf("alerts %+v, want the one created", rules)
+	}
+	defer deleteAlert(testGroupId, rules[0].ID)
+	if calls = g.send("600"); len(calls) != 0 {
+		t.Errorf("message after the wizard handled: %+v", calls)
+	}
+
+	telegram.setChatMemberStatus(t, "member")
+	calls = g.press("avviso elimina " + strconv.Itoa(rules[0].ID))
+	expectMethods(t, calls, "getChatMember", "answerCallbackQuery")
+	if len(getChatAlerts(testGroupId)) != 1 {
+		t.Fatal("alert deleted by a member")
+	}
+	calls = g.send("/avviso nazione deceduti sopra 100")
+	expectMethods(t, calls, "getChatMember", "sendMessage")
+	expectText(t, calls[1], "Solo gli amministratori")
+
+	telegram.setChatMemberStatus(t, "administrator")
+	calls = g.press("avviso elimina " + strconv.Itoa(rules[0].ID))
+	expectMethods(t, calls, "getChatMember", "editMessageText", "answerCallbackQuery")
+	expectText(t, calls[2], "Avviso eliminato")
+	if len(getChatAlerts(testGroupId)) != 0 {
+		t.Error("alert not deleted by an administrator")
+	}
+}
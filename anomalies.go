@@ -0,0 +1,255 @@
+package main
+
+import (
+	"github.com/DarkFighterLuke/covidgraphs"
+	"log"
+	"strconv"
+	"strings"
+)
+
+const (
+	anomalyMark          = " ⚠️"
+	outlierWindow        = 7 // Days used for the moving average
+	outlierFactor        = 3 // A daily value is an outlier when it exceeds the moving average by this factor
+	outlierMinimumAvg    = 10
+	anomalyKindNegative  = "negativo"
+	anomalyKindOutlier   = "anomalo"
+	anomalyKindNote      = "nota"
+	anomalyFieldWholeRow = ""
+)
+
+// Words in pcm-dpc notes announcing a data correction
+var recalculationKeywords = []string{"ricalcol", "riconteggi", "arretrat", "rettific", "correzion", "recuper", "revision", "riallinea"}
+
+// Cumulative fields whose daily delta is already reported by another field
+var deltaFields = map[string]string{
+	"totale_casi": "nuovi_positivi",
+}
+
+var fieldsLabels = map[string]string{
+	"totale_casi":     "Totale casi",
+	"dimessi_guariti": "Guariti",
+	"deceduti":        "Morti",
+	"nuovi_positivi":  "Nuovi positivi",
+	"tamponi":         "Tamponi",
+}
+
+// Suspicious value found while validating data
+type dataAnomaly struct {
+	field       string // Field name, empty if the anomaly concerns the whole row
+	kind        string
+	explanation string
+}
+
+// Looks for negative deltas, outliers and recalculation notes in the latest data of the snapshot
+func (d *dataSnapshot) validate() {
+	newNationAnomalies := make(map[int][]dataAnomaly)
+	newRegionsAnomalies := make(map[int][]dataAnomaly)
+	newProvincesAnomalies := make(map[int][]dataAnomaly)
+	count := 0
+
+	if len(d.nation) > 1 {
+		lastIndex := len(d.nation) - 1
+		series := make(map[string][]int)
+		for _, v := range d.nation {
+			series["totale_casi"] = append(series["totale_casi"], v.Totale_casi)
+			series["dimessi_guariti"] = append(series["dimessi_guariti"], v.Dimessi_guariti)
+			series["deceduti"] = append(series["deceduti"], v.Deceduti)
+			series["tamponi"] = append(series["tamponi"], v.Tamponi)
+			series["nuovi_positivi"] = append(series["nuovi_positivi"], v.Nuovi_positivi)
+		}
+		anomalies := checkRowSeries(series)
+		anomalies = append(anomalies, d.checkNote(d.nation[lastIndex].Note_it)...)
+		if len(anomalies) > 0 {
+			newNationAnomalies[lastIndex] = anomalies
+			count += len(anomalies)
+		}
+	}
+
+	if len(d.regions) > 21 {
+		for i := len(d.regions) - 21; i < len(d.regions); i++ {
+			series := make(map[string][]int)
+			for j := i % 21; j <= i; j += 21 {
+				if d.regions[j].Codice_regione != d.regions[i].Codice_regione {
+					continue
+				}
+				series["totale_casi"] = append(series["totale_casi"], d.regions[j].Totale_casi)
+				series["dimessi_guariti"] = append(series["dimessi_guariti"], d.regions[j].Dimessi_guariti)
+				series["deceduti"] = append(series["deceduti"], d.regions[j].Deceduti)
+				series["tamponi"] = append(series["tamponi"], d.regions[j].Tamponi)
+				series["nuovi_positivi"] = append(series["nuovi_positivi"], d.regions[j].Nuovi_positivi)
+			}
+			anomalies := checkRowSeries(series)
+			anomalies = append(anomalies, d.checkNote(d.regions[i].Note_it)...)
+			if len(anomalies) > 0 {
+				newRegionsAnomalies[i] = anomalies
+				count += len(anomalies)
+			}
+		}
+	}
+
+	if len(d.provinces) > 0 {
+		provincesIndexes := make(map[int][]int)
+		for i, v := range d.provinces {
+			provincesIndexes[v.Codice_provincia] = append(provincesIndexes[v.Codice_provincia], i)
+		}
+		lastDate := d.provinces[len(d.provinces)-1].Data
+		for _, indexes := range provincesIndexes {
+			lastIndex := indexes[len(indexes)-1]
+			if d.provinces[lastIndex].Data != lastDate {
+				continue
+			}
+			series := make(map[string][]int)
+			for _, j := range indexes {
+				series["totale_casi"] = append(series["totale_casi"], d.provinces[j].Totale_casi)
+				series["nuovi_positivi"] = append(series["nuovi_positivi"], d.provinces[j].NuoviCasi)
+			}
+			anomalies := checkRowSeries(series)
+			anomalies = append(anomalies, d.checkNote(d.provinces[lastIndex].Note_it)...)
+			if len(anomalies) > 0 {
+				newProvincesAnomalies[lastIndex] = anomalies
+				count += len(anomalies)
+			}
+		}
+	}
+
+	d.nationAnomalies = newNationAnomalies
+	d.regionsAnomalies = newRegionsAnomalies
+	d.provincesAnomalies = newProvincesAnomalies
+	log.Println("Data validation completed, anomalies found: " + strconv.Itoa(count))
+}
+
+// Checks the last value of each field series, cumulative fields are checked on their daily deltas
+func checkRowSeries(series map[string][]int) []dataAnomaly {
+	anomalies := make([]dataAnomaly, 0)
+	for _, field := range []string{"nuovi_positivi", "totale_casi", "dimessi_guariti", "deceduti", "tamponi"} {
+		values, ok := series[field]
+		if !ok || len(values) < 2 {
+			continue
+		}
+
+		if related, ok := deltaFields[field]; ok && hasAnomaly(anomalies, related) {
+			continue
+		}
+
+		daily := values
+		if field != "nuovi_positivi" {
+			daily = make([]int, 0)
+			for i := 1; i < len(values); i++ {
+				daily = append(daily, values[i]-values[i-1])
+			}
+		}
+		anomalies = append(anomalies, checkDailySeries(field, daily)...)
+	}
+
+	return anomalies
+}
+
+// Checks the last value of a daily series against negative values and the moving average
+func checkDailySeries(field string, daily []int) []dataAnomaly {
+	last := daily[len(daily)-1]
+	if last < 0 {
+		return []dataAnomaly{{
+			field:       field,
+			kind:        anomalyKindNegative,
+			explanation: fieldsLabels[field] + ": variazione giornaliera negativa (" + strconv.Itoa(last) + "), dovuta a una correzione dei dati già pubblicati",
+		}}
+	}
+
+	if len(daily) <= outlierWindow {
+		return nil
+	}
+	sum := 0
+	for _, v := range daily[len(daily)-1-outlierWindow : len(daily)-1] {
+		sum += v
+	}
+	average := float64(sum) / outlierWindow
+	if average >= outlierMinimumAvg && float64(last) > outlierFactor*average {
+		return []dataAnomaly{{
+			field: field,
+			kind:  anomalyKindOutlier,
+			explanation: fieldsLabels[field] + ": variazione giornaliera di " + strconv.Itoa(last) + " contro una media di " +
+				strconv.FormatFloat(average, 'f', 0, 64) + " negli ultimi " + strconv.Itoa(outlierWindow) + " giorni, possibile recupero di dati arretrati",
+		}}
+	}
+
+	return nil
+}
+
+// Checks if the note with the given code announces a recalculation
+func (d *dataSnapshot) checkNote(noteCode string) []dataAnomaly {
+	if noteCode == "" {
+		return nil
+	}
+	i, err := covidgraphs.FindFirstOccurrenceNote(&d.notes, "codice", noteCode)
+	if err != nil {
+		return nil
+	}
+
+	text := strings.ToLower(d.notes[i].Avviso + " " + d.notes[i].Note)
+	for _, keyword := range recalculationKeywords {
+		if strings.Contains(text, keyword) {
+			return []dataAnomaly{{
+				field:       anomalyFieldWholeRow,
+				kind:        anomalyKindNote,
+				explanation: "Le note della Protezione Civile segnalano un ricalcolo: i dati potrebbero non essere confrontabili con quelli dei giorni precedenti",
+			}}
+		}
+	}
+
+	return nil
+}
+
+// Checks if the anomaly concerns the given field, directly or through its daily delta
+func (a dataAnomaly) concerns(field string) bool {
+	return a.field == field || (a.field != anomalyFieldWholeRow && deltaFields[field] == a.field)
+}
+
+// Checks if any of the anomalies concerns the given field
+func hasAnomaly(anomalies []dataAnomaly, field string) bool {
+	for _, v := range anomalies {
+		if v.concerns(field) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the warning mark if the given field is affected by an anomaly
+func markAnomaly(anomalies []dataAnomaly, field string) string {
+	if hasAnomaly(anomalies, field) {
+		return anomalyMark
+	}
+	return ""
+}
+
+// Returns the anomalies concerning the given fields or the whole row
+func filterAnomalies(anomalies []dataAnomaly, fields []string) []dataAnomaly {
+	filtered := make([]dataAnomaly, 0)
+	for _, v := range anomalies {
+		if v.field == anomalyFieldWholeRow {
+			filtered = append(filtered, v)
+			continue
+		}
+		for _, f := range fields {
+			if v.concerns(f) {
+				filtered = append(filtered, v)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// Returns the caption section explaining the anomalies
+func setCaptionAnomalies(anomalies []dataAnomaly) string {
+	if len(anomalies) == 0 {
+		return ""
+	}
+
+	msg := "\n\n<b>⚠️ Avvisi sui dati:</b>"
+	for _, v := range anomalies {
+		msg += "\n- <i>" + v.explanation + "</i>"
+	}
+	return msg
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"github.com/DarkFighterLuke/covidgraphs"
+	"strings"
+	"testing"
+)
+
+func TestCheckDailySeries(t *testing.T) {
+	for _, v := range []struct {
+		daily []int
+		want  string
+	}{
+		{[]int{100, 120, -5}, anomalyKindNegative},
+		{[]int{100, 110, 90, 100, 105, 95, 100, 100, 400}, anomalyKindOutlier},
+		{[]int{100, 110, 90, 100, 105, 95, 100, 100, 250}, ""},
+		{[]int{1, 2, 1, 2, 1, 2, 1, 2, 50}, ""},
+		{[]int{100, 400}, ""},
+	} {
+		anomalies := checkDailySeries("deceduti", v.daily)
+		if v.want == "" {
+			if len(anomalies) != 0 {
+				t.Errorf("%v: unexpected anomalies %+v", v.daily, anomalies)
+			}
+			continue
+		}
+		if len(anomalies) != 1 || anomalies[0].kind != v.want || anomalies[0].field != "deceduti" {
+			t.Errorf("%v: anomalies %+v, want one %s", v.daily, anomalies, v.want)
+		}
+	}
+}
+
+func TestCheckRowSeriesReportsDeltaOnce(t *testing.T) {
+	series := map[string][]int{
+		"nuovi_positivi": {100, -20},
+		"totale_casi":    {1000, 980},
+		"deceduti":       {50, 40},
+	}
+	anomalies := checkRowSeries(series)
+	if len(anomalies) != 2 || anomalies[0].field != "nuovi_positivi" || anomalies[1].field != "deceduti" {
+		t.Fatalf("anomalies %+v, want new cases and deaths only", anomalies)
+	}
+	if markAnomaly(anomalies, "totale_casi") != anomalyMark {
+		t.Error("total cases not marked by the anomaly of new cases")
+	}
+	if filtered := filterAnomalies(anomalies, []string{"totale_casi"}); len(filtered) != 1 || filtered[0].field != "nuovi_positivi" {
+		t.Errorf("anomalies for total cases %+v, want the new cases one", filtered)
+	}
+
+	// A delta of total cases not matching the new cases is still reported
+	series["nuovi_positivi"] = []int{100, 20}
+	anomalies = checkRowSeries(series)
+	if len(anomalies) != 2 || anomalies[0].field != "totale_casi" {
+		t.Errorf("anomalies %+v, want total cases and deaths", anomalies)
+	}
+}
+
+func TestCheckNote(t *testing.T) {
+	d := &dataSnapshot{pcmDpcData: pcmDpcData{notes: []covidgraphs.NoteData{
+		{Codice: "a", Avviso: "Ricalcolo dei casi", Note: "Recuperati dati arretrati"},
+		{Codice: "b", Note: "Dato parziale"},
+	}}}
+
+	if anomalies := d.checkNote("a"); len(anomalies) != 1 || anomalies[0].kind != anomalyKindNote || anomalies[0].field != anomalyFieldWholeRow {
+		t.Errorf("anomalies %+v, want a note on the whole row", anomalies)
+	}
+	for _, code := range []string{"b", "c", ""} {
+		if anomalies := d.checkNote(code); len(anomalies) != 0 {
+			t.Errorf("note %q: unexpected anomalies %+v", code, anomalies)
+		}
+	}
+}
+
+func TestFilterAnomalies(t *testing.T) {
+	anomalies := []dataAnomaly{
+		{field: "deceduti", kind: anomalyKindNegative, explanation: "morti"},
+		{field: anomalyFieldWholeRow, kind: anomalyKindNote, explanation: "nota"},
+		{field: "tamponi", kind: anomalyKindOutlier, explanation: "tamponi"},
+	}
+
+	filtered := filterAnomalies(anomalies, []string{"tamponi"})
+	if len(filtered) != 2 || filtered[0].kind != anomalyKindNote || filtered[1].field != "tamponi" {
+		t.Errorf("filtered %+v, want the note and the tests", filtered)
+	}
+	if markAnomaly(anomalies, "dimessi_guariti") != "" || markAnomaly(anomalies, "deceduti") != anomalyMark {
+		t.Error("wrong fields marked")
+	}
+
+	msg := setCaptionAnomalies(filtered)
+	if !strings.Contains(msg, "<i>nota</i>") || !strings.Contains(msg, "<i>tamponi</i>") || strings.Contains(msg, "morti") {
+		t.Errorf("unexpected caption:\n%s", msg)
+	}
+	if setCaptionAnomalies(nil) != "" {
+		t.Error("caption without anomalies")
+	}
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/DarkFighterLuke/covidgraphs"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	apiPrefix          = "/api/"
+	apiAreaRegions     = "regioni"
+	apiAreaProvinces   = "province"
+	apiDefaultRanking  = 10
+	apiDateLayout      = "2006-01-02"
+	apiDataDateLayout  = "2006-01-02T15:04:05"
+	apiPlotContentType = "image/png"
+)
+
+// Provincial row with the new cases under the same name used by the other areas
+type apiProvinceData struct {
+	Data                    string  `json:"data"`
+	Stato                   string  `json:"stato"`
+	Codice_regione          int     `json:"codice_regione"`
+	Denominazione_regione   string  `json:"denominazione_regione"`
+	Codice_provincia        int     `json:"codice_provincia"`
+	Denominazione_provincia string  `json:"denominazione_provincia"`
+	Sigla_provincia         string  `json:"sigla_provincia"`
+	Lat                     float64 `json:"lat"`
+	Long                    float64 `json:"long"`
+	Totale_casi             int     `json:"totale_casi"`
+	Nuovi_positivi          int     `json:"nuovi_positivi"`
+	Note_it                 string  `json:"note_it"`
+}
+
+// Position of a region or a province in a ranking
+type apiRankingItem struct {
+	Posizione     int    `json:"posizione"`
+	Codice        int    `json:"codice"`
+	Denominazione string `json:"denominazione"`
+	Valore        int    `json:"valore"`
+}
+
+// Error returned to clients
+type apiError struct {
+	Errore string `json:"errore"`
+}
+
+var apiAddr = getEnv("CovidBotAPIAddr", "")   // Listen address of the API, empty to use the webhook listener
+var apiToken = getEnv("CovidBotAPIToken", "") // Bearer token required by the API, empty to leave it public
+
+// Registers the API handlers on their own listener if apiAddr is set, otherwise on the one used by the webhook
+func initAPI() {
+	if apiAddr == "" {
+		registerAPIHandlers(http.DefaultServeMux)
+		return
+	}
+	mux := http.NewServeMux()
+	registerAPIHandlers(mux)
+	server := newHTTPServer(apiAddr, mux)
+	go func() {
+		log.Println(server.ListenAndServe())
+	}()
+}
+
+// Registers the API handlers on the given mux
+func registerAPIHandlers(mux *http.ServeMux) {
+	mux.HandleFunc(apiPrefix+"nazione", apiHandler(apiNazione))
+	mux.HandleFunc(apiPrefix+"regioni", apiHandler(apiRegioni))
+	mux.HandleFunc(apiPrefix+"regioni/", apiHandler(apiRegione))
+	mux.HandleFunc(apiPrefix+"province", apiHandler(apiProvince))
+	mux.HandleFunc(apiPrefix+"province/", apiHandler(apiProvincia))
+	mux.HandleFunc(apiPrefix+"classifica", apiHandler(apiClassifica))
+	mux.HandleFunc(apiPrefix+"plot/", apiHandler(apiPlot))
+	mux.HandleFunc(apiPrefix, apiHandler(func(_ *dataSnapshot, w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, "risorsa non trovata")
+	}))
+}
+
+// Checks the bearer token of the request when the API requires one
+func isAPIAuthorized(r *http.Request) bool {
+	if apiToken == "" {
+		return true
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(apiToken)) == 1
+}
+
+// Wraps an API handler allowing only authorized read requests
+func apiHandler(handler func(d *dataSnapshot, w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAPIAuthorized(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAPIError(w, http.StatusUnauthorized, "token non valido")
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeAPIError(w, http.StatusMethodNotAllowed, "metodo non consentito")
+			return
+		}
+		d := getData()
+		if len(d.nation) == 0 {
+			writeAPIError(w, http.StatusServiceUnavailable, "dati non ancora disponibili")
+			return
+		}
+		handler(d, w, r)
+	}
+}
+
+// Writes the value as JSON
+func writeAPIJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// Writes an error message as JSON
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeAPIJSON(w, status, apiError{Errore: message})
+}
+
+// Returns the period requested with the "dal" and "al" parameters, zero values if missing
+func getAPIPeriod(r *http.Request) (time.Time, time.Time, error) {
+	var from, to time.Time
+	var err error
+	if v := r.URL.Query().Get("dal"); v != "" {
+		from, err = time.Parse(apiDateLayout, v)
+		if err != nil {
+			return from, to, fmt.Errorf("data iniziale non valida, usa il formato AAAA-MM-GG")
+		}
+	}
+	if v := r.URL.Query().Get("al"); v != "" {
+		to, err = time.Parse(apiDateLayout, v)
+		if err != nil {
+			return from, to, fmt.Errorf("data finale non valida, usa il formato AAAA-MM-GG")
+		}
+	}
+	return from, to, nil
+}
+
+// Checks if the date of a row is in the requested period
+func isInAPIPeriod(date string, from, to time.Time) bool {
+	day, err := time.Parse(apiDataDateLayout, date)
+	if err != nil {
+		log.Println("error parsing data in isInAPIPeriod()")
+		return false
+	}
+	day = day.Truncate(24 * time.Hour)
+	return (from.IsZero() || !day.Before(from)) && (to.IsZero() || !day.After(to))
+}
+
+// Returns the code that follows the given prefix in the request path
+func getAPICode(r *http.Request, prefix string) (int, error) {
+	code, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/"))
+	if err != nil {
+		return 0, fmt.Errorf("codice non valido")
+	}
+	return code, nil
+}
+
+// Returns the index of the last row of the region with the given code
+func (d *dataSnapshot) getLastRegionIndexByCode(code int) (int, error) {
+	for i := len(d.regions) - 1; i >= 0; i-- {
+		if d.regions[i].Codice_regione == code {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("regione non trovata")
+}
+
+// Returns the index of the last row of the province with the given code
+func (d *dataSnapshot) getLastProvinceIndexByCode(code int) (int, error) {
+	for i := len(d.provinces) - 1; i >= 0; i-- {
+		if d.provinces[i].Codice_provincia == code {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("provincia non trovata")
+}
+
+// Returns the provincial row in the API format
+func newAPIProvinceData(row covidgraphs.ProvinceData) apiProvinceData {
+	return apiProvinceData{
+		Data:                    row.Data,
+		Stato:                   row.Stato,
+		Codice_regione:          row.Codice_regione,
+		Denominazione_regione:   row.Denominazione_regione,
+		Codice_provincia:        row.Codice_provincia,
+		Denominazione_provincia: row.Denominazione_provincia,
+		Sigla_provincia:         row.Sigla_provincia,
+		Lat:                     row.Lat,
+		Long:                    row.Long,
+		Totale_casi:             row.Totale_casi,
+		Nuovi_positivi:          row.NuoviCasi,
+		Note_it:                 row.Note_it,
+	}
+}
+
+// Handles "/api/nazione", the national data of the requested period
+func apiNazione(d *dataSnapshot, w http.ResponseWriter, r *http.Request) {
+	from, to, err := getAPIPeriod(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rows := make([]covidgraphs.NationData, 0)
+	for _, v := range d.nation {
+		if isInAPIPeriod(v.Data, from, to) {
+			rows = append(rows, v)
+		}
+	}
+	writeAPIJSON(w, http.StatusOK, rows)
+}
+
+// Handles "/api/regioni", the last data of every region
+func apiRegioni(d *dataSnapshot, w http.ResponseWriter, r *http.Request) {
+	if len(d.regions) < 21 {
+		writeAPIError(w, http.StatusServiceUnavailable, "dati non ancora disponibili")
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, d.regions[len(d.regions)-21:])
+}
+
+// Handles "/api/regioni/{codice}", the data of a region in the requested period
+func apiRegione(d *dataSnapshot, w http.ResponseWriter, r *http.Request) {
+	code, err := getAPICode(r, apiPrefix+"regioni/")
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	from, to, err := getAPIPeriod(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := d.getLastRegionIndexByCode(code); err != nil {
+		writeAPIError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	rows := make([]covidgraphs.RegionData, 0)
+	for _, v := range d.regions {
+		if v.Codice_regione == code && isInAPIPeriod(v.Data, from, to) {
+			rows = append(rows, v)
+		}
+	}
+	writeAPIJSON(w, http.StatusOK, rows)
+}
+
+// Handles "/api/province", the last data of every province
+func apiProvince(d *dataSnapshot, w http.ResponseWriter, r *http.Request) {
+	lastDate := d.provinces[len(d.provinces)-1].Data
+	rows := make([]apiProvinceData, 0)
+	for _, v := range d.provinces {
+		if v.Data == lastDate {
+			rows = append(rows, newAPIProvinceData(v))
+		}
+	}
+	writeAPIJSON(w, http.StatusOK, rows)
+}
+
+// Handles "/api/province/{codice}", the data of a province in the requested period
+func apiProvincia(d *dataSnapshot, w http.ResponseWriter, r *http.Request) {
+	code, err := getAPICode(r, apiPrefix+"province/")
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	from, to, err := getAPIPeriod(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := d.getLastProvinceIndexByCode(code); err != nil {
+		writeAPIError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	rows := make([]apiProvinceData, 0)
+	for _, v := range d.provinces {
+		if v.Codice_provincia == code && isInAPIPeriod(v.Data, from, to) {
+			rows = append(rows, newAPIProvinceData(v))
+		}
+	}
+	writeAPIJSON(w, http.StatusOK, rows)
+}
+
+// Handles "/api/classifica?campo=&area=&n=", the regions or provinces sorted by the last value of a field
+func apiClassifica(d *dataSnapshot, w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	field := query.Get("campo")
+	if field == "" {
+		field = "totale_casi"
+	}
+	area := query.Get("area")
+	if area == "" {
+		area = apiAreaRegions
+	}
+	n := apiDefaultRanking
+	if v := query.Get("n"); v != "" {
+		var err error
+		n, err = strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeAPIError(w, http.StatusBadRequest, "numero di posizioni non valido")
+			return
+		}
+	}
+
+	items := make([]apiRankingItem, 0)
+	switch area {
+	case apiAreaRegions:
+		if len(d.regions) < 21 {
+			writeAPIError(w, http.StatusServiceUnavailable, "dati non ancora disponibili")
+			return
+		}
+		for _, v := range d.regions[len(d.regions)-21:] {
+			value, err := getRegionField(v, field)
+			if err != nil {
+				writeAPIError(w, http.StatusBadRequest, "campo non valido, campi disponibili: "+strings.Join(natregAttributes, ","))
+				return
+			}
+			items = append(items, apiRankingItem{Codice: v.Codice_regione, Denominazione: v.Denominazione_regione, Valore: value})
+		}
+	case apiAreaProvinces:
+		if field != "totale_casi" && field != "nuovi_positivi" {
+			writeAPIError(w, http.StatusBadRequest, "campo non valido, campi disponibili: "+strings.Join(provincesAttributes, ","))
+			return
+		}
+		lastDate := d.provinces[len(d.provinces)-1].Data
+		for _, v := range d.provinces {
+			if v.Data != lastDate {
+				continue
+			}
+			value, _ := getRegionField(provinceToRegionData(v), field)
+			items = append(items, apiRankingItem{Codice: v.Codice_provincia, Denominazione: v.Denominazione_provincia, Valore: value})
+		}
+	default:
+		writeAPIError(w, http.StatusBadRequest, "area non valida, aree disponibili: "+apiAreaRegions+","+apiAreaProvinces)
+		return
+	}
+
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Valore > items[j].Valore })
+	if len(items) > n {
+		items = items[:n]
+	}
+	for i := range items {
+		items[i].Posizione = i + 1
+	}
+	writeAPIJSON(w, http.StatusOK, items)
+}
+
+// Handles "/api/plot/nazione", "/api/plot/nazione/nuovi_positivi", "/api/plot/regioni/{codice}" and "/api/plot/province/{codice}"
+func apiPlot(d *dataSnapshot, w http.ResponseWriter, r *http.Request) {
+	tokens := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, apiPrefix+"plot/"), "/"), "/")
+
+	var filename string
+	var err error
+	switch {
+	case len(tokens) == 1 && tokens[0] == "nazione":
+		filename, err = d.getPlotAndamentoNazionale()
+	case len(tokens) == 2 && tokens[0] == "nazione" && tokens[1] == "nuovi_positivi":
+		filename, err = d.getPlotNuoviPositiviNazione()
+	case len(tokens) == 2 && tokens[0] == apiAreaRegions:
+		code, codeErr := strconv.Atoi(tokens[1])
+		if codeErr != nil {
+			writeAPIError(w, http.StatusBadRequest, "codice non valido")
+			return
+		}
+		regionIndex, indexErr := d.getLastRegionIndexByCode(code)
+		if indexErr != nil {
+			writeAPIError(w, http.StatusNotFound, indexErr.Error())
+			return
+		}
+		filename, err = d.getPlotAndamentoRegionale(regionIndex)
+	case len(tokens) == 2 && tokens[0] == apiAreaProvinces:
+		code, codeErr := strconv.Atoi(tokens[1])
+		if codeErr != nil {
+			writeAPIError(w, http.StatusBadRequest, "codice non valido")
+			return
+		}
+		provinceIndex, indexErr := d.getLastProvinceIndexByCode(code)
+		if indexErr != nil {
+			writeAPIError(w, http.StatusNotFound, indexErr.Error())
+			return
+		}
+		filename, err = d.getPlotProvinciale(provinceIndex)
+	default:
+		writeAPIError(w, http.StatusNotFound, "grafico non trovato")
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		writeAPIError(w, http.StatusInternalServerError, "impossibile generare il grafico")
+		return
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Println(err)
+		writeAPIError(w, http.StatusInternalServerError, "impossibile leggere il grafico")
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", apiPlotContentType)
+	_, err = io.Copy(w, f)
+	if err != nil {
+		log.Println(err)
+	}
+}
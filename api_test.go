@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Serves a request with the API handlers registered on their own mux
+func apiRequest(method, target string, header http.Header) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	registerAPIHandlers(mux)
+	r := httptest.NewRequest(method, target, nil)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	return w
+}
+
+func TestAPIData(t *testing.T) {
+	w := apiRequest(http.MethodGet, apiPrefix+"nazione?dal=2022-03-01&al=2022-03-05", nil)
+	var nation []struct {
+		Data              string `json:"data"`
+		Terapia_intensiva int    `json:"terapia_intensiva"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &nation); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("status %d: %v", w.Code, err)
+	}
+	if len(nation) != 5 || nation[4].Terapia_intensiva != 617 {
+		t.Errorf("unexpected national data %+v", nation)
+	}
+
+	w = apiRequest(http.MethodGet, apiPrefix+"province/72", nil)
+	var province []apiProvinceData
+	if err := json.Unmarshal(w.Body.Bytes(), &province); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("status %d: %v", w.Code, err)
+	}
+	if len(province) != 15 || province[0].Denominazione_provincia != "Bari" {
+		t.Errorf("%d rows of %s, want 15 of Bari", len(province), province[0].Denominazione_provincia)
+	}
+
+	w = apiRequest(http.MethodGet, apiPrefix+"classifica?area=regioni&n=3", nil)
+	var ranking []apiRankingItem
+	if err := json.Unmarshal(w.Body.Bytes(), &ranking); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("status %d: %v", w.Code, err)
+	}
+	if len(ranking) != 3 || ranking[0].Posizione != 1 || ranking[0].Valore < ranking[2].Valore {
+		t.Errorf("unexpected ranking %+v", ranking)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	for target, status := range map[string]int{
+		apiPrefix + "nazione?dal=ieri":          http.StatusBadRequest,
+		apiPrefix + "regioni/99":                http.StatusNotFound,
+		apiPrefix + "province/bari":             http.StatusBadRequest,
+		apiPrefix + "classifica?campo=positivi": http.StatusBadRequest,
+		apiPrefix + "classifica?area=comuni":    http.StatusBadRequest,
+		apiPrefix + "plot/comuni/1":             http.StatusNotFound,
+		apiPrefix + "vaccini":                   http.StatusNotFound,
+	} {
+		w := apiRequest(http.MethodGet, target, nil)
+		var body apiError
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != status || body.Errore == "" {
+			t.Errorf("%s: status %d body %q, want %d with an error", target, w.Code, w.Body.String(), status)
+		}
+	}
+
+	w := apiRequest(http.MethodPost, apiPrefix+"nazione", nil)
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, HEAD" {
+		t.Errorf("POST: status %d allow %q", w.Code, w.Header().Get("Allow"))
+	}
+}
+
+func TestAPIPlot(t *testing.T) {
+	w := apiRequest(http.MethodGet, apiPrefix+"plot/regioni/16", nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != apiPlotContentType {
+		t.Fatalf("status %d content type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if !bytes.HasPrefix(w.Body.Bytes(), []byte("\x89PNG")) {
+		t.Error("plot isn't a PNG image")
+	}
+}
+
+func TestAPIToken(t *testing.T) {
+	defer func(token string) { apiToken = token }(apiToken)
+	apiToken = "segreto"
+
+	for header, status := range map[string]int{
+		"":               http.StatusUnauthorized,
+		"Bearer errato":  http.StatusUnauthorized,
+		"segreto":        http.StatusOK,
+		"Bearer segreto": http.StatusOK,
+	} {
+		w := apiRequest(http.MethodGet, apiPrefix+"regioni", http.Header{"Authorization": {header}})
+		if w.Code != status {
+			t.Errorf("authorization %q: status %d, want %d", header, w.Code, status)
+		}
+	}
+}
+
+func TestNewHTTPServer(t *testing.T) {
+	server := newHTTPServer(":0", http.NotFoundHandler())
+	if server.ReadHeaderTimeout <= 0 || server.ReadTimeout <= 0 || server.IdleTimeout <= 0 || server.WriteTimeout < 30*time.Second {
+		t.Errorf("server timeouts %v %v %v %v", server.ReadHeaderTimeout, server.ReadTimeout, server.WriteTimeout, server.IdleTimeout)
+	}
+}
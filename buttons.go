@@ -5,6 +5,7 @@ import (
 	"github.com/DarkFighterLuke/covidgraphs"
 	"github.com/NicoNex/echotron"
 	"log"
+	"strconv"
 	"strings"
 )
 
@@ -80,10 +81,11 @@ func (b *bot) provinceButtons() ([]byte, error) {
 }
 
 // Creates northern regions buttons set
-func (b *bot) nordRegionsButtons() ([]byte, error) {
-	regions := covidgraphs.GetNordRegionsNamesList()
+func (b *bot) nordRegionsButtons(d *dataSnapshot) ([]byte, error) {
+	regions := make([]string, 0)
 	regionsCallback := make([]string, 0)
-	for _, v := range regions {
+	for _, v := range covidgraphs.GetNordRegionsNamesList() {
+		regions = append(regions, strings.TrimSpace(d.getZoneEmoji(v)+" "+v))
 		regionsCallback = append(regionsCallback, strings.ToLower(v))
 	}
 	regions = append(regions, "Annulla ❌")
@@ -97,10 +99,11 @@ func (b *bot) nordRegionsButtons() ([]byte, error) {
 }
 
 // Creates central regions buttons set
-func (b *bot) centroRegionsButtons() ([]byte, error) {
-	regions := covidgraphs.GetCentroRegionsNamesList()
+func (b *bot) centroRegionsButtons(d *dataSnapshot) ([]byte, error) {
+	regions := make([]string, 0)
 	regionsCallback := make([]string, 0)
-	for _, v := range regions {
+	for _, v := range covidgraphs.GetCentroRegionsNamesList() {
+		regions = append(regions, strings.TrimSpace(d.getZoneEmoji(v)+" "+v))
 		regionsCallback = append(regionsCallback, strings.ToLower(v))
 	}
 	regions = append(regions, "Annulla ❌")
@@ -114,10 +117,11 @@ func (b *bot) centroRegionsButtons() ([]byte, error) {
 }
 
 // Creates southern regions buttons set
-func (b *bot) sudRegionsButtons() ([]byte, error) {
-	regions := covidgraphs.GetSudRegionsNamesList()
+func (b *bot) sudRegionsButtons(d *dataSnapshot) ([]byte, error) {
+	regions := make([]string, 0)
 	regionsCallback := make([]string, 0)
-	for _, v := range regions {
+	for _, v := range covidgraphs.GetSudRegionsNamesList() {
+		regions = append(regions, strings.TrimSpace(d.getZoneEmoji(v)+" "+v))
 		regionsCallback = append(regionsCallback, strings.ToLower(v))
 	}
 	regions = append(regions, "Annulla ❌")
@@ -412,8 +416,8 @@ func (b *bot) buttonsRegionsGroupsP(zoneIndex, regionIndex int) ([]byte, error,
 	return buttons, nil, strings.ToLower(regionNames[regionIndex]), regionIndex
 }
 
-func (b *bot) buttonsProvincesGroup(provinceIndex int, regionName string) ([]byte, error, string, int) {
-	provinces := covidgraphs.GetLastProvincesByRegionName(&provincesData, strings.ToLower(regionName))
+func (b *bot) buttonsProvincesGroup(d *dataSnapshot, provinceIndex int, regionName string) ([]byte, error, string, int) {
+	provinces := covidgraphs.GetLastProvincesByRegionName(&d.provinces, strings.ToLower(regionName))
 	provinceNames := make([]string, 0)
 	for _, v := range *provinces {
 		provinceNames = append(provinceNames, v.Denominazione_provincia)
@@ -439,3 +443,112 @@ func (b *bot) buttonsProvincesGroup(provinceIndex int, regionName string) ([]byt
 
 	return buttons, nil, strings.ToLower(provinceNames[provinceIndex]), provinceIndex
 }
+
+// Creates alerts menu buttons set
+func (b *bot) alertsMenuButtons() ([]byte, error) {
+	buttonsNames := []string{"Nuovo avviso ➕", "I miei avvisi 📋", "Annulla ❌"}
+	callbackNames := []string{"avviso nuovo", "avviso lista", "avviso annulla"}
+	return b.makeButtons(buttonsNames, callbackNames, 1)
+}
+
+// Creates the buttons set to choose the area of an alert
+func (b *bot) alertAreaButtons() ([]byte, error) {
+	buttonsNames := []string{"Italia"}
+	callbackNames := []string{"avviso area " + alertAreaNation}
+	regions := append(covidgraphs.GetNordRegionsNamesList(), covidgraphs.GetCentroRegionsNamesList()...)
+	regions = append(regions, covidgraphs.GetSudRegionsNamesList()...)
+	for _, v := range regions {
+		buttonsNames = append(buttonsNames, v)
+		callbackNames = append(callbackNames, "avviso area "+strings.ToLower(v))
+	}
+	buttonsNames = append(buttonsNames, "Annulla ❌")
+	callbackNames = append(callbackNames, "avviso annulla")
+	return b.makeButtons(buttonsNames, callbackNames, 3)
+}
+
+// Creates the buttons set to choose the metric of an alert
+func (b *bot) alertMetricButtons() ([]byte, error) {
+	buttonsNames := make([]string, 0)
+	callbackNames := make([]string, 0)
+	for _, v := range alertMetrics {
+		buttonsNames = append(buttonsNames, alertMetricsLabels[v])
+		callbackNames = append(callbackNames, "avviso metrica "+v)
+	}
+	buttonsNames = append(buttonsNames, "Annulla ❌")
+	callbackNames = append(callbackNames, "avviso annulla")
+	return b.makeButtons(buttonsNames, callbackNames, 1)
+}
+
+// Creates the buttons set to choose the condition of an alert
+func (b *bot) alertConditionButtons() ([]byte, error) {
+	buttonsNames := make([]string, 0)
+	callbackNames := make([]string, 0)
+	for _, v := range alertConditions {
+		buttonsNames = append(buttonsNames, alertConditionsLabels[v])
+		callbackNames = append(callbackNames, "avviso condizione "+v)
+	}
+	buttonsNames = append(buttonsNames, "Annulla ❌")
+	callbackNames = append(callbackNames, "avviso annulla")
+	return b.makeButtons(buttonsNames, callbackNames, 2)
+}
+
+// Creates the buttons set to choose the threshold or the days of an alert
+func (b *bot) alertValueButtons(metric, condition string) ([]byte, error) {
+	buttonsNames := make([]string, 0)
+	callbackNames := make([]string, 0)
+	if condition == alertRising || condition == alertFalling {
+		for _, v := range alertTrendDays {
+			buttonsNames = append(buttonsNames, strconv.Itoa(v)+" giorni")
+			callbackNames = append(callbackNames, "avviso valore "+strconv.Itoa(v))
+		}
+	} else {
+		for _, v := range alertThresholds[metric] {
+			buttonsNames = append(buttonsNames, formatAlertValue(metric, float64(v)))
+			callbackNames = append(callbackNames, "avviso valore "+strconv.Itoa(v))
+		}
+	}
+	buttonsNames = append(buttonsNames, "Annulla ❌")
+	callbackNames = append(callbackNames, "avviso annulla")
+	return b.makeButtons(buttonsNames, callbackNames, 2)
+}
+
+// Creates the buttons set to delete the alerts of a chat
+func (b *bot) alertListButtons(rules []alertRule) ([]byte, error) {
+	buttonsNames := make([]string, 0)
+	callbackNames := make([]string, 0)
+	for i, v := range rules {
+		buttonsNames = append(buttonsNames, "Elimina "+strconv.Itoa(i+1)+" 🗑")
+		callbackNames = append(callbackNames, "avviso elimina "+strconv.Itoa(v.ID))
+	}
+	buttonsNames = append(buttonsNames, "Nuovo avviso ➕", "Chiudi ❌")
+	callbackNames = append(callbackNames, "avviso nuovo", "avviso annulla")
+	return b.makeButtons(buttonsNames, callbackNames, 2)
+}
+
+// Creates the buttons set to delete the scheduled posts of a chat
+func (b *bot) scheduleListButtons(posts []scheduledPost) ([]byte, error) {
+	buttonsNames := make([]string, 0)
+	callbackNames := make([]string, 0)
+	for i, v := range posts {
+		buttonsNames = append(buttonsNames, "Elimina "+strconv.Itoa(i+1)+" 🗑")
+		callbackNames = append(callbackNames, "programma elimina "+strconv.Itoa(v.ID))
+	}
+	buttonsNames = append(buttonsNames, "Chiudi ❌")
+	callbackNames = append(callbackNames, "programma chiudi")
+	return b.makeButtons(buttonsNames, callbackNames, 2)
+}
+
+// Returns the names and the callbacks of the buttons offering the export of the data of an area
+func exportButtonsData(area, name string) ([]string, []string) {
+	suffix := ""
+	if name != "" {
+		suffix = " " + name
+	}
+	return []string{"Esporta CSV 📄", "Esporta XLSX 📊"}, []string{"esporta " + area + " " + exportFormatCSV + suffix, "esporta " + area + " " + exportFormatXLSX + suffix}
+}
+
+// Creates the buttons set offering the export of the data of an area
+func (b *bot) exportButtons(area, name string) ([]byte, error) {
+	buttonsNames, callbackNames := exportButtonsData(area, name)
+	return b.makeButtons(buttonsNames, callbackNames, 2)
+}
@@ -5,71 +5,67 @@ import (
 	"github.com/DarkFighterLuke/covidgraphs"
 	"github.com/NicoNex/echotron"
 	"log"
-	"os"
+	"strconv"
 	"strings"
-	"time"
 )
 
-func (b *bot) callbackNuoviCasiNazione(cq *echotron.CallbackQuery) {
-	dirPath := workingDirectory + imageFolder
-	title := "Nuovi Positivi"
-	var filename string
-	var err error
-
-	filename = dirPath + covidgraphs.FilenameCreator(title)
-	if !covidgraphs.IsGraphExisting(filename) {
-		err, filename = covidgraphs.NuoviPositiviNazione(&nationData, true, title, filename)
+func (b *bot) callbackNuoviCasiNazione(d *dataSnapshot, cq *echotron.CallbackQuery) {
+	stopRendering := b.showPlotRendering(cq.Message.Chat.ID)
+	defer stopRendering()
 
-		if err != nil {
-			log.Println(err)
-		}
+	filename, err := d.getPlotNuoviPositiviNazione()
+	if err != nil {
+		log.Println(err)
 	}
 
-	buttons, err := b.makeButtons([]string{"Torna alla Home"}, []string{"home"}, 1)
+	buttonsNames, callbackNames := exportButtonsData(exportAreaNation, "")
+	buttons, err := b.makeButtons(append(buttonsNames, "Torna alla Home"), append(callbackNames, "home"), 2)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 
-	b.SendPhotoWithKeyboard(filename, setCaptionConfrontoNazione(len(nationData)-1, []string{"nuovi_positivi"}), cq.Message.Chat.ID, buttons, echotron.PARSE_HTML)
+	b.sendPlotWithKeyboard(filename, d.setCaptionConfrontoNazione(len(d.nation)-1, []string{"nuovi_positivi"}), cq.Message.Chat.ID, buttons, echotron.PARSE_HTML)
 	b.AnswerCallbackQuery(cq.ID, "Nuovi casi", false)
 	b.lastButton = "zonesButtons"
 	b.lastRegion = ""
 	b.lastProvince = ""
 }
 
-func (b *bot) callbackNuoviCasiRegione(cq *echotron.CallbackQuery) {
-	regionId, err := covidgraphs.FindFirstOccurrenceRegion(&regionsData, "denominazione_regione", b.lastRegion)
+func (b *bot) callbackNuoviCasiRegione(d *dataSnapshot, cq *echotron.CallbackQuery) {
+	stopRendering := b.showPlotRendering(cq.Message.Chat.ID)
+	defer stopRendering()
+
+	regionId, err := covidgraphs.FindFirstOccurrenceRegion(&d.regions, "denominazione_regione", b.lastRegion)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 
-	dirPath := workingDirectory + imageFolder
-	title := "Nuovi positivi regione " + regionsData[regionId].Denominazione_regione
+	title := "Nuovi positivi regione " + d.regions[regionId].Denominazione_regione
 	var filename string
 
-	filename = dirPath + covidgraphs.FilenameCreator(title)
-	if !covidgraphs.IsGraphExisting(filename) {
-		err, filename = covidgraphs.VociRegione(&regionsData, []string{"nuovi_positivi"}, 0, regionId, title, filename)
-
-		if err != nil {
-			log.Println(err)
-		}
+	filename = d.getPlotFilename(title)
+	filename, err = renderPlot(filename, func(filename string) (error, string) {
+		return covidgraphs.VociRegione(&d.regions, []string{"nuovi_positivi"}, 0, regionId, title, filename)
+	})
+	if err != nil {
+		log.Println(err)
 	}
 
-	buttons, err := b.makeButtons([]string{"Torna alla Regione", "Torna alla Home"}, []string{b.lastRegion, "home"}, 1)
+	buttonsNames, callbackNames := exportButtonsData(exportAreaRegion, d.regions[regionId].Denominazione_regione)
+	buttons, err := b.makeButtons(append(buttonsNames, "Torna alla Regione", "Torna alla Home"), append(callbackNames, b.lastRegion, "home"), 2)
 	if err != nil {
 		log.Println(err)
 		return
 	}
-	regionLastId, err := covidgraphs.FindLastOccurrenceRegion(&regionsData, "denominazione_regione", b.lastRegion)
+	regionLastId, err := covidgraphs.FindLastOccurrenceRegion(&d.regions, "denominazione_regione", b.lastRegion)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 
-	b.SendPhotoWithKeyboard(filename, setCaptionConfrontoRegione(regionLastId, []string{"nuovi_positivi"}), cq.Message.Chat.ID, buttons, echotron.PARSE_HTML)
+	b.sendPlotWithKeyboard(filename, d.setCaptionConfrontoRegione(regionLastId, []string{"nuovi_positivi"}), cq.Message.Chat.ID, buttons, echotron.PARSE_HTML)
 	b.AnswerCallbackQuery(cq.ID, "Nuovi casi", false)
 	b.lastButton = "province"
 	b.lastProvince = ""
@@ -118,30 +114,30 @@ func (b *bot) callbackConfrontoDatiRegione(cq *echotron.CallbackQuery) {
 	b.lastProvince = ""
 }
 
-func (b *bot) callbackClassificaRegioni(cq *echotron.CallbackQuery) {
+func (b *bot) callbackClassificaRegioni(d *dataSnapshot, cq *echotron.CallbackQuery) {
 	//TODO: grafico a barre classifica
 	homeButton, err := b.makeButtons([]string{"Torna alla Home"}, []string{"home"}, 1)
 	if err != nil {
 		log.Println(err)
 		return
 	}
-	b.SendMessageWithKeyboard(setCaptionTopRegions(), cq.Message.Chat.ID, homeButton, echotron.PARSE_HTML)
+	b.SendMessageWithKeyboard(d.setCaptionTopRegions(), cq.Message.Chat.ID, homeButton, echotron.PARSE_HTML)
 	b.AnswerCallbackQuery(cq.ID, "Classifica zonesButtons", false)
 }
 
-func (b *bot) callbackClassificaProvince(cq *echotron.CallbackQuery) {
+func (b *bot) callbackClassificaProvince(d *dataSnapshot, cq *echotron.CallbackQuery) {
 	//TODO: grafico a barre classifica
 	homeButton, err := b.makeButtons([]string{"Torna alla Home"}, []string{"home"}, 1)
 	if err != nil {
 		log.Println(err)
 		return
 	}
-	b.SendMessageWithKeyboard(setCaptionTopProvinces(), cq.Message.Chat.ID, homeButton, echotron.PARSE_HTML)
+	b.SendMessageWithKeyboard(d.setCaptionTopProvinces(), cq.Message.Chat.ID, homeButton, echotron.PARSE_HTML)
 	b.AnswerCallbackQuery(cq.ID, "Classifica province", false)
 }
 
-func (b *bot) callbackNord(cq *echotron.CallbackQuery) {
-	buttons, err := b.nordRegionsButtons()
+func (b *bot) callbackNord(d *dataSnapshot, cq *echotron.CallbackQuery) {
+	buttons, err := b.nordRegionsButtons(d)
 	if err != nil {
 		log.Println(err)
 	}
@@ -155,8 +151,8 @@ func (b *bot) callbackNord(cq *echotron.CallbackQuery) {
 	b.lastProvince = ""
 }
 
-func (b *bot) callbackCentro(cq *echotron.CallbackQuery) {
-	buttons, err := b.centroRegionsButtons()
+func (b *bot) callbackCentro(d *dataSnapshot, cq *echotron.CallbackQuery) {
+	buttons, err := b.centroRegionsButtons(d)
 	if err != nil {
 		log.Println(err)
 	}
@@ -170,8 +166,8 @@ func (b *bot) callbackCentro(cq *echotron.CallbackQuery) {
 	b.lastProvince = ""
 }
 
-func (b *bot) callbackSud(cq *echotron.CallbackQuery) {
-	buttons, err := b.sudRegionsButtons()
+func (b *bot) callbackSud(d *dataSnapshot, cq *echotron.CallbackQuery) {
+	buttons, err := b.sudRegionsButtons(d)
 	if err != nil {
 		log.Println(err)
 	}
@@ -185,8 +181,8 @@ func (b *bot) callbackSud(cq *echotron.CallbackQuery) {
 	b.lastProvince = ""
 }
 
-func (b *bot) callbackProvince(cq *echotron.CallbackQuery) {
-	provinces := covidgraphs.GetLastProvincesByRegionName(&provincesData, b.lastRegion)
+func (b *bot) callbackProvince(d *dataSnapshot, cq *echotron.CallbackQuery) {
+	provinces := covidgraphs.GetLastProvincesByRegionName(&d.provinces, b.lastRegion)
 	provincesNames := make([]string, 0)
 	for _, v := range *provinces {
 		provincesNames = append(provincesNames, v.Denominazione_provincia)
@@ -210,8 +206,8 @@ func (b *bot) callbackProvince(cq *echotron.CallbackQuery) {
 	b.lastButton = "province"
 }
 
-func (b *bot) callbackHome(cq *echotron.CallbackQuery) {
-	b.sendAndamentoNazionale(cq.Message)
+func (b *bot) callbackHome(d *dataSnapshot, cq *echotron.CallbackQuery) {
+	b.sendAndamentoNazionale(d, cq.Message.Chat.ID)
 	buttons, err := b.mainMenuButtons()
 	if err != nil {
 		log.Println(err)
@@ -225,8 +221,12 @@ func (b *bot) callbackHome(cq *echotron.CallbackQuery) {
 }
 
 func (b *bot) callbackReports(cq *echotron.CallbackQuery) {
-	buttonsNames := []string{"Report generale"}
-	buttonsCallback := []string{"report generale"}
+	buttonsNames := make([]string, 0)
+	buttonsCallback := make([]string, 0)
+	for _, v := range reportsRegistry {
+		buttonsNames = append(buttonsNames, "Report "+v.name)
+		buttonsCallback = append(buttonsCallback, "report "+v.name)
+	}
 	buttonsNames = append(buttonsNames, "Annulla ❌")
 	buttonsCallback = append(buttonsCallback, "annulla")
 	buttons, err := b.makeButtons(buttonsNames, buttonsCallback, 1)
@@ -239,81 +239,49 @@ func (b *bot) callbackReports(cq *echotron.CallbackQuery) {
 	b.lastButton = "reports"
 }
 
-func (b *bot) callbackReportGenerale(cq *echotron.CallbackQuery) {
-	buttons, err := b.makeButtons([]string{"Genera file", "Torna alla Home"}, []string{"genera_file", "home"}, 1)
-	if err != nil {
-		log.Println("Errore", err)
-		return
-	}
-
-	msg := setCaptionAndamentoNazionale() + "\n\n\n" + setCaptionTopRegions() + "\n" + setCaptionTopProvinces()
-	b.SendMessageWithKeyboard(msg, cq.Message.Chat.ID, buttons, echotron.PARSE_HTML)
-	b.AnswerCallbackQuery(cq.ID, "Report generale", false)
-	b.lastButton = "report generale"
-}
-
-func (b *bot) callbackGeneraFile(cq *echotron.CallbackQuery) {
-	msg := setCaptionAndamentoNazionale() + "\n\n\n" + setCaptionTopRegions() + "\n" + setCaptionTopProvinces()
+func (b *bot) callbackGeneraFile(d *dataSnapshot, cq *echotron.CallbackQuery) {
 	switch b.lastButton {
-	case "report generale":
-		filename := "report generale-" + time.Now().Format("20060102T150405") + ".txt"
-		f, err := os.Create(filename)
-		if err != nil {
-			log.Println(err)
-			return
-		}
-		_, err = f.WriteString(msg)
-		if err != nil {
-			log.Println(err)
-			f.Close()
+	case "report":
+		report, ok := getReport(b.lastReport)
+		if !ok {
 			b.AnswerCallbackQuery(cq.ID, "Si è verificato un errore", false)
 			return
 		}
-		err = f.Close()
-		if err != nil {
-			log.Println(err)
-			b.AnswerCallbackQuery(cq.ID, "Si è verificato un errore", false)
-			return
-		}
-		b.SendDocument(filename, "", cq.Message.Chat.ID)
-		b.AnswerCallbackQuery(cq.ID, "Report generato", false)
-		err = os.Remove(filename)
-		if err != nil {
-			log.Println("can't delete file " + filename)
-		}
+		b.AnswerCallbackQuery(cq.ID, "Generazione del report in corso", false)
+		b.sendReport(d, cq.Message.Chat.ID, report, b.lastReportArgument, reportFormatPDF)
 	}
 }
 
 // Recognizes the callback of regions named buttons
-func (b *bot) caseRegion(cq *echotron.CallbackQuery) {
-	regionIndex, err := covidgraphs.FindLastOccurrenceRegion(&regionsData, "denominazione_regione", cq.Data)
+func (b *bot) caseRegion(d *dataSnapshot, cq *echotron.CallbackQuery) {
+	regionIndex, err := covidgraphs.FindLastOccurrenceRegion(&d.regions, "denominazione_regione", cq.Data)
 	if err != nil {
 		b.AnswerCallbackQuery(cq.ID, "Si è verificato un errore", false)
 		return
 	}
 	b.DeleteMessage(cq.Message.Chat.ID, cq.Message.ID)
-	b.sendAndamentoRegionale(cq.Message, regionIndex)
+	b.sendAndamentoRegionale(d, cq.Message.Chat.ID, regionIndex)
 	buttons, err := b.provinceButtons()
 	if err != nil {
 		log.Println(err)
 		return
 	}
 	b.SendMessageWithKeyboard("Opzioni disponibili:", cq.Message.Chat.ID, buttons)
-	b.AnswerCallbackQuery(cq.ID, "Regione "+regionsData[regionIndex].Denominazione_regione, false)
+	b.AnswerCallbackQuery(cq.ID, "Regione "+d.regions[regionIndex].Denominazione_regione, false)
 	b.lastButton = cq.Data
 	b.lastRegion = cq.Data
 	b.lastProvince = ""
 }
 
 // Recognizes the callback of regions named buttons
-func (b *bot) caseProvince(cq *echotron.CallbackQuery) {
-	provinceIndex, err := covidgraphs.FindLastOccurrenceProvince(&provincesData, "denominazione_provincia", cq.Data)
+func (b *bot) caseProvince(d *dataSnapshot, cq *echotron.CallbackQuery) {
+	provinceIndex, err := covidgraphs.FindLastOccurrenceProvince(&d.provinces, "denominazione_provincia", cq.Data)
 	if err != nil {
 		log.Printf("province not found %v", err)
 		b.AnswerCallbackQuery(cq.ID, "Si è verificato un errore", false)
 		return
 	}
-	b.sendAndamentoProvinciale(cq, provinceIndex)
+	b.sendAndamentoProvinciale(d, cq, provinceIndex)
 }
 
 // Handles "Annulla" button callback to go back according to the current context
@@ -381,7 +349,7 @@ func (b *bot) back(cq *echotron.CallbackQuery) {
 }
 
 // Handles "Confronto dati regione" selected fields
-func (b *bot) caseConfrontoRegione(cq *echotron.CallbackQuery) error {
+func (b *bot) caseConfrontoRegione(d *dataSnapshot, cq *echotron.CallbackQuery) error {
 	buttonsNames := []string{"Ricoverati con sintomi", "Terapia intensiva", "Totale ospedalizzati", "Isolamento domiciliare", "Attualmente positivi", "Nuovi positivi", "Dimessi guariti", "Deceduti", "Totale casi", "Tamponi"}
 	buttonsCallback := make([]string, 0)
 	for _, v := range buttonsNames {
@@ -491,7 +459,7 @@ func (b *bot) caseConfrontoRegione(cq *echotron.CallbackQuery) error {
 		break
 	case "fatto regione":
 		b.DeleteMessage(cq.Message.Chat.ID, cq.Message.ID)
-		b.sendConfrontoDatiRegione(cq)
+		b.sendConfrontoDatiRegione(d, cq)
 		b.choicesConfrontoRegione = make([]string, 0)
 		break
 	default:
@@ -502,7 +470,7 @@ func (b *bot) caseConfrontoRegione(cq *echotron.CallbackQuery) error {
 }
 
 // Handles "Confronto dati nazione" selected fields
-func (b *bot) caseConfrontoNazione(cq *echotron.CallbackQuery) error {
+func (b *bot) caseConfrontoNazione(d *dataSnapshot, cq *echotron.CallbackQuery) error {
 	buttonsNames := []string{"Ricoverati con sintomi", "Terapia intensiva", "Totale ospedalizzati", "Isolamento domiciliare", "Attualmente positivi", "Nuovi positivi", "Dimessi guariti", "Deceduti", "Totale casi", "Tamponi"}
 	buttonsCallback := make([]string, 0)
 	for _, v := range buttonsNames {
@@ -612,7 +580,7 @@ func (b *bot) caseConfrontoNazione(cq *echotron.CallbackQuery) error {
 		break
 	case "fatto nazione":
 		b.DeleteMessage(cq.Message.Chat.ID, cq.Message.ID)
-		b.sendConfrontoDatiNazione(cq)
+		b.sendConfrontoDatiNazione(d, cq)
 		b.choicesConfrontoNazione = make([]string, 0)
 		break
 	default:
@@ -623,11 +591,11 @@ func (b *bot) caseConfrontoNazione(cq *echotron.CallbackQuery) error {
 }
 
 // Handles in group callbacks for selected nation fields to compare
-func (b *bot) caseInGroupNationAttr(cq *echotron.CallbackQuery) error {
+func (b *bot) caseInGroupNationAttr(d *dataSnapshot, cq *echotron.CallbackQuery) error {
 	switch strings.ToLower(cq.Data) {
 	case "andamento nazione groups":
 		b.DeleteMessage(cq.Message.Chat.ID, cq.Message.ID)
-		b.sendAndamentoNazionale(cq.Message)
+		b.sendAndamentoNazionale(d, cq.Message.Chat.ID)
 		break
 	case "previous nazione groups":
 		id := b.lastGroupAttrIndex
@@ -791,9 +759,9 @@ func (b *bot) caseInGroupNationAttr(cq *echotron.CallbackQuery) error {
 	case "fatto nazione groups":
 		b.DeleteMessage(cq.Message.Chat.ID, cq.Message.ID)
 		if len(b.choicesConfrontoNazione) == 0 {
-			b.sendAndamentoNazionale(cq.Message)
+			b.sendAndamentoNazionale(d, cq.Message.Chat.ID)
 		} else {
-			b.sendConfrontoDatiNazione(cq)
+			b.sendConfrontoDatiNazione(d, cq)
 		}
 		b.choicesConfrontoNazione = make([]string, 0)
 		b.lastGroupRegionIndex = 0
@@ -808,15 +776,15 @@ func (b *bot) caseInGroupNationAttr(cq *echotron.CallbackQuery) error {
 }
 
 // Handles in group callbacks for selected region fields to compare
-func (b *bot) caseInGroupRegionAttr(cq *echotron.CallbackQuery) error {
+func (b *bot) caseInGroupRegionAttr(d *dataSnapshot, cq *echotron.CallbackQuery) error {
 	switch strings.ToLower(cq.Data) {
 	case "andamento region attr groups":
-		regionIndex, err := covidgraphs.FindLastOccurrenceRegion(&regionsData, "denominazione_regione", b.lastRegion)
+		regionIndex, err := covidgraphs.FindLastOccurrenceRegion(&d.regions, "denominazione_regione", b.lastRegion)
 		if err != nil {
 			return err
 		}
 		b.DeleteMessage(cq.Message.Chat.ID, cq.Message.ID)
-		b.sendAndamentoRegionale(cq.Message, regionIndex)
+		b.sendAndamentoRegionale(d, cq.Message.Chat.ID, regionIndex)
 		break
 	case "previous region attr groups":
 		id := b.lastGroupAttrIndex
@@ -981,13 +949,13 @@ func (b *bot) caseInGroupRegionAttr(cq *echotron.CallbackQuery) error {
 	case "fatto region attr groups":
 		b.DeleteMessage(cq.Message.Chat.ID, cq.Message.ID)
 		if len(b.choicesConfrontoRegione) == 0 {
-			regionIndex, err := covidgraphs.FindLastOccurrenceRegion(&regionsData, "denominazione_regione", b.lastRegion)
+			regionIndex, err := covidgraphs.FindLastOccurrenceRegion(&d.regions, "denominazione_regione", b.lastRegion)
 			if err != nil {
 				return err
 			}
-			b.sendAndamentoRegionale(cq.Message, regionIndex)
+			b.sendAndamentoRegionale(d, cq.Message.Chat.ID, regionIndex)
 		} else {
-			b.sendConfrontoDatiRegione(cq)
+			b.sendConfrontoDatiRegione(d, cq)
 		}
 		b.choicesConfrontoRegione = make([]string, 0)
 		b.lastGroupRegionIndex = 0
@@ -1102,7 +1070,7 @@ func (b *bot) caseInGroupZone(cq *echotron.CallbackQuery) error {
 	return nil
 }
 
-func (b *bot) caseInGroupRegion(cq *echotron.CallbackQuery) error {
+func (b *bot) caseInGroupRegion(d *dataSnapshot, cq *echotron.CallbackQuery) error {
 	switch strings.ToLower(cq.Data) {
 	case "previous region groups":
 		id := b.lastGroupRegionIndex
@@ -1138,7 +1106,7 @@ func (b *bot) caseInGroupRegion(cq *echotron.CallbackQuery) error {
 		b.DeleteMessage(cq.Message.Chat.ID, cq.Message.ID)
 		break
 	}
-	_, err := covidgraphs.FindLastOccurrenceRegion(&regionsData, "denominazione_regione", strings.Replace(cq.Data, " groups", "", -1))
+	_, err := covidgraphs.FindLastOccurrenceRegion(&d.regions, "denominazione_regione", strings.Replace(cq.Data, " groups", "", -1))
 	if err == nil {
 		buttons, err := b.buttonsConfrontoRegioneGroups(0)
 		if err != nil {
@@ -1252,7 +1220,7 @@ func (b *bot) caseInGroupZoneP(cq *echotron.CallbackQuery) error {
 	return nil
 }
 
-func (b *bot) caseInGroupRegionP(cq *echotron.CallbackQuery) error {
+func (b *bot) caseInGroupRegionP(d *dataSnapshot, cq *echotron.CallbackQuery) error {
 	switch strings.ToLower(cq.Data) {
 	case "previous region p groups":
 		id := b.lastGroupRegionIndex
@@ -1288,9 +1256,9 @@ func (b *bot) caseInGroupRegionP(cq *echotron.CallbackQuery) error {
 		b.DeleteMessage(cq.Message.Chat.ID, cq.Message.ID)
 		break
 	}
-	_, err := covidgraphs.FindLastOccurrenceRegion(&regionsData, "denominazione_regione", strings.Replace(cq.Data, " p groups", "", -1))
+	_, err := covidgraphs.FindLastOccurrenceRegion(&d.regions, "denominazione_regione", strings.Replace(cq.Data, " p groups", "", -1))
 	if err == nil {
-		buttons, err, _, _ := b.buttonsProvincesGroup(0, b.lastRegion)
+		buttons, err, _, _ := b.buttonsProvincesGroup(d, 0, b.lastRegion)
 		if err != nil {
 			return err
 		}
@@ -1303,13 +1271,13 @@ func (b *bot) caseInGroupRegionP(cq *echotron.CallbackQuery) error {
 	return err
 }
 
-func (b *bot) caseInGroupProvince(cq *echotron.CallbackQuery) error {
+func (b *bot) caseInGroupProvince(d *dataSnapshot, cq *echotron.CallbackQuery) error {
 	switch strings.ToLower(cq.Data) {
 	case "previous province groups":
 		id := b.lastGroupProvinceIndex
 		id--
 
-		buttons, err, lastProvinceName, lastIndex := b.buttonsProvincesGroup(id, b.lastRegion)
+		buttons, err, lastProvinceName, lastIndex := b.buttonsProvincesGroup(d, id, b.lastRegion)
 		if err != nil {
 			return err
 		}
@@ -1323,7 +1291,7 @@ func (b *bot) caseInGroupProvince(cq *echotron.CallbackQuery) error {
 		id := b.lastGroupProvinceIndex
 		id++
 
-		buttons, err, lastProvinceName, lastIndex := b.buttonsProvincesGroup(id, b.lastRegion)
+		buttons, err, lastProvinceName, lastIndex := b.buttonsProvincesGroup(d, id, b.lastRegion)
 		if err != nil {
 			return err
 		}
@@ -1339,10 +1307,292 @@ func (b *bot) caseInGroupProvince(cq *echotron.CallbackQuery) error {
 		b.DeleteMessage(cq.Message.Chat.ID, cq.Message.ID)
 		break
 	}
-	provinceId, err := covidgraphs.FindLastOccurrenceProvince(&provincesData, "denominazione_provincia", strings.Replace(cq.Data, " province", "", -1))
+	provinceId, err := covidgraphs.FindLastOccurrenceProvince(&d.provinces, "denominazione_provincia", strings.Replace(cq.Data, " province", "", -1))
 	if err == nil {
-		b.sendAndamentoProvinciale(cq, provinceId)
+		b.sendAndamentoProvinciale(d, cq, provinceId)
 	}
 
 	return err
 }
+
+// Handles the alerts wizard callbacks
+func (b *bot) caseAvviso(d *dataSnapshot, cq *echotron.CallbackQuery) error {
+	data := strings.ToLower(cq.Data)
+	if !strings.HasPrefix(data, "avviso ") {
+		return fmt.Errorf("not an avviso case")
+	}
+	tokens := strings.SplitN(strings.TrimPrefix(data, "avviso "), " ", 2)
+	var arg string
+	if len(tokens) > 1 {
+		arg = tokens[1]
+	}
+
+	switch tokens[0] {
+	case "area", "metrica", "condizione", "valore":
+		if !b.isAlertDraftUser(cq.User) {
+			b.AnswerCallbackQuery(cq.ID, "Solo chi ha iniziato la creazione dell'avviso può completarla", true)
+			return nil
+		}
+	case "annulla":
+		if b.alertDraftUser != 0 && !b.isAlertDraftUser(cq.User) {
+			b.AnswerCallbackQuery(cq.ID, "Solo chi ha iniziato la creazione dell'avviso può completarla", true)
+			return nil
+		}
+	case "nuovo", "elimina":
+		if !b.isChatAdmin(cq.Message.Chat, cq.User) {
+			b.AnswerCallbackQuery(cq.ID, "Solo gli amministratori possono modificare gli avvisi", true)
+			return nil
+		}
+	}
+
+	switch tokens[0] {
+	case "nuovo":
+		b.alertDraft = alertRule{ChatId: cq.Message.Chat.ID}
+		b.alertDraftUser = cq.User.ID
+		b.awaitingAlertValue = false
+		buttons, err := b.alertAreaButtons()
+		if err != nil {
+			return err
+		}
+		b.EditMessageTextWithKeyboard(cq.Message.Chat.ID, cq.Message.ID, "Scegli la zona da monitorare:", buttons)
+		b.AnswerCallbackQuery(cq.ID, "Nuovo avviso", false)
+	case "lista":
+		rules := getChatAlerts(cq.Message.Chat.ID)
+		buttons, err := b.alertListButtons(rules)
+		if err != nil {
+			return err
+		}
+		b.EditMessageTextWithKeyboard(cq.Message.Chat.ID, cq.Message.ID, setCaptionAlerts(rules), buttons, echotron.PARSE_HTML)
+		b.AnswerCallbackQuery(cq.ID, "I miei avvisi", false)
+	case "area":
+		b.alertDraft.Area = arg
+		buttons, err := b.alertMetricButtons()
+		if err != nil {
+			return err
+		}
+		b.EditMessageTextWithKeyboard(cq.Message.Chat.ID, cq.Message.ID, "Scegli il dato da monitorare:", buttons)
+		b.AnswerCallbackQuery(cq.ID, "", false)
+	case "metrica":
+		b.alertDraft.Metric = arg
+		buttons, err := b.alertConditionButtons()
+		if err != nil {
+			return err
+		}
+		b.EditMessageTextWithKeyboard(cq.Message.Chat.ID, cq.Message.ID, "Quando vuoi essere avvisato?", buttons)
+		b.AnswerCallbackQuery(cq.ID, "", false)
+	case "condizione":
+		b.alertDraft.Condition = arg
+		buttons, err := b.alertValueButtons(b.alertDraft.Metric, b.alertDraft.Condition)
+		if err != nil {
+			return err
+		}
+		msg := "Per quanti giorni consecutivi?"
+		if arg == alertAbove || arg == alertBelow {
+			msg = "Scegli la soglia oppure scrivi il valore che preferisci:"
+			b.awaitingAlertValue = true
+		}
+		b.EditMessageTextWithKeyboard(cq.Message.Chat.ID, cq.Message.ID, msg, buttons)
+		b.AnswerCallbackQuery(cq.ID, "", false)
+	case "valore":
+		value, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return err
+		}
+		b.alertDraft.Value = value
+		b.DeleteMessage(cq.Message.Chat.ID, cq.Message.ID)
+		b.saveAlertDraft(d, cq.Message.Chat.ID)
+		b.AnswerCallbackQuery(cq.ID, "Avviso creato", false)
+	case "elimina":
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return err
+		}
+		err = deleteAlert(cq.Message.Chat.ID, id)
+		if err != nil {
+			log.Println(err)
+			b.AnswerCallbackQuery(cq.ID, "Si è verificato un errore", false)
+			return nil
+		}
+		rules := getChatAlerts(cq.Message.Chat.ID)
+		buttons, err := b.alertListButtons(rules)
+		if err != nil {
+			return err
+		}
+		b.EditMessageTextWithKeyboard(cq.Message.Chat.ID, cq.Message.ID, setCaptionAlerts(rules), buttons, echotron.PARSE_HTML)
+		b.AnswerCallbackQuery(cq.ID, "Avviso eliminato", false)
+	case "annulla":
+		b.alertDraft = alertRule{}
+		b.alertDraftUser = 0
+		b.awaitingAlertValue = false
+		b.DeleteMessage(cq.Message.Chat.ID, cq.Message.ID)
+		b.AnswerCallbackQuery(cq.ID, "Annulla", false)
+	default:
+		return fmt.Errorf("not an avviso case")
+	}
+
+	return nil
+}
+
+// Recognizes the callbacks of the scheduled posts list
+func (b *bot) caseProgramma(cq *echotron.CallbackQuery) error {
+	data := strings.ToLower(cq.Data)
+	if !strings.HasPrefix(data, "programma ") {
+		return fmt.Errorf("not a programma case")
+	}
+	tokens := strings.Fields(strings.TrimPrefix(data, "programma "))
+	if len(tokens) == 0 {
+		return fmt.Errorf("not a programma case")
+	}
+
+	if !b.isChatAdmin(cq.Message.Chat, cq.User) {
+		b.AnswerCallbackQuery(cq.ID, "Solo gli amministratori possono modificare la programmazione", true)
+		return nil
+	}
+
+	switch tokens[0] {
+	case "elimina":
+		if len(tokens) != 2 {
+			return fmt.Errorf("not a programma case")
+		}
+		id, err := strconv.Atoi(tokens[1])
+		if err != nil {
+			return err
+		}
+		err = deleteSchedule(cq.Message.Chat.ID, id)
+		if err != nil {
+			log.Println(err)
+			b.AnswerCallbackQuery(cq.ID, "Si è verificato un errore", false)
+			return nil
+		}
+		posts := getChatSchedules(cq.Message.Chat.ID)
+		buttons, err := b.scheduleListButtons(posts)
+		if err != nil {
+			return err
+		}
+		b.EditMessageTextWithKeyboard(cq.Message.Chat.ID, cq.Message.ID, setCaptionSchedules(posts), buttons, echotron.PARSE_HTML)
+		b.AnswerCallbackQuery(cq.ID, "Aggiornamento eliminato", false)
+	case digestReportName:
+		post := scheduledPost{
+			ChatId: cq.Message.Chat.ID,
+			Time:   digestScheduleTime,
+			Days:   digestScheduleDays,
+			Kind:   scheduleKindReport,
+			Target: digestReportName,
+		}
+		if existing, ok := findSchedule(post); ok {
+			b.SendMessage("ℹ️ <b>Aggiornamento già programmato</b>\n"+existing.describe()+"\n\nDigita /programma <code>lista</code> per gestire gli aggiornamenti.", cq.Message.Chat.ID, echotron.PARSE_HTML)
+			b.AnswerCallbackQuery(cq.ID, "Sei già iscritto", false)
+			return nil
+		}
+		post, err := addSchedule(post)
+		if err != nil {
+			log.Println(err)
+			b.AnswerCallbackQuery(cq.ID, "Si è verificato un errore", false)
+			return nil
+		}
+		b.SendMessage("✅ <b>Aggiornamento programmato</b>\n"+post.describe()+"\n\nDigita /programma <code>lista</code> per gestire gli aggiornamenti.", cq.Message.Chat.ID, echotron.PARSE_HTML)
+		b.AnswerCallbackQuery(cq.ID, "Iscrizione effettuata", false)
+	case "chiudi":
+		b.DeleteMessage(cq.Message.Chat.ID, cq.Message.ID)
+		b.AnswerCallbackQuery(cq.ID, "Chiudi", false)
+	default:
+		return fmt.Errorf("not a programma case")
+	}
+
+	return nil
+}
+
+// Recognizes the callbacks of the export buttons under the charts
+func (b *bot) caseEsporta(d *dataSnapshot, cq *echotron.CallbackQuery) error {
+	if !strings.HasPrefix(strings.ToLower(cq.Data), "esporta ") {
+		return fmt.Errorf("not an esporta case")
+	}
+	tokens := strings.SplitN(cq.Data, " ", 4)
+	if len(tokens) < 3 {
+		return fmt.Errorf("not an esporta case")
+	}
+
+	request := exportRequest{area: strings.ToLower(tokens[1]), format: strings.ToLower(tokens[2])}
+	if len(tokens) == 4 {
+		request.name = tokens[3]
+	}
+	b.AnswerCallbackQuery(cq.ID, "Esportazione in corso", false)
+	b.sendExport(d, cq.Message.Chat.ID, request)
+
+	return nil
+}
+
+// Recognizes the callbacks of the reports menu, asking for the region or the province when needed
+func (b *bot) caseReport(d *dataSnapshot, cq *echotron.CallbackQuery) error {
+	if !strings.HasPrefix(strings.ToLower(cq.Data), "report ") {
+		return fmt.Errorf("not a report case")
+	}
+	tokens := strings.SplitN(cq.Data, " ", 3)
+	report, ok := getReport(tokens[1])
+	if !ok {
+		return fmt.Errorf("not a report case")
+	}
+	var argument string
+	if len(tokens) == 3 {
+		argument = tokens[2]
+	}
+
+	if report.argument == reportArgumentRegion && argument == "" {
+		if len(d.regions) < 21 {
+			b.AnswerCallbackQuery(cq.ID, "Si è verificato un errore", false)
+			return nil
+		}
+		// The dataset has a row for every region and day, the names are taken from the last day
+		lastRegionsData := d.regions[len(d.regions)-21:]
+		regions := covidgraphs.GetRegionsNamesList(&lastRegionsData)
+		callbackNames := make([]string, 0)
+		for _, v := range regions {
+			callbackNames = append(callbackNames, "report "+report.name+" "+v)
+		}
+		buttons, err := b.makeButtons(append(regions, "Annulla ❌"), append(callbackNames, "annulla"), 2)
+		if err != nil {
+			return err
+		}
+		b.SendMessageWithKeyboard("Seleziona la regione:", cq.Message.Chat.ID, buttons)
+		b.AnswerCallbackQuery(cq.ID, "Report "+report.name, false)
+		return nil
+	} else if report.argument == reportArgumentProvince && argument == "" {
+		b.SendMessage("Digita /reports <code>"+report.usage()+"</code> per ottenere il report della provincia che preferisci.", cq.Message.Chat.ID, echotron.PARSE_HTML)
+		b.AnswerCallbackQuery(cq.ID, "Report "+report.name, false)
+		return nil
+	}
+
+	buttonsNames := []string{"Torna alla Home"}
+	callbackNames := []string{"home"}
+	if report.name == digestReportName {
+		buttonsNames = append([]string{"Ricevilo ogni lunedì 📬"}, buttonsNames...)
+		callbackNames = append([]string{"programma " + digestReportName}, callbackNames...)
+	}
+	if _, ok := report.builders[reportFormatPDF]; ok {
+		buttonsNames = append([]string{"Genera PDF"}, buttonsNames...)
+		callbackNames = append([]string{"genera_file"}, callbackNames...)
+	}
+	buttons, err := b.makeButtons(buttonsNames, callbackNames, 1)
+	if err != nil {
+		return err
+	}
+
+	b.lastButton = "report"
+	b.lastReport = report.name
+	b.lastReportArgument = argument
+	if _, ok := report.builders[reportFormatText]; !ok {
+		b.AnswerCallbackQuery(cq.ID, "Generazione del report in corso", false)
+		b.sendReport(d, cq.Message.Chat.ID, report, argument, reportFormatPDF)
+		return nil
+	}
+	msg, err := report.builders[reportFormatText](d, argument)
+	if err != nil {
+		log.Println(err)
+		b.AnswerCallbackQuery(cq.ID, "Si è verificato un errore", false)
+		return nil
+	}
+	b.SendMessageWithKeyboard(msg, cq.Message.Chat.ID, buttons, echotron.PARSE_HTML)
+	b.AnswerCallbackQuery(cq.ID, "Report "+report.name, false)
+
+	return nil
+}
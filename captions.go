@@ -7,316 +7,237 @@ import (
 	"time"
 )
 
-// Returns the caption for the national trend plot image
-func setCaptionAndamentoNazionale() string {
-	lastIndex := len(nationData) - 1
-	_, nuoviTotale := covidgraphs.CalculateDelta(nationData[lastIndex-1].Totale_positivi, nationData[lastIndex].Totale_positivi)
-	_, nuoviGuariti := covidgraphs.CalculateDelta(nationData[lastIndex-1].Dimessi_guariti, nationData[lastIndex].Dimessi_guariti)
-	_, nuoviMorti := covidgraphs.CalculateDelta(nationData[lastIndex-1].Deceduti, nationData[lastIndex].Deceduti)
-	_, nuoviPositivi := covidgraphs.CalculateDelta(nationData[lastIndex-1].Nuovi_positivi, nationData[lastIndex].Nuovi_positivi)
-	data, err := time.Parse("2006-01-02T15:04:05", nationData[lastIndex].Data)
-	if err != nil {
-		log.Println("error parsing data in setCaptionAndamentoNazionale()")
-	}
-
-	msg := "<b>Andamento nazionale " + data.Format("2006-01-02") + "</b>\n\n" +
-		"\n<b>Attualmente positivi: </b>" + strconv.Itoa(nationData[lastIndex].Totale_positivi) + " (<i>" + nuoviTotale + "</i>)" +
-		"\n<b>Guariti: </b>" + strconv.Itoa(nationData[lastIndex].Dimessi_guariti) + " (<i>" + nuoviGuariti + "</i>)" +
-		"\n<b>Morti: </b>" + strconv.Itoa(nationData[lastIndex].Deceduti) + " (<i>" + nuoviMorti + "</i>)" +
-		"\n\n<b>Nuovi positivi: </b>" + strconv.Itoa(nationData[lastIndex].Nuovi_positivi) + " (<i>" + nuoviPositivi + "</i>)"
-
-	if nationData[len(nationData)-1].Note_it != "" {
-		i, err := covidgraphs.FindFirstOccurrenceNote(&datiNote, "codice", nationData[len(nationData)-1].Note_it)
+// Returns the values of the given fields compared with the previous row
+func getCaptionValues(previous, current covidgraphs.RegionData, fields []string, anomalies []dataAnomaly) map[string]captionValue {
+	values := make(map[string]captionValue)
+	for _, field := range fields {
+		previousValue, err := getRegionField(previous, field)
 		if err != nil {
-			log.Println("errore nella ricerca della nota col codice indicato")
-		} else {
-			var campoProvincia string
-			if datiNote[i].Provincia != "" {
-				campoProvincia = ", " + datiNote[i].Provincia
-			}
-			var notesField string
-			if datiNote[i].Note != "" {
-				notesField = ", " + datiNote[i].Note
-			}
-			msg += "\n\n<b>Note:</b>\n[<i>" + datiNote[i].Tipologia_avviso + "] " + datiNote[i].Regione + campoProvincia + ": " + datiNote[i].Avviso + notesField + "</i>"
+			log.Println(err)
+			continue
 		}
+		currentValue, _ := getRegionField(current, field)
+		_, delta := covidgraphs.CalculateDelta(previousValue, currentValue)
+		values[field] = captionValue{Value: currentValue, Delta: delta, Anomaly: markAnomaly(anomalies, field)}
 	}
-
-	return msg
+	return values
 }
 
-// Returns the caption for the regions top 10
-func setCaptionTopRegions() string {
-	top := covidgraphs.GetTopTenRegionsTotaleContagi(&regionsData)
-	var msg = "<b>Top " + strconv.Itoa(nTopRegions) + " regioni per contagi</b>\n\n"
-	for i := 0; i < nTopRegions; i++ {
-		msg += "<b>" + strconv.Itoa(i+1) + ". </b>" + (*top)[i].Denominazione_regione + " (<code>" + strconv.Itoa((*top)[i].Totale_casi) + "</code>)\n"
+// Returns the text of the pcm-dpc note with the given code, empty if there isn't any
+func (d *dataSnapshot) getNoteText(code string) string {
+	if code == "" {
+		return ""
+	}
+	i, err := covidgraphs.FindFirstOccurrenceNote(&d.notes, "codice", code)
+	if err != nil {
+		log.Println("errore nella ricerca della nota col codice indicato")
+		return ""
 	}
 
-	return msg
+	var campoProvincia string
+	if d.notes[i].Provincia != "" {
+		campoProvincia = ", " + d.notes[i].Provincia
+	}
+	var notesField string
+	if d.notes[i].Note != "" {
+		notesField = ", " + d.notes[i].Note
+	}
+	return "[<i>" + d.notes[i].Tipologia_avviso + "] " + d.notes[i].Regione + campoProvincia + ": " + d.notes[i].Avviso + notesField + "</i>"
 }
 
-// Returns the caption for the provinces top 10
-func setCaptionTopProvinces() string {
-	top := covidgraphs.GetTopTenProvincesTotaleContagi(&provincesData)
-	var msg = "<b>Top " + strconv.Itoa(nTopRegions) + " province per contagi</b>\n\n"
-	for i := 0; i < nTopRegions; i++ {
-		msg += "<b>" + strconv.Itoa(i+1) + ". </b>" + (*top)[i].Denominazione_provincia + " (<code>" + strconv.Itoa((*top)[i].Totale_casi) + "</code>)\n"
+// Returns the explanations of the anomalies
+func getAnomaliesExplanations(anomalies []dataAnomaly) []string {
+	explanations := make([]string, 0)
+	for _, v := range anomalies {
+		explanations = append(explanations, v.explanation)
 	}
-
-	return msg
+	return explanations
 }
 
-// Returns the caption for a regional trend plot image
-func setCaptionRegion(regionId int) string {
-	_, nuoviTotale := covidgraphs.CalculateDelta(regionsData[regionId-21].Totale_casi, regionsData[regionId].Totale_casi)
-	_, nuoviGuariti := covidgraphs.CalculateDelta(regionsData[regionId-21].Dimessi_guariti, regionsData[regionId].Dimessi_guariti)
-	_, nuoviMorti := covidgraphs.CalculateDelta(regionsData[regionId-21].Deceduti, regionsData[regionId].Deceduti)
-	_, nuoviPositivi := covidgraphs.CalculateDelta(regionsData[regionId-21].Nuovi_positivi, regionsData[regionId].Nuovi_positivi)
-	_, nuoviRicoveratiConSintomi := covidgraphs.CalculateDelta(regionsData[regionId-21].Ricoverati_con_sintomi, regionsData[regionId].Ricoverati_con_sintomi)
-	_, nuoviTerapiaIntensiva := covidgraphs.CalculateDelta(regionsData[regionId-21].Terapia_intensiva, regionsData[regionId].Terapia_intensiva)
-	_, nuoviOspedalizzati := covidgraphs.CalculateDelta(regionsData[regionId-21].Totale_ospedalizzati, regionsData[regionId].Totale_ospedalizzati)
-	_, nuoviIsolamentoDomiciliare := covidgraphs.CalculateDelta(regionsData[regionId-21].Isolamento_domiciliare, regionsData[regionId].Isolamento_domiciliare)
-	_, nuoviTamponi := covidgraphs.CalculateDelta(regionsData[regionId-21].Tamponi, regionsData[regionId].Tamponi)
-	data, err := time.Parse("2006-01-02T15:04:05", regionsData[regionId].Data)
+// Executes a caption template, logging the error if it fails
+func executeCaptionTemplate(name string, data interface{}) string {
+	msg, err := executeTemplate(name, data)
 	if err != nil {
-		log.Println("error parsing data in setCaptionRegion()")
+		log.Println(err)
 	}
+	return msg
+}
 
-	msg := "<b>Andamento regione " + regionsData[regionId].Denominazione_regione + " " + data.Format("2006-01-02") + "</b>\n\n" +
-		"\n<b>Totale positivi: </b>" + strconv.Itoa(regionsData[regionId].Totale_casi) + " (<i>" + nuoviTotale + "</i>)" +
-		"\n<b>Guariti: </b>" + strconv.Itoa(regionsData[regionId].Dimessi_guariti) + " (<i>" + nuoviGuariti + "</i>)" +
-		"\n<b>Morti: </b>" + strconv.Itoa(regionsData[regionId].Deceduti) + " (<i>" + nuoviMorti + "</i>)" +
-		"\n<b>Nuovi positivi: </b>" + strconv.Itoa(regionsData[regionId].Nuovi_positivi) + " (<i>" + nuoviPositivi + "</i>)" +
-		"\n\n<b>Ricoverati con sintomi: </b>" + strconv.Itoa(regionsData[regionId].Ricoverati_con_sintomi) + " (<i>" + nuoviRicoveratiConSintomi + "</i>)" +
-		"\n<b>Terapia intensiva: </b>" + strconv.Itoa(regionsData[regionId].Terapia_intensiva) + " (<i>" + nuoviTerapiaIntensiva + "</i>)" +
-		"\n<b>Totale ospedalizzati: </b>" + strconv.Itoa(regionsData[regionId].Totale_ospedalizzati) + " (<i>" + nuoviOspedalizzati + "</i>)" +
-		"\n<b>Isolamento domiciliare: </b>" + strconv.Itoa(regionsData[regionId].Isolamento_domiciliare) + " (<i>" + nuoviIsolamentoDomiciliare + "</i>)" +
-		"\n<b>Tamponi effettuati: </b>" + strconv.Itoa(regionsData[regionId].Tamponi) + " (<i>" + nuoviTamponi + "</i>)"
+// Returns the national trend data shown in captions
+func (d *dataSnapshot) getCaptionAndamentoNazionale() captionAndamento {
+	return d.getCaptionNation(len(d.nation) - 1)
+}
 
-	if regionsData[regionId].Note_it != "" {
-		i, err := covidgraphs.FindFirstOccurrenceNote(&datiNote, "codice", regionsData[regionId].Note_it)
-		if err != nil {
-			log.Println("errore nella ricerca della nota col codice indicato")
-		}
+// Returns the national data of the given day shown in captions
+func (d *dataSnapshot) getCaptionNation(nationId int) captionAndamento {
+	data, err := time.Parse("2006-01-02T15:04:05", d.nation[nationId].Data)
+	if err != nil {
+		log.Println("error parsing data in getCaptionNation()")
+	}
+	anomalies := d.nationAnomalies[nationId]
 
-		var campoProvincia string
-		if datiNote[i].Provincia != "" {
-			campoProvincia = ", " + datiNote[i].Provincia
-		}
-		var notesField string
-		if datiNote[i].Note != "" {
-			notesField = ", " + datiNote[i].Note
-		}
-		msg += "\n\n<b>Note:</b>\n[<i>" + datiNote[i].Tipologia_avviso + "] " + datiNote[i].Regione + campoProvincia + ": " + datiNote[i].Avviso + notesField + "</i>"
+	return captionAndamento{
+		Date:      data.Format("2006-01-02"),
+		Anomaly:   markAnomaly(anomalies, anomalyFieldWholeRow),
+		Values:    getCaptionValues(nationToRegionData(d.nation[nationId-1]), nationToRegionData(d.nation[nationId]), natregAttributes, anomalies),
+		Note:      d.getNoteText(d.nation[nationId].Note_it),
+		Anomalies: getAnomaliesExplanations(anomalies),
 	}
+}
 
-	return msg
+// Returns the caption for the national trend plot image
+func (d *dataSnapshot) setCaptionAndamentoNazionale() string {
+	return executeCaptionTemplate("andamento_nazionale", d.getCaptionAndamentoNazionale())
 }
 
-// Returns the caption for a provincial trend plot image
-func setCaptionProvince(provinceId int) string {
-	provinceIndexes := covidgraphs.GetProvinceIndexesByName(&provincesData, provincesData[provinceId].Denominazione_provincia)
-	todayIndex := (*provinceIndexes)[len(*provinceIndexes)-1]
-	yesterdayIndex := (*provinceIndexes)[len(*provinceIndexes)-2]
-	_, nuoviTotale := covidgraphs.CalculateDelta(provincesData[yesterdayIndex].Totale_casi, provincesData[todayIndex].Totale_casi)
-	_, nuoviPositivi := covidgraphs.CalculateDelta(provincesData[yesterdayIndex].NuoviCasi, provincesData[todayIndex].NuoviCasi)
-	data, err := time.Parse("2006-01-02T15:04:05", provincesData[provinceId].Data)
-	if err != nil {
-		log.Println("error parsing data in setCaptionAndamentoNazionale()")
+// Returns the regions top 10 shown in captions
+func (d *dataSnapshot) getCaptionTopRegions() []captionItem {
+	top := covidgraphs.GetTopTenRegionsTotaleContagi(&d.regions)
+	items := make([]captionItem, 0)
+	for i := 0; i < nTopRegions && i < len(*top); i++ {
+		items = append(items, captionItem{Name: (*top)[i].Denominazione_regione, Value: (*top)[i].Totale_casi})
 	}
+	return items
+}
 
-	msg := "<b>Andamento provincia di " + provincesData[provinceId].Denominazione_provincia + " " + data.Format("2006-01-02") + "</b>\n\n" +
-		"\n<b>Totale positivi: </b>" + strconv.Itoa(provincesData[provinceId].Totale_casi) + " (<i>" + nuoviTotale + "</i>)" +
-		"\n\n<b>Nuovi positivi: </b>" + strconv.Itoa(provincesData[provinceId].NuoviCasi) + " (<i>" + nuoviPositivi + "</i>)"
-
-	if provincesData[provinceId].Note_it != "" {
-		i, err := covidgraphs.FindFirstOccurrenceNote(&datiNote, "codice", provincesData[provinceId].Note_it)
-		if err != nil {
-			log.Println("errore nella ricerca della nota col codice indicato")
-		}
+// Returns the caption for the regions top 10
+func (d *dataSnapshot) setCaptionTopRegions() string {
+	return executeCaptionTemplate("top_regioni", d.getCaptionTopRegions())
+}
 
-		var campoProvincia string
-		if datiNote[i].Provincia != "" {
-			campoProvincia = ", " + datiNote[i].Provincia
-		}
-		var notesField string
-		if datiNote[i].Note != "" {
-			notesField = ", " + datiNote[i].Note
-		}
-		msg += "\n\n<b>Note:</b>\n[<i>" + datiNote[i].Tipologia_avviso + "] " + datiNote[i].Regione + campoProvincia + ": " + datiNote[i].Avviso + notesField
+// Returns the provinces top 10 shown in captions
+func (d *dataSnapshot) getCaptionTopProvinces() []captionItem {
+	top := covidgraphs.GetTopTenProvincesTotaleContagi(&d.provinces)
+	items := make([]captionItem, 0)
+	for i := 0; i < nTopRegions && i < len(*top); i++ {
+		items = append(items, captionItem{Name: (*top)[i].Denominazione_provincia, Value: (*top)[i].Totale_casi})
 	}
+	return items
+}
 
-	return msg
+// Returns the caption for the provinces top 10
+func (d *dataSnapshot) setCaptionTopProvinces() string {
+	return executeCaptionTemplate("top_province", d.getCaptionTopProvinces())
 }
 
-// Returns the caption for the requested regional fields comparison plot
-func setCaptionConfrontoRegione(regionId int, fieldsNames []string) string {
-	_, nuoviTotale := covidgraphs.CalculateDelta(regionsData[regionId-21].Totale_casi, regionsData[regionId].Totale_casi)
-	_, nuoviGuariti := covidgraphs.CalculateDelta(regionsData[regionId-21].Dimessi_guariti, regionsData[regionId].Dimessi_guariti)
-	_, nuoviMorti := covidgraphs.CalculateDelta(regionsData[regionId-21].Deceduti, regionsData[regionId].Deceduti)
-	_, nuoviTotalePositivi := covidgraphs.CalculateDelta(regionsData[regionId-21].Totale_positivi, regionsData[regionId].Totale_positivi)
-	_, nuoviPositivi := covidgraphs.CalculateDelta(regionsData[regionId-21].Nuovi_positivi, regionsData[regionId].Nuovi_positivi)
-	_, nuoviRicoveratiConSintomi := covidgraphs.CalculateDelta(regionsData[regionId-21].Ricoverati_con_sintomi, regionsData[regionId].Ricoverati_con_sintomi)
-	_, nuoviTerapiaIntensiva := covidgraphs.CalculateDelta(regionsData[regionId-21].Terapia_intensiva, regionsData[regionId].Terapia_intensiva)
-	_, nuoviOspedalizzati := covidgraphs.CalculateDelta(regionsData[regionId-21].Totale_ospedalizzati, regionsData[regionId].Totale_ospedalizzati)
-	_, nuoviIsolamentoDomiciliare := covidgraphs.CalculateDelta(regionsData[regionId-21].Isolamento_domiciliare, regionsData[regionId].Isolamento_domiciliare)
-	_, nuoviTamponi := covidgraphs.CalculateDelta(regionsData[regionId-21].Tamponi, regionsData[regionId].Tamponi)
-	data, err := time.Parse("2006-01-02T15:04:05", regionsData[regionId].Data)
+// Returns the regional trend data shown in captions
+func (d *dataSnapshot) getCaptionRegion(regionId int) captionAndamento {
+	data, err := time.Parse("2006-01-02T15:04:05", d.regions[regionId].Data)
 	if err != nil {
-		log.Println("error parsing data in region caption")
+		log.Println("error parsing data in getCaptionRegion()")
 	}
+	anomalies := d.regionsAnomalies[regionId]
 
-	msg := "<b>Andamento regione " + regionsData[regionId].Denominazione_regione + " " + data.Format("2006-01-02") + "</b>\n"
-	for _, v := range fieldsNames {
-		if v == "totale_casi" {
-			msg += "\n<b>Totale positivi: </b>" + strconv.Itoa(regionsData[regionId].Totale_casi) + " (<i>" + nuoviTotale + "</i>)"
-		}
+	caption := captionAndamento{
+		Name:      d.regions[regionId].Denominazione_regione,
+		Date:      data.Format("2006-01-02"),
+		Anomaly:   markAnomaly(anomalies, anomalyFieldWholeRow),
+		Values:    getCaptionValues(d.regions[regionId-21], d.regions[regionId], natregAttributes, anomalies),
+		Note:      d.getNoteText(d.regions[regionId].Note_it),
+		Anomalies: getAnomaliesExplanations(anomalies),
 	}
-	for _, v := range fieldsNames {
-		if v == "dimessi_guariti" {
-			msg += "\n<b>Guariti: </b>" + strconv.Itoa(regionsData[regionId].Dimessi_guariti) + " (<i>" + nuoviGuariti + "</i>)"
-		}
-	}
-	for _, v := range fieldsNames {
-		if v == "deceduti" {
-			msg += "\n<b>Morti: </b>" + strconv.Itoa(regionsData[regionId].Deceduti) + " (<i>" + nuoviMorti + "</i>)"
-		}
-	}
-	for _, v := range fieldsNames {
-		if v == "attualmente_positivi" {
-			msg += "\n<b>Attualmente positivi: </b>" + strconv.Itoa(regionsData[regionId].Totale_positivi) + " (<i>" + nuoviTotalePositivi + "</i>)"
-		}
-	}
-	for _, v := range fieldsNames {
-		if v == "nuovi_positivi" {
-			msg += "\n<b>Nuovi positivi: </b>" + strconv.Itoa(regionsData[regionId].Nuovi_positivi) + " (<i>" + nuoviPositivi + "</i>)"
-		}
-	}
-	for _, v := range fieldsNames {
-		if v == "ricoverati_con_sintomi" {
-			msg += "\n<b>Ricoverati con sintomi: </b>" + strconv.Itoa(regionsData[regionId].Ricoverati_con_sintomi) + " (<i>" + nuoviRicoveratiConSintomi + "</i>)"
-		}
-	}
-	for _, v := range fieldsNames {
-		if v == "terapia_intensiva" {
-			msg += "\n<b>Terapia intensiva: </b>" + strconv.Itoa(regionsData[regionId].Terapia_intensiva) + " (<i>" + nuoviTerapiaIntensiva + "</i>)"
-		}
-	}
-	for _, v := range fieldsNames {
-		if v == "totale_ospedalizzati" {
-			msg += "\n<b>Totale ospedalizzati: </b>" + strconv.Itoa(regionsData[regionId].Totale_ospedalizzati) + " (<i>" + nuoviOspedalizzati + "</i>)"
-		}
-	}
-	for _, v := range fieldsNames {
-		if v == "isolamento_domiciliare" {
-			msg += "\n<b>Isolamento domiciliare: </b>" + strconv.Itoa(regionsData[regionId].Isolamento_domiciliare) + " (<i>" + nuoviIsolamentoDomiciliare + "</i>)"
-		}
-	}
-	for _, v := range fieldsNames {
-		if v == "tamponi" {
-			msg += "\n<b>Tamponi effettuati: </b>" + strconv.Itoa(regionsData[regionId].Tamponi) + " (<i>" + nuoviTamponi + "</i>)"
-		}
+	zone, err := d.getRegionZone(regionId)
+	if err != nil {
+		log.Println("errore nel calcolo della zona:", err)
+	} else {
+		caption.Zone = setCaptionZone(zone)
 	}
 
-	return msg
+	return caption
 }
 
-// Returns the caption for the requested national fields comparison plot
-func setCaptionConfrontoNazione(nationId int, fieldsNames []string) string {
-	_, nuoviTotale := covidgraphs.CalculateDelta(nationData[nationId-1].Totale_casi, nationData[nationId].Totale_casi)
-	_, nuoviGuariti := covidgraphs.CalculateDelta(nationData[nationId-1].Dimessi_guariti, nationData[nationId].Dimessi_guariti)
-	_, nuoviMorti := covidgraphs.CalculateDelta(nationData[nationId-1].Deceduti, nationData[nationId].Deceduti)
-	_, nuoviTotalePositivi := covidgraphs.CalculateDelta(nationData[nationId-1].Totale_positivi, nationData[nationId].Totale_positivi)
-	_, nuoviPositivi := covidgraphs.CalculateDelta(nationData[nationId-1].Nuovi_positivi, nationData[nationId].Nuovi_positivi)
-	_, nuoviRicoveratiConSintomi := covidgraphs.CalculateDelta(nationData[nationId-1].Ricoverati_con_sintomi, nationData[nationId].Ricoverati_con_sintomi)
-	_, nuoviTerapiaIntensiva := covidgraphs.CalculateDelta(nationData[nationId-1].Terapia_intensiva, nationData[nationId].Terapia_intensiva)
-	_, nuoviOspedalizzati := covidgraphs.CalculateDelta(nationData[nationId-1].Totale_ospedalizzati, nationData[nationId].Totale_ospedalizzati)
-	_, nuoviIsolamentoDomiciliare := covidgraphs.CalculateDelta(nationData[nationId-1].Isolamento_domiciliare, nationData[nationId].Isolamento_domiciliare)
-	_, nuoviTamponi := covidgraphs.CalculateDelta(nationData[nationId-1].Tamponi, nationData[nationId].Tamponi)
-	data, err := time.Parse("2006-01-02T15:04:05", nationData[nationId].Data)
+// Returns the caption for a regional trend plot image
+func (d *dataSnapshot) setCaptionRegion(regionId int) string {
+	return executeCaptionTemplate("andamento_regione", d.getCaptionRegion(regionId))
+}
+
+// Returns the provincial trend data shown in captions
+func (d *dataSnapshot) getCaptionProvince(provinceId int) captionAndamento {
+	provinceIndexes := covidgraphs.GetProvinceIndexesByName(&d.provinces, d.provinces[provinceId].Denominazione_provincia)
+	todayIndex := (*provinceIndexes)[len(*provinceIndexes)-1]
+	yesterdayIndex := (*provinceIndexes)[len(*provinceIndexes)-2]
+	data, err := time.Parse("2006-01-02T15:04:05", d.provinces[provinceId].Data)
 	if err != nil {
-		log.Println("error parsing data in nation caption")
+		log.Println("error parsing data in getCaptionProvince()")
 	}
+	anomalies := d.provincesAnomalies[provinceId]
 
-	msg := "<b>Andamento nazione " + data.Format("2006-01-02") + "</b>\n"
-	for _, v := range fieldsNames {
-		if v == "totale_casi" {
-			msg += "\n<b>Totale positivi: </b>" + strconv.Itoa(nationData[nationId].Totale_casi) + " (<i>" + nuoviTotale + "</i>)"
-		}
-	}
-	for _, v := range fieldsNames {
-		if v == "dimessi_guariti" {
-			msg += "\n<b>Guariti: </b>" + strconv.Itoa(nationData[nationId].Dimessi_guariti) + " (<i>" + nuoviGuariti + "</i>)"
-		}
-	}
-	for _, v := range fieldsNames {
-		if v == "deceduti" {
-			msg += "\n<b>Morti: </b>" + strconv.Itoa(nationData[nationId].Deceduti) + " (<i>" + nuoviMorti + "</i>)"
-		}
-	}
-	for _, v := range fieldsNames {
-		if v == "attualmente_positivi" {
-			msg += "\n<b>Attualmente positivi: </b>" + strconv.Itoa(nationData[nationId].Totale_positivi) + " (<i>" + nuoviTotalePositivi + "</i>)"
-		}
-	}
-	for _, v := range fieldsNames {
-		if v == "nuovi_positivi" {
-			msg += "\n<b>Nuovi positivi: </b>" + strconv.Itoa(nationData[nationId].Nuovi_positivi) + " (<i>" + nuoviPositivi + "</i>)"
-		}
-	}
-	for _, v := range fieldsNames {
-		if v == "ricoverati_con_sintomi" {
-			msg += "\n<b>Ricoverati con sintomi: </b>" + strconv.Itoa(nationData[nationId].Ricoverati_con_sintomi) + " (<i>" + nuoviRicoveratiConSintomi + "</i>)"
-		}
+	return captionAndamento{
+		Name:      d.provinces[provinceId].Denominazione_provincia,
+		Date:      data.Format("2006-01-02"),
+		Anomaly:   markAnomaly(anomalies, anomalyFieldWholeRow),
+		Values:    getCaptionValues(provinceToRegionData(d.provinces[yesterdayIndex]), provinceToRegionData(d.provinces[todayIndex]), provincesAttributes, anomalies),
+		Note:      d.getNoteText(d.provinces[provinceId].Note_it),
+		Anomalies: getAnomaliesExplanations(anomalies),
 	}
-	for _, v := range fieldsNames {
-		if v == "terapia_intensiva" {
-			msg += "\n<b>Terapia intensiva: </b>" + strconv.Itoa(nationData[nationId].Terapia_intensiva) + " (<i>" + nuoviTerapiaIntensiva + "</i>)"
-		}
-	}
-	for _, v := range fieldsNames {
-		if v == "totale_ospedalizzati" {
-			msg += "\n<b>Totale ospedalizzati: </b>" + strconv.Itoa(nationData[nationId].Totale_ospedalizzati) + " (<i>" + nuoviOspedalizzati + "</i>)"
-		}
+}
+
+// Returns the caption for a provincial trend plot image
+func (d *dataSnapshot) setCaptionProvince(provinceId int) string {
+	return executeCaptionTemplate("andamento_provincia", d.getCaptionProvince(provinceId))
+}
+
+// Returns the comparison data of the selected fields, fields lists all the fields available for the area
+func getCaptionConfronto(caption captionAndamento, fields []string, selected []string, anomalies []dataAnomaly) captionConfronto {
+	confronto := captionConfronto{captionAndamento: caption, Fields: make(map[string]bool)}
+	for _, v := range fields {
+		confronto.Fields[v] = false
 	}
-	for _, v := range fieldsNames {
-		if v == "isolamento_domiciliare" {
-			msg += "\n<b>Isolamento domiciliare: </b>" + strconv.Itoa(nationData[nationId].Isolamento_domiciliare) + " (<i>" + nuoviIsolamentoDomiciliare + "</i>)"
+	selectedFields := make([]string, 0, len(selected))
+	for _, v := range selected {
+		if v == "attualmente_positivi" { // covidgraphs name of totale_positivi
+			v = "totale_positivi"
 		}
-	}
-	for _, v := range fieldsNames {
-		if v == "tamponi" {
-			msg += "\n<b>Tamponi effettuati: </b>" + strconv.Itoa(nationData[nationId].Tamponi) + " (<i>" + nuoviTamponi + "</i>)"
+		if _, ok := confronto.Fields[v]; ok {
+			confronto.Fields[v] = true
+			selectedFields = append(selectedFields, v)
 		}
 	}
+	confronto.Anomalies = getAnomaliesExplanations(filterAnomalies(anomalies, selectedFields))
 
-	return msg
+	return confronto
+}
+
+// Returns the caption for the requested regional fields comparison plot
+func (d *dataSnapshot) setCaptionConfrontoRegione(regionId int, fieldsNames []string) string {
+	caption := d.getCaptionRegion(regionId)
+	return executeCaptionTemplate("confronto_regione", getCaptionConfronto(caption, natregAttributes, fieldsNames, d.regionsAnomalies[regionId]))
+}
+
+// Returns the caption for the requested national fields comparison plot
+func (d *dataSnapshot) setCaptionConfrontoNazione(nationId int, fieldsNames []string) string {
+	caption := d.getCaptionNation(nationId)
+	return executeCaptionTemplate("confronto_nazionale", getCaptionConfronto(caption, natregAttributes, fieldsNames, d.nationAnomalies[nationId]))
 }
 
 // Returns a caption with the selected province fields data
-func setCaptionConfrontoProvincia(provinceId int, fieldsNames []string) string {
-	provinceIndexes := covidgraphs.GetProvinceIndexesByName(&provincesData, provincesData[provinceId].Denominazione_provincia)
-	todayIndex := (*provinceIndexes)[len(*provinceIndexes)-1]
-	yesterdayIndex := (*provinceIndexes)[len(*provinceIndexes)-2]
-	_, nuoviTotale := covidgraphs.CalculateDelta(provincesData[yesterdayIndex].Totale_casi, provincesData[todayIndex].Totale_casi)
-	_, nuoviPositivi := covidgraphs.CalculateDelta(provincesData[yesterdayIndex].NuoviCasi, provincesData[todayIndex].NuoviCasi)
-	data, err := time.Parse("2006-01-02T15:04:05", provincesData[provinceId].Data)
-	if err != nil {
-		log.Println("error parsing data for province caption")
+func (d *dataSnapshot) setCaptionConfrontoProvincia(provinceId int, fieldsNames []string) string {
+	caption := d.getCaptionProvince(provinceId)
+	return executeCaptionTemplate("confronto_provincia", getCaptionConfronto(caption, provincesAttributes, fieldsNames, d.provincesAnomalies[provinceId]))
+}
+
+// Returns the caption listing the alerts of a chat
+func setCaptionAlerts(rules []alertRule) string {
+	if len(rules) == 0 {
+		return "<b>Avvisi</b>\n\nNon hai ancora impostato nessun avviso."
 	}
 
-	msg := "<b>Andamento provincia di " + provincesData[provinceId].Denominazione_provincia + " " + data.Format("2006-01-02") + "</b>\n"
-	for _, v := range fieldsNames {
-		if v == "totale_casi" {
-			msg += "\n<b>Totale positivi: </b>" + strconv.Itoa(provincesData[provinceId].Totale_casi) + " (<i>" + nuoviTotale + "</i>)"
-		}
+	msg := "<b>I tuoi avvisi</b>\n"
+	for i, v := range rules {
+		msg += "\n<b>" + strconv.Itoa(i+1) + ". </b>" + v.describe()
 	}
-	for _, v := range fieldsNames {
-		if v == "nuovi_positivi" {
-			msg += "\n<b>Nuovi positivi: </b>" + strconv.Itoa(provincesData[provinceId].NuoviCasi) + " (<i>" + nuoviPositivi + "</i>)"
-		}
+	return msg
+}
+
+// Returns the caption listing the scheduled posts of a chat
+func setCaptionSchedules(posts []scheduledPost) string {
+	if len(posts) == 0 {
+		return "<b>Aggiornamenti programmati</b>\n\nNon è ancora stato programmato nessun aggiornamento."
 	}
 
+	msg := "<b>Aggiornamenti programmati</b>\n"
+	for i, v := range posts {
+		msg += "\n<b>" + strconv.Itoa(i+1) + ". </b>" + v.describe()
+	}
 	return msg
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sort"
+	"sync"
+)
+
+const knownChatsFile = "/chats.json"
+
+var knownChats = make(map[int64]bool) // Chats that used the bot, the recipients of the broadcasts
+var knownChatsMutex = &sync.Mutex{}
+
+// Loads the known chats from the bot data folder. The first time the file doesn't exist
+// the chats with alerts, scheduled posts or live messages are added
+func loadKnownChats() {
+	knownChatsMutex.Lock()
+	defer knownChatsMutex.Unlock()
+
+	if _, err := os.Stat(workingDirectory + knownChatsFile); os.IsNotExist(err) {
+		alertsMutex.Lock()
+		for _, v := range alertRules {
+			knownChats[v.ChatId] = true
+		}
+		alertsMutex.Unlock()
+		schedulesMutex.Lock()
+		for _, v := range scheduledPosts {
+			knownChats[v.ChatId] = true
+		}
+		schedulesMutex.Unlock()
+		liveMutex.Lock()
+		for k := range liveMessages {
+			knownChats[k] = true
+		}
+		liveMutex.Unlock()
+
+		err = saveKnownChats()
+		if err != nil {
+			log.Println("errore nel salvataggio delle chat:", err)
+		}
+		return
+	}
+
+	chats := make([]int64, 0)
+	err := loadJSON(knownChatsFile, &chats)
+	if err != nil {
+		log.Println("errore nel caricamento delle chat:", err)
+		return
+	}
+	for _, v := range chats {
+		knownChats[v] = true
+	}
+}
+
+// Saves the known chats in the bot data folder, knownChatsMutex must be held
+func saveKnownChats() error {
+	return saveJSON(knownChatsFile, getSortedKnownChats())
+}
+
+// Returns the known chats sorted by id, knownChatsMutex must be held
+func getSortedKnownChats() []int64 {
+	chats := make([]int64, 0, len(knownChats))
+	for k := range knownChats {
+		chats = append(chats, k)
+	}
+	sort.Slice(chats, func(i, j int) bool { return chats[i] < chats[j] })
+	return chats
+}
+
+// Adds the chat of the update to the known chats, the file is written only for new chats
+func rememberChat(chatId int64) {
+	knownChatsMutex.Lock()
+	defer knownChatsMutex.Unlock()
+
+	if knownChats[chatId] {
+		return
+	}
+	knownChats[chatId] = true
+	err := saveKnownChats()
+	if err != nil {
+		log.Println("errore nel salvataggio delle chat:", err)
+	}
+}
+
+// Removes a chat from the known chats
+func forgetChat(chatId int64) error {
+	knownChatsMutex.Lock()
+	defer knownChatsMutex.Unlock()
+
+	if !knownChats[chatId] {
+		return nil
+	}
+	delete(knownChats, chatId)
+	return saveKnownChats()
+}
+
+// Checks if the chat is among the known chats
+func isKnownChat(chatId int64) bool {
+	knownChatsMutex.Lock()
+	defer knownChatsMutex.Unlock()
+	return knownChats[chatId]
+}
+
+// Returns the known chats sorted by id
+func getKnownChats() []int64 {
+	knownChatsMutex.Lock()
+	defer knownChatsMutex.Unlock()
+	return getSortedKnownChats()
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestTextNation(t *testing.T) {
+	b := newTestBot(testChatId)
+	calls := b.send("/nazione andamento")
+	expectMethods(t, calls, "sendPhoto", "deleteMessage")
+	expectPlot(t, calls[0], "Andamento nazionale")
+	expectText(t, calls[0], "<b>Andamento nazionale 2022-03-05</b>")
+	expectKeyboard(t, calls[0], "esporta nazione csv", "esporta nazione xlsx")
+
+	calls = b.send("/nazione deceduti terapia_intensiva")
+	expectMethods(t, calls, "sendPhoto", "deleteMessage")
+	expectPlot(t, calls[0], "Nazionedeceduti_terapia_intensiva_")
+	expectText(t, calls[0], "Morti: </b>38496 (<i>+33</i>)", "Terapia intensiva: </b>617 (<i>-17</i>)")
+
+	calls = b.send("/nazione")
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "Uso Corretto del Comando:", "/nazione <code>andamento</code>")
+}
+
+func TestTextRegion(t *testing.T) {
+	b := newTestBot(testChatId)
+	calls := b.send("/regione puglia andamento")
+	expectMethods(t, calls, "sendPhoto", "deleteMessage")
+	expectPlot(t, calls[0], "Dati regione Puglia")
+	expectText(t, calls[0], "<b>Andamento regione Puglia 2022-03-05</b>", "Totale positivi: </b>210545 (<i>+589</i>)")
+	expectKeyboard(t, calls[0], "esporta regione csv Puglia", "esporta regione xlsx Puglia")
+
+	calls = b.send("/regione puglia")
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "Uso Corretto del Comando:", "/regione <code>nome_regione andamento</code>")
+}
+
+func TestTextProvince(t *testing.T) {
+	b := newTestBot(testChatId)
+	calls := b.send("/provincia bari totale_casi")
+	expectMethods(t, calls, "sendPhoto", "deleteMessage")
+	expectPlot(t, calls[0], "Totale Contagi Bari")
+	expectText(t, calls[0], "<b>Andamento provincia di Bari 2022-03-05</b>", "Totale positivi: </b>126327 (<i>+354</i>)")
+	expectKeyboard(t, calls[0], "esporta provincia csv Bari", "esporta provincia xlsx Bari")
+
+	calls = b.send("/provincia bari")
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "Uso Corretto del Comando:", "/provincia <code>nome_provincia totale_casi</code>")
+}
+
+func TestTextZone(t *testing.T) {
+	b := newTestBot(testChatId)
+	calls := b.send("/zone")
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "<b>Zone di rischio 2022-03-05</b>", "incidenza settimanale")
+}
+
+func TestTextReports(t *testing.T) {
+	b := newTestBot(testChatId)
+	calls := b.send("/reports")
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "Uso Corretto del Comando:", "<code>generale</code>")
+}
+
+func TestTextAvviso(t *testing.T) {
+	b := newTestBot(testChatId)
+	calls := b.send("/avviso")
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "<b>Avvisi</b>")
+	expectKeyboard(t, calls[0], "avviso nuovo", "avviso lista", "avviso annulla")
+
+	// Trend alert chosen with the buttons
+	calls = b.press("avviso nuovo")
+	expectMethods(t, calls, "editMessageText", "answerCallbackQuery")
+	expectText(t, calls[0], "Scegli la zona da monitorare:")
+	calls = b.press("avviso area puglia")
+	expectText(t, calls[0], "Scegli il dato da monitorare:")
+	calls = b.press("avviso metrica terapia_intensiva")
+	expectText(t, calls[0], "Quando vuoi essere avvisato?")
+	calls = b.press("avviso condizione " + alertRising)
+	expectText(t, calls[0], "Per quanti giorni consecutivi?")
+	expectKeyboard(t, calls[0], "avviso valore 2", "avviso valore 3", "avviso valore 5", "avviso valore 7", "avviso annulla")
+	calls = b.press("avviso valore 3")
+	expectMethods(t, calls, "deleteMessage", "sendMessage", "answerCallbackQuery")
+	expectText(t, calls[1], "Avviso creato", "Puglia, terapia intensiva in crescita per 3 giorni consecutivi")
+
+	// Threshold written by the user
+	b.press("avviso nuovo")
+	b.press("avviso area " + alertAreaNation)
+	b.press("avviso metrica deceduti")
+	b.press("avviso condizione " + alertAbove)
+	calls = b.send("150")
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "Avviso creato", "Italia, deceduti giornalieri sopra 150")
+
+	rules := getChatAlerts(testChatId)
+	if len(rules) != 2 {
+		t.Fatalf("alerts %+v, want the two created", rules)
+	}
+	calls = b.send("/avviso lista")
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "<b>I tuoi avvisi</b>", "<b>1. </b>Puglia", "<b>2. </b>")
+	expectKeyboard(t, calls[0], "avviso elimina "+strconv.Itoa(rules[0].ID), "avviso elimina "+strconv.Itoa(rules[1].ID), "avviso nuovo", "avviso annulla")
+	for _, v := range rules {
+		calls = b.press("avviso elimina " + strconv.Itoa(v.ID))
+		expectMethods(t, calls, "editMessageText", "answerCallbackQuery")
+	}
+	expectText(t, calls[0], "Non hai ancora impostato nessun avviso.")
+
+	calls = b.send("/avviso puglia positivi sopra 100")
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "Impossibile creare l'avviso")
+}
+
+func TestTextProgramma(t *testing.T) {
+	b := newTestBot(testChatId)
+	calls := b.send("/programma")
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "Uso Corretto del Comando:", "/programma <code>lista</code>")
+
+	calls = b.send("/programma 25:00 nazione")
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "Impossibile programmare")
+
+	calls = b.send("/programma 07:30 provincia bari")
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "Aggiornamento programmato", "Bari, ogni giorno alle 07:30")
+	posts := getChatSchedules(testChatId)
+	if len(posts) != 1 {
+		t.Fatalf("posts %+v, want the one added", posts)
+	}
+	defer deleteSchedule(testChatId, posts[0].ID)
+
+	calls = b.send("/programma lista")
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "<b>1. </b>")
+	expectKeyboard(t, calls[0], "programma elimina "+strconv.Itoa(posts[0].ID), "programma chiudi")
+	calls = b.press("programma chiudi")
+	expectMethods(t, calls, "deleteMessage", "answerCallbackQuery")
+}
+
+func TestTextRegistro(t *testing.T) {
+	b := newTestBot(testChatId)
+	calls := b.send("/registro")
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "I comandi di questa chat vengono registrati in forma anonima.")
+
+	calls = b.send("/registro no")
+	expectText(t, calls[0], "I comandi di questa chat non verranno più registrati.")
+	defer setInteractionsOptOut(testChatId, false)
+	calls = b.send("/registro")
+	expectText(t, calls[0], "I comandi di questa chat non vengono registrati.")
+	calls = b.send("/registro si")
+	expectText(t, calls[0], "verranno di nuovo registrati")
+	if isInteractionsOptOut(testChatId) {
+		t.Error("chat still opted out")
+	}
+
+	calls = b.send("/registro forse")
+	expectText(t, calls[0], "Uso Corretto del Comando:")
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Returns the value of the environment variable, or the default value if it isn't set
+func getEnv(key, defaultValue string) string {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+	return defaultValue
+}
+
+// Returns the environment variable as an integer, or the default value if it isn't set or valid
+func getEnvInt(key string, defaultValue int) int {
+	v := getEnv(key, "")
+	if v == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Println("invalid value for " + key + ", using the default one")
+		return defaultValue
+	}
+	return n
+}
+
+// Returns the environment variable as a duration (e.g. "36h"), or the default value if it isn't set or valid
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	v := getEnv(key, "")
+	if v == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Println("invalid value for " + key + ", using the default one")
+		return defaultValue
+	}
+	return d
+}
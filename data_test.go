@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestUpdateDataPublishesSnapshot(t *testing.T) {
+	previous := getData()
+	updateData(nil)
+	d := getData()
+
+	if d == previous {
+		t.Fatal("the update didn't publish a new snapshot")
+	}
+	if d.version != previous.version+1 {
+		t.Errorf("version %d after %d, want %d", d.version, previous.version, previous.version+1)
+	}
+	if len(previous.nation) == 0 || len(previous.regions) == 0 || len(previous.provinces) == 0 {
+		t.Error("the previous snapshot has been modified by the update")
+	}
+	if d.versionKey != previous.versionKey {
+		t.Errorf("version key %q for the same data, want %q", d.versionKey, previous.versionKey)
+	}
+}
+
+func TestRemoveStalePlots(t *testing.T) {
+	dirPath := workingDirectory + imageFolder
+	for _, name := range []string{"old-a.png", "previous-a.png", "current-a.png"} {
+		err := ioutil.WriteFile(dirPath+name, []byte("png"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(dirPath + name)
+	}
+
+	removeStalePlots("current", "previous", getData().versionKey)
+
+	for name, kept := range map[string]bool{"old-a.png": false, "previous-a.png": true, "current-a.png": true} {
+		if _, err := os.Stat(dirPath + name); (err == nil) != kept {
+			t.Errorf("%s kept: %v, want %v", name, err == nil, kept)
+		}
+	}
+}
+
+func TestCheckData(t *testing.T) {
+	data := getData().pcmDpcData
+	if err := data.check(); err != nil {
+		t.Fatal(err)
+	}
+
+	older := getData().pcmDpcData
+	older.nation = older.nation[:len(older.nation)-1]
+	older.regions = older.regions[:len(older.regions)-21]
+	for name, invalid := range map[string]pcmDpcData{
+		"one national day": {nation: data.nation[:1], regions: data.regions, provinces: data.provinces},
+		"missing regions":  {nation: data.nation, regions: data.regions[:len(data.regions)-1], provinces: data.provinces},
+		"no provinces":     {nation: data.nation, regions: data.regions},
+		"misaligned":       {nation: data.nation[:len(data.nation)-1], regions: data.regions, provinces: data.provinces},
+		"older":            older,
+	} {
+		if err := invalid.check(); err == nil {
+			t.Errorf("%s: invalid data accepted", name)
+		}
+	}
+}
+
+func TestUpdateDataKeepsPreviousOnFailure(t *testing.T) {
+	defer func(transport http.RoundTripper, attempts int, delay time.Duration) {
+		http.DefaultTransport, updateAttempts, updateRetryDelay = transport, attempts, delay
+		setUpdateStatus(nil)
+	}(http.DefaultTransport, updateAttempts, updateRetryDelay)
+
+	requests := 0
+	http.DefaultTransport = fakeTransport{"raw.githubusercontent.com": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, "non disponibile", http.StatusServiceUnavailable)
+	})}
+	updateAttempts, updateRetryDelay = 2, time.Millisecond
+
+	previous := getData()
+	updateData(nil)
+	if getData() != previous {
+		t.Error("a failed update replaced the data")
+	}
+	if requests != updateAttempts {
+		t.Errorf("%d downloads, want %d", requests, updateAttempts)
+	}
+	if status := getHealthStatus(); status.Stato != healthStatusDegraded {
+		t.Errorf("status %+v after a failed update, want degraded", status)
+	}
+}
+
+func TestUpdateDataInterrupted(t *testing.T) {
+	defer func(transport http.RoundTripper, attempts int, delay time.Duration) {
+		http.DefaultTransport, updateAttempts, updateRetryDelay = transport, attempts, delay
+		setUpdateStatus(nil)
+	}(http.DefaultTransport, updateAttempts, updateRetryDelay)
+
+	done := make(chan struct{})
+	requests := 0
+	http.DefaultTransport = fakeTransport{"raw.githubusercontent.com": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests++; requests == 1 {
+			close(done)
+		}
+		http.Error(w, "non disponibile", http.StatusServiceUnavailable)
+	})}
+	updateAttempts, updateRetryDelay = 5, time.Hour
+
+	previous := getData()
+	updated := make(chan struct{})
+	go func() {
+		updateData(done)
+		close(updated)
+	}()
+	select {
+	case <-updated:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the shutdown didn't stop the retries of the data update")
+	}
+	if getData() != previous || requests != 1 {
+		t.Errorf("%d downloads, want the first one only and the previous data kept", requests)
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/DarkFighterLuke/covidgraphs"
+	"github.com/wcharczuk/go-chart"
+	"github.com/wcharczuk/go-chart/drawing"
+	"image"
+	"image/draw"
+	"image/png"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	digestDays          = 7
+	digestTopRegions    = 3 // Regions listed among the best and the worst
+	digestChartColumns  = 3
+	digestChartWidth    = 400
+	digestChartHeight   = 200
+	digestScheduleTime  = "09:00"
+	digestScheduleDays  = "1" // Monday in the cron day of week field
+	digestReportName    = "riepilogo"
+	digestChartFilename = "Riepilogo settimanale regioni.png"
+)
+
+// Sums of the daily values of an area over one week
+type weekStats struct {
+	cases  int
+	deaths int
+	tests  int
+}
+
+// Last week compared with the previous one for an area
+type weekComparison struct {
+	area     string
+	last     weekStats
+	previous weekStats
+	daily    []float64 // Daily new cases of both weeks, oldest first
+}
+
+// Returns the percent change between the two values, NaN if the previous value is zero
+func percentChange(previous, last int) float64 {
+	if previous == 0 {
+		return math.NaN()
+	}
+	return float64(last-previous) * 100 / float64(previous)
+}
+
+// Formats a percent change with its sign
+func formatPercentChange(change float64) string {
+	if math.IsNaN(change) {
+		return "n.d."
+	}
+	msg := strconv.FormatFloat(change, 'f', 1, 64) + "%"
+	if change > 0 {
+		msg = "+" + msg
+	}
+	return msg
+}
+
+// Compares the last week with the previous one, rows must be ordered oldest first
+func compareWeeks(area string, rows []covidgraphs.RegionData) (weekComparison, error) {
+	comparison := weekComparison{area: area}
+	if len(rows) < 2*digestDays+1 {
+		return comparison, fmt.Errorf("not enough data to compare the weeks of %s", area)
+	}
+	rows = rows[len(rows)-2*digestDays-1:]
+
+	for i := 1; i < len(rows); i++ {
+		stats := &comparison.previous
+		if i > digestDays {
+			stats = &comparison.last
+		}
+		stats.cases += rows[i].Nuovi_positivi
+		stats.deaths += rows[i].Deceduti - rows[i-1].Deceduti
+		stats.tests += rows[i].Tamponi - rows[i-1].Tamponi
+		comparison.daily = append(comparison.daily, float64(rows[i].Nuovi_positivi))
+	}
+
+	return comparison, nil
+}
+
+// Returns the comparison for the nation and for each region, regions are sorted from the best to the worst new cases trend
+func (d *dataSnapshot) getWeekComparisons() (weekComparison, []weekComparison, error) {
+	rows, err := d.getExportRows(&exportRequest{area: exportAreaNation})
+	if err != nil {
+		return weekComparison{}, nil, err
+	}
+	nation, err := compareWeeks("Italia", rows)
+	if err != nil {
+		return weekComparison{}, nil, err
+	}
+
+	if len(d.regions) < 21 {
+		return weekComparison{}, nil, fmt.Errorf("not enough regional data")
+	}
+	regions := make([]weekComparison, 0)
+	for _, region := range d.regions[len(d.regions)-21:] {
+		rows, err := d.getExportRows(&exportRequest{area: exportAreaRegion, name: region.Denominazione_regione})
+		if err != nil {
+			return weekComparison{}, nil, err
+		}
+		comparison, err := compareWeeks(region.Denominazione_regione, rows)
+		if err != nil {
+			return weekComparison{}, nil, err
+		}
+		regions = append(regions, comparison)
+	}
+
+	sort.SliceStable(regions, func(i, j int) bool {
+		a := percentChange(regions[i].previous.cases, regions[i].last.cases)
+		b := percentChange(regions[j].previous.cases, regions[j].last.cases)
+		if math.IsNaN(b) {
+			return !math.IsNaN(a)
+		}
+		return a < b
+	})
+
+	return nation, regions, nil
+}
+
+// Returns the regions with the best and the worst new cases trend
+func getBestWorstRegions(regions []weekComparison) ([]weekComparison, []weekComparison) {
+	n := digestTopRegions
+	if len(regions) < 2*n {
+		n = len(regions) / 2
+	}
+	worst := make([]weekComparison, 0)
+	for i := len(regions) - 1; i >= len(regions)-n; i-- {
+		worst = append(worst, regions[i])
+	}
+	return regions[:n], worst
+}
+
+// Returns the text of the weekly digest
+func (d *dataSnapshot) setCaptionReportRiepilogo() (string, error) {
+	nation, regions, err := d.getWeekComparisons()
+	if err != nil {
+		return "", err
+	}
+	data, err := time.Parse("2006-01-02T15:04:05", d.nation[len(d.nation)-1].Data)
+	if err != nil {
+		log.Println("error parsing data in setCaptionReportRiepilogo()")
+	}
+
+	best, worst := getBestWorstRegions(regions)
+	return executeTemplate("report_riepilogo", struct {
+		From    string
+		To      string
+		Nation  captionWeek
+		Best    []captionWeek
+		Worst   []captionWeek
+		Regions []captionWeek
+	}{
+		data.AddDate(0, 0, -digestDays+1).Format("2006-01-02"),
+		data.Format("2006-01-02"),
+		nation.caption(),
+		getCaptionWeeks(best),
+		getCaptionWeeks(worst),
+		getCaptionWeeks(regions),
+	})
+}
+
+// Returns the comparison data shown in the digest text
+func (c weekComparison) caption() captionWeek {
+	return captionWeek{
+		Area:         c.area,
+		Cases:        c.last.cases,
+		CasesChange:  formatPercentChange(percentChange(c.previous.cases, c.last.cases)),
+		Deaths:       c.last.deaths,
+		DeathsChange: formatPercentChange(percentChange(c.previous.deaths, c.last.deaths)),
+		Tests:        c.last.tests,
+		TestsChange:  formatPercentChange(percentChange(c.previous.tests, c.last.tests)),
+	}
+}
+
+// Returns the comparisons data shown in the digest text
+func getCaptionWeeks(comparisons []weekComparison) []captionWeek {
+	weeks := make([]captionWeek, 0)
+	for _, v := range comparisons {
+		weeks = append(weeks, v.caption())
+	}
+	return weeks
+}
+
+// Renders the chart of the new cases of one region over the two weeks
+func renderDigestChart(comparison weekComparison) (image.Image, error) {
+	xValues := make([]float64, 0)
+	for i := range comparison.daily {
+		xValues = append(xValues, float64(i+1))
+	}
+
+	graph := chart.Chart{
+		Title:  comparison.area + " " + formatPercentChange(percentChange(comparison.previous.cases, comparison.last.cases)),
+		Width:  digestChartWidth,
+		Height: digestChartHeight,
+		TitleStyle: chart.Style{
+			FontSize: 11,
+		},
+		Background: chart.Style{
+			Padding: chart.Box{Top: 30, Left: 10, Right: 10, Bottom: 10},
+		},
+		XAxis: chart.XAxis{
+			Style: chart.Style{Hidden: true},
+		},
+		YAxis: chart.YAxis{
+			ValueFormatter: func(v interface{}) string {
+				return strconv.Itoa(int(v.(float64)))
+			},
+			TickStyle: chart.Style{FontSize: 8},
+		},
+		Series: []chart.Series{
+			chart.ContinuousSeries{
+				Name:    "Settimana precedente",
+				Style:   chart.Style{StrokeColor: drawing.ColorFromHex("a0a0a0"), StrokeWidth: 2},
+				XValues: xValues[:digestDays+1],
+				YValues: comparison.daily[:digestDays+1],
+			},
+			chart.ContinuousSeries{
+				Name:    "Ultima settimana",
+				Style:   chart.Style{StrokeColor: drawing.ColorFromHex("0074d9"), StrokeWidth: 2},
+				XValues: xValues[digestDays:],
+				YValues: comparison.daily[digestDays:],
+			},
+		},
+	}
+
+	buffer := &bytes.Buffer{}
+	err := graph.Render(chart.PNG, buffer)
+	if err != nil {
+		return nil, err
+	}
+	return png.Decode(buffer)
+}
+
+// Returns the small multiples chart with the new cases of every region, creating it if needed
+func (d *dataSnapshot) getPlotRiepilogo(regions []weekComparison) (string, error) {
+	return renderPlot(d.getPlotPath(digestChartFilename), func(filename string) (error, string) {
+		return drawPlotRiepilogo(regions, filename), filename
+	})
+}
+
+// Draws the small multiples chart with the new cases of every region into the file
+func drawPlotRiepilogo(regions []weekComparison, filename string) error {
+	rows := (len(regions) + digestChartColumns - 1) / digestChartColumns
+	canvas := image.NewRGBA(image.Rect(0, 0, digestChartColumns*digestChartWidth, rows*digestChartHeight))
+	draw.Draw(canvas, canvas.Bounds(), image.White, image.Point{}, draw.Src)
+	for i, v := range regions {
+		img, err := renderDigestChart(v)
+		if err != nil {
+			return err
+		}
+		origin := image.Point{X: (i % digestChartColumns) * digestChartWidth, Y: (i / digestChartColumns) * digestChartHeight}
+		draw.Draw(canvas, image.Rectangle{Min: origin, Max: origin.Add(img.Bounds().Size())}, img, img.Bounds().Min, draw.Src)
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = png.Encode(f, canvas)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Builds the weekly digest and returns the PDF file path
+func (d *dataSnapshot) buildReportRiepilogo() (string, error) {
+	nation, regions, err := d.getWeekComparisons()
+	if err != nil {
+		return "", err
+	}
+	data, err := time.Parse("2006-01-02T15:04:05", d.nation[len(d.nation)-1].Data)
+	if err != nil {
+		log.Println("error parsing data in buildReportRiepilogo()")
+	}
+
+	r := newPdfReport("Riepilogo settimanale", "Dal "+data.AddDate(0, 0, -digestDays+1).Format("2006-01-02")+" al "+data.Format("2006-01-02")+
+		"\na confronto con i 7 giorni precedenti")
+
+	r.section("Italia")
+	r.table([]string{"Dato", "Ultimi 7 giorni", "7 giorni precedenti", "Variazione"}, []float64{45, 45, 45, 45}, [][]string{
+		{"Nuovi positivi", strconv.Itoa(nation.last.cases), strconv.Itoa(nation.previous.cases), formatPercentChange(percentChange(nation.previous.cases, nation.last.cases))},
+		{"Morti", strconv.Itoa(nation.last.deaths), strconv.Itoa(nation.previous.deaths), formatPercentChange(percentChange(nation.previous.deaths, nation.last.deaths))},
+		{"Tamponi", strconv.Itoa(nation.last.tests), strconv.Itoa(nation.previous.tests), formatPercentChange(percentChange(nation.previous.tests, nation.last.tests))},
+	})
+	best, worst := getBestWorstRegions(regions)
+	r.subsection("Regioni in miglioramento")
+	for _, v := range best {
+		r.paragraph(v.area + ": nuovi positivi " + formatPercentChange(percentChange(v.previous.cases, v.last.cases)))
+	}
+	r.subsection("Regioni in peggioramento")
+	for _, v := range worst {
+		r.paragraph(v.area + ": nuovi positivi " + formatPercentChange(percentChange(v.previous.cases, v.last.cases)))
+	}
+
+	r.section("Regioni")
+	rows := make([][]string, 0)
+	for _, v := range regions {
+		rows = append(rows, []string{
+			v.area,
+			strconv.Itoa(v.last.cases),
+			formatPercentChange(percentChange(v.previous.cases, v.last.cases)),
+			strconv.Itoa(v.last.deaths),
+			formatPercentChange(percentChange(v.previous.deaths, v.last.deaths)),
+			strconv.Itoa(v.last.tests),
+			formatPercentChange(percentChange(v.previous.tests, v.last.tests)),
+		})
+	}
+	r.table([]string{"Regione", "Positivi", "Var.", "Morti", "Var.", "Tamponi", "Var."}, []float64{46, 22, 22, 20, 22, 26, 22}, rows)
+
+	filename, err := d.getPlotRiepilogo(regions)
+	if err != nil {
+		log.Println(err)
+	} else {
+		r.section("Nuovi positivi giornalieri per regione")
+		r.paragraph("In grigio la settimana precedente, in blu l'ultima settimana.")
+		r.image(filename)
+	}
+
+	return r.save("riepilogo settimanale")
+}
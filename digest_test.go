@@ -0,0 +1,96 @@
+package main
+
+import (
+	"github.com/DarkFighterLuke/covidgraphs"
+	"math"
+	"testing"
+)
+
+func TestPercentChange(t *testing.T) {
+	for _, v := range []struct {
+		previous, last int
+		want           string
+	}{
+		{100, 150, "+50.0%"},
+		{200, 150, "-25.0%"},
+		{100, 100, "0.0%"},
+		{0, 10, "n.d."},
+	} {
+		if got := formatPercentChange(percentChange(v.previous, v.last)); got != v.want {
+			t.Errorf("change from %d to %d: %s, want %s", v.previous, v.last, got, v.want)
+		}
+	}
+	if !math.IsNaN(percentChange(0, 0)) {
+		t.Error("change from zero isn't NaN")
+	}
+}
+
+func TestCompareWeeks(t *testing.T) {
+	rows := make([]covidgraphs.RegionData, 0)
+	for i := 0; i < 2*digestDays+3; i++ {
+		rows = append(rows, covidgraphs.RegionData{Nuovi_positivi: i, Deceduti: 10 * i, Tamponi: 100 * i})
+	}
+
+	comparison, err := compareWeeks("Prova", rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The weeks are the last 14 days, from the 4th row on
+	if comparison.previous.cases != 3+4+5+6+7+8+9 || comparison.last.cases != 10+11+12+13+14+15+16 {
+		t.Errorf("cases %d and %d", comparison.previous.cases, comparison.last.cases)
+	}
+	if comparison.previous.deaths != 70 || comparison.last.tests != 700 || len(comparison.daily) != 2*digestDays {
+		t.Errorf("unexpected comparison %+v", comparison)
+	}
+
+	if _, err = compareWeeks("Prova", rows[:2*digestDays]); err == nil {
+		t.Error("weeks compared without enough data")
+	}
+}
+
+func TestGetWeekComparisons(t *testing.T) {
+	nation, regions, err := getData().getWeekComparisons()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nation.area != "Italia" || len(regions) != 21 {
+		t.Fatalf("comparisons of %s and %d regions", nation.area, len(regions))
+	}
+	for i := 1; i < len(regions); i++ {
+		if percentChange(regions[i-1].previous.cases, regions[i-1].last.cases) > percentChange(regions[i].previous.cases, regions[i].last.cases) {
+			t.Errorf("%s listed before %s", regions[i-1].area, regions[i].area)
+		}
+	}
+
+	best, worst := getBestWorstRegions(regions)
+	if len(best) != digestTopRegions || best[0].area != regions[0].area || worst[0].area != regions[20].area {
+		t.Errorf("best %v worst %v", best, worst)
+	}
+	best, worst = getBestWorstRegions(regions[:4])
+	if len(best) != 2 || len(worst) != 2 {
+		t.Errorf("%d best and %d worst out of 4 regions", len(best), len(worst))
+	}
+
+	if _, _, err = (&dataSnapshot{}).getWeekComparisons(); err == nil {
+		t.Error("weeks compared without data")
+	}
+}
+
+func TestDigestSubscription(t *testing.T) {
+	b := newTestBot(testChatId)
+	calls := b.press("programma " + digestReportName)
+	expectMethods(t, calls, "sendMessage", "answerCallbackQuery")
+	expectText(t, calls[0], "Aggiornamento programmato", "Report riepilogo, ogni lunedì alle 09:00")
+	posts := getChatSchedules(testChatId)
+	if len(posts) != 1 {
+		t.Fatalf("posts %+v, want the digest", posts)
+	}
+	defer deleteSchedule(testChatId, posts[0].ID)
+
+	calls = b.press("programma " + digestReportName)
+	expectMethods(t, calls, "sendMessage", "answerCallbackQuery")
+	expectText(t, calls[0], "Aggiornamento già programmato")
+	if posts = getChatSchedules(testChatId); len(posts) != 1 {
+		t.Errorf("posts %+v, want the digest once", posts)
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/DarkFighterLuke/covidgraphs"
+	"github.com/NicoNex/echotron"
+	"github.com/xuri/excelize/v2"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	exportAreaNation   = "nazione"
+	exportAreaRegion   = "regione"
+	exportAreaProvince = "provincia"
+	exportFormatCSV    = "csv"
+	exportFormatXLSX   = "xlsx"
+	exportDateLayout   = "2006-01-02"
+	exportAverageDays  = 7
+)
+
+var provincesAttributes = []string{"totale_casi", "nuovi_positivi"} // Provincial fields names
+
+// Fields whose daily value is the difference between two consecutive days
+var cumulativeFields = map[string]bool{
+	"totale_casi":     true,
+	"dimessi_guariti": true,
+	"deceduti":        true,
+	"tamponi":         true,
+}
+
+// Data export requested by a user
+type exportRequest struct {
+	area   string // "nazione", "regione" or "provincia"
+	name   string // Region or province name, empty for the nation
+	fields []string
+	from   time.Time // Zero value to export from the first day
+	to     time.Time // Zero value to export up to the last day
+	format string
+}
+
+// Exported data, the first column is the date and the other ones are numbers
+type exportTable struct {
+	header []string
+	rows   [][]interface{}
+}
+
+// Returns the value of a national or regional field
+func getRegionField(row covidgraphs.RegionData, field string) (int, error) {
+	switch field {
+	case "ricoverati_con_sintomi":
+		return row.Ricoverati_con_sintomi, nil
+	case "terapia_intensiva":
+		return row.Terapia_intensiva, nil
+	case "totale_ospedalizzati":
+		return row.Totale_ospedalizzati, nil
+	case "isolamento_domiciliare":
+		return row.Isolamento_domiciliare, nil
+	case "totale_positivi":
+		return row.Totale_positivi, nil
+	case "nuovi_positivi":
+		return row.Nuovi_positivi, nil
+	case "dimessi_guariti":
+		return row.Dimessi_guariti, nil
+	case "deceduti":
+		return row.Deceduti, nil
+	case "totale_casi":
+		return row.Totale_casi, nil
+	case "tamponi":
+		return row.Tamponi, nil
+	default:
+		return 0, fmt.Errorf("wrong field name passed")
+	}
+}
+
+// Returns the fields available for the given area
+func getExportFields(area string) []string {
+	if area == exportAreaProvince {
+		return provincesAttributes
+	}
+	return natregAttributes
+}
+
+// Returns a national row as a regional one
+func nationToRegionData(row covidgraphs.NationData) covidgraphs.RegionData {
+	return covidgraphs.RegionData{
+		Data:                   row.Data,
+		Ricoverati_con_sintomi: row.Ricoverati_con_sintomi,
+		Terapia_intensiva:      row.Terapia_intensiva,
+		Totale_ospedalizzati:   row.Totale_ospedalizzati,
+		Isolamento_domiciliare: row.Isolamento_domiciliare,
+		Totale_positivi:        row.Totale_positivi,
+		Nuovi_positivi:         row.Nuovi_positivi,
+		Dimessi_guariti:        row.Dimessi_guariti,
+		Deceduti:               row.Deceduti,
+		Totale_casi:            row.Totale_casi,
+		Tamponi:                row.Tamponi,
+	}
+}
+
+// Returns a provincial row as a regional one, with only the provincial fields set
+func provinceToRegionData(row covidgraphs.ProvinceData) covidgraphs.RegionData {
+	return covidgraphs.RegionData{
+		Data:           row.Data,
+		Totale_casi:    row.Totale_casi,
+		Nuovi_positivi: row.NuoviCasi,
+	}
+}
+
+// Returns the rows of the requested area, oldest first, and normalizes the requested name
+func (d *dataSnapshot) getExportRows(request *exportRequest) ([]covidgraphs.RegionData, error) {
+	rows := make([]covidgraphs.RegionData, 0)
+
+	switch request.area {
+	case exportAreaNation:
+		request.name = ""
+		for _, v := range d.nation {
+			rows = append(rows, nationToRegionData(v))
+		}
+	case exportAreaRegion:
+		regionIndex, err := covidgraphs.FindLastOccurrenceRegion(&d.regions, "denominazione_regione", request.name)
+		if err != nil {
+			return nil, fmt.Errorf("regione non trovata")
+		}
+		request.name = d.regions[regionIndex].Denominazione_regione
+		for _, v := range d.regions {
+			if v.Codice_regione == d.regions[regionIndex].Codice_regione {
+				rows = append(rows, v)
+			}
+		}
+	case exportAreaProvince:
+		provinceIndex, err := covidgraphs.FindLastOccurrenceProvince(&d.provinces, "denominazione_provincia", request.name)
+		if err != nil {
+			return nil, fmt.Errorf("provincia non trovata")
+		}
+		request.name = d.provinces[provinceIndex].Denominazione_provincia
+		for _, i := range *covidgraphs.GetProvinceIndexesByName(&d.provinces, request.name) {
+			rows = append(rows, provinceToRegionData(d.provinces[i]))
+		}
+	default:
+		return nil, fmt.Errorf("zona non valida")
+	}
+
+	return rows, nil
+}
+
+// Builds the table with the requested fields, their daily variation and their moving average
+func (d *dataSnapshot) buildExportTable(request *exportRequest) (exportTable, error) {
+	var table exportTable
+	if !request.to.IsZero() && request.from.After(request.to) {
+		return table, fmt.Errorf("la data di inizio è successiva a quella di fine")
+	}
+	rows, err := d.getExportRows(request)
+	if err != nil {
+		return table, err
+	}
+	if len(request.fields) == 0 {
+		request.fields = getExportFields(request.area)
+	}
+
+	table.header = []string{"data"}
+	columns := make([][]interface{}, 0)
+	for _, field := range request.fields {
+		values := make([]int, len(rows))
+		for i, row := range rows {
+			values[i], err = getRegionField(row, field)
+			if err != nil {
+				return table, fmt.Errorf("campo %s non valido", field)
+			}
+		}
+
+		// Cumulative fields are averaged on their daily variation, the other ones on their value
+		daily := make([]int, len(values))
+		deltas := make([]interface{}, len(values))
+		averages := make([]interface{}, len(values))
+		for i := range values {
+			if i > 0 {
+				deltas[i] = values[i] - values[i-1]
+			} else {
+				deltas[i] = ""
+			}
+			daily[i] = values[i]
+			if cumulativeFields[field] && i > 0 {
+				daily[i] = values[i] - values[i-1]
+			}
+			averages[i] = ""
+			first := 0 // First index with a meaningful daily value
+			if cumulativeFields[field] {
+				first = 1
+			}
+			if i-exportAverageDays+1 >= first {
+				sum := 0
+				for _, v := range daily[i-exportAverageDays+1 : i+1] {
+					sum += v
+				}
+				averages[i] = float64(sum) / exportAverageDays
+			}
+		}
+
+		column := make([]interface{}, len(values))
+		for i, v := range values {
+			column[i] = v
+		}
+		table.header = append(table.header, field, field+"_variazione", field+"_media_mobile_7g")
+		columns = append(columns, column, deltas, averages)
+	}
+
+	// Data are published in the afternoon, so days are compared from midnight
+	from, to := request.from.Truncate(24*time.Hour), request.to.Truncate(24*time.Hour)
+	for i, row := range rows {
+		date, err := time.Parse("2006-01-02T15:04:05", row.Data)
+		if err != nil {
+			log.Println("error parsing data in buildExportTable()")
+			continue
+		}
+		day := date.Truncate(24 * time.Hour)
+		if (!from.IsZero() && day.Before(from)) || (!to.IsZero() && day.After(to)) {
+			continue
+		}
+
+		values := []interface{}{date.Format(exportDateLayout)}
+		for _, column := range columns {
+			values = append(values, column[i])
+		}
+		table.rows = append(table.rows, values)
+	}
+	if len(table.rows) == 0 {
+		return table, fmt.Errorf("nessun dato nel periodo richiesto")
+	}
+
+	return table, nil
+}
+
+// Formats a cell value as text
+func formatExportValue(value interface{}) string {
+	switch v := value.(type) {
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', 1, 64)
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Writes the table in a CSV file
+func writeExportCSV(table exportTable, file io.Writer) error {
+	w := csv.NewWriter(file)
+	err := w.Write(table.header)
+	for i := 0; err == nil && i < len(table.rows); i++ {
+		record := make([]string, len(table.rows[i]))
+		for j, v := range table.rows[i] {
+			record[j] = formatExportValue(v)
+		}
+		err = w.Write(record)
+	}
+	w.Flush()
+	if err == nil {
+		err = w.Error()
+	}
+
+	return err
+}
+
+// Writes the table in a XLSX file
+func writeExportXLSX(table exportTable, file io.Writer, sheetName string) error {
+	f := excelize.NewFile()
+	f.SetSheetName("Sheet1", sheetName)
+
+	header := make([]interface{}, len(table.header))
+	for i, v := range table.header {
+		header[i] = v
+	}
+	err := f.SetSheetRow(sheetName, "A1", &header)
+	if err != nil {
+		return err
+	}
+	for i, row := range table.rows {
+		cell, err := excelize.CoordinatesToCellName(1, i+2)
+		if err != nil {
+			return err
+		}
+		err = f.SetSheetRow(sheetName, cell, &row)
+		if err != nil {
+			return err
+		}
+	}
+	err = f.SetPanes(sheetName, `{"freeze":true,"split":false,"x_split":1,"y_split":1,"top_left_cell":"B2","active_pane":"bottomRight"}`)
+	if err != nil {
+		log.Println(err)
+	}
+
+	return f.Write(file)
+}
+
+// Builds the requested export and returns the file path
+func (d *dataSnapshot) buildExport(request *exportRequest) (string, error) {
+	table, err := d.buildExportTable(request)
+	if err != nil {
+		return "", err
+	}
+
+	name := request.area
+	if request.name != "" {
+		name += " " + strings.Replace(request.name, "/", "-", -1)
+	}
+	if request.format != exportFormatCSV && request.format != exportFormatXLSX {
+		return "", fmt.Errorf("formato non valido")
+	}
+	return createTempOutput(name+"-*."+request.format, func(w io.Writer) error {
+		if request.format == exportFormatCSV {
+			return writeExportCSV(table, w)
+		}
+		sheetName := request.area
+		if request.name != "" {
+			sheetName = strings.NewReplacer("/", "-", "'", "").Replace(request.name)
+		}
+		if len(sheetName) > 31 {
+			sheetName = sheetName[:31]
+		}
+		return writeExportXLSX(table, w, sheetName)
+	})
+}
+
+// Builds the requested export and sends it as a document
+func (b *bot) sendExport(d *dataSnapshot, chatId int64, request exportRequest) {
+	b.SendChatAction(echotron.UPLOAD_DOCUMENT, chatId)
+	filename, err := d.buildExport(&request)
+	if err != nil {
+		log.Println(err)
+		b.SendMessage("Impossibile esportare i dati: "+err.Error()+".", chatId)
+		return
+	}
+
+	b.SendDocument(filename, "", chatId)
+	err = os.Remove(filename)
+	if err != nil {
+		log.Println("can't delete file " + filename)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/csv"
+	"github.com/xuri/excelize/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildExportTable(t *testing.T) {
+	d := getData()
+	table, err := d.buildExportTable(&exportRequest{area: exportAreaNation, fields: []string{"deceduti", "terapia_intensiva"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(table.header, ",") != "data,deceduti,deceduti_variazione,deceduti_media_mobile_7g,"+
+		"terapia_intensiva,terapia_intensiva_variazione,terapia_intensiva_media_mobile_7g" {
+		t.Errorf("unexpected header %v", table.header)
+	}
+	if len(table.rows) != len(d.nation) || table.rows[0][0] != "2022-02-19" {
+		t.Fatalf("%d rows from %v, want %d from the first day", len(table.rows), table.rows[0][0], len(d.nation))
+	}
+
+	// The cumulative deaths are averaged on their daily variation, intensive care on its value
+	last := len(d.nation) - 1
+	if table.rows[0][2] != "" || table.rows[last][2] != d.nation[last].Deceduti-d.nation[last-1].Deceduti {
+		t.Errorf("deaths variations %v and %v", table.rows[0][2], table.rows[last][2])
+	}
+	if table.rows[6][3] != "" || table.rows[7][3] != float64(d.nation[7].Deceduti-d.nation[0].Deceduti)/exportAverageDays {
+		t.Errorf("deaths averages %v and %v", table.rows[6][3], table.rows[7][3])
+	}
+	sum := 0
+	for _, v := range d.nation[:exportAverageDays] {
+		sum += v.Terapia_intensiva
+	}
+	if table.rows[5][6] != "" || table.rows[6][6] != float64(sum)/exportAverageDays {
+		t.Errorf("intensive care averages %v and %v", table.rows[5][6], table.rows[6][6])
+	}
+
+	from, _ := time.Parse(exportDateLayout, "2022-03-01")
+	to, _ := time.Parse(exportDateLayout, "2022-03-03")
+	table, err = d.buildExportTable(&exportRequest{area: exportAreaProvince, name: "Bari", from: from, to: to})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(table.rows) != 3 || table.rows[0][0] != "2022-03-01" || len(table.header) != 1+3*len(provincesAttributes) {
+		t.Errorf("%d rows from %v with header %v, want the 3 days requested", len(table.rows), table.rows[0][0], table.header)
+	}
+
+	table, err = d.buildExportTable(&exportRequest{area: exportAreaNation, from: to, to: to})
+	if err != nil || len(table.rows) != 1 || table.rows[0][0] != "2022-03-03" {
+		t.Errorf("export of a single day: %v %v", table.rows, err)
+	}
+
+	for _, request := range []exportRequest{
+		{area: exportAreaNation, fields: []string{"positivi"}},
+		{area: exportAreaRegion, name: "Atlantide"},
+		{area: exportAreaNation, from: to.AddDate(1, 0, 0)},
+		{area: exportAreaNation, from: to, to: from},
+	} {
+		if _, err = d.buildExportTable(&request); err == nil {
+			t.Errorf("invalid export %+v accepted", request)
+		}
+	}
+}
+
+func TestBuildExportCSV(t *testing.T) {
+	filename, err := getData().buildExport(&exportRequest{area: exportAreaRegion, name: "Puglia", fields: []string{"deceduti"}, format: exportFormatCSV})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	if !strings.HasPrefix(filepath.Base(filename), "regione Puglia-") || filepath.Ext(filename) != ".csv" {
+		t.Errorf("unexpected file name %s", filename)
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 16 || strings.Join(records[0], ",") != "data,deceduti,deceduti_variazione,deceduti_media_mobile_7g" {
+		t.Errorf("%d records with header %v", len(records), records[0])
+	}
+	if records[1][2] != "" || !strings.Contains(records[15][3], ".") {
+		t.Errorf("unexpected records %v and %v", records[1], records[15])
+	}
+}
+
+func TestBuildExportXLSX(t *testing.T) {
+	filename, err := getData().buildExport(&exportRequest{area: exportAreaProvince, name: "L'Aquila", format: exportFormatXLSX})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	if !strings.HasPrefix(filepath.Base(filename), "provincia L'Aquila-") || filepath.Ext(filename) != ".xlsx" {
+		t.Errorf("unexpected file name %s", filename)
+	}
+
+	f, err := excelize.OpenFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := f.GetRows("LAquila")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 16 || rows[0][1] != "totale_casi" || rows[1][0] != "2022-02-19" {
+		t.Errorf("unexpected rows %v", rows[:2])
+	}
+}
+
+func TestBuildExportUniqueNames(t *testing.T) {
+	d := getData()
+	request := exportRequest{area: exportAreaNation, format: exportFormatCSV}
+	first, err := d.buildExport(&request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(first)
+	second, err := d.buildExport(&request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(second)
+	if first == second {
+		t.Errorf("two exports saved in %s", first)
+	}
+
+	request.format = "pdf"
+	if _, err = d.buildExport(&request); err == nil {
+		t.Error("export in an unknown format accepted")
+	}
+}
+
+func TestEsporta(t *testing.T) {
+	b := newTestBot(testChatId)
+	calls := b.press("esporta regione xlsx Puglia")
+	expectMethods(t, calls, "answerCallbackQuery", "sendDocument")
+	if !strings.HasPrefix(calls[1].Document, "regione Puglia-") || !strings.HasSuffix(calls[1].Document, ".xlsx") {
+		t.Errorf("unexpected document %s", calls[1].Document)
+	}
+
+	calls = b.send("/esporta nazione deceduti 2022-03-01 2022-03-05 csv")
+	expectMethods(t, calls, "sendDocument")
+	if !strings.HasPrefix(calls[0].Document, "nazione-") || !strings.HasSuffix(calls[0].Document, ".csv") {
+		t.Errorf("unexpected document %s", calls[0].Document)
+	}
+
+	calls = b.send("/esporta nazione 2022-03-05 2022-03-01")
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "Impossibile esportare i dati: la data di inizio è successiva a quella di fine.")
+
+	calls = b.send("/esporta regione atlantide")
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "Impossibile esportare i dati")
+
+	files, err := filepath.Glob(workingDirectory + reportsFolder + "*")
+	if err != nil || len(files) != 0 {
+		t.Errorf("exports left in the reports folder: %v", files)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Request received by the fake Telegram Bot API
+type apiCall struct {
+	Method    string
+	ChatId    int64
+	MessageId int
+	Text      string   // Text of a message or caption of a photo
+	Photo     string   // Name of the plot file, also when it is sent again by file_id
+	PNG       bool     // The uploaded photo is a PNG picture
+	Document  string   // Name of the uploaded document
+	PDF       bool     // The uploaded document is a PDF file
+	Keyboard  []button // Inline keyboard, one button after the other
+	Params    url.Values
+}
+
+// Button of an inline keyboard
+type button struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
+}
+
+// Returns the callback data of the keyboard buttons
+func (c apiCall) callbacks() []string {
+	data := make([]string, 0, len(c.Keyboard))
+	for _, v := range c.Keyboard {
+		data = append(data, v.CallbackData)
+	}
+	return data
+}
+
+// Returns the text of the keyboard buttons
+func (c apiCall) buttons() []string {
+	texts := make([]string, 0, len(c.Keyboard))
+	for _, v := range c.Keyboard {
+		texts = append(texts, v.Text)
+	}
+	return texts
+}
+
+// In-process Telegram Bot API recording every request and answering like Telegram does
+type fakeTelegram struct {
+	mutex            sync.Mutex
+	calls            []apiCall
+	lastMessageId    int
+	files            map[string]string // Name of the uploaded file of every file_id returned
+	chatMemberStatus string            // Status returned by getChatMember
+	blockedChats     map[int64]bool    // Chats that blocked the bot, their messages are refused
+}
+
+func newFakeTelegram() *fakeTelegram {
+	return &fakeTelegram{
+		files:            make(map[string]string),
+		chatMemberStatus: "member",
+		blockedChats:     make(map[int64]bool),
+	}
+}
+
+// Refuses the messages to the chat as if it blocked the bot, until the end of the test
+func (f *fakeTelegram) blockChat(t *testing.T, chatId int64) {
+	f.mutex.Lock()
+	f.blockedChats[chatId] = true
+	f.mutex.Unlock()
+	t.Cleanup(func() {
+		f.mutex.Lock()
+		delete(f.blockedChats, chatId)
+		f.mutex.Unlock()
+	})
+}
+
+// Sets the status of every user in the groups, restored to member at the end of the test
+func (f *fakeTelegram) setChatMemberStatus(t *testing.T, status string) {
+	f.mutex.Lock()
+	f.chatMemberStatus = status
+	f.mutex.Unlock()
+	t.Cleanup(func() {
+		f.mutex.Lock()
+		f.chatMemberStatus = "member"
+		f.mutex.Unlock()
+	})
+}
+
+// Forgets the recorded requests
+func (f *fakeTelegram) reset() {
+	f.mutex.Lock()
+	f.calls = nil
+	f.mutex.Unlock()
+}
+
+// Returns the recorded requests, leaving out the chat actions sent while plots are rendered
+func (f *fakeTelegram) requests() []apiCall {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	calls := make([]apiCall, 0, len(f.calls))
+	for _, v := range f.calls {
+		if v.Method != "sendChatAction" {
+			calls = append(calls, v)
+		}
+	}
+	return calls
+}
+
+func (f *fakeTelegram) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	call := apiCall{Method: path.Base(r.URL.Path), Params: r.URL.Query()}
+	call.ChatId, _ = strconv.ParseInt(call.Params.Get("chat_id"), 10, 64)
+	call.MessageId, _ = strconv.Atoi(call.Params.Get("message_id"))
+	call.Text = call.Params.Get("text")
+	if call.Text == "" {
+		call.Text = call.Params.Get("caption")
+	}
+	if markup := call.Params.Get("reply_markup"); markup != "" {
+		var keyboard struct {
+			InlineKeyboard [][]button `json:"inline_keyboard"`
+		}
+		if err := json.Unmarshal([]byte(markup), &keyboard); err != nil {
+			writeTelegramError(w, 400, "Bad Request: can't parse reply keyboard markup JSON object")
+			return
+		}
+		for _, row := range keyboard.InlineKeyboard {
+			call.Keyboard = append(call.Keyboard, row...)
+		}
+	}
+
+	photo := call.Params.Get("photo")
+	if call.Method == "editMessageMedia" {
+		var media inputMediaPhoto
+		json.Unmarshal([]byte(call.Params.Get("media")), &media)
+		photo = media.Media
+		call.Text = media.Caption
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.blockedChats[call.ChatId] {
+		writeTelegramError(w, 403, "Forbidden: bot was blocked by the user")
+		return
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if file, header, err := r.FormFile("photo"); err == nil {
+			content, _ := ioutil.ReadAll(file)
+			file.Close()
+			call.Photo = header.Filename
+			call.PNG = bytes.HasPrefix(content, []byte("\x89PNG"))
+		} else if file, header, err := r.FormFile("document"); err == nil {
+			content, _ := ioutil.ReadAll(file)
+			file.Close()
+			call.Document = header.Filename
+			call.PDF = bytes.HasPrefix(content, []byte("%PDF"))
+		} else {
+			writeTelegramError(w, 400, "Bad Request: there is no file in the request")
+			return
+		}
+	} else if photo != "" {
+		filename, ok := f.files[photo]
+		if !ok {
+			writeTelegramError(w, 400, "Bad Request: wrong file identifier/HTTP URL specified")
+			return
+		}
+		call.Photo = filename
+		call.PNG = true
+	}
+	f.calls = append(f.calls, call)
+
+	var result interface{} = true
+	switch call.Method {
+	case "sendMessage", "sendPhoto", "sendDocument", "editMessageText", "editMessageReplyMarkup", "editMessageMedia", "editMessageCaption":
+		message := map[string]interface{}{
+			"message_id": call.MessageId,
+			"chat":       map[string]interface{}{"id": call.ChatId},
+			"text":       call.Text,
+		}
+		if call.MessageId == 0 {
+			f.lastMessageId++
+			message["message_id"] = f.lastMessageId
+		}
+		if call.Photo != "" {
+			fileId := "file-" + call.Photo
+			f.files[fileId] = call.Photo
+			message["photo"] = []map[string]interface{}{{"file_id": fileId, "width": 800, "height": 600}}
+		}
+		result = message
+	case "getChatMember":
+		result = map[string]interface{}{"user": map[string]interface{}{"id": 1}, "status": f.chatMemberStatus}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+}
+
+// Answers like Telegram does to a refused request
+func writeTelegramError(w http.ResponseWriter, code int, description string) {
+	json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error_code": code, "description": description})
+}
+
+// Serves the pcm-dpc datasets from the CSV files in dir, converting the data files to JSON
+// as they are published in the dati-json folder of the repository
+type pcmDpcFixtures struct {
+	dir string
+}
+
+func (p pcmDpcFixtures) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSuffix(path.Base(r.URL.Path), path.Ext(r.URL.Path))
+	content, err := ioutil.ReadFile(filepath.Join(p.dir, name+".csv"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if path.Ext(r.URL.Path) == ".csv" {
+		w.Write(content)
+		return
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(content)).ReadAll()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rows := make([]map[string]interface{}, 0, len(records))
+	for _, record := range records[1:] {
+		row := make(map[string]interface{})
+		for i, v := range record {
+			row[records[0][i]] = csvValue(v)
+		}
+		rows = append(rows, row)
+	}
+	json.NewEncoder(w).Encode(rows)
+}
+
+// Returns the JSON value of a CSV field: numbers for numeric fields and null for empty ones
+func csvValue(v string) interface{} {
+	if v == "" {
+		return nil
+	}
+	if n, err := strconv.Atoi(v); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(v, 64); err == nil {
+		return f
+	}
+	return v
+}
+
+// Hands the requests to the in-process server of their host, so that no request leaves the test
+type fakeTransport map[string]http.Handler
+
+func (t fakeTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	handler, ok := t[request.URL.Host]
+	if !ok {
+		return nil, fmt.Errorf("unexpected request to %s", request.URL.Host)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder.Result(), nil
+}
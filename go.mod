@@ -6,5 +6,10 @@ require (
 	github.com/DarkFighterLuke/covidgraphs v1.1.1
 	github.com/DarkFighterLuke/gitUpdateChecker/v2 v2.0.0
 	github.com/NicoNex/echotron v1.0.0
+	github.com/jung-kurt/gofpdf v1.16.2
+	github.com/prometheus/client_golang v1.11.1
 	github.com/robfig/cron/v3 v3.0.1
+	github.com/wcharczuk/go-chart v2.0.2-0.20191206192251-962b9abdec2b+incompatible
+	github.com/xuri/excelize/v2 v2.6.1
+	golang.org/x/time v0.0.0-20220722155302-e5dcc9cfc0b9
 )
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestGroupStart(t *testing.T) {
+	g := newTestBot(testGroupId)
+	calls := g.send("/start" + botUsername)
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "Questo comando non è disponibile nei gruppi.")
+}
+
+func TestGroupNation(t *testing.T) {
+	g := newTestBot(testGroupId)
+	calls := g.send("/nazione" + botUsername)
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "<b>Dati nazione</b>")
+	expectKeyboard(t, calls[0], "previous nazione groups", "andamento nazione groups", "next nazione groups", "annulla nazione groups", "fatto nazione groups")
+
+	calls = g.press("next nazione groups")
+	expectMethods(t, calls, "editMessageReplyMarkup", "answerCallbackQuery")
+	expectKeyboard(t, calls[0], "previous nazione groups", "ricoverati con sintomi nazione groups", "next nazione groups", "annulla nazione groups", "fatto nazione groups")
+
+	calls = g.press("previous nazione groups")
+	expectMethods(t, calls, "editMessageReplyMarkup", "answerCallbackQuery")
+	expectKeyboard(t, calls[0], "previous nazione groups", "andamento nazione groups", "next nazione groups", "annulla nazione groups", "fatto nazione groups")
+
+	calls = g.press("andamento nazione groups")
+	expectMethods(t, calls, "deleteMessage", "sendPhoto")
+	expectPlot(t, calls[1], "Andamento nazionale")
+	expectText(t, calls[1], "<b>Andamento nazionale 2022-03-05</b>")
+	expectKeyboard(t, calls[1], "esporta nazione csv", "esporta nazione xlsx")
+
+	g.send("/nazione" + botUsername)
+	calls = g.press("terapia intensiva nazione groups")
+	expectMethods(t, calls, "editMessageReplyMarkup", "answerCallbackQuery")
+	expectKeyboard(t, calls[0], "previous nazione groups", "totale ospedalizzati nazione groups", "next nazione groups", "annulla nazione groups", "fatto nazione groups")
+	expectText(t, calls[1], "Aggiunto al confronto")
+
+	calls = g.press("fatto nazione groups")
+	expectMethods(t, calls, "deleteMessage", "sendPhoto", "answerCallbackQuery")
+	expectPlot(t, calls[1], "Nazioneterapia_intensiva__")
+	expectText(t, calls[1], "<b>Andamento nazione 2022-03-05</b>", "Terapia intensiva: </b>617 (<i>-17</i>)")
+
+	g.send("/nazione" + botUsername)
+	calls = g.press("annulla nazione groups")
+	expectMethods(t, calls, "deleteMessage")
+}
+
+func TestGroupRegion(t *testing.T) {
+	g := newTestBot(testGroupId)
+	calls := g.send("/regione" + botUsername)
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "<b>Dati regione</b>")
+	expectKeyboard(t, calls[0], "previous zone groups", "sud groups", "next zone groups", "annulla zone groups")
+
+	calls = g.press("next zone groups")
+	expectMethods(t, calls, "editMessageReplyMarkup", "answerCallbackQuery")
+	expectKeyboard(t, calls[0], "previous zone groups", "centro groups", "next zone groups", "annulla zone groups")
+
+	calls = g.press("previous zone groups")
+	expectKeyboard(t, calls[0], "previous zone groups", "sud groups", "next zone groups", "annulla zone groups")
+
+	calls = g.press("sud groups")
+	expectMethods(t, calls, "editMessageReplyMarkup", "answerCallbackQuery")
+	expectKeyboard(t, calls[0], "previous region groups", "abruzzo groups", "next region groups", "annulla region groups")
+
+	calls = g.press("next region groups")
+	expectMethods(t, calls, "editMessageReplyMarkup", "answerCallbackQuery")
+	expectKeyboard(t, calls[0], "previous region groups", "molise groups", "next region groups", "annulla region groups")
+
+	attributes := []string{"previous region attr groups", "andamento region attr groups", "next region attr groups", "annulla region attr groups", "fatto region attr groups"}
+	calls = g.press("molise groups")
+	expectMethods(t, calls, "editMessageReplyMarkup", "answerCallbackQuery")
+	expectKeyboard(t, calls[0], attributes...)
+
+	calls = g.press("andamento region attr groups")
+	expectMethods(t, calls, "deleteMessage", "sendPhoto")
+	expectPlot(t, calls[1], "Dati regione Molise")
+	expectText(t, calls[1], "<b>Andamento regione Molise 2022-03-05</b>", "Totale positivi: </b>118545 (<i>+429</i>)")
+	expectKeyboard(t, calls[1], "esporta regione csv Molise", "esporta regione xlsx Molise")
+
+	calls = g.press("molise groups")
+	expectKeyboard(t, calls[0], attributes...)
+	calls = g.press("terapia intensiva region attr groups")
+	expectMethods(t, calls, "editMessageReplyMarkup", "answerCallbackQuery")
+	expectKeyboard(t, calls[0], "previous region attr groups", "totale ospedalizzati region attr groups", "next region attr groups", "annulla region attr groups", "fatto region attr groups")
+
+	calls = g.press("fatto region attr groups")
+	expectMethods(t, calls, "deleteMessage", "sendPhoto", "answerCallbackQuery")
+	expectPlot(t, calls[1], "RegioneMoliseterapia_intensiva__")
+	expectText(t, calls[1], "<b>Andamento regione Molise 2022-03-05</b>", "Terapia intensiva: </b>16 (<i>+0</i>)")
+
+	g.send("/regione" + botUsername)
+	calls = g.press("annulla zone groups")
+	expectMethods(t, calls, "deleteMessage")
+}
+
+func TestGroupProvince(t *testing.T) {
+	g := newTestBot(testGroupId)
+	calls := g.send("/provincia" + botUsername)
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "<b>Dati provincia</b>")
+	expectKeyboard(t, calls[0], "previous zone p groups", "sud p groups", "next zone p groups", "annulla zone p groups")
+
+	calls = g.press("sud p groups")
+	expectMethods(t, calls, "editMessageReplyMarkup", "answerCallbackQuery")
+	expectKeyboard(t, calls[0], "previous region p groups", "abruzzo p groups", "next region p groups", "annulla region p groups")
+
+	calls = g.press("abruzzo p groups")
+	expectMethods(t, calls, "editMessageReplyMarkup", "answerCallbackQuery")
+	expectKeyboard(t, calls[0], "previous province groups", "l'aquila province", "next province groups", "annulla province groups")
+
+	calls = g.press("next province groups")
+	expectMethods(t, calls, "editMessageReplyMarkup", "answerCallbackQuery")
+	expectKeyboard(t, calls[0], "previous province groups", "pescara province", "next province groups", "annulla province groups")
+
+	calls = g.press("pescara province")
+	expectMethods(t, calls, "sendPhoto", "answerCallbackQuery")
+	expectPlot(t, calls[0], "Totale Contagi Pescara")
+	expectText(t, calls[0], "<b>Andamento provincia di Pescara 2022-03-05</b>")
+	expectKeyboard(t, calls[0], "esporta provincia csv Pescara", "esporta provincia xlsx Pescara")
+
+	calls = g.press("annulla province groups")
+	expectMethods(t, calls, "deleteMessage")
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	healthStatusOK       = "ok"
+	healthStatusDegraded = "degradato"
+	healthStatusNotReady = "non pronto"
+)
+
+var maxDataAge = getEnvDuration("CovidBotMaxDataAge", 36*time.Hour) // Age of the latest data after which health is degraded
+
+var (
+	healthMutex       sync.RWMutex
+	lastUpdateAttempt time.Time
+	lastUpdateSuccess time.Time
+	lastUpdateError   error
+)
+
+// Status returned by the health endpoints
+type healthStatus struct {
+	Stato               string `json:"stato"`
+	VersioneDati        uint64 `json:"versione_dati"`
+	DataDati            string `json:"data_dati,omitempty"`
+	UltimoTentativo     string `json:"ultimo_tentativo,omitempty"`
+	UltimoAggiornamento string `json:"ultimo_aggiornamento,omitempty"`
+	Errore              string `json:"errore,omitempty"`
+}
+
+// Registers the health endpoints on the same listener used by the webhook
+func initHealth() {
+	http.HandleFunc("/healthz", handleHealthz)
+	http.HandleFunc("/readyz", handleReadyz)
+}
+
+// Saves the outcome of a data update attempt
+func setUpdateStatus(err error) {
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+
+	lastUpdateAttempt = time.Now()
+	lastUpdateError = err
+	if err == nil {
+		lastUpdateSuccess = lastUpdateAttempt
+	}
+}
+
+// Checks if every dataset has been loaded
+func (d *dataSnapshot) isReady() bool {
+	return len(d.nation) > 0 && len(d.regions) > 0 && len(d.provinces) > 0
+}
+
+// Returns the current health status, checking the data age and the last update attempt
+func getHealthStatus() healthStatus {
+	d := getData()
+	healthMutex.RLock()
+	defer healthMutex.RUnlock()
+
+	status := healthStatus{Stato: healthStatusOK, VersioneDati: d.version}
+	if !lastUpdateAttempt.IsZero() {
+		status.UltimoTentativo = lastUpdateAttempt.Format(time.RFC3339)
+	}
+	if !lastUpdateSuccess.IsZero() {
+		status.UltimoAggiornamento = lastUpdateSuccess.Format(time.RFC3339)
+	}
+	if !d.isReady() {
+		status.Stato = healthStatusNotReady
+		return status
+	}
+
+	dataDate, err := time.ParseInLocation("2006-01-02T15:04:05", d.nation[len(d.nation)-1].Data, romeLocation())
+	if err != nil {
+		status.Stato = healthStatusDegraded
+		status.Errore = "data dei dati non valida"
+		return status
+	}
+	status.DataDati = dataDate.Format(time.RFC3339)
+
+	if lastUpdateError != nil {
+		status.Stato = healthStatusDegraded
+		status.Errore = "ultimo aggiornamento fallito: " + lastUpdateError.Error()
+	} else if time.Since(dataDate) > maxDataAge {
+		status.Stato = healthStatusDegraded
+		status.Errore = "dati più vecchi di " + maxDataAge.String()
+	}
+	return status
+}
+
+// Returns the Europe/Rome location, used by pcm-dpc dates
+func romeLocation() *time.Location {
+	loc, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// Handles "/healthz", failing when data are stale or the last update errored
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	status := getHealthStatus()
+	code := http.StatusOK
+	if status.Stato != healthStatusOK {
+		code = http.StatusServiceUnavailable
+	}
+	writeAPIJSON(w, code, status)
+}
+
+// Handles "/readyz", failing until the first data update has loaded every dataset
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ready := getData().isReady()
+
+	status := healthStatus{Stato: healthStatusOK}
+	code := http.StatusOK
+	if !ready {
+		status.Stato = healthStatusNotReady
+		code = http.StatusServiceUnavailable
+	}
+	writeAPIJSON(w, code, status)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Serves a request to a health endpoint and decodes its status
+func healthRequest(t *testing.T, handler http.HandlerFunc) (int, healthStatus) {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	var status healthStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, status
+}
+
+func TestHealthz(t *testing.T) {
+	defer func(age time.Duration) { maxDataAge = age }(maxDataAge)
+	defer setUpdateStatus(nil)
+
+	// The fixtures end on 2022-03-05
+	maxDataAge = time.Since(time.Date(2022, 3, 4, 0, 0, 0, 0, time.UTC))
+	setUpdateStatus(nil)
+	code, status := healthRequest(t, handleHealthz)
+	if code != http.StatusOK || status.Stato != healthStatusOK || status.DataDati[:10] != "2022-03-05" || status.VersioneDati != getData().version {
+		t.Errorf("status %d %+v, want healthy", code, status)
+	}
+
+	setUpdateStatus(fmt.Errorf("pcm-dpc non raggiungibile"))
+	code, status = healthRequest(t, handleHealthz)
+	if code != http.StatusServiceUnavailable || status.Stato != healthStatusDegraded || status.Errore != "ultimo aggiornamento fallito: pcm-dpc non raggiungibile" {
+		t.Errorf("status %d %+v, want degraded by the failed update", code, status)
+	}
+
+	setUpdateStatus(nil)
+	maxDataAge = 36 * time.Hour
+	code, status = healthRequest(t, handleHealthz)
+	if code != http.StatusServiceUnavailable || status.Stato != healthStatusDegraded || status.Errore != "dati più vecchi di 36h0m0s" {
+		t.Errorf("status %d %+v, want degraded by stale data", code, status)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	code, status := healthRequest(t, handleReadyz)
+	if code != http.StatusOK || status.Stato != healthStatusOK {
+		t.Errorf("status %d %+v, want ready", code, status)
+	}
+
+	d := getData()
+	defer currentData.Store(d)
+	currentData.Store(&dataSnapshot{pcmDpcData: pcmDpcData{nation: d.nation, regions: d.regions}})
+	for _, handler := range []http.HandlerFunc{handleReadyz, handleHealthz} {
+		code, status = healthRequest(t, handler)
+		if code != http.StatusServiceUnavailable || status.Stato != healthStatusNotReady {
+			t.Errorf("status %d %+v without provinces, want not ready", code, status)
+		}
+	}
+}
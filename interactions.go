@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/NicoNex/echotron"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	interactionsFilePrefix = "interazioni-"
+	interactionsFileExt    = ".jsonl"
+	interactionsOptOutFile = "/log_optout.json"
+	interactionsSaltFile   = "/log_salt"
+	interactionCommand     = "comando"
+	interactionCallback    = "callback"
+)
+
+var interactionsEnabled = getEnv("CovidBotInteractionLog", "true") != "false" // Set to false to disable the interactions log
+var interactionsRetentionDays = getEnvInt("CovidBotLogRetentionDays", 30)     // Days after which daily log files are deleted
+
+// One handled command or callback. Chats are identified only by a keyed hash of their id
+// and only the command name or the callback data are recorded, never names or free text
+type interaction struct {
+	Ora      string `json:"ora"`
+	Chat     string `json:"chat"`
+	TipoChat string `json:"tipo_chat"`
+	Tipo     string `json:"tipo"`
+	Azione   string `json:"azione"`
+	DurataMs int64  `json:"durata_ms"`
+	Limitato bool   `json:"limitato,omitempty"`
+}
+
+var interactionsMutex = &sync.Mutex{}
+var interactionsFile *os.File // Log file of the current day
+var interactionsDate string   // Day of the open log file
+var interactionsSalt []byte
+var interactionsOptOut = make(map[string]bool) // Hashes of the chats that don't want to be logged
+
+// Loads the hashing key and the opted out chats, creating the key the first time
+func initInteractionsLog() {
+	interactionsMutex.Lock()
+	defer interactionsMutex.Unlock()
+
+	if salt := getEnv("CovidBotLogSalt", ""); salt != "" {
+		interactionsSalt = []byte(salt)
+	} else {
+		salt, err := ioutil.ReadFile(workingDirectory + interactionsSaltFile)
+		if os.IsNotExist(err) {
+			salt = make([]byte, 32)
+			_, err = rand.Read(salt)
+			if err == nil {
+				err = ioutil.WriteFile(workingDirectory+interactionsSaltFile, salt, 0600)
+			}
+		}
+		if err != nil {
+			log.Println("impossibile caricare la chiave dei log, registro delle interazioni disattivato:", err)
+			interactionsEnabled = false
+			return
+		}
+		interactionsSalt = salt
+	}
+
+	var optOut []string
+	err := loadJSON(interactionsOptOutFile, &optOut)
+	if err != nil {
+		log.Println("errore nel caricamento delle esclusioni dai log:", err)
+	}
+	for _, v := range optOut {
+		interactionsOptOut[v] = true
+	}
+
+	removeOldInteractionsLogs()
+}
+
+// Returns the anonymised identifier of a chat
+func hashChatId(chatId int64) string {
+	mac := hmac.New(sha256.New, interactionsSalt)
+	mac.Write([]byte(strconv.FormatInt(chatId, 10)))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// Returns the chat, the kind and the action of a command or callback update.
+// The last value is false for the other updates and for the privacy actions, which aren't recorded
+func getInteraction(update *echotron.Update) (*echotron.Chat, string, string, bool) {
+	var chat *echotron.Chat
+	var kind, action string
+	switch {
+	case update.Message != nil && update.Message.Chat != nil && strings.HasPrefix(update.Message.Text, "/"):
+		chat = update.Message.Chat
+		kind = interactionCommand
+		action = strings.TrimSuffix(strings.Fields(update.Message.Text)[0], botUsername)
+	case update.CallbackQuery != nil && update.CallbackQuery.Message != nil && update.CallbackQuery.Message.Chat != nil:
+		chat = update.CallbackQuery.Message.Chat
+		kind = interactionCallback
+		action = update.CallbackQuery.Data
+	default:
+		return nil, "", "", false
+	}
+	return chat, kind, action, !isPrivacyAction(action)
+}
+
+// Records an update handled since start, if the chat hasn't opted out
+func logInteraction(update *echotron.Update, start time.Time, limited bool) {
+	if !interactionsEnabled {
+		return
+	}
+
+	chat, kind, action, ok := getInteraction(update)
+	if !ok {
+		return
+	}
+	entry := interaction{
+		Ora:      start.Format(time.RFC3339),
+		Chat:     hashChatId(chat.ID),
+		TipoChat: chat.Type,
+		Tipo:     kind,
+		Azione:   action,
+		DurataMs: time.Since(start).Milliseconds(),
+		Limitato: limited,
+	}
+
+	interactionsMutex.Lock()
+	defer interactionsMutex.Unlock()
+	if interactionsOptOut[entry.Chat] {
+		return
+	}
+
+	err := writeInteraction(entry)
+	if err != nil {
+		log.Println("errore nella scrittura del registro delle interazioni:", err)
+	}
+}
+
+// Appends the entry to the log file of the day, opening a new file when the day changes.
+// Must be called with interactionsMutex held
+func writeInteraction(entry interaction) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	date := time.Now().Format("2006-01-02")
+	if interactionsFile == nil || date != interactionsDate {
+		closeInteractionsFile()
+		interactionsFile, err = os.OpenFile(workingDirectory+logsFolder+interactionsFilePrefix+date+interactionsFileExt, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+		if err != nil {
+			return err
+		}
+		interactionsDate = date
+		removeOldInteractionsLogs()
+	}
+
+	_, err = interactionsFile.Write(append(data, '\n'))
+	return err
+}
+
+// Closes the log file of the day. Must be called with interactionsMutex held
+func closeInteractionsFile() {
+	if interactionsFile == nil {
+		return
+	}
+	err := interactionsFile.Close()
+	if err != nil {
+		log.Println(err)
+	}
+	interactionsFile = nil
+}
+
+// Closes the interactions log, used when the bot stops
+func closeInteractionsLog() {
+	interactionsMutex.Lock()
+	closeInteractionsFile()
+	interactionsMutex.Unlock()
+}
+
+// Deletes the daily log files older than the retention period
+func removeOldInteractionsLogs() {
+	files, err := filepath.Glob(workingDirectory + logsFolder + interactionsFilePrefix + "*" + interactionsFileExt)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	limit := time.Now().AddDate(0, 0, -interactionsRetentionDays).Format("2006-01-02")
+	for _, v := range files {
+		date := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(v), interactionsFilePrefix), interactionsFileExt)
+		if date >= limit {
+			continue
+		}
+		err = os.Remove(v)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// Excludes a chat from the interactions log or includes it again
+func setInteractionsOptOut(chatId int64, optOut bool) error {
+	interactionsMutex.Lock()
+	defer interactionsMutex.Unlock()
+
+	hash := hashChatId(chatId)
+	if optOut {
+		interactionsOptOut[hash] = true
+	} else {
+		delete(interactionsOptOut, hash)
+	}
+
+	hashes := make([]string, 0, len(interactionsOptOut))
+	for k := range interactionsOptOut {
+		hashes = append(hashes, k)
+	}
+	return saveJSON(interactionsOptOutFile, hashes)
+}
+
+// Returns true if the chat has asked not to be logged
+func isInteractionsOptOut(chatId int64) bool {
+	interactionsMutex.Lock()
+	defer interactionsMutex.Unlock()
+	return interactionsOptOut[hashChatId(chatId)]
+}
+
+// Returns the logged interactions of a chat, oldest first
+func getChatInteractions(chatId int64) ([]interaction, error) {
+	interactionsMutex.Lock()
+	defer interactionsMutex.Unlock()
+
+	hash := hashChatId(chatId)
+	entries := make([]interaction, 0)
+	err := forEachInteractionsLog(func(filename string, lines [][]byte) error {
+		for _, line := range lines {
+			var entry interaction
+			if json.Unmarshal(line, &entry) == nil && entry.Chat == hash {
+				entries = append(entries, entry)
+			}
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// Removes the logged interactions of a chat from every log file
+func deleteChatInteractions(chatId int64) error {
+	interactionsMutex.Lock()
+	defer interactionsMutex.Unlock()
+
+	// The file of the day is opened again at the next interaction
+	closeInteractionsFile()
+
+	hash := hashChatId(chatId)
+	return forEachInteractionsLog(func(filename string, lines [][]byte) error {
+		kept := make([]byte, 0)
+		removed := false
+		for _, line := range lines {
+			var entry interaction
+			if json.Unmarshal(line, &entry) == nil && entry.Chat == hash {
+				removed = true
+				continue
+			}
+			kept = append(append(kept, line...), '\n')
+		}
+		if !removed {
+			return nil
+		}
+
+		err := ioutil.WriteFile(filename+".tmp", kept, 0600)
+		if err != nil {
+			return err
+		}
+		return os.Rename(filename+".tmp", filename)
+	})
+}
+
+// Calls f with the non empty lines of every log file. Must be called with interactionsMutex held
+func forEachInteractionsLog(f func(filename string, lines [][]byte) error) error {
+	files, err := filepath.Glob(workingDirectory + logsFolder + interactionsFilePrefix + "*" + interactionsFileExt)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range files {
+		content, err := ioutil.ReadFile(v)
+		if err != nil {
+			return err
+		}
+		lines := make([][]byte, 0)
+		for _, line := range bytes.Split(content, []byte("\n")) {
+			if len(line) > 0 {
+				lines = append(lines, line)
+			}
+		}
+		err = f(v, lines)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
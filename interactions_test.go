@@ -0,0 +1,151 @@
+package main
+
+import (
+	"github.com/NicoNex/echotron"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Moves the interactions log to a new empty directory for the duration of the test
+func useEmptyLogsFolder(t *testing.T) string {
+	closeInteractionsLog()
+	previous := workingDirectory
+	workingDirectory = t.TempDir()
+	t.Cleanup(func() {
+		closeInteractionsLog()
+		workingDirectory = previous
+	})
+	if err := os.MkdirAll(workingDirectory+logsFolder, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return workingDirectory + logsFolder
+}
+
+// Returns a command sent by a user in the given chat
+func commandUpdate(chatId int64, text string) *echotron.Update {
+	return &echotron.Update{Message: &echotron.Message{
+		Text: text,
+		User: &echotron.User{ID: testUserId, FirstName: "Mario"},
+		Chat: &echotron.Chat{ID: chatId, Type: "private", FirstName: "Mario", Username: "mario"},
+	}}
+}
+
+func TestHashChatId(t *testing.T) {
+	hash := hashChatId(testChatId)
+	if !regexp.MustCompile(`^[0-9a-f]{16}$`).MatchString(hash) || hash != hashChatId(testChatId) || hash == hashChatId(testGroupId) {
+		t.Errorf("hash %q of the test chat", hash)
+	}
+}
+
+func TestGetInteraction(t *testing.T) {
+	chat, kind, action, ok := getInteraction(commandUpdate(testChatId, "/regione"+botUsername+" Puglia"))
+	if !ok || chat.ID != testChatId || kind != interactionCommand || action != "/regione" {
+		t.Errorf("command logged as %s %q %v", kind, action, ok)
+	}
+
+	_, kind, action, ok = getInteraction(&echotron.Update{CallbackQuery: &echotron.CallbackQuery{
+		Data:    "nuovi casi nazione",
+		Message: &echotron.Message{Chat: testChat(testGroupId)},
+	}})
+	if !ok || kind != interactionCallback || action != "nuovi casi nazione" {
+		t.Errorf("callback logged as %s %q %v", kind, action, ok)
+	}
+
+	for _, text := range []string{"Puglia", "/cancellami", "/privacy"} {
+		if _, _, _, ok = getInteraction(commandUpdate(testChatId, text)); ok {
+			t.Errorf("%q logged", text)
+		}
+	}
+}
+
+func TestLogAndDeleteInteractions(t *testing.T) {
+	dirPath := useEmptyLogsFolder(t)
+	const otherChatId = 5678
+
+	logInteraction(commandUpdate(testChatId, "/regione Puglia"), time.Now(), false)
+	logInteraction(commandUpdate(otherChatId, "/nazione"), time.Now(), true)
+	logInteraction(commandUpdate(testChatId, "/zone"), time.Now(), false)
+
+	filename := dirPath + interactionsFilePrefix + time.Now().Format("2006-01-02") + interactionsFileExt
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"Mario", "mario", "Puglia"} {
+		if strings.Contains(string(content), v) {
+			t.Errorf("log contains %q:\n%s", v, content)
+		}
+	}
+
+	entries, err := getChatInteractions(testChatId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Azione != "/regione" || entries[1].Azione != "/zone" || entries[0].TipoChat != "private" {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+
+	if err = deleteChatInteractions(testChatId); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ = getChatInteractions(testChatId); len(entries) != 0 {
+		t.Errorf("entries %+v left after the deletion", entries)
+	}
+	if entries, _ = getChatInteractions(otherChatId); len(entries) != 1 || !entries[0].Limitato {
+		t.Errorf("entries %+v of the other chat, want the limited command", entries)
+	}
+
+	// The file of the day is opened again
+	logInteraction(commandUpdate(testChatId, "/home"), time.Now(), false)
+	if entries, _ = getChatInteractions(testChatId); len(entries) != 1 || entries[0].Azione != "/home" {
+		t.Errorf("entries %+v after the deletion, want the new command", entries)
+	}
+	if files, _ := filepath.Glob(dirPath + "*.tmp"); len(files) != 0 {
+		t.Errorf("temporary files left: %v", files)
+	}
+}
+
+func TestInteractionsOptOut(t *testing.T) {
+	useEmptyLogsFolder(t)
+	if err := setInteractionsOptOut(testChatId, true); err != nil {
+		t.Fatal(err)
+	}
+	if !isInteractionsOptOut(testChatId) {
+		t.Fatal("opt out not saved")
+	}
+	logInteraction(commandUpdate(testChatId, "/home"), time.Now(), false)
+	if entries, _ := getChatInteractions(testChatId); len(entries) != 0 {
+		t.Errorf("entries %+v of an opted out chat", entries)
+	}
+
+	if err := setInteractionsOptOut(testChatId, false); err != nil {
+		t.Fatal(err)
+	}
+	logInteraction(commandUpdate(testChatId, "/home"), time.Now(), false)
+	if entries, _ := getChatInteractions(testChatId); len(entries) != 1 {
+		t.Errorf("entries %+v after opting in again", entries)
+	}
+}
+
+func TestRemoveOldInteractionsLogs(t *testing.T) {
+	dirPath := useEmptyLogsFolder(t)
+	old := time.Now().AddDate(0, 0, -interactionsRetentionDays-1).Format("2006-01-02")
+	recent := time.Now().AddDate(0, 0, -interactionsRetentionDays).Format("2006-01-02")
+	for _, date := range []string{old, recent} {
+		if err := ioutil.WriteFile(dirPath+interactionsFilePrefix+date+interactionsFileExt, []byte("{}\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removeOldInteractionsLogs()
+	for date, kept := range map[string]bool{old: false, recent: true} {
+		if _, err := os.Stat(dirPath + interactionsFilePrefix + date + interactionsFileExt); (err == nil) != kept {
+			t.Errorf("log of %s kept: %v, want %v", date, err == nil, kept)
+		}
+	}
+}
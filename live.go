@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"github.com/DarkFighterLuke/covidgraphs"
+	"github.com/NicoNex/echotron"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const liveMessagesFile = "/live.json"
+
+// Pinned message of a chat edited in place whenever new data arrives
+type liveMessage struct {
+	ChatId    int64  `json:"chat_id"`
+	MessageId int    `json:"message_id"`
+	Kind      string `json:"kind"`   // "nazione", "regione" or "provincia"
+	Target    string `json:"target"` // Region or province name, empty for the nation
+}
+
+var liveMessages = make(map[int64]liveMessage) // Live messages indexed by chat id
+var liveMutex = &sync.Mutex{}
+
+// Loads live messages from the bot data folder
+func loadLiveMessages() {
+	liveMutex.Lock()
+	defer liveMutex.Unlock()
+
+	messages := make([]liveMessage, 0)
+	err := loadJSON(liveMessagesFile, &messages)
+	if err != nil {
+		log.Println("errore nel caricamento dei messaggi live:", err)
+		return
+	}
+	for _, v := range messages {
+		liveMessages[v.ChatId] = v
+	}
+}
+
+// Saves live messages in the bot data folder, liveMutex must be held
+func saveLiveMessages() error {
+	messages := make([]liveMessage, 0, len(liveMessages))
+	for _, v := range liveMessages {
+		messages = append(messages, v)
+	}
+	return saveJSON(liveMessagesFile, messages)
+}
+
+// Returns the live message of the given chat
+func getLiveMessage(chatId int64) (liveMessage, bool) {
+	liveMutex.Lock()
+	defer liveMutex.Unlock()
+
+	message, ok := liveMessages[chatId]
+	return message, ok
+}
+
+// Stores the live message of a chat replacing the previous one
+func setLiveMessage(message liveMessage) error {
+	liveMutex.Lock()
+	defer liveMutex.Unlock()
+
+	liveMessages[message.ChatId] = message
+	return saveLiveMessages()
+}
+
+// Removes the live message of a chat
+func deleteLiveMessage(chatId int64) error {
+	liveMutex.Lock()
+	defer liveMutex.Unlock()
+
+	if _, ok := liveMessages[chatId]; !ok {
+		return fmt.Errorf("live message not found")
+	}
+	delete(liveMessages, chatId)
+	return saveLiveMessages()
+}
+
+// Returns the plot filename and the caption of a live message, normalizing its target name
+func getLiveContent(d *dataSnapshot, message *liveMessage) (string, string, error) {
+	var filename, caption string
+	var err error
+
+	switch message.Kind {
+	case scheduleKindNation:
+		message.Target = ""
+		filename, err = d.getPlotAndamentoNazionale()
+		caption = d.setCaptionAndamentoNazionale()
+	case scheduleKindRegion:
+		regionIndex, e := covidgraphs.FindLastOccurrenceRegion(&d.regions, "denominazione_regione", message.Target)
+		if e != nil {
+			return "", "", fmt.Errorf("regione non trovata")
+		}
+		message.Target = d.regions[regionIndex].Denominazione_regione
+		filename, err = d.getPlotAndamentoRegionale(regionIndex)
+		caption = d.setCaptionRegion(regionIndex)
+	case scheduleKindProvince:
+		provinceIndex, e := covidgraphs.FindLastOccurrenceProvince(&d.provinces, "denominazione_provincia", message.Target)
+		if e != nil {
+			return "", "", fmt.Errorf("provincia non trovata")
+		}
+		message.Target = d.provinces[provinceIndex].Denominazione_provincia
+		filename, err = d.getPlotProvinciale(provinceIndex)
+		caption = d.setCaptionProvince(provinceIndex)
+	default:
+		return "", "", fmt.Errorf("tipo non valido")
+	}
+
+	return filename, caption + "\n\n<i>📌 Messaggio aggiornato automaticamente</i>", err
+}
+
+// Returns a human readable description of the live message
+func (m liveMessage) describe() string {
+	msg := scheduleKindsLabels[m.Kind]
+	if m.Target != "" {
+		msg += " " + m.Target
+	}
+	return msg
+}
+
+// Edits every live message with the latest data
+func updateLiveMessages(d *dataSnapshot) {
+	liveMutex.Lock()
+	messages := make([]liveMessage, 0, len(liveMessages))
+	for _, v := range liveMessages {
+		messages = append(messages, v)
+	}
+	liveMutex.Unlock()
+
+	updated := 0
+	for _, message := range messages {
+		b := &bot{
+			chatId: message.ChatId,
+			Api:    echotron.NewApi(TOKEN),
+		}
+
+		// The message keeps its previous content until the next update when the new one can't be made
+		filename, caption, err := getLiveContent(d, &message)
+		if err != nil {
+			log.Println("errore nell'aggiornamento del messaggio live:", err)
+			continue
+		}
+
+		response := b.editMessagePhoto(message.ChatId, message.MessageId, filename, caption)
+		if response.Ok || strings.Contains(response.Description, "message is not modified") {
+			updated++
+		} else if strings.Contains(response.Description, "message to edit not found") || response.ErrorCode == 403 {
+			log.Println("live message of chat " + strconv.FormatInt(message.ChatId, 10) + " is no longer available, removing it")
+			_ = deleteLiveMessage(message.ChatId)
+		} else {
+			log.Println("errore nella modifica del messaggio live:", response.Description)
+		}
+	}
+	log.Println("Live messages updated: " + strconv.Itoa(updated))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestGetLiveContent(t *testing.T) {
+	d := getData()
+	for _, v := range []struct {
+		message liveMessage
+		target  string
+		caption string
+	}{
+		{liveMessage{Kind: scheduleKindNation, Target: "ignorato"}, "", "<b>Andamento nazionale 2022-03-05</b>"},
+		{liveMessage{Kind: scheduleKindRegion, Target: "puglia"}, "Puglia", "<b>Andamento regione Puglia 2022-03-05</b>"},
+		{liveMessage{Kind: scheduleKindProvince, Target: "bari"}, "Bari", "<b>Andamento provincia di Bari 2022-03-05</b>"},
+	} {
+		message := v.message
+		filename, caption, err := getLiveContent(d, &message)
+		if err != nil {
+			t.Errorf("%+v: %v", v.message, err)
+			continue
+		}
+		if filename == "" || message.Target != v.target {
+			t.Errorf("%+v: plot %q target %q, want a plot of %q", v.message, filename, message.Target, v.target)
+		}
+		call := apiCall{Text: caption}
+		expectText(t, call, v.caption, "Messaggio aggiornato automaticamente")
+	}
+
+	for _, message := range []liveMessage{
+		{Kind: scheduleKindRegion, Target: "atlantide"},
+		{Kind: scheduleKindProvince, Target: "atlantide"},
+		{Kind: scheduleKindZones},
+	} {
+		if _, _, err := getLiveContent(d, &message); err == nil {
+			t.Errorf("invalid live message %+v accepted", message)
+		}
+	}
+}
+
+func TestUpdateLiveMessages(t *testing.T) {
+	valid := liveMessage{ChatId: 5678, MessageId: 7, Kind: scheduleKindRegion, Target: "Puglia"}
+	invalid := liveMessage{ChatId: 5679, MessageId: 8, Kind: scheduleKindRegion, Target: "Atlantide"}
+	for _, v := range []liveMessage{valid, invalid} {
+		if err := setLiveMessage(v); err != nil {
+			t.Fatal(err)
+		}
+		defer deleteLiveMessage(v.ChatId)
+	}
+
+	telegram.reset()
+	updateLiveMessages(getData())
+	calls := telegram.requests()
+	expectMethods(t, calls, "editMessageMedia")
+	if calls[0].ChatId != valid.ChatId || calls[0].MessageId != valid.MessageId {
+		t.Errorf("edited message %d of chat %d, want %d of chat %d", calls[0].MessageId, calls[0].ChatId, valid.MessageId, valid.ChatId)
+	}
+	if _, ok := getLiveMessage(invalid.ChatId); !ok {
+		t.Error("live message whose content can't be made has been removed")
+	}
+}
+
+func TestLive(t *testing.T) {
+	b := newTestBot(testChatId)
+	calls := b.send("/live regione puglia")
+	expectMethods(t, calls, "sendPhoto", "pinChatMessage", "deleteMessage")
+	expectPlot(t, calls[0], "Dati regione Puglia")
+	expectText(t, calls[0], "Messaggio aggiornato automaticamente")
+	message, ok := getLiveMessage(testChatId)
+	if !ok || message.Target != "Puglia" || message.MessageId != calls[1].MessageId {
+		t.Fatalf("live message %+v, want the one pinned", message)
+	}
+
+	calls = b.send("/live")
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "Messaggio live attivo:</b> Andamento regionale Puglia")
+
+	calls = b.send("/live stop")
+	if _, ok = getLiveMessage(testChatId); ok {
+		t.Error("live message not removed")
+	}
+	if len(calls) == 0 || calls[0].Method != "unpinChatMessage" {
+		t.Errorf("requests %+v, want the message unpinned", calls)
+	}
+}
+
+func TestGroupLive(t *testing.T) {
+	g := newTestBot(testGroupId)
+	calls := g.send("/live nazione")
+	expectMethods(t, calls, "getChatMember", "sendMessage")
+	expectText(t, calls[1], "Solo gli amministratori")
+	if _, ok := getLiveMessage(testGroupId); ok {
+		t.Error("live message set by a member")
+	}
+}
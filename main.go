@@ -5,20 +5,27 @@ import (
 	"github.com/DarkFighterLuke/covidgraphs"
 	"github.com/DarkFighterLuke/gitUpdateChecker/v2"
 	"github.com/NicoNex/echotron"
-	"github.com/robfig/cron/v3"
 	"log"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	nTopRegions      = 10
-	botDataDirectory = "CovidBot"
-	imageFolder      = "/plots/"
-	logsFolder       = "/logs/"
-	botUsername      = "@covidata19bot"
+	nTopRegions         = 10
+	botDataDirectory    = "CovidBot"
+	imageFolder         = "/plots/"
+	logsFolder          = "/logs/"
+	reportsFolder       = "/reports/"
+	botUsername         = "@covidata19bot"
+	updateRawFilesDelay = 5 * time.Minute // Time given to the raw files to be updated after a new commit
+)
+
+var (
+	updateAttempts   = 5                // Downloads tried before giving up a data update
+	updateRetryDelay = 30 * time.Second // Delay before the first retry, doubled at every attempt
 )
 
 var workingDirectory string
@@ -37,19 +44,18 @@ type bot struct {
 	lastGroupAttrIndex      int
 	lastZoneIndex           int
 	lastGroupProvinceIndex  int
+	lastReport              string    // Name of the last report shown from the reports menu
+	lastReportArgument      string    // Region or province of the last report shown from the reports menu
+	alertDraft              alertRule // Alert being created with the wizard
+	alertDraftUser          int       // User creating alertDraft, the only one who can complete it
+	awaitingAlertValue      bool      // Whether the next text message of alertDraftUser is the threshold of alertDraft
+	broadcastDraft          string    // Message previewed with the broadcast command, waiting for confirmation
 }
 
-var nationData []covidgraphs.NationData      // National data array
-var regionsData []covidgraphs.RegionData     // Regional data array
-var provincesData []covidgraphs.ProvinceData // Provincial data array
-var datiNote []covidgraphs.NoteData          // Notes array
-
 var natregAttributes = []string{"ricoverati_con_sintomi", "terapia_intensiva", "totale_ospedalizzati",
 	"isolamento_domiciliare", "totale_positivi", "nuovi_positivi", "dimessi_guariti", "deceduti",
 	"totale_casi", "tamponi"} // National and regional fields names
 
-var reports = []string{"generale"} // Types of reports avvailable
-
 var helpMsg = fmt.Sprintf(`/nazione <code>andamento</code>
 per ottenere l'andamento della nazione
 /nazione <code>nome_dei_campi</code>
@@ -65,7 +71,29 @@ per ottenere informazioni sul totale dei casi della provincia scelta
 /provincia <code>nome_provincia nuovi_positivi</code>
 per ottenere informazioni sui nuovi positivi della provincia scelta
 
-/reports <code>[file] nome_report</code>
+/reports <code>[file] nome_report [argomento]</code>
+per ottenere un report, con <code>file</code> lo ricevi in PDF con grafici e tabelle
+
+/esporta <code>nazione|regione nome_regione|provincia nome_provincia [campi] [da] [a] [csv|xlsx]</code>
+per scaricare i dati con variazioni giornaliere e medie mobili
+
+/zone
+per ottenere il colore di rischio di ogni regione
+
+/avviso
+per ricevere un messaggio quando i dati superano una soglia o cambiano tendenza, nei gruppi solo gli amministratori possono crearlo
+
+/programma <code>[giorno] HH:MM nazione|zone|regione nome_regione|provincia nome_provincia|report nome_report</code>
+per ricevere un aggiornamento all'orario scelto, ogni giorno o solo nel giorno indicato (es. <code>lunedi</code>), nei gruppi solo gli amministratori possono programmarlo
+
+/live <code>nazione|regione nome_regione|provincia nome_provincia</code>
+per fissare un messaggio che si aggiorna da solo ad ogni nuovo bollettino, nei gruppi solo gli amministratori possono configurarlo
+
+/registro <code>[si|no]</code>
+per sapere se i comandi della chat vengono registrati in forma anonima o per disattivare la registrazione
+
+/privacy
+per sapere quali dati vengono salvati, scaricarli con /esportamiei o cancellarli con /cancellami
 
 
 Dati nazione disponibili:
@@ -74,10 +102,33 @@ Dati nazione disponibili:
 Dati regione disponibili:
 {<code>%s</code>}
 
-Report disponibili:{<code>%s</code>}`, strings.Join(natregAttributes, ","),
-	strings.Join(natregAttributes, ","), strings.Join(reports, ","))
+Report disponibili:%s`, strings.Join(natregAttributes, ","),
+	strings.Join(natregAttributes, ","), getReportsHelp())
+
+// Data the bot answers with, replaced as a whole by every data update and never modified once published.
+// Update handlers, scheduled posts and the API load it once and use that snapshot from start to end,
+// so they always see a consistent dataset without holding any lock while talking to Telegram
+type dataSnapshot struct {
+	pcmDpcData
+	version            uint64                // Incremented every time data are replaced
+	versionKey         string                // Identifies the data plots are made from, used as prefix of the plot filenames
+	nationAnomalies    map[int][]dataAnomaly // Anomalies indexed by nation index
+	regionsAnomalies   map[int][]dataAnomaly // Anomalies indexed by regions index
+	provincesAnomalies map[int][]dataAnomaly // Anomalies indexed by provinces index
+}
+
+var currentData atomic.Value // Current *dataSnapshot, stored only by updateData
+
+// Serializes data updates, so that the reload command can't run one together with the update checker
+var updateMutex = &sync.Mutex{}
 
-var mutex = &sync.Mutex{} // Mutex used when updating data from the pcm-dpc repo
+// Returns the current data, empty until the first update succeeds
+func getData() *dataSnapshot {
+	if d, ok := currentData.Load().(*dataSnapshot); ok {
+		return d
+	}
+	return &dataSnapshot{}
+}
 
 var TOKEN = os.Getenv("CovidBot")
 
@@ -88,97 +139,187 @@ func newBot(chatId int64) echotron.Bot {
 	}
 }
 
-func checkUpdate(nazione *[]covidgraphs.NationData, regioni *[]covidgraphs.RegionData, province *[]covidgraphs.ProvinceData, note *[]covidgraphs.NoteData, frequency time.Duration, stop chan bool) {
+// Updates data whenever the pcm-dpc repository gets a new commit, until a value is sent on stop or done is closed
+func checkUpdate(frequency time.Duration, stop chan bool, done <-chan struct{}) {
 	log.Println("Starting update checker...")
-	_ = gitUpdateChecker.SetRepoInfo("https://github.com/pcm-dpc/COVID-19.git", "master")
-	ch, err := gitUpdateChecker.StartUpdateProcess(frequency)
-	if err != nil {
-		log.Println(err)
-	}
+	ch, stopRepoChecker := gitUpdateChecker.StartUpdateProcess(frequency)
+	defer func() {
+		// The repository checker reads its stop channel only between two checks
+		// and may be waiting to send a new commit, which is discarded
+		go func() {
+			for {
+				select {
+				case stopRepoChecker <- true:
+					return
+				case <-ch:
+				}
+			}
+		}()
+	}()
 
 	for {
 		select {
 		case u := <-ch:
 			if u {
 				log.Println("There is a new commit on pandemic data repository. Waiting 5 minutes to let update raw files...")
-				time.Sleep(5 * time.Minute)
+				select {
+				case <-time.After(updateRawFilesDelay):
+				case <-done:
+					log.Println("Stopping update checker...")
+					return
+				}
 				log.Println("Retrieving data...")
-				updateData(nazione, regioni, province, note)()
+				updateData(done)
 			}
 		case s := <-stop:
 			if s {
 				log.Println("Stopping update checker...")
 				return
 			}
+		case <-done:
+			log.Println("Stopping update checker...")
+			return
 		}
 	}
 }
 
+// Stops the running update checker, if any
+func stopUpdateChecker(stop chan bool) {
+	select {
+	case stop <- true:
+	default:
+	}
+}
+
 func main() {
 	log.SetOutput(os.Stdout)
 	//http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 	initFolders()
-	updateData(&nationData, &regionsData, &provincesData, &datiNote)()
-
+	err := loadTemplates()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	loadAlerts()
+	loadLiveMessages()
+	initInteractionsLog()
+	loadUsageStats()
+	loadKnownChats()
+
+	// Serving the webhook, the API, the metrics and the health endpoints on the same listener,
+	// updates are refused until data are loaded
+	dispatcher := newWebhookDispatcher(newBot)
+	initAPI()
+	initMetrics()
+	initRateLimits()
+	initHealth()
+	server := startWebhookServer(dispatcher)
+
+	updateData(shutdownStarted)
+
+	// Set once, since the repository checkers of previous days may still be reading it
+	_ = gitUpdateChecker.SetRepoInfo("https://github.com/pcm-dpc/COVID-19.git", "master")
 	stop := make(chan bool)
 	loc, _ := time.LoadLocation("Europe/Rome")
 	now := time.Now()
 	startHour := time.Date(now.Year(), now.Month(), now.Day(), 16, 00, 00, 00, loc)
 	endHour := time.Date(now.Year(), now.Month(), now.Day(), 19, 00, 00, 00, loc)
 	if time.Now().After(startHour) && time.Now().Before(endHour) {
-		go checkUpdate(&nationData, &regionsData, &provincesData, &datiNote, 30*time.Second, stop)
+		go checkUpdate(30*time.Second, stop, shutdownStarted)
 	}
 
 	// Planning cronjobs to update data from pcm-dpc repo
-	var cronjob = cron.New()
-	_, _ = cronjob.AddFunc("CRON_TZ=Europe/Rome 00 16 * * *", func() { checkUpdate(&nationData, &regionsData, &provincesData, &datiNote, 30*time.Second, stop) })
-	_, _ = cronjob.AddFunc("CRON_TZ=Europe/Rome 00 19 * * *", func() { stop <- true })
+	_, _ = cronjob.AddFunc("CRON_TZ=Europe/Rome 00 16 * * *", func() { checkUpdate(30*time.Second, stop, shutdownStarted) })
+	_, _ = cronjob.AddFunc("CRON_TZ=Europe/Rome 00 19 * * *", func() { stopUpdateChecker(stop) })
+	loadSchedules()
 	cronjob.Start()
 
-	// Creating bot instance using webhook mode
-	dsp := echotron.NewDispatcher(TOKEN, newBot)
-	dsp.ListenWebhook("https://hiddenfile.ml:443/bot/CovidBot", 40987)
+	err = setWebhook()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	waitForShutdown(server, dispatcher)
 }
 
 func (b *bot) Update(update *echotron.Update) {
-	writeOperation(update, botDataDirectory+logsFolder)
+	start := time.Now()
+	countUpdate(update)
+	if ok, warn := allowUpdate(update); !ok {
+		b.warnRateLimited(update, warn)
+		logInteraction(update, start, true)
+		return
+	}
+	defer logInteraction(update, start, false)
+	d := getData()
+	recordUsage(d, update)
+	if chatId, ok := getUpdateChatId(update); ok {
+		rememberChat(chatId)
+	}
 	if update.Message != nil {
 		keywords := strings.Split(update.Message.Text, " ")
-		if keywords[0] == "/start" || keywords[0] == "/start"+botUsername {
+		if b.awaitingAlertValue && b.isAlertDraftUser(update.Message.User) && !strings.HasPrefix(update.Message.Text, "/") {
+			b.textAlertValue(d, update)
+		} else if keywords[0] == "/start" || keywords[0] == "/start"+botUsername {
 			b.sendStart(update)
 		} else if keywords[0] == "/help" || keywords[0] == "/help"+botUsername {
 			b.sendHelp(update)
 		} else if keywords[0] == "/home" || keywords[0] == "/home"+botUsername {
-			b.sendHome(update)
+			b.sendHome(d, update)
 		} else if keywords[0] == "/nazione" {
-			b.textNation(update)
+			b.textNation(d, update)
 		} else if keywords[0] == "/nazione"+botUsername {
 			b.inGroupTextNation(update.Message.Chat.ID)
 		} else if keywords[0] == "/regione" {
-			b.textRegion(update)
+			b.textRegion(d, update)
 		} else if keywords[0] == "/regione"+botUsername {
 			b.inGroupTextRegions(update.Message.Chat.ID)
 		} else if keywords[0] == "/provincia" {
-			b.textProvince(update)
+			b.textProvince(d, update)
 		} else if keywords[0] == "/provincia"+botUsername {
 			b.inGroupTextProvinces(update.Message.Chat.ID)
 		} else if keywords[0] == "/reports" || keywords[0] == "/reports"+botUsername {
-			b.textReport(update)
+			b.textReport(d, update)
+		} else if keywords[0] == "/zone" || keywords[0] == "/zone"+botUsername {
+			b.textZone(d, update)
+		} else if keywords[0] == "/avviso" || keywords[0] == "/avviso"+botUsername {
+			b.textAvviso(d, update)
+		} else if keywords[0] == "/programma" || keywords[0] == "/programma"+botUsername {
+			b.textProgramma(d, update)
+		} else if keywords[0] == "/live" || keywords[0] == "/live"+botUsername {
+			b.textLive(d, update)
+		} else if keywords[0] == "/esporta" || keywords[0] == "/esporta"+botUsername {
+			b.textEsporta(d, update)
+		} else if keywords[0] == "/registro" || keywords[0] == "/registro"+botUsername {
+			b.textRegistro(update)
+		} else if keywords[0] == "/privacy" || keywords[0] == "/privacy"+botUsername {
+			b.textPrivacy(update)
+		} else if keywords[0] == "/esportamiei" || keywords[0] == "/esportamiei"+botUsername {
+			b.textEsportaMiei(update)
+		} else if keywords[0] == "/cancellami" || keywords[0] == "/cancellami"+botUsername {
+			b.textCancellami(update)
+		} else if keywords[0] == "/stats" || keywords[0] == "/stats"+botUsername {
+			b.textStats(update)
+		} else if keywords[0] == "/status" || keywords[0] == "/status"+botUsername {
+			b.textStatus(d, update)
+		} else if keywords[0] == "/reload" || keywords[0] == "/reload"+botUsername {
+			b.textReload(update)
+		} else if keywords[0] == "/broadcast" || keywords[0] == "/broadcast"+botUsername {
+			b.textBroadcast(update)
 		} else if keywords[0] == "/credits" || keywords[0] == "/credits"+botUsername {
 			b.sendCredits(update.Message.Chat.ID)
 		}
 
 	} else if update.CallbackQuery != nil {
+		defer observeCallbackDuration(time.Now())
 		cq := update.CallbackQuery
 		switch strings.ToLower(cq.Data) {
 		case "credits":
 			b.sendCredits(update.CallbackQuery.Message.Chat.ID)
 			b.AnswerCallbackQuery(cq.ID, "Crediti", false)
 		case "nuovi casi nazione":
-			b.callbackNuoviCasiNazione(cq)
+			b.callbackNuoviCasiNazione(d, cq)
 			break
 		case "nuovi casi regione":
-			b.callbackNuoviCasiRegione(cq)
+			b.callbackNuoviCasiRegione(d, cq)
 			break
 		case "storico nazione":
 			b.callbackStoricoNazione(cq)
@@ -193,28 +334,28 @@ func (b *bot) Update(update *echotron.Update) {
 			b.callbackConfrontoDatiRegione(cq)
 			break
 		case "classifica regioni":
-			b.callbackClassificaRegioni(cq)
+			b.callbackClassificaRegioni(d, cq)
 			break
 		case "classifica province":
-			b.callbackClassificaProvince(cq)
+			b.callbackClassificaProvince(d, cq)
 			break
 
 		case "nord":
-			b.callbackNord(cq)
+			b.callbackNord(d, cq)
 			break
 		case "centro":
-			b.callbackCentro(cq)
+			b.callbackCentro(d, cq)
 			break
 		case "sud":
-			b.callbackSud(cq)
+			b.callbackSud(d, cq)
 			break
 
 		case "province":
-			b.callbackProvince(cq)
+			b.callbackProvince(d, cq)
 			break
 
 		case "home":
-			b.callbackHome(cq)
+			b.callbackHome(d, cq)
 			break
 		case "annulla":
 			b.back(cq)
@@ -223,36 +364,45 @@ func (b *bot) Update(update *echotron.Update) {
 		case "reports":
 			b.callbackReports(cq)
 			break
-		case "report generale":
-			b.callbackReportGenerale(cq)
-			break
 		case "genera_file":
-			b.callbackGeneraFile(cq)
+			b.callbackGeneraFile(d, cq)
 			break
 
 		default:
 			//DON'T CHANGE THIS ORDER, OR CALLBACK HANDLING WILL FAIL!
-			if _, err := covidgraphs.FindFirstOccurrenceRegion(&regionsData, "denominazione_regione", cq.Data); err == nil {
-				b.caseRegion(cq)
-			} else if _, err = covidgraphs.FindFirstOccurrenceProvince(&provincesData, "denominazione_provincia", cq.Data); err == nil {
-				b.caseProvince(cq)
-			} else if err = b.caseConfrontoRegione(cq); err == nil {
+			if _, err := covidgraphs.FindFirstOccurrenceRegion(&d.regions, "denominazione_regione", cq.Data); err == nil {
+				b.caseRegion(d, cq)
+			} else if _, err = covidgraphs.FindFirstOccurrenceProvince(&d.provinces, "denominazione_provincia", cq.Data); err == nil {
+				b.caseProvince(d, cq)
+			} else if err = b.casePrivacy(cq); err == nil {
+				break
+			} else if err = b.caseBroadcast(cq); err == nil {
+				break
+			} else if err = b.caseAvviso(d, cq); err == nil {
+				break
+			} else if err = b.caseProgramma(cq); err == nil {
 				break
-			} else if err = b.caseConfrontoNazione(cq); err == nil {
+			} else if err = b.caseEsporta(d, cq); err == nil {
 				break
-			} else if err = b.caseInGroupNationAttr(cq); err == nil {
+			} else if err = b.caseReport(d, cq); err == nil {
+				break
+			} else if err = b.caseConfrontoRegione(d, cq); err == nil {
+				break
+			} else if err = b.caseConfrontoNazione(d, cq); err == nil {
+				break
+			} else if err = b.caseInGroupNationAttr(d, cq); err == nil {
 				break
 			} else if err = b.caseInGroupZoneP(cq); err == nil {
 				break
-			} else if err = b.caseInGroupRegionP(cq); err == nil {
+			} else if err = b.caseInGroupRegionP(d, cq); err == nil {
 				break
-			} else if err = b.caseInGroupProvince(cq); err == nil {
+			} else if err = b.caseInGroupProvince(d, cq); err == nil {
 				break
 			} else if err = b.caseInGroupZone(cq); err == nil {
 				break
-			} else if err = b.caseInGroupRegionAttr(cq); err == nil {
+			} else if err = b.caseInGroupRegionAttr(d, cq); err == nil {
 				break
-			} else if err = b.caseInGroupRegion(cq); err == nil {
+			} else if err = b.caseInGroupRegion(d, cq); err == nil {
 				break
 			} else {
 				log.Println("dati callback incorretti")
@@ -261,41 +411,107 @@ func (b *bot) Update(update *echotron.Update) {
 	}
 }
 
-// Updates data from pcm-dpc repository
-func updateData(nazione *[]covidgraphs.NationData, regioni *[]covidgraphs.RegionData, province *[]covidgraphs.ProvinceData, note *[]covidgraphs.NoteData) func() {
-	return func() {
-		log.Println("Updating data...")
-		mutex.Lock()
+// Datasets downloaded from the pcm-dpc repository
+type pcmDpcData struct {
+	nation    []covidgraphs.NationData
+	regions   []covidgraphs.RegionData
+	provinces []covidgraphs.ProvinceData
+	notes     []covidgraphs.NoteData
+}
 
-		covidgraphs.DeleteAllPlots(workingDirectory + imageFolder)
+// Downloads all the datasets and checks them, the current data are left untouched
+func fetchData() (pcmDpcData, error) {
+	var data pcmDpcData
 
-		ptrNazione, err := covidgraphs.GetNation()
-		if err != nil {
-			log.Println("errore nell'aggiornamento dei dati nazione")
-			log.Println(err)
-		}
-		*nazione = *ptrNazione
+	ptrNazione, err := covidgraphs.GetNation()
+	if err != nil || ptrNazione == nil {
+		return data, fmt.Errorf("errore nell'aggiornamento dei dati nazione: %v", err)
+	}
+	ptrRegioni, err := covidgraphs.GetRegions()
+	if err != nil || ptrRegioni == nil {
+		return data, fmt.Errorf("errore nell'aggiornamento dei dati regione: %v", err)
+	}
+	ptrProvince, err := covidgraphs.GetProvinces()
+	if err != nil || ptrProvince == nil {
+		return data, fmt.Errorf("errore nell'aggiornamento dei dati province: %v", err)
+	}
+	ptrNote, err := covidgraphs.GetNotes()
+	if err != nil || ptrNote == nil {
+		return data, fmt.Errorf("errore nell'aggiornamento dei dati note: %v", err)
+	}
+	data = pcmDpcData{nation: *ptrNazione, regions: *ptrRegioni, provinces: *ptrProvince, notes: *ptrNote}
 
-		ptrRegioni, err := covidgraphs.GetRegions()
-		if err != nil {
-			log.Println("errore nell'aggiornamento dei dati regione")
-			log.Println(err)
-		}
-		*regioni = *ptrRegioni
+	return data, data.check()
+}
 
-		ptrProvince, err := covidgraphs.GetProvinces()
-		if err != nil {
-			log.Println("errore nell'aggiornamento dei dati province")
-			log.Println(err)
-		}
-		*province = *ptrProvince
+// Checks that the downloaded datasets can replace the current ones
+func (d pcmDpcData) check() error {
+	if len(d.nation) < 2 {
+		return fmt.Errorf("dati nazione incompleti")
+	}
+	// Handlers expect the 21 regions and autonomous provinces for every day
+	if len(d.regions) < 42 || len(d.regions)%21 != 0 {
+		return fmt.Errorf("dati regione incompleti")
+	}
+	if len(d.provinces) == 0 {
+		return fmt.Errorf("dati province incompleti")
+	}
+	if d.regions[len(d.regions)-1].Data != d.nation[len(d.nation)-1].Data {
+		return fmt.Errorf("dati nazione e regione non allineati")
+	}
+	if current := getData(); len(current.nation) > 0 && d.nation[len(d.nation)-1].Data < current.nation[len(current.nation)-1].Data {
+		return fmt.Errorf("dati scaricati più vecchi di quelli attuali")
+	}
+	return nil
+}
+
+// Updates data from pcm-dpc repository, retrying with an increasing delay on failure until done is closed.
+// New data replace the current ones only if all the datasets have been downloaded correctly
+func updateData(done <-chan struct{}) {
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
+	log.Println("Updating data...")
+
+	data, err := fetchDataWithRetries(done)
+	previous := getData()
+	if err != nil {
+		log.Println(err)
+		log.Println("data update failed, keeping the previous data")
+		recordDataUpdate(previous, err)
+		setUpdateStatus(err)
+		return
+	}
+
+	d := &dataSnapshot{pcmDpcData: data, version: previous.version + 1}
+	d.versionKey = d.getVersionKey()
+	d.validate()
+	currentData.Store(d)
+	// Handlers that loaded the previous data may still be sending their plots
+	removeStalePlots(d.versionKey, previous.versionKey)
+	recordDataUpdate(d, nil)
+	setUpdateStatus(nil)
+
+	d.prewarmPlots()
+	evaluateAlerts(d)
+	updateLiveMessages(d)
+}
 
-		ptrNote, err := covidgraphs.GetNotes()
-		if err != nil {
-			log.Println("errore nell'aggiornamento dei dati note")
-			log.Println(err)
+// Downloads the data, retrying with a delay doubled at every attempt.
+// Gives up after updateAttempts downloads or as soon as done is closed
+func fetchDataWithRetries(done <-chan struct{}) (pcmDpcData, error) {
+	delay := updateRetryDelay
+	for attempt := 1; ; attempt++ {
+		data, err := fetchData()
+		if err == nil || attempt == updateAttempts {
+			return data, err
+		}
+		log.Println(err)
+		log.Println("Retrying data update in " + delay.String() + "...")
+		select {
+		case <-time.After(delay):
+		case <-done:
+			return data, err
 		}
-		*note = *ptrNote
-		mutex.Unlock()
+		delay *= 2
 	}
 }
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"github.com/NicoNex/echotron"
+	"golang.org/x/time/rate"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	testUserId    = 1234
+	testChatId    = 1234
+	testGroupId   = -1001234
+	testMessageId = 42 // Message holding the keyboard whose buttons are pressed
+)
+
+var telegram = newFakeTelegram()
+
+// Loads the fixture datasets in a temporary bot data folder, with every request served in-process
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+
+	dir, err := ioutil.TempDir("", "covidbot")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	workingDirectory = dir
+	for _, v := range []string{imageFolder, logsFolder, reportsFolder, templatesFolder} {
+		os.MkdirAll(workingDirectory+v, 0755)
+	}
+
+	http.DefaultTransport = fakeTransport{
+		telegramAPIHost:             telegram,
+		"raw.githubusercontent.com": pcmDpcFixtures{dir: filepath.Join("testdata", "pcm-dpc")},
+	}
+	// Tests send many updates from the same chat in a short time
+	chatUpdatesPerMinute = 0
+	globalUpdateLimiter = rate.NewLimiter(rate.Inf, 0)
+
+	err = loadTemplates()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	initInteractionsLog()
+
+	// Checked first since a failed update is retried for minutes
+	if _, err := fetchData(); err != nil {
+		log.Fatalln("invalid fixtures:", err)
+	}
+	updateData(nil)
+
+	code := m.Run()
+	closeInteractionsLog()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// Returns a new session of the chat, as the webhook dispatcher does for its first update
+func newTestBot(chatId int64) *bot {
+	return newBot(chatId).(*bot)
+}
+
+// Returns the chat of the test user or the test group
+func testChat(chatId int64) *echotron.Chat {
+	if chatId < 0 {
+		return &echotron.Chat{ID: chatId, Type: "supergroup", Title: "Gruppo di prova"}
+	}
+	return &echotron.Chat{ID: chatId, Type: "private", FirstName: "Mario"}
+}
+
+// Sends a text message to the bot and returns the requests made to handle it
+func (b *bot) send(text string) []apiCall {
+	return b.sendAs(testUserId, text)
+}
+
+// Sends a text message from the given user and returns the requests made to handle it
+func (b *bot) sendAs(userId int, text string) []apiCall {
+	telegram.reset()
+	b.Update(&echotron.Update{
+		ID: 1,
+		Message: &echotron.Message{
+			ID:   1,
+			User: &echotron.User{ID: userId, FirstName: "Mario"},
+			Chat: testChat(b.chatId),
+			Text: text,
+		},
+	})
+	return telegram.requests()
+}
+
+// Presses an inline button with the given callback data and returns the requests made to handle it
+func (b *bot) press(data string) []apiCall {
+	return b.pressAs(testUserId, data)
+}
+
+// Presses an inline button as the given user and returns the requests made to handle it
+func (b *bot) pressAs(userId int, data string) []apiCall {
+	telegram.reset()
+	b.Update(&echotron.Update{
+		ID: 1,
+		CallbackQuery: &echotron.CallbackQuery{
+			ID:      "callback",
+			User:    &echotron.User{ID: userId, FirstName: "Mario"},
+			Message: &echotron.Message{ID: testMessageId, Chat: testChat(b.chatId)},
+			Data:    data,
+		},
+	})
+	return telegram.requests()
+}
+
+// Fails unless the requests are made with the given methods in the given order
+func expectMethods(t *testing.T, calls []apiCall, methods ...string) {
+	t.Helper()
+	got := make([]string, 0, len(calls))
+	for _, v := range calls {
+		got = append(got, v.Method)
+	}
+	if strings.Join(got, " ") != strings.Join(methods, " ") {
+		t.Fatalf("expected requests %v, got %v", methods, got)
+	}
+}
+
+// Fails unless the text contains every substring
+func expectText(t *testing.T, call apiCall, substrings ...string) {
+	t.Helper()
+	for _, v := range substrings {
+		if !strings.Contains(call.Text, v) {
+			t.Errorf("%s: expected text containing %q, got %q", call.Method, v, call.Text)
+		}
+	}
+}
+
+// Fails unless the keyboard buttons have exactly the given callback data
+func expectKeyboard(t *testing.T, call apiCall, callbacks ...string) {
+	t.Helper()
+	if strings.Join(call.callbacks(), "|") != strings.Join(callbacks, "|") {
+		t.Errorf("%s: expected keyboard %q, got %q", call.Method, callbacks, call.callbacks())
+	}
+}
+
+// Fails unless the request sends the plot with the given title made from the current data
+func expectPlot(t *testing.T, call apiCall, title string) {
+	t.Helper()
+	if call.Method != "sendPhoto" {
+		t.Fatalf("expected sendPhoto, got %s", call.Method)
+	}
+	if want := filepath.Base(getData().getPlotFilename(title)); call.Photo != want {
+		t.Errorf("expected plot %q, got %q", want, call.Photo)
+	}
+	if !call.PNG {
+		t.Errorf("plot %q isn't a PNG picture", call.Photo)
+	}
+}
+
+// Fails unless the request uploads a PDF file whose name starts with prefix
+func expectDocument(t *testing.T, call apiCall, prefix string) {
+	t.Helper()
+	if call.Method != "sendDocument" {
+		t.Fatalf("expected sendDocument, got %s", call.Method)
+	}
+	if !strings.HasPrefix(call.Document, prefix) {
+		t.Errorf("expected document %q..., got %q", prefix, call.Document)
+	}
+	if !call.PDF {
+		t.Errorf("document %q isn't a PDF file", call.Document)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"testing"
+)
+
+var mainMenu = []string{"nuovi casi nazione", "zonesButtons", "confronto dati nazione", "classifica regioni", "classifica province", "reports"}
+
+func TestStart(t *testing.T) {
+	b := newTestBot(testChatId)
+	calls := b.send("/start")
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "Benvenuto <b>Mario</b>!")
+	expectKeyboard(t, calls[0], "credits", "home")
+}
+
+func TestHelp(t *testing.T) {
+	b := newTestBot(testChatId)
+	calls := b.send("/help")
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "/nazione <code>andamento</code>", "/regione", "/provincia")
+}
+
+func TestHome(t *testing.T) {
+	b := newTestBot(testChatId)
+	calls := b.send("/home")
+	expectMethods(t, calls, "sendPhoto", "sendMessage")
+	expectPlot(t, calls[0], "Andamento nazionale")
+	expectText(t, calls[0], "<b>Andamento nazionale 2022-03-05</b>", "Attualmente positivi: </b>563667 (<i>+4444</i>)", "Nuovi positivi: </b>14060 (<i>+537</i>)")
+	expectKeyboard(t, calls[0], "esporta nazione csv", "esporta nazione xlsx")
+	expectText(t, calls[1], "Scegli un opzione")
+	expectKeyboard(t, calls[1], mainMenu...)
+
+	calls = b.press("home")
+	expectMethods(t, calls, "sendPhoto", "deleteMessage", "sendMessage", "answerCallbackQuery")
+	expectPlot(t, calls[0], "Andamento nazionale")
+	if calls[1].MessageId != testMessageId {
+		t.Errorf("expected the menu message to be deleted, got message %d", calls[1].MessageId)
+	}
+	expectKeyboard(t, calls[2], mainMenu...)
+	expectText(t, calls[3], "Home")
+}
+
+// The second time a plot is sent it is sent by the file_id returned by Telegram
+func TestPlotFileIdReused(t *testing.T) {
+	b := newTestBot(testChatId)
+	b.send("/home")
+	calls := b.send("/home")
+	expectPlot(t, calls[0], "Andamento nazionale")
+	if calls[0].Params.Get("photo") != "file-"+calls[0].Photo {
+		t.Errorf("expected the plot to be sent by file_id, got photo %q", calls[0].Params.Get("photo"))
+	}
+}
+
+func TestCredits(t *testing.T) {
+	b := newTestBot(testChatId)
+	calls := b.press("credits")
+	expectMethods(t, calls, "sendMessage", "answerCallbackQuery")
+	expectText(t, calls[0], "Bot creato da")
+	expectKeyboard(t, calls[0], "home")
+}
+
+func TestNuoviCasiNazione(t *testing.T) {
+	b := newTestBot(testChatId)
+	calls := b.press("nuovi casi nazione")
+	expectMethods(t, calls, "sendPhoto", "answerCallbackQuery")
+	expectPlot(t, calls[0], "Nuovi Positivi")
+	expectText(t, calls[0], "<b>Andamento nazione 2022-03-05</b>", "Nuovi positivi: </b>14060 (<i>+537</i>)")
+	expectKeyboard(t, calls[0], "esporta nazione csv", "esporta nazione xlsx", "home")
+}
+
+func TestZones(t *testing.T) {
+	b := newTestBot(testChatId)
+	zones := []string{"nord", "centro", "sud", "annulla"}
+
+	calls := b.press("zonesButtons")
+	expectMethods(t, calls, "editMessageReplyMarkup", "answerCallbackQuery")
+	expectKeyboard(t, calls[0], zones...)
+
+	regions := map[string][]string{
+		"nord":   {"piemonte", "valle d'aosta", "liguria", "lombardia", "p.a. trento", "p.a. bolzano", "veneto", "friuli venezia giulia", "emilia romagna", "annulla"},
+		"centro": {"toscana", "umbria", "marche", "lazio", "annulla"},
+		"sud":    {"abruzzo", "molise", "campania", "puglia", "basilicata", "calabria", "sicilia", "sardegna", "annulla"},
+	}
+	for _, zone := range zones[:3] {
+		calls = b.press(zone)
+		expectMethods(t, calls, "editMessageReplyMarkup", "answerCallbackQuery")
+		expectKeyboard(t, calls[0], regions[zone]...)
+
+		calls = b.press("annulla")
+		expectMethods(t, calls, "editMessageReplyMarkup", "answerCallbackQuery")
+		expectKeyboard(t, calls[0], zones...)
+	}
+
+	calls = b.press("annulla")
+	expectMethods(t, calls, "editMessageReplyMarkup", "answerCallbackQuery")
+	expectKeyboard(t, calls[0], mainMenu...)
+}
+
+func TestRegion(t *testing.T) {
+	b := newTestBot(testChatId)
+	calls := b.press("puglia")
+	expectMethods(t, calls, "deleteMessage", "sendPhoto", "sendMessage", "answerCallbackQuery")
+	expectPlot(t, calls[1], "Dati regione Puglia")
+	expectText(t, calls[1], "<b>Andamento regione Puglia 2022-03-05</b>", "Totale positivi: </b>210545 (<i>+589</i>)")
+	expectKeyboard(t, calls[1], "esporta regione csv Puglia", "esporta regione xlsx Puglia")
+	expectText(t, calls[2], "Opzioni disponibili:")
+	expectKeyboard(t, calls[2], "nuovi casi regione", "province", "confronto dati regione", "home")
+	expectText(t, calls[3], "Regione Puglia")
+
+	calls = b.press("nuovi casi regione")
+	expectMethods(t, calls, "sendPhoto", "answerCallbackQuery")
+	expectPlot(t, calls[0], "Nuovi positivi regione Puglia")
+	expectText(t, calls[0], "<b>Andamento regione Puglia 2022-03-05</b>", "Nuovi positivi: </b>589 (<i>+8</i>)")
+	expectKeyboard(t, calls[0], "esporta regione csv Puglia", "esporta regione xlsx Puglia", "puglia", "home")
+}
+
+func TestProvince(t *testing.T) {
+	b := newTestBot(testChatId)
+	b.press("puglia")
+
+	calls := b.press("province")
+	expectMethods(t, calls, "editMessageReplyMarkup", "answerCallbackQuery")
+	expectKeyboard(t, calls[0], "bari", "lecce", "annulla")
+
+	calls = b.press("bari")
+	expectMethods(t, calls, "sendPhoto", "sendMessage", "answerCallbackQuery")
+	expectPlot(t, calls[0], "Totale Contagi Bari")
+	expectText(t, calls[0], "<b>Andamento provincia di Bari 2022-03-05</b>", "Totale positivi: </b>126327 (<i>+354</i>)")
+	expectKeyboard(t, calls[0], "esporta provincia csv Bari", "esporta provincia xlsx Bari")
+	expectKeyboard(t, calls[1], "puglia", "home")
+}
+
+func TestConfrontoNazione(t *testing.T) {
+	b := newTestBot(testChatId)
+	calls := b.press("confronto dati nazione")
+	expectMethods(t, calls, "editMessageText", "answerCallbackQuery")
+	expectText(t, calls[0], "Seleziona i campi che vuoi mettere a confronto:")
+	expectKeyboard(t, calls[0], "ricoverati con sintomi nazione", "terapia intensiva nazione", "totale ospedalizzati nazione", "isolamento domiciliare nazione",
+		"attualmente positivi nazione", "nuovi positivi nazione", "dimessi guariti nazione", "deceduti nazione", "totale casi nazione", "tamponi nazione", "annulla")
+
+	// A selected field is removed from the keyboard, which gets the button to make the plot
+	calls = b.press("terapia intensiva nazione")
+	expectMethods(t, calls, "editMessageReplyMarkup", "answerCallbackQuery")
+	expectKeyboard(t, calls[0], "ricoverati con sintomi nazione", "totale ospedalizzati nazione", "isolamento domiciliare nazione",
+		"attualmente positivi nazione", "nuovi positivi nazione", "dimessi guariti nazione", "deceduti nazione", "totale casi nazione", "tamponi nazione", "annulla", "fatto nazione")
+	expectText(t, calls[1], "Aggiunto al confronto")
+	b.press("deceduti nazione")
+
+	calls = b.press("fatto nazione")
+	expectMethods(t, calls, "deleteMessage", "sendPhoto", "sendMessage", "answerCallbackQuery")
+	expectPlot(t, calls[1], "Nazionedeceduti_terapia_intensiva_")
+	expectText(t, calls[1], "<b>Andamento nazione 2022-03-05</b>", "Morti: </b>38496 (<i>+33</i>)", "Terapia intensiva: </b>617 (<i>-17</i>)")
+	expectKeyboard(t, calls[1], "esporta nazione csv", "esporta nazione xlsx")
+	expectKeyboard(t, calls[2], "home")
+	expectText(t, calls[3], "Confronto effettuato")
+}
+
+func TestConfrontoRegione(t *testing.T) {
+	b := newTestBot(testChatId)
+	b.press("lazio")
+
+	calls := b.press("confronto dati regione")
+	expectMethods(t, calls, "editMessageText", "answerCallbackQuery")
+	expectText(t, calls[0], "Seleziona i campi che vuoi mettere a confronto:")
+	expectKeyboard(t, calls[0], "ricoverati con sintomi regione", "terapia intensiva regione", "totale ospedalizzati regione", "isolamento domiciliare regione",
+		"attualmente positivi regione", "nuovi positivi regione", "dimessi guariti regione", "deceduti regione", "totale casi regione", "tamponi regione", "annulla")
+
+	calls = b.press("tamponi regione")
+	expectMethods(t, calls, "editMessageReplyMarkup", "answerCallbackQuery")
+	expectKeyboard(t, calls[0], "ricoverati con sintomi regione", "terapia intensiva regione", "totale ospedalizzati regione", "isolamento domiciliare regione",
+		"attualmente positivi regione", "nuovi positivi regione", "dimessi guariti regione", "deceduti regione", "totale casi regione", "annulla", "fatto regione")
+
+	calls = b.press("fatto regione")
+	expectMethods(t, calls, "deleteMessage", "sendPhoto", "sendMessage", "answerCallbackQuery")
+	expectPlot(t, calls[1], "RegioneLaziotamponi__")
+	expectText(t, calls[1], "<b>Andamento regione Lazio 2022-03-05</b>", "Tamponi effettuati: </b>3149328 (<i>+22932</i>)")
+	expectKeyboard(t, calls[1], "esporta regione csv Lazio", "esporta regione xlsx Lazio")
+	expectKeyboard(t, calls[2], "lazio", "home")
+}
+
+func TestClassifiche(t *testing.T) {
+	b := newTestBot(testChatId)
+	calls := b.press("classifica regioni")
+	expectMethods(t, calls, "sendMessage", "answerCallbackQuery")
+	expectText(t, calls[0], "<b>Top 10 regioni per contagi</b>", "<b>1. </b>Sardegna (<code>394645</code>)")
+	expectKeyboard(t, calls[0], "home")
+
+	calls = b.press("classifica province")
+	expectMethods(t, calls, "sendMessage", "answerCallbackQuery")
+	expectText(t, calls[0], "<b>Top 10 province per contagi</b>", "<b>1. </b>Cagliari (<code>236787</code>)")
+	expectKeyboard(t, calls[0], "home")
+}
+
+func TestReports(t *testing.T) {
+	b := newTestBot(testChatId)
+	calls := b.press("reports")
+	expectMethods(t, calls, "sendMessage", "answerCallbackQuery")
+	expectText(t, calls[0], "Seleziona un tipo di report:")
+	expectKeyboard(t, calls[0], "report generale", "report settimanale", "report riepilogo", "report regione", "report provincia", "annulla")
+
+	calls = b.press("annulla")
+	expectMethods(t, calls, "deleteMessage")
+}
+
+func TestReportPDF(t *testing.T) {
+	tests := []struct {
+		callback string
+		text     string
+		keyboard []string
+		document string
+	}{
+		{"report generale", "<b>Andamento nazionale 2022-03-05</b>", []string{"genera_file", "home"}, "report generale-"},
+		{"report settimanale", "<b>Report settimanale nazionale</b>", []string{"genera_file", "home"}, "report settimanale-"},
+		{"report riepilogo", "<b>Riepilogo settimanale 2022-02-27 - 2022-03-05</b>", []string{"genera_file", "programma riepilogo", "home"}, "riepilogo settimanale-"},
+		{"report regione Puglia", "<b>Andamento regione Puglia 2022-03-05</b>", []string{"genera_file", "home"}, "report Puglia-"},
+	}
+	for _, test := range tests {
+		t.Run(test.callback, func(t *testing.T) {
+			b := newTestBot(testChatId)
+			calls := b.press(test.callback)
+			expectMethods(t, calls, "sendMessage", "answerCallbackQuery")
+			expectText(t, calls[0], test.text)
+			expectKeyboard(t, calls[0], test.keyboard...)
+
+			calls = b.press("genera_file")
+			expectMethods(t, calls, "answerCallbackQuery", "sendDocument")
+			expectDocument(t, calls[1], test.document)
+		})
+	}
+}
+
+func TestReportArguments(t *testing.T) {
+	b := newTestBot(testChatId)
+	calls := b.press("report regione")
+	expectMethods(t, calls, "sendMessage", "answerCallbackQuery")
+	expectText(t, calls[0], "Seleziona la regione:")
+	if got := calls[0].callbacks(); len(got) != 22 || got[0] != "report regione Abruzzo" || got[21] != "annulla" {
+		t.Errorf("expected a button for every region, got %q", got)
+	}
+
+	calls = b.press("report provincia")
+	expectMethods(t, calls, "sendMessage", "answerCallbackQuery")
+	expectText(t, calls[0], "/reports <code>provincia nome_provincia</code>")
+}
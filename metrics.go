@@ -0,0 +1,144 @@
+package main
+
+import (
+	"github.com/NicoNex/echotron"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	metricsNamespace  = "covidbot"
+	telegramAPIHost   = "api.telegram.org"
+	metricsNoCommand  = "nessuno"
+	metricsOtherValue = "altro"
+)
+
+var metricsPath = getEnv("CovidBotMetricsPath", "/metrics") // Path of the metrics endpoint
+var metricsAddr = getEnv("CovidBotMetricsAddr", "")         // Listen address of the metrics endpoint, empty to use the webhook listener
+
+// Commands counted separately, the other ones are counted together to keep the number of series bounded
+var metricsCommands = map[string]bool{
+	"/start": true, "/help": true, "/home": true, "/nazione": true, "/regione": true, "/provincia": true,
+	"/reports": true, "/zone": true, "/avviso": true, "/programma": true, "/live": true, "/esporta": true, "/registro": true, "/privacy": true,
+	"/esportamiei": true, "/cancellami": true, "/stats": true, "/status": true, "/reload": true, "/broadcast": true, "/credits": true,
+}
+
+var (
+	updatesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "updates_total",
+		Help:      "Telegram updates processed by type and command.",
+	}, []string{"type", "command"})
+	callbackDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "callback_duration_seconds",
+		Help:      "Time spent handling callback queries.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	})
+	plotCacheCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "plot_cache_total",
+		Help:      "Plot lookups by result: hit if the plot was already on disk, miss, shared with a render in progress, evicted from the cache or sent by Telegram file_id.",
+	}, []string{"result"})
+	plotRenderDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "plot_render_duration_seconds",
+		Help:      "Time spent rendering plots.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	})
+	plotRenderQueueGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "plot_render_queue",
+		Help:      "Plot renders waiting for a free render worker.",
+	})
+	telegramErrorsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "telegram_api_errors_total",
+		Help:      "Failed Telegram Bot API requests by method.",
+	}, []string{"method"})
+	rateLimitedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rate_limited_total",
+		Help:      "Updates dropped because a chat sent too many requests (in) and Telegram requests refused with 429 (out).",
+	}, []string{"direction"})
+	lastUpdateGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "data_last_update_timestamp_seconds",
+		Help:      "Unix time of the last successful data update.",
+	})
+	datasetRowsGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "dataset_rows",
+		Help:      "Rows loaded for each dataset.",
+	}, []string{"dataset"})
+)
+
+// Counts failed requests to the Telegram Bot API made with the default transport
+type telegramMetricsTransport struct {
+	base http.RoundTripper
+}
+
+func (t *telegramMetricsTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := t.base.RoundTrip(request)
+	if request.URL.Host == telegramAPIHost && (err != nil || response.StatusCode != http.StatusOK) {
+		telegramErrorsCounter.WithLabelValues(path.Base(request.URL.Path)).Inc()
+	}
+	return response, err
+}
+
+// Wraps the default transport and serves the metrics endpoint
+func initMetrics() {
+	http.DefaultTransport = &telegramMetricsTransport{base: http.DefaultTransport}
+
+	if metricsAddr == "" {
+		http.Handle(metricsPath, promhttp.Handler())
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, promhttp.Handler())
+	server := newHTTPServer(metricsAddr, mux)
+	go func() {
+		log.Println(server.ListenAndServe())
+	}()
+}
+
+// Counts an update by type and command
+func countUpdate(update *echotron.Update) {
+	switch {
+	case update.Message != nil:
+		command := metricsNoCommand
+		if strings.HasPrefix(update.Message.Text, "/") {
+			command = strings.TrimSuffix(strings.Fields(update.Message.Text)[0], botUsername)
+			if !metricsCommands[command] {
+				command = metricsOtherValue
+			}
+		}
+		updatesCounter.WithLabelValues("message", command).Inc()
+	case update.CallbackQuery != nil:
+		updatesCounter.WithLabelValues("callback_query", metricsNoCommand).Inc()
+	default:
+		updatesCounter.WithLabelValues(metricsOtherValue, metricsNoCommand).Inc()
+	}
+}
+
+// Records the time spent handling a callback query started at the given time
+func observeCallbackDuration(start time.Time) {
+	callbackDuration.Observe(time.Since(start).Seconds())
+}
+
+// Records the outcome of a data update and the dataset sizes
+func recordDataUpdate(d *dataSnapshot, err error) {
+	if err == nil {
+		lastUpdateGauge.SetToCurrentTime()
+	}
+	datasetRowsGauge.WithLabelValues("nazione").Set(float64(len(d.nation)))
+	datasetRowsGauge.WithLabelValues("regioni").Set(float64(len(d.regions)))
+	datasetRowsGauge.WithLabelValues("province").Set(float64(len(d.provinces)))
+	datasetRowsGauge.WithLabelValues("note").Set(float64(len(d.notes)))
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"github.com/NicoNex/echotron"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCountUpdate(t *testing.T) {
+	for text, command := range map[string]string{
+		"/nazione":               "/nazione",
+		"/zone" + botUsername:    "/zone",
+		"/sconosciuto argomento": metricsOtherValue,
+		"Puglia":                 metricsNoCommand,
+	} {
+		counter := updatesCounter.WithLabelValues("message", command)
+		before := testutil.ToFloat64(counter)
+		countUpdate(&echotron.Update{Message: &echotron.Message{Text: text}})
+		if got := testutil.ToFloat64(counter) - before; got != 1 {
+			t.Errorf("%q counted %v times as %s", text, got, command)
+		}
+	}
+
+	counter := updatesCounter.WithLabelValues("callback_query", metricsNoCommand)
+	before := testutil.ToFloat64(counter)
+	countUpdate(&echotron.Update{CallbackQuery: &echotron.CallbackQuery{Data: "nazione"}})
+	if got := testutil.ToFloat64(counter) - before; got != 1 {
+		t.Errorf("callback query counted %v times", got)
+	}
+}
+
+func TestTelegramMetricsTransport(t *testing.T) {
+	transport := &telegramMetricsTransport{base: fakeTransport{
+		telegramAPIHost: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(r.URL.Path, "/sendPhoto") {
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		}),
+		"example.com": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}),
+	}}
+
+	failed := telegramErrorsCounter.WithLabelValues("sendPhoto")
+	sent := telegramErrorsCounter.WithLabelValues("sendMessage")
+	other := telegramErrorsCounter.WithLabelValues("pagina")
+	before := []float64{testutil.ToFloat64(failed), testutil.ToFloat64(sent), testutil.ToFloat64(other)}
+	for _, url := range []string{
+		"https://" + telegramAPIHost + "/botTOKEN/sendPhoto",
+		"https://" + telegramAPIHost + "/botTOKEN/sendMessage",
+		"https://example.com/pagina",
+	} {
+		request, _ := http.NewRequest(http.MethodPost, url, nil)
+		if _, err := transport.RoundTrip(request); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if testutil.ToFloat64(failed)-before[0] != 1 || testutil.ToFloat64(sent) != before[1] || testutil.ToFloat64(other) != before[2] {
+		t.Error("only the failed Telegram request should be counted")
+	}
+}
+
+func TestRecordDataUpdate(t *testing.T) {
+	d := getData()
+	recordDataUpdate(d, nil)
+	for dataset, rows := range map[string]int{"nazione": len(d.nation), "regioni": len(d.regions), "province": len(d.provinces), "note": len(d.notes)} {
+		if got := testutil.ToFloat64(datasetRowsGauge.WithLabelValues(dataset)); got != float64(rows) {
+			t.Errorf("%s: %v rows, want %d", dataset, got, rows)
+		}
+	}
+	if testutil.ToFloat64(lastUpdateGauge) == 0 {
+		t.Error("last update time not set")
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	observeCallbackDuration(time.Now())
+	w := httptest.NewRecorder()
+	promhttp.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, metricsPath, nil))
+	for _, name := range []string{"covidbot_updates_total", "covidbot_callback_duration_seconds_count", "covidbot_dataset_rows"} {
+		if !strings.Contains(w.Body.String(), name) {
+			t.Errorf("%s not exposed", name)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"github.com/DarkFighterLuke/covidgraphs"
+	"github.com/jung-kurt/gofpdf"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	pdfFont       = "Helvetica"
+	pdfMargin     = 15.0
+	pdfPageWidth  = 210.0 // A4 width in mm
+	pdfLineHeight = 6.0
+	pdfRowHeight  = 7.0
+)
+
+// PDF document with the helpers used to lay out reports
+type pdfReport struct {
+	pdf *gofpdf.Fpdf
+	tr  func(string) string // Converts UTF-8 strings to the encoding of the core fonts
+}
+
+// Creates a report document with its title page
+func newPdfReport(title, subtitle string) *pdfReport {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	r := &pdfReport{pdf: pdf, tr: pdf.UnicodeTranslatorFromDescriptor("")}
+
+	pdf.SetMargins(pdfMargin, pdfMargin, pdfMargin)
+	pdf.SetAutoPageBreak(true, pdfMargin)
+	pdf.SetTitle(title, true)
+	pdf.SetCreator("CovidBot19 "+botUsername, true)
+	pdf.SetFooterFunc(func() {
+		if pdf.PageNo() == 1 {
+			return
+		}
+		pdf.SetY(-pdfMargin)
+		pdf.SetFont(pdfFont, "I", 8)
+		pdf.SetTextColor(128, 128, 128)
+		pdf.CellFormat(0, 10, r.tr(title+" - pagina "+strconv.Itoa(pdf.PageNo())), "", 0, "C", false, 0, "")
+		pdf.SetTextColor(0, 0, 0)
+	})
+
+	pdf.AddPage()
+	pdf.SetY(90)
+	pdf.SetFont(pdfFont, "B", 28)
+	pdf.MultiCell(0, 14, r.tr(title), "", "C", false)
+	pdf.Ln(4)
+	pdf.SetFont(pdfFont, "", 14)
+	pdf.MultiCell(0, 8, r.tr(subtitle), "", "C", false)
+	pdf.Ln(30)
+	pdf.SetFont(pdfFont, "I", 10)
+	pdf.MultiCell(0, 5, r.tr("Generato da "+botUsername+" il "+time.Now().Format("2006-01-02 15:04")+
+		"\nDati: Dipartimento della Protezione Civile, github.com/pcm-dpc/COVID-19"), "", "C", false)
+
+	return r
+}
+
+// Starts a new page with the given section title
+func (r *pdfReport) section(title string) {
+	r.pdf.AddPage()
+	r.subsection(title)
+}
+
+// Writes a section title on the current page
+func (r *pdfReport) subsection(title string) {
+	r.pdf.SetFont(pdfFont, "B", 16)
+	r.pdf.CellFormat(0, 10, r.tr(title), "", 1, "L", false, 0, "")
+	r.pdf.Ln(2)
+}
+
+// Writes a paragraph of plain text
+func (r *pdfReport) paragraph(text string) {
+	r.pdf.SetFont(pdfFont, "", 10)
+	r.pdf.MultiCell(0, pdfLineHeight, r.tr(text), "", "L", false)
+	r.pdf.Ln(2)
+}
+
+// Draws a PNG image using the whole page width
+func (r *pdfReport) image(filename string) {
+	width := pdfPageWidth - 2*pdfMargin
+	r.pdf.ImageOptions(filename, pdfMargin, r.pdf.GetY(), width, 0, true, gofpdf.ImageOptions{ImageType: "PNG", ReadDpi: true}, 0, "")
+	r.pdf.Ln(4)
+}
+
+// Draws a table, the first column is left aligned and the others right aligned
+func (r *pdfReport) table(header []string, widths []float64, rows [][]string) {
+	r.pdf.SetFont(pdfFont, "B", 9)
+	r.pdf.SetFillColor(220, 220, 220)
+	for i, v := range header {
+		r.pdf.CellFormat(widths[i], pdfRowHeight, r.tr(v), "1", 0, "C", true, 0, "")
+	}
+	r.pdf.Ln(-1)
+
+	r.pdf.SetFont(pdfFont, "", 9)
+	r.pdf.SetFillColor(245, 245, 245)
+	for n, row := range rows {
+		for i, v := range row {
+			align := "R"
+			if i == 0 {
+				align = "L"
+			}
+			r.pdf.CellFormat(widths[i], pdfRowHeight, r.tr(v), "1", 0, align, n%2 == 1, 0, "")
+		}
+		r.pdf.Ln(-1)
+	}
+	r.pdf.Ln(4)
+}
+
+// Writes a section with the pcm-dpc notes and the anomalies found in data
+func (r *pdfReport) notesSection(notes []covidgraphs.NoteData, anomalies []dataAnomaly) {
+	r.section("Note sui dati")
+	if len(notes) == 0 {
+		r.paragraph("La Protezione Civile non ha pubblicato note per questa data.")
+	}
+	for _, v := range notes {
+		text := "[" + v.Tipologia_avviso + "] " + v.Regione
+		if v.Provincia != "" {
+			text += ", " + v.Provincia
+		}
+		text += ": " + v.Avviso
+		if v.Note != "" {
+			text += ", " + v.Note
+		}
+		r.paragraph(text)
+	}
+
+	if len(anomalies) > 0 {
+		r.subsection("Avvisi sui dati")
+		for _, v := range anomalies {
+			r.paragraph("- " + v.explanation)
+		}
+	}
+}
+
+// Writes the document into the reports folder and returns its path
+func (r *pdfReport) save(name string) (string, error) {
+	return createTempOutput(strings.Replace(name, "/", "-", -1)+"-*.pdf", r.pdf.Output)
+}
+
+// Formats a daily variation with its sign
+func formatDelta(delta int) string {
+	if delta > 0 {
+		return "+" + strconv.Itoa(delta)
+	}
+	return strconv.Itoa(delta)
+}
+
+// Returns the pcm-dpc notes published for the given date
+func (d *dataSnapshot) getNotesByDate(date string) []covidgraphs.NoteData {
+	notes := make([]covidgraphs.NoteData, 0)
+	if len(date) < 10 {
+		return notes
+	}
+	for _, v := range d.notes {
+		if strings.HasPrefix(v.Data, date[:10]) {
+			notes = append(notes, v)
+		}
+	}
+
+	return notes
+}
+
+// Builds the "generale" report and returns the PDF file path
+func (d *dataSnapshot) buildReportGenerale() (string, error) {
+	if len(d.nation) < 2 || len(d.regions) < 42 || len(d.provinces) == 0 {
+		return "", fmt.Errorf("not enough data to build the report")
+	}
+	lastIndex := len(d.nation) - 1
+	today := d.nation[lastIndex]
+	yesterday := d.nation[lastIndex-1]
+	data, err := time.Parse("2006-01-02T15:04:05", today.Data)
+	if err != nil {
+		log.Println("error parsing data in buildReportGenerale()")
+	}
+
+	r := newPdfReport("Report generale", "Situazione COVID-19 in Italia al "+data.Format("2006-01-02"))
+
+	r.section("Andamento nazionale")
+	r.table([]string{"Dato", "Valore", "Variazione giornaliera"}, []float64{80, 50, 50}, [][]string{
+		{"Attualmente positivi", strconv.Itoa(today.Totale_positivi), formatDelta(today.Totale_positivi - yesterday.Totale_positivi)},
+		{"Nuovi positivi", strconv.Itoa(today.Nuovi_positivi), formatDelta(today.Nuovi_positivi - yesterday.Nuovi_positivi)},
+		{"Ricoverati con sintomi", strconv.Itoa(today.Ricoverati_con_sintomi), formatDelta(today.Ricoverati_con_sintomi - yesterday.Ricoverati_con_sintomi)},
+		{"Terapia intensiva", strconv.Itoa(today.Terapia_intensiva), formatDelta(today.Terapia_intensiva - yesterday.Terapia_intensiva)},
+		{"Isolamento domiciliare", strconv.Itoa(today.Isolamento_domiciliare), formatDelta(today.Isolamento_domiciliare - yesterday.Isolamento_domiciliare)},
+		{"Guariti", strconv.Itoa(today.Dimessi_guariti), formatDelta(today.Dimessi_guariti - yesterday.Dimessi_guariti)},
+		{"Morti", strconv.Itoa(today.Deceduti), formatDelta(today.Deceduti - yesterday.Deceduti)},
+		{"Totale casi", strconv.Itoa(today.Totale_casi), formatDelta(today.Totale_casi - yesterday.Totale_casi)},
+		{"Tamponi", strconv.Itoa(today.Tamponi), formatDelta(today.Tamponi - yesterday.Tamponi)},
+	})
+	filename, err := d.getPlotAndamentoNazionale()
+	if err != nil {
+		log.Println(err)
+	} else {
+		r.image(filename)
+	}
+
+	r.section("Classifiche")
+	r.subsection("Top " + strconv.Itoa(nTopRegions) + " regioni per contagi")
+	rows := make([][]string, 0)
+	topRegions := covidgraphs.GetTopTenRegionsTotaleContagi(&d.regions)
+	for i := 0; i < nTopRegions && i < len(*topRegions); i++ {
+		rows = append(rows, []string{strconv.Itoa(i+1) + ". " + (*topRegions)[i].Denominazione_regione, strconv.Itoa((*topRegions)[i].Totale_casi)})
+	}
+	r.table([]string{"Regione", "Totale casi"}, []float64{100, 80}, rows)
+	r.subsection("Top " + strconv.Itoa(nTopRegions) + " province per contagi")
+	rows = make([][]string, 0)
+	topProvinces := covidgraphs.GetTopTenProvincesTotaleContagi(&d.provinces)
+	for i := 0; i < nTopRegions && i < len(*topProvinces); i++ {
+		rows = append(rows, []string{strconv.Itoa(i+1) + ". " + (*topProvinces)[i].Denominazione_provincia, strconv.Itoa((*topProvinces)[i].Totale_casi)})
+	}
+	r.table([]string{"Provincia", "Totale casi"}, []float64{100, 80}, rows)
+
+	r.section("Riepilogo regionale")
+	rows = make([][]string, 0)
+	for i := len(d.regions) - 21; i < len(d.regions); i++ {
+		region := d.regions[i]
+		zone := "-"
+		if z, err := d.getRegionZone(i); err == nil {
+			zone = z.colour
+		}
+		rows = append(rows, []string{
+			region.Denominazione_regione,
+			strconv.Itoa(region.Nuovi_positivi),
+			strconv.Itoa(region.Totale_positivi),
+			strconv.Itoa(region.Terapia_intensiva),
+			formatDelta(region.Deceduti - d.regions[i-21].Deceduti),
+			zone,
+		})
+	}
+	r.table([]string{"Regione", "Nuovi positivi", "Positivi", "Terapia int.", "Morti oggi", "Zona"}, []float64{50, 28, 28, 26, 24, 24}, rows)
+
+	anomalies := append([]dataAnomaly{}, d.nationAnomalies[lastIndex]...)
+	for i := len(d.regions) - 21; i < len(d.regions); i++ {
+		for _, v := range d.regionsAnomalies[i] {
+			v.explanation = d.regions[i].Denominazione_regione + " - " + v.explanation
+			anomalies = append(anomalies, v)
+		}
+	}
+	r.notesSection(d.getNotesByDate(today.Data), anomalies)
+
+	return r.save("report generale")
+}
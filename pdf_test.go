@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPdfReportSave(t *testing.T) {
+	const reports = 4
+	filenames := make([]string, reports)
+	errs := make([]error, reports)
+	var wg sync.WaitGroup
+	for i := 0; i < reports; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := newPdfReport("Report di prova", "2022-03-05")
+			r.section("Sezione")
+			r.paragraph("Testo del report")
+			filenames[i], errs[i] = r.save("prova/test")
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for i, filename := range filenames {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		defer os.Remove(filename)
+		if seen[filename] {
+			t.Errorf("%s saved by two reports", filename)
+		}
+		seen[filename] = true
+
+		if !strings.HasPrefix(filepath.Base(filename), "prova-test-") || filepath.Ext(filename) != ".pdf" {
+			t.Errorf("unexpected file name %s", filename)
+		}
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(content, []byte("%PDF-")) {
+			t.Errorf("%s isn't a PDF file", filename)
+		}
+	}
+}
+
+func TestBuildReportGenerale(t *testing.T) {
+	filename, err := getData().buildReportGenerale()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+	if !strings.HasPrefix(filepath.Base(filename), "report generale-") {
+		t.Errorf("unexpected file name %s", filename)
+	}
+
+	if _, err = (&dataSnapshot{}).buildReportGenerale(); err == nil {
+		t.Error("report built without data")
+	}
+}
+
+func TestFormatDelta(t *testing.T) {
+	for delta, want := range map[int]string{12: "+12", 0: "0", -7: "-7"} {
+		if got := formatDelta(delta); got != want {
+			t.Errorf("formatDelta(%d) = %s, want %s", delta, got, want)
+		}
+	}
+}
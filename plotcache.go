@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/DarkFighterLuke/covidgraphs"
+	"github.com/NicoNex/echotron"
+	"hash/fnv"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const plotPrewarmProvinces = 5 // Number of most requested provinces whose plot is rendered after an update
+
+var plotCacheMaxSize = int64(getEnvInt("CovidBotPlotCacheMB", 100)) << 20 // Size of the plots folder above which the least recently used plots are removed
+
+var plotCacheMutex = &sync.Mutex{}
+var plotLastUse = make(map[string]time.Time)    // Last time each plot file has been requested
+var plotFileIds = make(map[string]string)       // Telegram file_id of each plot already uploaded
+var provincePlotRequests = make(map[string]int) // Number of plot requests for each province, used to choose the plots to prewarm
+
+// Returns the key identifying the data plots are made from: date of the latest data and a hash of their content,
+// so that plots are rendered again when pcm-dpc corrects the data of the same day
+func (d *dataSnapshot) getVersionKey() string {
+	if len(d.nation) == 0 {
+		return ""
+	}
+	lastDate := d.nation[len(d.nation)-1].Data
+
+	h := fnv.New32a()
+	last := struct {
+		Nation    covidgraphs.NationData
+		Regions   []covidgraphs.RegionData
+		Provinces []covidgraphs.ProvinceData
+	}{Nation: d.nation[len(d.nation)-1]}
+	for i := len(d.regions) - 1; i >= 0 && d.regions[i].Data == lastDate; i-- {
+		last.Regions = append(last.Regions, d.regions[i])
+	}
+	for i := len(d.provinces) - 1; i >= 0 && d.provinces[i].Data == lastDate; i-- {
+		last.Provinces = append(last.Provinces, d.provinces[i])
+	}
+	content, err := json.Marshal(last)
+	if err != nil {
+		log.Println(err)
+	}
+	h.Write(content)
+
+	date, err := time.Parse("2006-01-02T15:04:05", lastDate)
+	if err != nil {
+		return fmt.Sprintf("%08x", h.Sum32())
+	}
+	return date.Format("20060102") + fmt.Sprintf("-%08x", h.Sum32())
+}
+
+// Returns the filename of the plot with the given title for the snapshot data
+func (d *dataSnapshot) getPlotFilename(title string) string {
+	return d.getPlotPath(covidgraphs.FilenameCreator(title))
+}
+
+// Returns the path of the plot file with the given name for the snapshot data
+func (d *dataSnapshot) getPlotPath(name string) string {
+	return workingDirectory + imageFolder + d.versionKey + "-" + name
+}
+
+// Returns true if the plot file already exists, marking it as recently used.
+// On a miss the least recently used plots are removed if the cache is too big
+func isPlotCached(filename string) bool {
+	plotCacheMutex.Lock()
+	defer plotCacheMutex.Unlock()
+
+	plotLastUse[filename] = time.Now()
+	if covidgraphs.IsGraphExisting(filename) {
+		plotCacheCounter.WithLabelValues("hit").Inc()
+		return true
+	}
+	plotCacheCounter.WithLabelValues("miss").Inc()
+	prunePlots()
+	return false
+}
+
+// Removes the least recently used plots until the plots folder fits in the maximum size.
+// Plots never requested since the bot started are considered as used when they were created.
+// Must be called with plotCacheMutex held
+func prunePlots() {
+	dirPath := workingDirectory + imageFolder
+	files, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	var size int64
+	for _, f := range files {
+		size += f.Size()
+	}
+	if size <= plotCacheMaxSize {
+		return
+	}
+
+	lastUse := func(f os.FileInfo) time.Time {
+		if t, ok := plotLastUse[dirPath+f.Name()]; ok {
+			return t
+		}
+		return f.ModTime()
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return lastUse(files[i]).Before(lastUse(files[j]))
+	})
+
+	for _, f := range files {
+		if size <= plotCacheMaxSize {
+			break
+		}
+		err = os.Remove(dirPath + f.Name())
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		delete(plotLastUse, dirPath+f.Name())
+		delete(plotFileIds, dirPath+f.Name())
+		plotCacheCounter.WithLabelValues("evicted").Inc()
+		size -= f.Size()
+	}
+}
+
+// Removes the plots made from data other than the ones with the given version keys
+func removeStalePlots(keys ...string) {
+	plotCacheMutex.Lock()
+	defer plotCacheMutex.Unlock()
+
+	dirPath := workingDirectory + imageFolder
+	isStale := func(name string) bool {
+		for _, key := range keys {
+			if strings.HasPrefix(name, key+"-") {
+				return false
+			}
+		}
+		return true
+	}
+
+	files, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, f := range files {
+		if !isStale(f.Name()) {
+			continue
+		}
+		err = os.Remove(dirPath + f.Name())
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	for filename := range plotLastUse {
+		if isStale(strings.TrimPrefix(filename, dirPath)) {
+			delete(plotLastUse, filename)
+		}
+	}
+	for filename := range plotFileIds {
+		if isStale(strings.TrimPrefix(filename, dirPath)) {
+			delete(plotFileIds, filename)
+		}
+	}
+}
+
+// Returns the Telegram file_id of the plot, if it has already been uploaded
+func getPlotFileId(filename string) (string, bool) {
+	plotCacheMutex.Lock()
+	defer plotCacheMutex.Unlock()
+
+	fileId, ok := plotFileIds[filename]
+	if ok {
+		plotLastUse[filename] = time.Now()
+		plotCacheCounter.WithLabelValues("file_id").Inc()
+	}
+	return fileId, ok
+}
+
+// Records the Telegram file_id of the plot returned by an upload
+func setPlotFileId(filename string, response echotron.APIResponseMessage) {
+	if !response.Ok || response.Result == nil || len(response.Result.Photo) == 0 {
+		return
+	}
+	// Sizes are sorted from the smallest, the last one is the original picture
+	photo := response.Result.Photo[len(response.Result.Photo)-1]
+	if photo == nil || photo.FileId == "" {
+		return
+	}
+
+	plotCacheMutex.Lock()
+	plotFileIds[filename] = photo.FileId
+	plotCacheMutex.Unlock()
+}
+
+// Forgets the Telegram file_id of the plot, so that it will be uploaded again
+func forgetPlotFileId(filename string) {
+	plotCacheMutex.Lock()
+	delete(plotFileIds, filename)
+	plotCacheMutex.Unlock()
+}
+
+// Counts a plot request for the given province
+func countProvincePlotRequest(name string) {
+	plotCacheMutex.Lock()
+	provincePlotRequests[name]++
+	plotCacheMutex.Unlock()
+}
+
+// Returns the names of the most requested provinces, at most n
+func getMostRequestedProvinces(n int) []string {
+	plotCacheMutex.Lock()
+	defer plotCacheMutex.Unlock()
+
+	names := make([]string, 0, len(provincePlotRequests))
+	for k := range provincePlotRequests {
+		names = append(names, k)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if provincePlotRequests[names[i]] != provincePlotRequests[names[j]] {
+			return provincePlotRequests[names[i]] > provincePlotRequests[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > n {
+		names = names[:n]
+	}
+	return names
+}
+
+// Renders the most requested plots so that they are ready when users ask for them:
+// national trend and new cases, the trend of every region and of the most requested provinces
+func (d *dataSnapshot) prewarmPlots() {
+	start := time.Now()
+
+	if _, err := d.getPlotAndamentoNazionale(); err != nil {
+		log.Println(err)
+	}
+	if _, err := d.getPlotNuoviPositiviNazione(); err != nil {
+		log.Println(err)
+	}
+	for i := len(d.regions) - 1; i >= 0 && d.regions[i].Data == d.regions[len(d.regions)-1].Data; i-- {
+		if _, err := d.getPlotAndamentoRegionale(i); err != nil {
+			log.Println(err)
+		}
+	}
+	for _, name := range getMostRequestedProvinces(plotPrewarmProvinces) {
+		indexes := covidgraphs.GetProvinceIndexesByName(&d.provinces, name)
+		if len(*indexes) == 0 {
+			continue
+		}
+		if _, err := d.renderPlotProvinciale((*indexes)[len(*indexes)-1]); err != nil {
+			log.Println(err)
+		}
+	}
+
+	log.Println("plots prewarmed in " + time.Since(start).Round(time.Millisecond).String())
+}
+
+// Returns the number and the total size of the plot files and the number of plots already uploaded to Telegram
+func getPlotCacheStats() (int, int64, int) {
+	plotCacheMutex.Lock()
+	defer plotCacheMutex.Unlock()
+
+	files, err := ioutil.ReadDir(workingDirectory + imageFolder)
+	if err != nil {
+		log.Println(err)
+	}
+	var size int64
+	for _, f := range files {
+		size += f.Size()
+	}
+	return len(files), size, len(plotFileIds)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetVersionKey(t *testing.T) {
+	d := getData()
+	if !regexp.MustCompile(`^20220305-[0-9a-f]{8}$`).MatchString(d.versionKey) || d.getVersionKey() != d.versionKey {
+		t.Fatalf("version key %q", d.versionKey)
+	}
+
+	// A correction of the latest data gives a new key, older days don't count
+	corrected := *d
+	corrected.provinces = append(corrected.provinces[:0:0], d.provinces...)
+	corrected.provinces[len(corrected.provinces)-1].Totale_casi++
+	if corrected.getVersionKey() == d.versionKey {
+		t.Error("same key after a correction of the latest data")
+	}
+	corrected.provinces = append(corrected.provinces[:0:0], d.provinces...)
+	corrected.provinces[0].Totale_casi++
+	if corrected.getVersionKey() != d.versionKey {
+		t.Error("new key after a correction of older data")
+	}
+
+	if key := (&dataSnapshot{}).getVersionKey(); key != "" {
+		t.Errorf("key %q without data", key)
+	}
+}
+
+// Moves the plots folder to a new empty directory for the duration of the test
+func useEmptyPlotsFolder(t *testing.T) string {
+	previous := workingDirectory
+	workingDirectory = t.TempDir()
+	t.Cleanup(func() { workingDirectory = previous })
+	if err := os.MkdirAll(workingDirectory+imageFolder, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return workingDirectory + imageFolder
+}
+
+func TestPrunePlots(t *testing.T) {
+	dirPath := useEmptyPlotsFolder(t)
+	defer func(size int64) { plotCacheMaxSize = size }(plotCacheMaxSize)
+	plotCacheMaxSize = 250
+
+	// The oldest file has been requested last, so the newest one goes first
+	now := time.Now()
+	for i, name := range []string{"a.png", "b.png", "c.png"} {
+		if err := ioutil.WriteFile(dirPath+name, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := now.Add(time.Duration(i-3) * time.Hour)
+		if err := os.Chtimes(dirPath+name, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	plotCacheMutex.Lock()
+	plotLastUse[dirPath+"a.png"] = now
+	plotFileIds[dirPath+"b.png"] = "file-b"
+	prunePlots()
+	_, idKept := plotFileIds[dirPath+"b.png"]
+	delete(plotLastUse, dirPath+"a.png")
+	plotCacheMutex.Unlock()
+
+	for name, kept := range map[string]bool{"a.png": true, "b.png": false, "c.png": true} {
+		if _, err := os.Stat(dirPath + name); (err == nil) != kept {
+			t.Errorf("%s kept: %v, want %v", name, err == nil, kept)
+		}
+	}
+	if idKept {
+		t.Error("file_id of a removed plot kept")
+	}
+	if files, size, _ := getPlotCacheStats(); files != 2 || size != 200 {
+		t.Errorf("%d files of %d bytes after pruning, want 2 of 200", files, size)
+	}
+}
+
+func TestIsPlotCached(t *testing.T) {
+	dirPath := useEmptyPlotsFolder(t)
+	filename := dirPath + "trend.png"
+	defer func() {
+		plotCacheMutex.Lock()
+		delete(plotLastUse, filename)
+		plotCacheMutex.Unlock()
+	}()
+
+	if isPlotCached(filename) {
+		t.Error("missing plot found in the cache")
+	}
+	if err := ioutil.WriteFile(filename, []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !isPlotCached(filename) {
+		t.Error("plot not found in the cache")
+	}
+}
+
+func TestGetMostRequestedProvinces(t *testing.T) {
+	plotCacheMutex.Lock()
+	previous := provincePlotRequests
+	provincePlotRequests = make(map[string]int)
+	plotCacheMutex.Unlock()
+	defer func() {
+		plotCacheMutex.Lock()
+		provincePlotRequests = previous
+		plotCacheMutex.Unlock()
+	}()
+
+	for _, name := range []string{"Lecce", "Bari", "Bari", "Roma", "Milano", "Milano", "Milano"} {
+		countProvincePlotRequest(name)
+	}
+	// Ties are sorted by name
+	if names := strings.Join(getMostRequestedProvinces(3), ","); names != "Milano,Bari,Lecce" {
+		t.Errorf("most requested provinces %s", names)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/NicoNex/echotron"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const privacyCallbackPrefix = "privacy "
+
+var privacyText = `🔒 <b>Privacy</b>
+
+Per ogni chat il bot conserva solo quello che serve a farlo funzionare:
+- l'<b>id della chat</b>, per inviare le rare comunicazioni sul servizio, ad esempio una manutenzione;
+- gli <b>avvisi</b> creati con /avviso, per inviarteli quando i dati cambiano;
+- gli <b>aggiornamenti programmati</b> con /programma, per inviarli all'orario scelto;
+- il <b>messaggio live</b> fissato con /live, per aggiornarlo ad ogni bollettino;
+- il <b>registro delle interazioni</b>: comando o pulsante usato, ora, tipo di chat e tempo di risposta, con un identificativo anonimo della chat al posto del suo id, per capire quali funzioni vengono usate e se il bot risponde in tempo. I file vengono eliminati dopo ` + strconv.Itoa(interactionsRetentionDays) + ` giorni e puoi escluderti con /registro <code>no</code>;
+- la <b>preferenza</b> di non essere registrato, se l'hai espressa;
+- le <b>statistiche d'uso</b>, contatori giornalieri aggregati di comandi, pulsanti, regioni e province richieste che non permettono di risalire alle singole chat. Per contare le chat attive l'identificativo anonimo viene conservato fino alla fine della giornata.
+
+Nomi, username e testo dei messaggi non vengono salvati. Le versioni precedenti del bot salvavano gli update completi in un file con il tuo nome: se esiste viene incluso nell'esportazione e cancellato insieme agli altri dati.
+
+/esportamiei
+per scaricare i dati di questa chat in formato JSON
+/cancellami
+per cancellare i dati di questa chat, avvisi e programmazioni compresi
+
+Nei gruppi i dati sono quelli del gruppo e solo gli amministratori possono scaricarli o cancellarli.`
+
+// Data stored about a chat
+type personalData struct {
+	Chat                  int64           `json:"chat"`
+	IdentificativoAnonimo string          `json:"identificativo_anonimo"`
+	Avvisi                []alertRule     `json:"avvisi"`
+	Programmazioni        []scheduledPost `json:"programmazioni"`
+	MessaggioLive         *liveMessage    `json:"messaggio_live,omitempty"`
+	ComunicazioniServizio bool            `json:"comunicazioni_servizio"`
+	EsclusaDalRegistro    bool            `json:"esclusa_dal_registro"`
+	Interazioni           []interaction   `json:"interazioni"`
+	VecchiLog             []string        `json:"vecchi_log,omitempty"`
+}
+
+// Returns true for the privacy commands and callbacks, which aren't logged
+// so that a deletion doesn't leave a trace of the chat
+func isPrivacyAction(action string) bool {
+	return action == "/privacy" || action == "/esportamiei" || action == "/cancellami" || strings.HasPrefix(strings.ToLower(action), privacyCallbackPrefix)
+}
+
+// Returns the file where previous versions logged the updates of the chat, named after its username,
+// its first and last name or its title. Returns an empty string if there isn't one
+func getLegacyLogFilename(chat *echotron.Chat) string {
+	var name string
+	if chat.Type == "private" {
+		if chat.Username == "" {
+			name = chat.FirstName + "_" + chat.LastName
+		} else {
+			name = chat.Username
+		}
+	} else {
+		name = chat.Title
+	}
+	if name == "" || name == "_" || strings.Contains(name, "/") {
+		return ""
+	}
+
+	filename := workingDirectory + logsFolder + name + ".txt"
+	if _, err := os.Stat(filename); err != nil {
+		return ""
+	}
+	return filename
+}
+
+// Collects the data stored about a chat
+func getPersonalData(chat *echotron.Chat) (personalData, error) {
+	data := personalData{
+		Chat:                  chat.ID,
+		IdentificativoAnonimo: hashChatId(chat.ID),
+		Avvisi:                getChatAlerts(chat.ID),
+		Programmazioni:        getChatSchedules(chat.ID),
+		ComunicazioniServizio: isKnownChat(chat.ID),
+		EsclusaDalRegistro:    isInteractionsOptOut(chat.ID),
+	}
+	if message, ok := getLiveMessage(chat.ID); ok {
+		data.MessaggioLive = &message
+	}
+
+	var err error
+	data.Interazioni, err = getChatInteractions(chat.ID)
+	if err != nil {
+		return data, err
+	}
+
+	if filename := getLegacyLogFilename(chat); filename != "" {
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return data, err
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			if line != "" {
+				data.VecchiLog = append(data.VecchiLog, line)
+			}
+		}
+	}
+	return data, nil
+}
+
+// Deletes the data stored about a chat, unpinning its live message
+func (b *bot) deletePersonalData(chat *echotron.Chat) error {
+	for _, v := range getChatAlerts(chat.ID) {
+		err := deleteAlert(chat.ID, v.ID)
+		if err != nil {
+			return err
+		}
+	}
+	for _, v := range getChatSchedules(chat.ID) {
+		err := deleteSchedule(chat.ID, v.ID)
+		if err != nil {
+			return err
+		}
+	}
+	if message, ok := getLiveMessage(chat.ID); ok {
+		b.unpinChatMessage(chat.ID, message.MessageId)
+		err := deleteLiveMessage(chat.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := deleteChatInteractions(chat.ID)
+	if err != nil {
+		return err
+	}
+	forgetUsageChat(chat.ID)
+	err = forgetChat(chat.ID)
+	if err != nil {
+		return err
+	}
+	err = setInteractionsOptOut(chat.ID, false)
+	if err != nil {
+		return err
+	}
+
+	if filename := getLegacyLogFilename(chat); filename != "" {
+		return os.Remove(filename)
+	}
+	return nil
+}
+
+// Handles "privacy" textual command
+func (b *bot) textPrivacy(update *echotron.Update) {
+	buttons, err := b.makeButtons([]string{"Scarica i miei dati 📦", "Cancella i miei dati 🗑"}, []string{privacyCallbackPrefix + "esporta", privacyCallbackPrefix + "cancella"}, 1)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	b.SendMessageWithKeyboard(privacyText, update.Message.Chat.ID, buttons, echotron.PARSE_HTML)
+}
+
+// Handles "esportamiei" textual command
+func (b *bot) textEsportaMiei(update *echotron.Update) {
+	b.askPrivacyConfirmation(update.Message.Chat.ID, "esporta")
+}
+
+// Handles "cancellami" textual command
+func (b *bot) textCancellami(update *echotron.Update) {
+	b.askPrivacyConfirmation(update.Message.Chat.ID, "cancella")
+}
+
+// Asks to confirm the export or the deletion of the chat data
+func (b *bot) askPrivacyConfirmation(chatId int64, action string) {
+	text := "📦 Vuoi ricevere un file JSON con tutti i dati salvati per questa chat?"
+	confirm := "Sì, invia i dati"
+	if action == "cancella" {
+		text = "🗑 Vuoi cancellare tutti i dati salvati per questa chat?\n" +
+			"Verranno eliminati avvisi, aggiornamenti programmati, messaggio live, registro delle interazioni e preferenze, e la chat non riceverà più le comunicazioni sul servizio finché non userai di nuovo il bot. L'operazione non si può annullare."
+		confirm = "Sì, cancella tutto"
+	}
+
+	buttons, err := b.makeButtons([]string{confirm, "Annulla"}, []string{privacyCallbackPrefix + action + " conferma", privacyCallbackPrefix + "annulla"}, 2)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	b.SendMessageWithKeyboard(text, chatId, buttons)
+}
+
+// Recognizes the callbacks of the privacy commands
+func (b *bot) casePrivacy(cq *echotron.CallbackQuery) error {
+	data := strings.ToLower(cq.Data)
+	if !strings.HasPrefix(data, privacyCallbackPrefix) {
+		return fmt.Errorf("not a privacy case")
+	}
+	tokens := strings.Fields(strings.TrimPrefix(data, privacyCallbackPrefix))
+	if len(tokens) == 0 {
+		return fmt.Errorf("not a privacy case")
+	}
+	chat := cq.Message.Chat
+
+	switch {
+	case tokens[0] == "annulla":
+		b.EditMessageText(chat.ID, cq.Message.ID, "Operazione annullata.")
+		b.AnswerCallbackQuery(cq.ID, "Annullato", false)
+		return nil
+	case len(tokens) == 1 && (tokens[0] == "esporta" || tokens[0] == "cancella"):
+		b.askPrivacyConfirmation(chat.ID, tokens[0])
+		b.AnswerCallbackQuery(cq.ID, "", false)
+		return nil
+	case len(tokens) != 2 || tokens[1] != "conferma":
+		return fmt.Errorf("not a privacy case")
+	}
+
+	if !b.isChatAdmin(chat, cq.User) {
+		b.AnswerCallbackQuery(cq.ID, "Solo gli amministratori possono gestire i dati del gruppo", true)
+		return nil
+	}
+
+	switch tokens[0] {
+	case "esporta":
+		b.AnswerCallbackQuery(cq.ID, "Preparazione dei dati", false)
+		b.EditMessageText(chat.ID, cq.Message.ID, "📦 Ecco i dati salvati per questa chat.")
+		b.sendPersonalData(chat)
+	case "cancella":
+		err := b.deletePersonalData(chat)
+		if err != nil {
+			log.Println(err)
+			b.AnswerCallbackQuery(cq.ID, "Si è verificato un errore", false)
+			b.SendMessage("Impossibile cancellare tutti i dati al momento.\nRiprova più tardi.", chat.ID)
+			return nil
+		}
+		b.AnswerCallbackQuery(cq.ID, "Dati cancellati", false)
+		b.EditMessageText(chat.ID, cq.Message.ID, "🗑 Tutti i dati di questa chat sono stati cancellati.")
+	default:
+		return fmt.Errorf("not a privacy case")
+	}
+	return nil
+}
+
+// Sends the data stored about a chat as a JSON document
+func (b *bot) sendPersonalData(chat *echotron.Chat) {
+	b.SendChatAction(echotron.UPLOAD_DOCUMENT, chat.ID)
+	data, err := getPersonalData(chat)
+	if err != nil {
+		log.Println(err)
+		b.SendMessage("Impossibile esportare i dati al momento.\nRiprova più tardi.", chat.ID)
+		return
+	}
+
+	content, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	filename, err := createTempOutput("dati-"+data.IdentificativoAnonimo+"-*.json", func(w io.Writer) error {
+		_, err := w.Write(content)
+		return err
+	})
+	if err != nil {
+		log.Println(err)
+		b.SendMessage("Impossibile esportare i dati al momento.\nRiprova più tardi.", chat.ID)
+		return
+	}
+
+	b.SendDocument(filename, "", chat.ID)
+	err = os.Remove(filename)
+	if err != nil {
+		log.Println("can't delete file " + filename)
+	}
+}
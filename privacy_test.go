@@ -0,0 +1,128 @@
+package main
+
+import (
+	"github.com/NicoNex/echotron"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const privacyChatId = 4321
+
+func TestIsPrivacyAction(t *testing.T) {
+	for action, private := range map[string]bool{
+		"/privacy":                       true,
+		"/cancellami":                    true,
+		"Privacy cancella conferma":      true,
+		"/esporta":                       false,
+		"programma " + scheduleKindZones: false,
+	} {
+		if isPrivacyAction(action) != private {
+			t.Errorf("%q privacy action: %v, want %v", action, !private, private)
+		}
+	}
+}
+
+func TestGetLegacyLogFilename(t *testing.T) {
+	filename := workingDirectory + logsFolder + "mario_rossi.txt"
+	if err := ioutil.WriteFile(filename, []byte("update\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+
+	for chat, want := range map[*echotron.Chat]string{
+		{Type: "private", Username: "mario_rossi"}:               filename,
+		{Type: "private", FirstName: "mario", LastName: "rossi"}: filename,
+		{Type: "private", Username: "luigi"}:                     "",
+		{Type: "private"}:                                        "",
+		{Type: "group", Title: "../mario_rossi"}:                 "",
+	} {
+		if got := getLegacyLogFilename(chat); got != want {
+			t.Errorf("legacy log of %+v: %q, want %q", chat, got, want)
+		}
+	}
+}
+
+func TestPrivacyExportAndDelete(t *testing.T) {
+	b := newTestBot(privacyChatId)
+	rule, err := addAlert(alertRule{ChatId: privacyChatId, Area: "nazione", Metric: "deceduti", Condition: alertAbove, Value: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer deleteAlert(privacyChatId, rule.ID)
+	post, err := addSchedule(scheduledPost{ChatId: privacyChatId, Time: "18:00", Days: scheduleAllDays, Kind: scheduleKindNation})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer deleteSchedule(privacyChatId, post.ID)
+	if err = setLiveMessage(liveMessage{ChatId: privacyChatId, MessageId: 7, Kind: "nazione"}); err != nil {
+		t.Fatal(err)
+	}
+	defer deleteLiveMessage(privacyChatId)
+	legacyLog := workingDirectory + logsFolder + "Mario_.txt"
+	if err = ioutil.WriteFile(legacyLog, []byte("vecchio update\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(legacyLog)
+
+	calls := b.send("/privacy")
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "Privacy", "/esportamiei", "/cancellami")
+	expectKeyboard(t, calls[0], "privacy esporta", "privacy cancella")
+	b.send("/zone")
+
+	data, err := getPersonalData(testChat(privacyChatId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Avvisi) != 1 || len(data.Programmazioni) != 1 || data.MessaggioLive == nil || !data.ComunicazioniServizio ||
+		len(data.Interazioni) != 1 || data.Interazioni[0].Azione != "/zone" || len(data.VecchiLog) != 1 {
+		t.Errorf("unexpected personal data %+v", data)
+	}
+
+	calls = b.send("/esportamiei")
+	expectMethods(t, calls, "sendMessage")
+	expectKeyboard(t, calls[0], "privacy esporta conferma", "privacy annulla")
+	calls = b.press("privacy esporta conferma")
+	expectMethods(t, calls, "answerCallbackQuery", "editMessageText", "sendDocument")
+	if !strings.HasPrefix(calls[2].Document, "dati-"+data.IdentificativoAnonimo+"-") || filepath.Ext(calls[2].Document) != ".json" {
+		t.Errorf("unexpected document %s", calls[2].Document)
+	}
+
+	calls = b.send("/cancellami")
+	expectKeyboard(t, calls[0], "privacy cancella conferma", "privacy annulla")
+	calls = b.press("privacy cancella conferma")
+	expectMethods(t, calls, "unpinChatMessage", "answerCallbackQuery", "editMessageText")
+	expectText(t, calls[2], "Tutti i dati di questa chat sono stati cancellati")
+
+	data, err = getPersonalData(testChat(privacyChatId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Avvisi) != 0 || len(data.Programmazioni) != 0 || data.MessaggioLive != nil || data.ComunicazioniServizio ||
+		len(data.Interazioni) != 0 || len(data.VecchiLog) != 0 {
+		t.Errorf("personal data %+v left after the deletion", data)
+	}
+	if _, err = os.Stat(legacyLog); err == nil {
+		t.Error("legacy log not deleted")
+	}
+	if files, _ := filepath.Glob(workingDirectory + reportsFolder + "dati-*"); len(files) != 0 {
+		t.Errorf("exports left in the reports folder: %v", files)
+	}
+}
+
+func TestGroupPrivacy(t *testing.T) {
+	b := newTestBot(testGroupId)
+	calls := b.press("privacy cancella conferma")
+	expectMethods(t, calls, "getChatMember", "answerCallbackQuery")
+	if calls[1].Params.Get("text") != "Solo gli amministratori possono gestire i dati del gruppo" {
+		t.Errorf("answer %q, want the admin check", calls[1].Params.Get("text"))
+	}
+
+	telegram.setChatMemberStatus(t, "administrator")
+	calls = b.press("privacy annulla")
+	expectMethods(t, calls, "editMessageText", "answerCallbackQuery")
+	expectText(t, calls[0], "Operazione annullata.")
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/NicoNex/echotron"
+	"golang.org/x/time/rate"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	limitersCleanupInterval = 10 * time.Minute // Limiters of chats idle for this long are dropped
+	telegramMaxRetries      = 3                // Times a request refused with 429 is sent again
+	telegramDefaultRetry    = 5 * time.Second  // Wait after a 429 response without retry_after
+	sendQueueSize           = 50               // Requests waiting to be sent to a chat, further ones are refused
+	sendQueueIdleTimeout    = time.Minute      // The goroutine sending to a chat stops after being idle for this long
+)
+
+// Incoming commands and callbacks allowed for each chat and for the whole bot
+var chatUpdatesPerMinute = getEnvInt("CovidBotChatUpdatesPerMinute", 20)
+var chatUpdatesBurst = getEnvInt("CovidBotChatUpdatesBurst", 5)
+var globalUpdatesPerSecond = getEnvInt("CovidBotUpdatesPerSecond", 30)
+
+// Outgoing messages allowed by Telegram: about one per second in a private chat,
+// 20 per minute in a group and 30 per second overall
+var (
+	privateSendLimit = rate.Every(time.Second)
+	groupSendLimit   = rate.Every(time.Minute / 20)
+	globalSendLimit  = rate.Limit(30)
+)
+
+// Token bucket limiters indexed by chat, the ones unused for a while are dropped
+type chatLimiters struct {
+	mutex       sync.Mutex
+	limiters    map[int64]*chatLimiter
+	newLimiter  func(chatId int64) *rate.Limiter
+	lastCleanup time.Time
+}
+
+// Limiter of a chat
+type chatLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+	warned   bool // The chat has already been told it's sending too many requests
+}
+
+// A value not greater than zero disables the corresponding limit
+var updateLimiters = newChatLimiters(func(chatId int64) *rate.Limiter {
+	if chatUpdatesPerMinute <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	return rate.NewLimiter(rate.Every(time.Minute/time.Duration(chatUpdatesPerMinute)), chatUpdatesBurst)
+})
+var globalUpdateLimiter = newGlobalUpdateLimiter()
+
+var globalSendLimiter = rate.NewLimiter(globalSendLimit, 30)
+
+// Returns the limiter of the updates handled by the whole bot
+func newGlobalUpdateLimiter() *rate.Limiter {
+	if globalUpdatesPerSecond <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	return rate.NewLimiter(rate.Limit(globalUpdatesPerSecond), 2*globalUpdatesPerSecond)
+}
+
+// Returns an empty set of limiters created with the given function
+func newChatLimiters(newLimiter func(chatId int64) *rate.Limiter) *chatLimiters {
+	return &chatLimiters{
+		limiters:    make(map[int64]*chatLimiter),
+		newLimiter:  newLimiter,
+		lastCleanup: time.Now(),
+	}
+}
+
+// Returns the limiter of the chat, creating it if needed. Must be called with the mutex held
+func (l *chatLimiters) get(chatId int64) *chatLimiter {
+	now := time.Now()
+	if now.Sub(l.lastCleanup) > limitersCleanupInterval {
+		for k, v := range l.limiters {
+			if now.Sub(v.lastSeen) > limitersCleanupInterval {
+				delete(l.limiters, k)
+			}
+		}
+		l.lastCleanup = now
+	}
+
+	limiter, ok := l.limiters[chatId]
+	if !ok {
+		limiter = &chatLimiter{limiter: l.newLimiter(chatId)}
+		l.limiters[chatId] = limiter
+	}
+	limiter.lastSeen = now
+	return limiter
+}
+
+// Returns true if the update can be handled. Only commands and callbacks are limited,
+// so that normal messages in groups don't use the tokens of the chat.
+// The second value is true the first time a chat is limited, to warn it only once
+func allowUpdate(update *echotron.Update) (bool, bool) {
+	if update.CallbackQuery == nil && (update.Message == nil || !strings.HasPrefix(update.Message.Text, "/")) {
+		return true, false
+	}
+	chatId, ok := getUpdateChatId(update)
+	if !ok {
+		return true, false
+	}
+
+	updateLimiters.mutex.Lock()
+	defer updateLimiters.mutex.Unlock()
+
+	limiter := updateLimiters.get(chatId)
+	// The token of the chat is given back when the global limit is reached, so that it isn't limited twice
+	now := time.Now()
+	reservation := limiter.limiter.ReserveN(now, 1)
+	if reservation.OK() && reservation.DelayFrom(now) == 0 && globalUpdateLimiter.AllowN(now, 1) {
+		limiter.warned = false
+		return true, false
+	}
+	reservation.CancelAt(now)
+	rateLimitedCounter.WithLabelValues("in").Inc()
+	warn := !limiter.warned
+	limiter.warned = true
+	return false, warn
+}
+
+// Tells a chat sending too many requests to slow down
+func (b *bot) warnRateLimited(update *echotron.Update, warn bool) {
+	msg := "Troppe richieste, attendi qualche secondo prima di riprovare."
+	if update.CallbackQuery != nil {
+		b.AnswerCallbackQuery(update.CallbackQuery.ID, msg, false)
+	} else if warn {
+		b.SendMessage(msg, update.Message.Chat.ID)
+	}
+}
+
+// Sends the requests to the Telegram Bot API respecting its limits, and sends again the ones refused with 429.
+// Messages are queued by chat and sent in order by a goroutine of the chat, so a slow chat doesn't delay the others
+type telegramRateTransport struct {
+	base   http.RoundTripper
+	mutex  sync.Mutex
+	queues map[int64]chan *queuedRequest
+}
+
+// Request waiting in the queue of its chat
+type queuedRequest struct {
+	request *http.Request
+	body    []byte
+	result  chan queuedResult
+}
+
+// Outcome of a queued request
+type queuedResult struct {
+	response *http.Response
+	err      error
+}
+
+// Response of the Bot API to a refused request
+type apiResponseRetry struct {
+	echotron.APIResponseBase
+	Parameters struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+// Returns a transport sending the Telegram requests through base
+func newTelegramRateTransport(base http.RoundTripper) *telegramRateTransport {
+	return &telegramRateTransport{
+		base:   base,
+		queues: make(map[int64]chan *queuedRequest),
+	}
+}
+
+func (t *telegramRateTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if request.URL.Host != telegramAPIHost {
+		return t.base.RoundTrip(request)
+	}
+
+	// The body is kept in memory, so that uploads can be sent again after a 429
+	var body []byte
+	if request.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(request.Body)
+		request.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	chatId, ok := getRequestChatId(request)
+	if !ok {
+		return t.send(request, body)
+	}
+
+	queued := &queuedRequest{request: request, body: body, result: make(chan queuedResult, 1)}
+	err := t.enqueue(chatId, queued)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case result := <-queued.result:
+		return result.response, result.err
+	case <-request.Context().Done():
+		return nil, request.Context().Err()
+	}
+}
+
+// Returns the chat of a request that sends or edits a message, the only ones limited by Telegram
+func getRequestChatId(request *http.Request) (int64, bool) {
+	method := path.Base(request.URL.Path)
+	if method == "sendChatAction" || !(strings.HasPrefix(method, "send") || strings.HasPrefix(method, "edit")) {
+		return 0, false
+	}
+	chatId, err := strconv.ParseInt(request.URL.Query().Get("chat_id"), 10, 64)
+	return chatId, err == nil
+}
+
+// Adds the request to the queue of the chat, starting its goroutine if it isn't running
+func (t *telegramRateTransport) enqueue(chatId int64, queued *queuedRequest) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	queue, ok := t.queues[chatId]
+	if !ok {
+		queue = make(chan *queuedRequest, sendQueueSize)
+		t.queues[chatId] = queue
+		go t.serveQueue(chatId, queue)
+	}
+	select {
+	case queue <- queued:
+		return nil
+	default:
+		rateLimitedCounter.WithLabelValues("out").Inc()
+		return fmt.Errorf("coda dei messaggi della chat %d piena", chatId)
+	}
+}
+
+// Sends the queued requests of a chat in order, within the limits of the chat and the global one.
+// Returns when the queue has been empty for a while
+func (t *telegramRateTransport) serveQueue(chatId int64, queue chan *queuedRequest) {
+	limiter := rate.NewLimiter(privateSendLimit, 3)
+	if chatId < 0 {
+		limiter = rate.NewLimiter(groupSendLimit, 3)
+	}
+
+	for {
+		select {
+		case queued := <-queue:
+			ctx := queued.request.Context()
+			err := limiter.Wait(ctx)
+			if err == nil {
+				err = globalSendLimiter.Wait(ctx)
+			}
+			if err != nil {
+				queued.result <- queuedResult{err: err}
+				continue
+			}
+			response, err := t.send(queued.request, queued.body)
+			queued.result <- queuedResult{response: response, err: err}
+		case <-time.After(sendQueueIdleTimeout):
+			t.mutex.Lock()
+			if len(queue) == 0 {
+				delete(t.queues, chatId)
+				t.mutex.Unlock()
+				return
+			}
+			t.mutex.Unlock()
+		}
+	}
+}
+
+// Sends a request with the given body, sending it again when refused with 429
+func (t *telegramRateTransport) send(request *http.Request, body []byte) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		attemptRequest := request.Clone(request.Context())
+		if body != nil {
+			attemptRequest.Body = ioutil.NopCloser(bytes.NewReader(body))
+			attemptRequest.GetBody = func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(body)), nil
+			}
+			attemptRequest.ContentLength = int64(len(body))
+		}
+
+		response, err := t.base.RoundTrip(attemptRequest)
+		if err != nil || response.StatusCode != http.StatusTooManyRequests || attempt == telegramMaxRetries {
+			return response, err
+		}
+
+		content, err := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		var refused apiResponseRetry
+		json.Unmarshal(content, &refused)
+		delay := telegramDefaultRetry
+		if refused.Parameters.RetryAfter > 0 {
+			delay = time.Duration(refused.Parameters.RetryAfter) * time.Second
+		}
+		rateLimitedCounter.WithLabelValues("out").Inc()
+		log.Println("telegram refused " + path.Base(request.URL.Path) + " with 429, retrying in " + delay.String())
+
+		select {
+		case <-request.Context().Done():
+			response.Body = ioutil.NopCloser(bytes.NewReader(content))
+			return response, nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// Limits the requests sent to Telegram through the default transport
+func initRateLimits() {
+	http.DefaultTransport = newTelegramRateTransport(http.DefaultTransport)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"github.com/NicoNex/echotron"
+	"golang.org/x/time/rate"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Answers the Telegram requests with the given responses in order, recording the bodies received
+type scriptedTransport struct {
+	mutex     sync.Mutex
+	responses []string
+	statuses  []int
+	bodies    []string
+	release   chan struct{} // If not nil, every request waits for it to be closed
+}
+
+func (t *scriptedTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if t.release != nil {
+		<-t.release
+	}
+	var body []byte
+	if request.Body != nil {
+		body, _ = ioutil.ReadAll(request.Body)
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	i := len(t.bodies)
+	t.bodies = append(t.bodies, string(body))
+	if i >= len(t.responses) {
+		i = len(t.responses) - 1
+	}
+	return &http.Response{
+		StatusCode: t.statuses[i],
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader(t.responses[i])),
+		Request:    request,
+	}, nil
+}
+
+// Returns a request to the Bot API whose body can't be read again by the transport
+func newUploadRequest(t *testing.T, chatId string, body string) *http.Request {
+	request, err := http.NewRequest("POST", "https://"+telegramAPIHost+"/botTOKEN/sendPhoto?chat_id="+chatId, ioutil.NopCloser(strings.NewReader(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return request
+}
+
+func TestTelegramRateTransportRetriesUploads(t *testing.T) {
+	base := &scriptedTransport{
+		responses: []string{`{"ok":false,"error_code":429,"parameters":{"retry_after":1}}`, `{"ok":true}`},
+		statuses:  []int{http.StatusTooManyRequests, http.StatusOK},
+	}
+	transport := newTelegramRateTransport(base)
+
+	response, err := transport.RoundTrip(newUploadRequest(t, "1001", "plot"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("status %d, want %d", response.StatusCode, http.StatusOK)
+	}
+	if len(base.bodies) != 2 || base.bodies[0] != "plot" || base.bodies[1] != "plot" {
+		t.Errorf("bodies sent %q, want the upload sent twice", base.bodies)
+	}
+}
+
+func TestTelegramRateTransportQueueFull(t *testing.T) {
+	base := &scriptedTransport{responses: []string{`{"ok":true}`}, statuses: []int{http.StatusOK}, release: make(chan struct{})}
+	transport := newTelegramRateTransport(base)
+
+	// The first request is taken by the goroutine of the chat, the next ones fill its queue
+	queued := &queuedRequest{request: newUploadRequest(t, "1002", ""), result: make(chan queuedResult, 1)}
+	if err := transport.enqueue(1002, queued); err != nil {
+		t.Fatal(err)
+	}
+	queueLength := func() int {
+		transport.mutex.Lock()
+		defer transport.mutex.Unlock()
+		return len(transport.queues[1002])
+	}
+	for deadline := time.Now().Add(time.Second); queueLength() > 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < sendQueueSize; i++ {
+		err := transport.enqueue(1002, &queuedRequest{request: newUploadRequest(t, "1002", ""), result: make(chan queuedResult, 1)})
+		if err != nil {
+			t.Fatalf("request %d refused: %v", i, err)
+		}
+	}
+	if err := transport.enqueue(1002, &queuedRequest{request: newUploadRequest(t, "1002", ""), result: make(chan queuedResult, 1)}); err == nil {
+		t.Error("request accepted with the queue full")
+	}
+	// Other chats have their own queue
+	if err := transport.enqueue(1003, &queuedRequest{request: newUploadRequest(t, "1003", ""), result: make(chan queuedResult, 1)}); err != nil {
+		t.Errorf("request of another chat refused: %v", err)
+	}
+	close(base.release)
+
+	result := <-queued.result
+	if result.err != nil || result.response.StatusCode != http.StatusOK {
+		t.Errorf("first request: %v", result.err)
+	}
+}
+
+func TestGetRequestChatId(t *testing.T) {
+	for url, want := range map[string]int64{
+		"sendMessage?chat_id=-1001234":   -1001234,
+		"editMessageMedia?chat_id=42":    42,
+		"sendChatAction?chat_id=42":      0,
+		"answerCallbackQuery?chat_id=42": 0,
+		"getChatMember?chat_id=42":       0,
+	} {
+		request, _ := http.NewRequest("GET", "https://"+telegramAPIHost+"/botTOKEN/"+url, nil)
+		chatId, ok := getRequestChatId(request)
+		if chatId != want || ok != (want != 0) {
+			t.Errorf("%s: chat %d %v, want %d", url, chatId, ok, want)
+		}
+	}
+}
+
+func TestAllowUpdate(t *testing.T) {
+	defer func(perMinute int) { chatUpdatesPerMinute = perMinute }(chatUpdatesPerMinute)
+	chatUpdatesPerMinute = 1
+
+	command := &echotron.Update{Message: &echotron.Message{Text: "/nazione andamento", Chat: &echotron.Chat{ID: 5678, Type: "private"}}}
+	for i := 0; i < chatUpdatesBurst; i++ {
+		if ok, _ := allowUpdate(command); !ok {
+			t.Fatalf("command %d refused within the burst", i)
+		}
+	}
+	if ok, warn := allowUpdate(command); ok || !warn {
+		t.Errorf("first command over the limit: allowed %v, warned %v", ok, warn)
+	}
+	if ok, warn := allowUpdate(command); ok || warn {
+		t.Errorf("second command over the limit: allowed %v, warned %v", ok, warn)
+	}
+
+	text := &echotron.Update{Message: &echotron.Message{Text: "ciao", Chat: command.Message.Chat}}
+	if ok, _ := allowUpdate(text); !ok {
+		t.Error("plain message refused")
+	}
+}
+
+func TestAllowUpdateGlobalLimit(t *testing.T) {
+	defer func(perMinute int, global *rate.Limiter) {
+		chatUpdatesPerMinute, globalUpdateLimiter = perMinute, global
+	}(chatUpdatesPerMinute, globalUpdateLimiter)
+	chatUpdatesPerMinute = 1
+
+	// The commands refused by the global limit don't count for the chat
+	command := &echotron.Update{Message: &echotron.Message{Text: "/nazione andamento", Chat: &echotron.Chat{ID: 6789, Type: "private"}}}
+	globalUpdateLimiter = rate.NewLimiter(0, 0)
+	for i := 0; i < chatUpdatesBurst; i++ {
+		if ok, _ := allowUpdate(command); ok {
+			t.Fatal("command allowed over the global limit")
+		}
+	}
+	globalUpdateLimiter = rate.NewLimiter(rate.Inf, 0)
+	for i := 0; i < chatUpdatesBurst; i++ {
+		if ok, _ := allowUpdate(command); !ok {
+			t.Fatalf("command %d refused within the burst of the chat", i)
+		}
+	}
+}
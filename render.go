@@ -0,0 +1,114 @@
+package main
+
+import (
+	"github.com/NicoNex/echotron"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	renderActionDelay    = 500 * time.Millisecond // Time after which users are told that a plot is being generated
+	renderActionInterval = 4 * time.Second        // Telegram shows a chat action for 5 seconds at most
+)
+
+var renderWorkers = getEnvInt("CovidBotRenderWorkers", 2) // Number of plots rendered at the same time
+
+// Free render slots, a render takes one before starting
+var renderSlots = newRenderSlots()
+
+// Render of a plot, shared by all the requests of the same file
+type renderCall struct {
+	done     chan struct{}
+	filename string
+	err      error
+}
+
+var renderMutex = &sync.Mutex{}
+var renderCalls = make(map[string]*renderCall) // Renders in progress indexed by plot filename
+
+// Returns the render slots, at least one
+func newRenderSlots() chan struct{} {
+	if renderWorkers < 1 {
+		log.Println("invalid value for CovidBotRenderWorkers, using one render worker")
+		renderWorkers = 1
+	}
+	return make(chan struct{}, renderWorkers)
+}
+
+// Returns the plot file, rendering it if it isn't cached. At most renderWorkers plots are rendered
+// at the same time and requests of a plot already being rendered wait for that render.
+// The render function has the same signature of covidgraphs plot functions
+func renderPlot(filename string, render func(filename string) (error, string)) (string, error) {
+	// The cache is checked holding renderMutex, so that a plot being rendered isn't taken for a cached one
+	renderMutex.Lock()
+	if call, ok := renderCalls[filename]; ok {
+		renderMutex.Unlock()
+		plotCacheCounter.WithLabelValues("shared").Inc()
+		<-call.done
+		return call.filename, call.err
+	}
+	if isPlotCached(filename) {
+		renderMutex.Unlock()
+		return filename, nil
+	}
+	call := &renderCall{done: make(chan struct{})}
+	renderCalls[filename] = call
+	renderMutex.Unlock()
+
+	plotRenderQueueGauge.Inc()
+	renderSlots <- struct{}{}
+	plotRenderQueueGauge.Dec()
+	start := time.Now()
+	call.filename, call.err = renderPlotFile(filename, render)
+	plotRenderDuration.Observe(time.Since(start).Seconds())
+	<-renderSlots
+
+	renderMutex.Lock()
+	delete(renderCalls, filename)
+	renderMutex.Unlock()
+	close(call.done)
+	return call.filename, call.err
+}
+
+// Renders the plot into a temporary file and moves it to filename once it is complete,
+// so that a partially written plot is never sent or cached. The temporary file is removed if the render fails
+func renderPlotFile(filename string, render func(filename string) (error, string)) (string, error) {
+	tmpFilename := filename + ".tmp"
+	err, rendered := render(tmpFilename)
+	if err != nil {
+		os.Remove(tmpFilename)
+		return "", err
+	}
+	err = os.Rename(rendered, filename)
+	if err != nil {
+		os.Remove(rendered)
+		return "", err
+	}
+	return filename, nil
+}
+
+// Shows the "sending photo" chat action while a plot is being prepared, if it takes more than renderActionDelay.
+// Returns the function that stops it, to be called once the plot has been sent
+func (b *bot) showPlotRendering(chatId int64) func() {
+	done := make(chan struct{})
+	go func() {
+		timer := time.NewTimer(renderActionDelay)
+		defer timer.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-timer.C:
+				b.SendChatAction(echotron.UPLOAD_PHOTO, chatId)
+				timer.Reset(renderActionInterval)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Waits until the condition is true, failing the test after ten seconds
+func waitFor(t *testing.T, condition func() bool, what string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for " + what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Returns a render function counting its calls and blocking until release is closed.
+// Like covidgraphs, it creates the file before writing the plot
+func blockingRender(started, running, maxRunning *int32, release chan struct{}) func(string) (error, string) {
+	return func(filename string) (error, string) {
+		if err := ioutil.WriteFile(filename, nil, 0644); err != nil {
+			return err, ""
+		}
+		atomic.AddInt32(started, 1)
+		n := atomic.AddInt32(running, 1)
+		for {
+			max := atomic.LoadInt32(maxRunning)
+			if n <= max || atomic.CompareAndSwapInt32(maxRunning, max, n) {
+				break
+			}
+		}
+		<-release
+		atomic.AddInt32(running, -1)
+		return ioutil.WriteFile(filename, []byte("png"), 0644), filename
+	}
+}
+
+func TestRenderPlotShared(t *testing.T) {
+	filename := useEmptyPlotsFolder(t) + "condiviso.png"
+	shared := plotCacheCounter.WithLabelValues("shared")
+	before := testutil.ToFloat64(shared)
+
+	const requests = 4
+	var started, running, maxRunning int32
+	release := make(chan struct{})
+	render := blockingRender(&started, &running, &maxRunning, release)
+	results := make([]string, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = renderPlot(filename, render)
+		}(i)
+		if i == 0 {
+			waitFor(t, func() bool { return atomic.LoadInt32(&started) == 1 }, "the first render")
+		}
+	}
+	waitFor(t, func() bool { return testutil.ToFloat64(shared)-before == requests-1 }, "the shared requests")
+	if isPlotCached(filename) {
+		t.Error("plot being rendered taken for a cached one")
+	}
+	close(release)
+	wg.Wait()
+
+	if started != 1 {
+		t.Errorf("plot rendered %d times, want once", started)
+	}
+	for _, v := range results {
+		if v != filename {
+			t.Errorf("result %q, want %q", v, filename)
+		}
+	}
+	if content, _ := ioutil.ReadFile(filename); string(content) != "png" {
+		t.Errorf("plot content %q, want the complete plot", content)
+	}
+}
+
+func TestRenderPlotFailed(t *testing.T) {
+	dirPath := useEmptyPlotsFolder(t)
+	filename := dirPath + "fallito.png"
+	_, err := renderPlot(filename, func(filename string) (error, string) {
+		ioutil.WriteFile(filename, []byte("pn"), 0644)
+		return fmt.Errorf("render failed"), ""
+	})
+	if err == nil {
+		t.Fatal("failed render without error")
+	}
+	if files, _ := ioutil.ReadDir(dirPath); len(files) != 0 {
+		t.Errorf("%d files left by the failed render", len(files))
+	}
+	if isPlotCached(filename) {
+		t.Error("failed render taken for a cached plot")
+	}
+}
+
+func TestRenderPlotBounded(t *testing.T) {
+	dirPath := useEmptyPlotsFolder(t)
+	workers := cap(renderSlots)
+
+	var started, running, maxRunning int32
+	release := make(chan struct{})
+	render := blockingRender(&started, &running, &maxRunning, release)
+	var wg sync.WaitGroup
+	for i := 0; i <= workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := renderPlot(fmt.Sprintf("%sgrafico-%d.png", dirPath, i), render); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	waitFor(t, func() bool { return atomic.LoadInt32(&started) == int32(workers) }, "the render workers")
+	waitFor(t, func() bool { return testutil.ToFloat64(plotRenderQueueGauge) >= 1 }, "the queued render")
+	close(release)
+	wg.Wait()
+
+	if started != int32(workers)+1 || maxRunning != int32(workers) {
+		t.Errorf("%d renders with %d at the same time, want %d with %d", started, maxRunning, workers+1, workers)
+	}
+}
+
+func TestRenderPlotCached(t *testing.T) {
+	filename := useEmptyPlotsFolder(t) + "salvato.png"
+	if err := ioutil.WriteFile(filename, []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, err := renderPlot(filename, func(string) (error, string) {
+		t.Error("cached plot rendered again")
+		return nil, ""
+	})
+	if err != nil || result != filename {
+		t.Errorf("result %q %v, want the cached plot", result, err)
+	}
+}
+
+func TestShowPlotRendering(t *testing.T) {
+	b := newTestBot(testChatId)
+	chatActions := func() int {
+		telegram.mutex.Lock()
+		defer telegram.mutex.Unlock()
+		n := 0
+		for _, v := range telegram.calls {
+			if v.Method == "sendChatAction" {
+				n++
+			}
+		}
+		return n
+	}
+
+	// Fast plots don't show the chat action
+	telegram.reset()
+	b.showPlotRendering(testChatId)()
+	time.Sleep(renderActionDelay + 100*time.Millisecond)
+	if n := chatActions(); n != 0 {
+		t.Errorf("%d chat actions for a fast plot", n)
+	}
+
+	stop := b.showPlotRendering(testChatId)
+	waitFor(t, func() bool { return chatActions() == 1 }, "the chat action")
+	stop()
+	stop()
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"fmt"
+	"github.com/DarkFighterLuke/covidgraphs"
+	"github.com/NicoNex/echotron"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats a report can be built in
+const (
+	reportFormatText = "testo"
+	reportFormatPDF  = "pdf"
+)
+
+// Arguments a report can require
+const (
+	reportArgumentNone     = ""
+	reportArgumentRegion   = "nome_regione"
+	reportArgumentProvince = "nome_provincia"
+)
+
+const reportDays = 14 // Days listed in the daily tables of the area reports
+
+var reportFormats = []string{reportFormatText, reportFormatPDF}
+
+// Builds a report from the snapshot data for the given argument, returning the message text or the file path depending on the format
+type reportBuilder func(d *dataSnapshot, argument string) (string, error)
+
+// Type of report available to users
+type reportType struct {
+	name        string
+	description string
+	argument    string                   // Kind of argument required, reportArgumentNone if the report has none
+	builders    map[string]reportBuilder // Builders indexed by format
+}
+
+// Available reports, in the order they are listed to users
+var reportsRegistry = []reportType{
+	{
+		name:        "generale",
+		description: "andamento nazionale, classifiche, riepilogo regionale e note",
+		builders: map[string]reportBuilder{
+			reportFormatText: func(d *dataSnapshot, _ string) (string, error) { return d.setCaptionReportGenerale() },
+			reportFormatPDF:  func(d *dataSnapshot, _ string) (string, error) { return d.buildReportGenerale() },
+		},
+	},
+	{
+		name:        "settimanale",
+		description: "dati nazionali giorno per giorno degli ultimi 7 giorni",
+		builders: map[string]reportBuilder{
+			reportFormatText: func(d *dataSnapshot, _ string) (string, error) { return d.setCaptionReportSettimanale() },
+			reportFormatPDF:  func(d *dataSnapshot, _ string) (string, error) { return d.buildReportSettimanale() },
+		},
+	},
+	{
+		name:        digestReportName,
+		description: "ultimi 7 giorni a confronto con i 7 precedenti per la nazione e ogni regione",
+		builders: map[string]reportBuilder{
+			reportFormatText: func(d *dataSnapshot, _ string) (string, error) { return d.setCaptionReportRiepilogo() },
+			reportFormatPDF:  func(d *dataSnapshot, _ string) (string, error) { return d.buildReportRiepilogo() },
+		},
+	},
+	{
+		name:        "regione",
+		description: "andamento, zona di rischio e province di una regione",
+		argument:    reportArgumentRegion,
+		builders: map[string]reportBuilder{
+			reportFormatText: (*dataSnapshot).setCaptionReportRegione,
+			reportFormatPDF:  (*dataSnapshot).buildReportRegione,
+		},
+	},
+	{
+		name:        "provincia",
+		description: "andamento dei contagi di una provincia",
+		argument:    reportArgumentProvince,
+		builders: map[string]reportBuilder{
+			reportFormatText: (*dataSnapshot).setCaptionReportProvincia,
+			reportFormatPDF:  (*dataSnapshot).buildReportProvincia,
+		},
+	},
+}
+
+// Returns the report with the given name
+func getReport(name string) (reportType, bool) {
+	for _, v := range reportsRegistry {
+		if v.name == strings.ToLower(name) {
+			return v, true
+		}
+	}
+	return reportType{}, false
+}
+
+// Returns the names of the available reports
+func getReportsNames() []string {
+	names := make([]string, 0, len(reportsRegistry))
+	for _, v := range reportsRegistry {
+		names = append(names, v.name)
+	}
+	return names
+}
+
+// Returns the formats the report can be built in
+func (r reportType) formats() []string {
+	formats := make([]string, 0)
+	for _, v := range reportFormats {
+		if _, ok := r.builders[v]; ok {
+			formats = append(formats, v)
+		}
+	}
+	return formats
+}
+
+// Returns the report usage, e.g. "regione nome_regione"
+func (r reportType) usage() string {
+	if r.argument == reportArgumentNone {
+		return r.name
+	}
+	return r.name + " " + r.argument
+}
+
+// Checks the report argument and returns the normalized region or province name
+func (r reportType) normalizeArgument(d *dataSnapshot, argument string) (string, error) {
+	argument = strings.Replace(strings.TrimSpace(argument), "_", " ", -1)
+	switch r.argument {
+	case reportArgumentRegion:
+		regionIndex, err := covidgraphs.FindLastOccurrenceRegion(&d.regions, "denominazione_regione", argument)
+		if err != nil {
+			return "", fmt.Errorf("regione non trovata")
+		}
+		return d.regions[regionIndex].Denominazione_regione, nil
+	case reportArgumentProvince:
+		provinceIndex, err := covidgraphs.FindLastOccurrenceProvince(&d.provinces, "denominazione_provincia", argument)
+		if err != nil {
+			return "", fmt.Errorf("provincia non trovata")
+		}
+		return d.provinces[provinceIndex].Denominazione_provincia, nil
+	default:
+		return "", nil
+	}
+}
+
+// Returns the list of reports shown in the help message
+func getReportsHelp() string {
+	msg := ""
+	for _, v := range reportsRegistry {
+		msg += "\n<code>" + v.usage() + "</code>: " + v.description + " (" + strings.Join(v.formats(), ", ") + ")"
+	}
+	return msg
+}
+
+// Builds a report and sends it to the chat, as a message or as a document depending on the format
+func (b *bot) sendReport(d *dataSnapshot, chatId int64, report reportType, argument, format string) {
+	builder, ok := report.builders[format]
+	if !ok {
+		b.SendMessage("Il report "+report.name+" non è disponibile in formato "+format+".", chatId)
+		return
+	}
+	argument, err := report.normalizeArgument(d, argument)
+	if err != nil {
+		b.SendMessage("Impossibile generare il report: "+err.Error()+".", chatId)
+		return
+	}
+
+	if format != reportFormatText {
+		b.SendChatAction(echotron.UPLOAD_DOCUMENT, chatId)
+	}
+	result, err := builder(d, argument)
+	if err != nil {
+		log.Println(err)
+		b.SendMessage("Impossibile generare il report al momento.\nRiprova più tardi.", chatId)
+		return
+	}
+
+	if format == reportFormatText {
+		b.SendMessage(result, chatId, echotron.PARSE_HTML)
+		return
+	}
+	b.SendDocument(result, "", chatId)
+	err = os.Remove(result)
+	if err != nil {
+		log.Println("can't delete file " + result)
+	}
+}
+
+// Returns the national rows of the last days as regional rows, oldest first
+func (d *dataSnapshot) getLastNationRows(days int) ([]covidgraphs.RegionData, error) {
+	rows, err := d.getExportRows(&exportRequest{area: exportAreaNation})
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) < days+1 {
+		return nil, fmt.Errorf("not enough data to build the report")
+	}
+	return rows[len(rows)-days-1:], nil
+}
+
+// Returns the text of the general report
+func (d *dataSnapshot) setCaptionReportGenerale() (string, error) {
+	return executeTemplate("report_generale", struct {
+		Nation       captionAndamento
+		TopRegions   []captionItem
+		TopProvinces []captionItem
+	}{d.getCaptionAndamentoNazionale(), d.getCaptionTopRegions(), d.getCaptionTopProvinces()})
+}
+
+// Returns the text of the weekly national report
+func (d *dataSnapshot) setCaptionReportSettimanale() (string, error) {
+	rows, err := d.getLastNationRows(7)
+	if err != nil {
+		return "", err
+	}
+
+	days := make([]captionDay, 0)
+	var total captionDay
+	for i := 1; i < len(rows); i++ {
+		data, err := time.Parse("2006-01-02T15:04:05", rows[i].Data)
+		if err != nil {
+			log.Println("error parsing data in setCaptionReportSettimanale()")
+		}
+		day := captionDay{
+			Date:          data.Format("2006-01-02"),
+			Cases:         rows[i].Nuovi_positivi,
+			Deaths:        rows[i].Deceduti - rows[i-1].Deceduti,
+			Tests:         rows[i].Tamponi - rows[i-1].Tamponi,
+			IntensiveCare: rows[i].Terapia_intensiva,
+		}
+		total.Cases += day.Cases
+		total.Deaths += day.Deaths
+		total.Tests += day.Tests
+		days = append(days, day)
+	}
+
+	return executeTemplate("report_settimanale", struct {
+		Days  []captionDay
+		Total captionDay
+	}{days, total})
+}
+
+// Returns the table rows with the daily data of the given rows, oldest first
+func getDailyTableRows(rows []covidgraphs.RegionData) [][]string {
+	table := make([][]string, 0)
+	for i := 1; i < len(rows); i++ {
+		data, err := time.Parse("2006-01-02T15:04:05", rows[i].Data)
+		if err != nil {
+			log.Println("error parsing data in getDailyTableRows()")
+		}
+		table = append(table, []string{
+			data.Format("2006-01-02"),
+			strconv.Itoa(rows[i].Nuovi_positivi),
+			strconv.Itoa(rows[i].Deceduti - rows[i-1].Deceduti),
+			strconv.Itoa(rows[i].Tamponi - rows[i-1].Tamponi),
+			strconv.Itoa(rows[i].Terapia_intensiva),
+			strconv.Itoa(rows[i].Ricoverati_con_sintomi),
+		})
+	}
+	return table
+}
+
+var dailyTableHeader = []string{"Data", "Nuovi positivi", "Morti", "Tamponi", "Terapia int.", "Ricoverati"}
+var dailyTableWidths = []float64{35, 30, 25, 30, 30, 30}
+
+// Builds the weekly national report and returns the PDF file path
+func (d *dataSnapshot) buildReportSettimanale() (string, error) {
+	rows, err := d.getLastNationRows(7)
+	if err != nil {
+		return "", err
+	}
+	data, err := time.Parse("2006-01-02T15:04:05", rows[len(rows)-1].Data)
+	if err != nil {
+		log.Println("error parsing data in buildReportSettimanale()")
+	}
+
+	r := newPdfReport("Report settimanale", "Dati nazionali dal "+data.AddDate(0, 0, -6).Format("2006-01-02")+" al "+data.Format("2006-01-02"))
+
+	r.section("Ultimi 7 giorni")
+	table := getDailyTableRows(rows)
+	var totalCases, totalDeaths, totalTests int
+	for i := 1; i < len(rows); i++ {
+		totalCases += rows[i].Nuovi_positivi
+		totalDeaths += rows[i].Deceduti - rows[i-1].Deceduti
+		totalTests += rows[i].Tamponi - rows[i-1].Tamponi
+	}
+	table = append(table, []string{"Totale", strconv.Itoa(totalCases), strconv.Itoa(totalDeaths), strconv.Itoa(totalTests), "", ""})
+	r.table(dailyTableHeader, dailyTableWidths, table)
+
+	filename, err := d.getPlotNuoviPositiviNazione()
+	if err != nil {
+		log.Println(err)
+	} else {
+		r.image(filename)
+	}
+
+	return r.save("report settimanale")
+}
+
+// Returns the text of the report of the given region
+func (d *dataSnapshot) setCaptionReportRegione(regionName string) (string, error) {
+	regionIndex, err := covidgraphs.FindLastOccurrenceRegion(&d.regions, "denominazione_regione", regionName)
+	if err != nil {
+		return "", err
+	}
+
+	provinces := make([]captionItem, 0)
+	for _, v := range *covidgraphs.GetLastProvincesByRegionName(&d.provinces, d.regions[regionIndex].Denominazione_regione) {
+		provinces = append(provinces, captionItem{Name: v.Denominazione_provincia, Value: v.Totale_casi, Delta: v.NuoviCasi})
+	}
+
+	return executeTemplate("report_regione", struct {
+		Region    captionAndamento
+		Provinces []captionItem
+	}{d.getCaptionRegion(regionIndex), provinces})
+}
+
+// Builds the report of the given region and returns the PDF file path
+func (d *dataSnapshot) buildReportRegione(regionName string) (string, error) {
+	regionIndex, err := covidgraphs.FindLastOccurrenceRegion(&d.regions, "denominazione_regione", regionName)
+	if err != nil {
+		return "", err
+	}
+	region := d.regions[regionIndex]
+	rows, err := d.getExportRows(&exportRequest{area: exportAreaRegion, name: region.Denominazione_regione})
+	if err != nil {
+		return "", err
+	}
+	if len(rows) < reportDays+1 {
+		return "", fmt.Errorf("not enough data to build the report")
+	}
+	data, err := time.Parse("2006-01-02T15:04:05", region.Data)
+	if err != nil {
+		log.Println("error parsing data in buildReportRegione()")
+	}
+
+	r := newPdfReport("Report "+region.Denominazione_regione, "Situazione COVID-19 al "+data.Format("2006-01-02"))
+
+	r.section("Andamento")
+	zone, err := d.getRegionZone(regionIndex)
+	if err == nil {
+		text := "Zona " + zone.colour
+		if zone.official {
+			text += " (da decreto)"
+		} else {
+			text += " (stimata dai dati)"
+		}
+		r.paragraph(text + ". Incidenza settimanale: " + strconv.FormatFloat(zone.incidence, 'f', 1, 64) + " casi ogni 100.000 abitanti, terapie intensive occupate al " +
+			formatPercentage(zone.icuOccupation) + ", area medica occupata al " + formatPercentage(zone.wardOccupation) + ".")
+	}
+	yesterday := rows[len(rows)-2]
+	r.table([]string{"Dato", "Valore", "Variazione giornaliera"}, []float64{80, 50, 50}, [][]string{
+		{"Attualmente positivi", strconv.Itoa(region.Totale_positivi), formatDelta(region.Totale_positivi - yesterday.Totale_positivi)},
+		{"Nuovi positivi", strconv.Itoa(region.Nuovi_positivi), formatDelta(region.Nuovi_positivi - yesterday.Nuovi_positivi)},
+		{"Ricoverati con sintomi", strconv.Itoa(region.Ricoverati_con_sintomi), formatDelta(region.Ricoverati_con_sintomi - yesterday.Ricoverati_con_sintomi)},
+		{"Terapia intensiva", strconv.Itoa(region.Terapia_intensiva), formatDelta(region.Terapia_intensiva - yesterday.Terapia_intensiva)},
+		{"Guariti", strconv.Itoa(region.Dimessi_guariti), formatDelta(region.Dimessi_guariti - yesterday.Dimessi_guariti)},
+		{"Morti", strconv.Itoa(region.Deceduti), formatDelta(region.Deceduti - yesterday.Deceduti)},
+		{"Totale casi", strconv.Itoa(region.Totale_casi), formatDelta(region.Totale_casi - yesterday.Totale_casi)},
+	})
+	filename, err := d.getPlotAndamentoRegionale(regionIndex)
+	if err != nil {
+		log.Println(err)
+	} else {
+		r.image(filename)
+	}
+
+	r.section("Ultimi " + strconv.Itoa(reportDays) + " giorni")
+	r.table(dailyTableHeader, dailyTableWidths, getDailyTableRows(rows[len(rows)-reportDays-1:]))
+
+	r.section("Province")
+	table := make([][]string, 0)
+	for _, v := range *covidgraphs.GetLastProvincesByRegionName(&d.provinces, region.Denominazione_regione) {
+		table = append(table, []string{v.Denominazione_provincia, strconv.Itoa(v.Totale_casi), formatDelta(v.NuoviCasi)})
+	}
+	r.table([]string{"Provincia", "Totale casi", "Nuovi casi"}, []float64{80, 50, 50}, table)
+
+	notes := make([]covidgraphs.NoteData, 0)
+	for _, v := range d.getNotesByDate(region.Data) {
+		if v.Regione == "" || normalizeRegionName(v.Regione) == normalizeRegionName(region.Denominazione_regione) {
+			notes = append(notes, v)
+		}
+	}
+	r.notesSection(notes, d.regionsAnomalies[regionIndex])
+
+	return r.save("report " + region.Denominazione_regione)
+}
+
+// Returns the text of the report of the given province
+func (d *dataSnapshot) setCaptionReportProvincia(provinceName string) (string, error) {
+	provinceIndex, err := covidgraphs.FindLastOccurrenceProvince(&d.provinces, "denominazione_provincia", provinceName)
+	if err != nil {
+		return "", err
+	}
+
+	return executeTemplate("report_provincia", d.getCaptionProvince(provinceIndex))
+}
+
+// Builds the report of the given province and returns the PDF file path
+func (d *dataSnapshot) buildReportProvincia(provinceName string) (string, error) {
+	provinceIndex, err := covidgraphs.FindLastOccurrenceProvince(&d.provinces, "denominazione_provincia", provinceName)
+	if err != nil {
+		return "", err
+	}
+	province := d.provinces[provinceIndex]
+	indexes := *covidgraphs.GetProvinceIndexesByName(&d.provinces, province.Denominazione_provincia)
+	if len(indexes) < reportDays {
+		return "", fmt.Errorf("not enough data to build the report")
+	}
+	data, err := time.Parse("2006-01-02T15:04:05", province.Data)
+	if err != nil {
+		log.Println("error parsing data in buildReportProvincia()")
+	}
+
+	r := newPdfReport("Report "+province.Denominazione_provincia, "Provincia di "+province.Denominazione_provincia+" ("+
+		province.Denominazione_regione+"), dati al "+data.Format("2006-01-02"))
+
+	r.section("Andamento")
+	filename, err := d.getPlotProvinciale(provinceIndex)
+	if err != nil {
+		log.Println(err)
+	} else {
+		r.image(filename)
+	}
+
+	r.subsection("Ultimi " + strconv.Itoa(reportDays) + " giorni")
+	table := make([][]string, 0)
+	for _, i := range indexes[len(indexes)-reportDays:] {
+		date, err := time.Parse("2006-01-02T15:04:05", d.provinces[i].Data)
+		if err != nil {
+			log.Println("error parsing data in buildReportProvincia()")
+		}
+		table = append(table, []string{date.Format("2006-01-02"), strconv.Itoa(d.provinces[i].Totale_casi), formatDelta(d.provinces[i].NuoviCasi)})
+	}
+	r.table([]string{"Data", "Totale casi", "Nuovi casi"}, []float64{60, 60, 60}, table)
+
+	notes := make([]covidgraphs.NoteData, 0)
+	for _, v := range d.getNotesByDate(province.Data) {
+		if strings.EqualFold(v.Provincia, province.Denominazione_provincia) {
+			notes = append(notes, v)
+		}
+	}
+	r.notesSection(notes, d.provincesAnomalies[provinceIndex])
+
+	return r.save("report " + province.Denominazione_provincia)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestGetReport(t *testing.T) {
+	report, ok := getReport("Regione")
+	if !ok || report.name != "regione" || report.usage() != "regione "+reportArgumentRegion {
+		t.Errorf("report %q usage %q, want the regional report", report.name, report.usage())
+	}
+	if report, ok = getReport("generale"); !ok || report.usage() != "generale" {
+		t.Errorf("usage %q of the general report", report.usage())
+	}
+	if _, ok = getReport("mensile"); ok {
+		t.Error("unknown report found")
+	}
+
+	if strings.Join(getReportsNames(), ",") != "generale,settimanale,"+digestReportName+",regione,provincia" {
+		t.Errorf("unexpected reports %v", getReportsNames())
+	}
+	help := getReportsHelp()
+	if !strings.Contains(help, "<code>provincia nome_provincia</code>: andamento dei contagi di una provincia (testo, pdf)") {
+		t.Errorf("unexpected help:\n%s", help)
+	}
+}
+
+func TestNormalizeReportArgument(t *testing.T) {
+	d := getData()
+	for name, arguments := range map[string]map[string]string{
+		"generale":  {"ignorato": ""},
+		"regione":   {"emilia_romagna": "Emilia-Romagna", " PUGLIA ": "Puglia"},
+		"provincia": {"l'aquila": "L'Aquila", "bari": "Bari"},
+	} {
+		report, _ := getReport(name)
+		for argument, want := range arguments {
+			if got, err := report.normalizeArgument(d, argument); err != nil || got != want {
+				t.Errorf("%s %q: %q %v, want %q", name, argument, got, err, want)
+			}
+		}
+		if report.argument != reportArgumentNone {
+			if _, err := report.normalizeArgument(d, "atlantide"); err == nil {
+				t.Errorf("%s: unknown argument accepted", name)
+			}
+		}
+	}
+}
+
+// Every report is built in all its formats from the test data
+func TestReportsBuilders(t *testing.T) {
+	d := getData()
+	arguments := map[string]string{reportArgumentRegion: "Puglia", reportArgumentProvince: "Bari"}
+	for _, report := range reportsRegistry {
+		if len(report.formats()) != len(reportFormats) {
+			t.Errorf("%s: formats %v", report.name, report.formats())
+		}
+		for format, builder := range report.builders {
+			result, err := builder(d, arguments[report.argument])
+			if err != nil {
+				t.Errorf("%s %s: %v", report.name, format, err)
+				continue
+			}
+			if format == reportFormatText {
+				if !strings.Contains(result, "2022-03-05") {
+					t.Errorf("%s: text without the last date:\n%s", report.name, result)
+				}
+				continue
+			}
+			if _, err = os.Stat(result); err != nil {
+				t.Errorf("%s: %v", report.name, err)
+			}
+			os.Remove(result)
+		}
+	}
+}
+
+func TestTextReportFile(t *testing.T) {
+	b := newTestBot(testChatId)
+	calls := b.send("/reports file provincia bari")
+	expectMethods(t, calls, "sendMessage", "deleteMessage", "sendDocument")
+	expectText(t, calls[0], "Bari")
+	expectDocument(t, calls[2], "report Bari-")
+
+	calls = b.send("/reports regione atlantide")
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "Impossibile generare il report: regione non trovata.")
+
+	calls = b.send("/reports regione")
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "Uso Corretto del Comando:")
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"github.com/DarkFighterLuke/covidgraphs"
+	"github.com/NicoNex/echotron"
+	"github.com/robfig/cron/v3"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	schedulesFile        = "/schedules.json"
+	scheduleKindNation   = "nazione"
+	scheduleKindRegion   = "regione"
+	scheduleKindProvince = "provincia"
+	scheduleKindReport   = "report"
+	scheduleKindZones    = "zone"
+	scheduleAllDays      = "*"
+)
+
+var scheduleKindsLabels = map[string]string{
+	scheduleKindNation:   "Andamento nazionale",
+	scheduleKindRegion:   "Andamento regionale",
+	scheduleKindProvince: "Andamento provinciale",
+	scheduleKindReport:   "Report",
+	scheduleKindZones:    "Zone di rischio",
+}
+
+// Cron day of week values indexed by Italian day name
+var scheduleWeekdays = map[string]string{
+	"domenica":  "0",
+	"lunedi":    "1",
+	"lunedì":    "1",
+	"martedi":   "2",
+	"martedì":   "2",
+	"mercoledi": "3",
+	"mercoledì": "3",
+	"giovedi":   "4",
+	"giovedì":   "4",
+	"venerdi":   "5",
+	"venerdì":   "5",
+	"sabato":    "6",
+}
+
+var scheduleWeekdaysLabels = []string{"domenica", "lunedì", "martedì", "mercoledì", "giovedì", "venerdì", "sabato"}
+
+// Post a chat receives at a set time, every day or on the chosen day of the week
+type scheduledPost struct {
+	ID     int    `json:"id"`
+	ChatId int64  `json:"chat_id"`
+	Time   string `json:"time"` // Local time in the HH:MM format
+	Days   string `json:"days"` // Cron day of week field, "*" for every day
+	Kind   string `json:"kind"`
+	Target string `json:"target"` // Region, province or report name, empty for the other kinds
+}
+
+var cronjob = cron.New()
+
+var scheduledPosts = make([]scheduledPost, 0)
+var schedulesEntries = make(map[int]cron.EntryID) // Cron entries indexed by scheduled post id
+var schedulesMutex = &sync.Mutex{}
+
+// Loads scheduled posts from the bot data folder and plans them
+func loadSchedules() {
+	schedulesMutex.Lock()
+	defer schedulesMutex.Unlock()
+
+	err := loadJSON(schedulesFile, &scheduledPosts)
+	if err != nil {
+		log.Println("errore nel caricamento delle programmazioni:", err)
+		return
+	}
+
+	for _, v := range scheduledPosts {
+		err = planSchedule(v)
+		if err != nil {
+			log.Println("errore nella programmazione", v.ID, err)
+		}
+	}
+	log.Println("Scheduled posts loaded: " + strconv.Itoa(len(scheduledPosts)))
+}
+
+// Adds the cron entry of a scheduled post, schedulesMutex must be held
+func planSchedule(post scheduledPost) error {
+	t, err := time.Parse("15:04", post.Time)
+	if err != nil {
+		return err
+	}
+	days := post.Days
+	if days == "" {
+		days = scheduleAllDays
+	}
+
+	spec := fmt.Sprintf("CRON_TZ=Europe/Rome %02d %02d * * %s", t.Minute(), t.Hour(), days)
+	id, err := cronjob.AddFunc(spec, func() { runSchedule(post) })
+	if err != nil {
+		return err
+	}
+	schedulesEntries[post.ID] = id
+
+	return nil
+}
+
+// Saves and plans a new scheduled post assigning it an id
+func addSchedule(post scheduledPost) (scheduledPost, error) {
+	schedulesMutex.Lock()
+	defer schedulesMutex.Unlock()
+
+	for _, v := range scheduledPosts {
+		if v.ID >= post.ID {
+			post.ID = v.ID + 1
+		}
+	}
+	err := planSchedule(post)
+	if err != nil {
+		return post, err
+	}
+	scheduledPosts = append(scheduledPosts, post)
+
+	return post, saveJSON(schedulesFile, scheduledPosts)
+}
+
+// Deletes a scheduled post of the given chat and removes its cron entry
+func deleteSchedule(chatId int64, id int) error {
+	schedulesMutex.Lock()
+	defer schedulesMutex.Unlock()
+
+	for i, v := range scheduledPosts {
+		if v.ID == id && v.ChatId == chatId {
+			if entry, ok := schedulesEntries[id]; ok {
+				cronjob.Remove(entry)
+				delete(schedulesEntries, id)
+			}
+			scheduledPosts = append(scheduledPosts[:i], scheduledPosts[i+1:]...)
+			return saveJSON(schedulesFile, scheduledPosts)
+		}
+	}
+
+	return fmt.Errorf("scheduled post not found")
+}
+
+// Returns the scheduled posts of the given chat
+func getChatSchedules(chatId int64) []scheduledPost {
+	schedulesMutex.Lock()
+	defer schedulesMutex.Unlock()
+
+	posts := make([]scheduledPost, 0)
+	for _, v := range scheduledPosts {
+		if v.ChatId == chatId {
+			posts = append(posts, v)
+		}
+	}
+
+	return posts
+}
+
+// Returns the post of the same chat sending the same update on the same days at the same time, if any
+func findSchedule(post scheduledPost) (scheduledPost, bool) {
+	schedulesMutex.Lock()
+	defer schedulesMutex.Unlock()
+
+	for _, v := range scheduledPosts {
+		if v.ChatId == post.ChatId && v.Time == post.Time && v.Days == post.Days && v.Kind == post.Kind && v.Target == post.Target {
+			return v, true
+		}
+	}
+
+	return scheduledPost{}, false
+}
+
+// Checks that the scheduled post fields are valid and normalizes its target name
+func validateSchedule(d *dataSnapshot, post *scheduledPost) error {
+	t, err := time.Parse("15:04", post.Time)
+	if err != nil {
+		return fmt.Errorf("orario non valido")
+	}
+	post.Time = t.Format("15:04")
+	if post.Days == "" {
+		post.Days = scheduleAllDays
+	}
+
+	switch post.Kind {
+	case scheduleKindNation, scheduleKindZones:
+		post.Target = ""
+	case scheduleKindRegion:
+		regionIndex, err := covidgraphs.FindLastOccurrenceRegion(&d.regions, "denominazione_regione", post.Target)
+		if err != nil {
+			return fmt.Errorf("regione non trovata")
+		}
+		post.Target = d.regions[regionIndex].Denominazione_regione
+	case scheduleKindProvince:
+		provinceIndex, err := covidgraphs.FindLastOccurrenceProvince(&d.provinces, "denominazione_provincia", post.Target)
+		if err != nil {
+			return fmt.Errorf("provincia non trovata")
+		}
+		post.Target = d.provinces[provinceIndex].Denominazione_provincia
+	case scheduleKindReport:
+		if post.Target == "" {
+			post.Target = "generale"
+		}
+		tokens := strings.SplitN(post.Target, " ", 2)
+		report, ok := getReport(tokens[0])
+		if !ok {
+			return fmt.Errorf("report non trovato")
+		}
+		post.Target = report.name
+		if report.argument != reportArgumentNone {
+			if len(tokens) < 2 {
+				return fmt.Errorf("manca il nome della zona del report")
+			}
+			argument, err := report.normalizeArgument(d, tokens[1])
+			if err != nil {
+				return err
+			}
+			post.Target += " " + argument
+		}
+	default:
+		return fmt.Errorf("tipo non valido")
+	}
+
+	return nil
+}
+
+// Returns a human readable description of the scheduled post
+func (p scheduledPost) describe() string {
+	msg := scheduleKindsLabels[p.Kind]
+	if p.Target != "" {
+		msg += " " + p.Target
+	}
+	if p.Days == "" || p.Days == scheduleAllDays {
+		msg += ", ogni giorno alle " + p.Time
+	} else if day, err := strconv.Atoi(p.Days); err == nil && day >= 0 && day < len(scheduleWeekdaysLabels) {
+		msg += ", ogni " + scheduleWeekdaysLabels[day] + " alle " + p.Time
+	} else {
+		msg += ", giorni " + p.Days + " alle " + p.Time
+	}
+
+	return msg
+}
+
+// Sends a scheduled post to its chat
+func runSchedule(post scheduledPost) {
+	d := getData()
+	b := &bot{
+		chatId: post.ChatId,
+		Api:    echotron.NewApi(TOKEN),
+	}
+
+	switch post.Kind {
+	case scheduleKindNation:
+		b.sendAndamentoNazionale(d, post.ChatId)
+	case scheduleKindRegion:
+		regionIndex, err := covidgraphs.FindLastOccurrenceRegion(&d.regions, "denominazione_regione", post.Target)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		b.sendAndamentoRegionale(d, post.ChatId, regionIndex)
+	case scheduleKindProvince:
+		provinceIndex, err := covidgraphs.FindLastOccurrenceProvince(&d.provinces, "denominazione_provincia", post.Target)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		_ = b.sendPlotProvinciale(d, post.ChatId, provinceIndex)
+	case scheduleKindReport:
+		tokens := strings.SplitN(post.Target, " ", 2)
+		report, ok := getReport(tokens[0])
+		if !ok {
+			log.Println("scheduled report not found:", post.Target)
+			return
+		}
+		var argument string
+		if len(tokens) == 2 {
+			argument = tokens[1]
+		}
+		format := reportFormatText
+		if _, ok := report.builders[format]; !ok {
+			format = reportFormatPDF
+		}
+		b.sendReport(d, post.ChatId, report, argument, format)
+	case scheduleKindZones:
+		b.SendMessage(d.setCaptionZones(), post.ChatId, echotron.PARSE_HTML)
+	default:
+		log.Println("wrong scheduled post kind:", post.Kind)
+		return
+	}
+	log.Println("Scheduled post " + strconv.Itoa(post.ID) + " sent to " + strconv.FormatInt(post.ChatId, 10))
+}
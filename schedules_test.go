@@ -0,0 +1,133 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestValidateSchedule(t *testing.T) {
+	d := getData()
+	for _, v := range []struct {
+		post   scheduledPost
+		target string
+	}{
+		{scheduledPost{Time: "8:05", Kind: scheduleKindNation, Target: "ignorato"}, ""},
+		{scheduledPost{Time: "18:00", Kind: scheduleKindRegion, Target: "emilia romagna"}, "Emilia-Romagna"},
+		{scheduledPost{Time: "18:00", Kind: scheduleKindProvince, Target: "bari"}, "Bari"},
+		{scheduledPost{Time: "18:00", Kind: scheduleKindReport}, "generale"},
+		{scheduledPost{Time: "18:00", Kind: scheduleKindReport, Target: "regione puglia"}, "regione Puglia"},
+	} {
+		post := v.post
+		if err := validateSchedule(d, &post); err != nil {
+			t.Errorf("%+v: %v", v.post, err)
+			continue
+		}
+		if post.Target != v.target || post.Days != scheduleAllDays {
+			t.Errorf("%+v: target %q days %q, want %q every day", v.post, post.Target, post.Days, v.target)
+		}
+	}
+
+	for _, post := range []scheduledPost{
+		{Time: "25:00", Kind: scheduleKindNation},
+		{Time: "18:00", Kind: "meteo"},
+		{Time: "18:00", Kind: scheduleKindRegion, Target: "atlantide"},
+		{Time: "18:00", Kind: scheduleKindProvince, Target: "atlantide"},
+		{Time: "18:00", Kind: scheduleKindReport, Target: "mensile"},
+		{Time: "18:00", Kind: scheduleKindReport, Target: "regione"},
+	} {
+		if err := validateSchedule(d, &post); err == nil {
+			t.Errorf("invalid post %+v accepted", post)
+		}
+	}
+}
+
+func TestScheduledPostDescribe(t *testing.T) {
+	for post, want := range map[scheduledPost]string{
+		{Time: "08:00", Days: scheduleAllDays, Kind: scheduleKindNation}:           "Andamento nazionale, ogni giorno alle 08:00",
+		{Time: "18:30", Days: "1", Kind: scheduleKindRegion, Target: "Puglia"}:     "Andamento regionale Puglia, ogni lunedì alle 18:30",
+		{Time: "18:30", Days: "1-5", Kind: scheduleKindReport, Target: "generale"}: "Report generale, giorni 1-5 alle 18:30",
+		{Time: "07:00", Kind: scheduleKindZones}:                                   "Zone di rischio, ogni giorno alle 07:00",
+	} {
+		if got := post.describe(); got != want {
+			t.Errorf("description %q, want %q", got, want)
+		}
+	}
+}
+
+func TestAddDeleteSchedule(t *testing.T) {
+	post, err := addSchedule(scheduledPost{ChatId: 5678, Time: "18:00", Days: "0", Kind: scheduleKindZones})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := addSchedule(scheduledPost{ChatId: 5678, Time: "19:00", Days: scheduleAllDays, Kind: scheduleKindNation})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.ID <= post.ID {
+		t.Errorf("id %d after %d", other.ID, post.ID)
+	}
+	if posts := getChatSchedules(5678); len(posts) != 2 {
+		t.Fatalf("posts %+v, want the two added", posts)
+	}
+	schedulesMutex.Lock()
+	_, planned := schedulesEntries[post.ID]
+	schedulesMutex.Unlock()
+	if !planned {
+		t.Error("post not planned")
+	}
+
+	if err = deleteSchedule(1234, post.ID); err == nil {
+		t.Error("post deleted from another chat")
+	}
+	for _, v := range []scheduledPost{post, other} {
+		if err = deleteSchedule(5678, v.ID); err != nil {
+			t.Error(err)
+		}
+	}
+	schedulesMutex.Lock()
+	_, planned = schedulesEntries[post.ID]
+	schedulesMutex.Unlock()
+	if planned || len(getChatSchedules(5678)) != 0 {
+		t.Error("deleted post still planned")
+	}
+}
+
+func TestRunSchedule(t *testing.T) {
+	telegram.reset()
+	runSchedule(scheduledPost{ChatId: testChatId, Kind: scheduleKindRegion, Target: "Puglia"})
+	calls := telegram.requests()
+	expectMethods(t, calls, "sendPhoto")
+	expectPlot(t, calls[0], "Dati regione Puglia")
+
+	telegram.reset()
+	runSchedule(scheduledPost{ChatId: testChatId, Kind: scheduleKindZones})
+	calls = telegram.requests()
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "<b>Zone di rischio 2022-03-05</b>")
+}
+
+func TestGroupProgramma(t *testing.T) {
+	g := newTestBot(testGroupId)
+	calls := g.send("/programma 18:00 nazione")
+	expectMethods(t, calls, "getChatMember", "sendMessage")
+	expectText(t, calls[1], "Solo gli amministratori")
+
+	telegram.setChatMemberStatus(t, "administrator")
+	calls = g.send("/programma lunedi 18:00 regione puglia")
+	expectMethods(t, calls, "getChatMember", "sendMessage")
+	expectText(t, calls[1], "Aggiornamento programmato", "Andamento regionale Puglia, ogni lunedì alle 18:00")
+	posts := getChatSchedules(testGroupId)
+	if len(posts) != 1 {
+		t.Fatalf("posts %+v, want the one added", posts)
+	}
+
+	calls = g.send("/programma lista")
+	expectMethods(t, calls, "sendMessage")
+	expectKeyboard(t, calls[0], "programma elimina "+strconv.Itoa(posts[0].ID), "programma chiudi")
+
+	calls = g.press("programma elimina " + strconv.Itoa(posts[0].ID))
+	expectMethods(t, calls, "getChatMember", "editMessageText", "answerCallbackQuery")
+	if len(getChatSchedules(testGroupId)) != 0 {
+		t.Error("post not deleted")
+	}
+}
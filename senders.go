@@ -8,74 +8,137 @@ import (
 	"strings"
 )
 
-// Sends national trend plot and text with related buttons
-func (b *bot) sendAndamentoNazionale(message *echotron.Message) {
-	dirPath := workingDirectory + imageFolder
+// Returns the national trend plot filename, creating the plot if needed
+func (d *dataSnapshot) getPlotAndamentoNazionale() (string, error) {
 	title := "Andamento nazionale"
 	var filename string
 	var err error
 
-	filename = dirPath + covidgraphs.FilenameCreator(title)
-	if !covidgraphs.IsGraphExisting(filename) {
+	filename = d.getPlotFilename(title)
+	filename, err = renderPlot(filename, func(filename string) (error, string) {
 		fields := make([]string, 3)
 		fields[0] = "attualmente_positivi"
 		fields[1] = "dimessi_guariti"
 		fields[2] = "deceduti"
-		err, filename = covidgraphs.VociNazione(&nationData, fields, 0, title, filename)
+		return covidgraphs.VociNazione(&d.nation, fields, 0, title, filename)
+	})
 
-		if err != nil {
-			log.Println(err)
-			b.SendMessage("Impossibile reperire il grafico al momento.\nRiprova più tardi.", message.Chat.ID)
-			return
-		}
-	}
+	return filename, err
+}
 
-	b.SendPhoto(filename, setCaptionAndamentoNazionale(), message.Chat.ID, echotron.PARSE_HTML)
+// Returns the national new cases plot filename, creating the plot if needed
+func (d *dataSnapshot) getPlotNuoviPositiviNazione() (string, error) {
+	title := "Nuovi Positivi"
+	var filename string
+	var err error
+
+	filename = d.getPlotFilename(title)
+	filename, err = renderPlot(filename, func(filename string) (error, string) {
+		return covidgraphs.NuoviPositiviNazione(&d.nation, true, title, filename)
+	})
+
+	return filename, err
 }
 
-// Sends a region trend plot and text with related buttons
-func (b *bot) sendAndamentoRegionale(message *echotron.Message, regionIndex int) {
-	firstRegionIndex, err := covidgraphs.FindFirstOccurrenceRegion(&regionsData, "codice_regione", regionsData[regionIndex].Codice_regione)
+// Returns the trend plot filename of the region at the given index, creating the plot if needed
+func (d *dataSnapshot) getPlotAndamentoRegionale(regionIndex int) (string, error) {
+	firstRegionIndex, err := covidgraphs.FindFirstOccurrenceRegion(&d.regions, "codice_regione", d.regions[regionIndex].Codice_regione)
 	if err != nil {
-		b.SendMessage("Impossibile reperire il grafico al momento.\nRiprova più tardi.", message.Chat.ID)
-		return
+		return "", err
 	}
 
-	dirPath := workingDirectory + imageFolder
-	title := "Dati regione " + regionsData[firstRegionIndex].Denominazione_regione
+	title := "Dati regione " + d.regions[firstRegionIndex].Denominazione_regione
 	var filename string
 
-	filename = dirPath + covidgraphs.FilenameCreator(title)
-	if !covidgraphs.IsGraphExisting(filename) {
-		err, filename = covidgraphs.VociRegione(&regionsData, []string{"totale_casi", "dimessi_guariti", "deceduti"}, 0, firstRegionIndex, title, filename)
+	filename = d.getPlotFilename(title)
+	filename, err = renderPlot(filename, func(filename string) (error, string) {
+		return covidgraphs.VociRegione(&d.regions, []string{"totale_casi", "dimessi_guariti", "deceduti"}, 0, firstRegionIndex, title, filename)
+	})
 
-		if err != nil {
-			b.SendMessage("Impossibile reperire il grafico al momento.\nRiprova più tardi.", message.Chat.ID)
-			return
-		}
-	}
+	return filename, err
+}
 
-	b.SendPhoto(filename, setCaptionRegion(regionIndex), message.Chat.ID, echotron.PARSE_HTML)
+// Returns the trend plot filename of the province at the given index, creating the plot if needed
+func (d *dataSnapshot) getPlotProvinciale(provinceIndex int) (string, error) {
+	countProvincePlotRequest(d.provinces[provinceIndex].Denominazione_provincia)
+	return d.renderPlotProvinciale(provinceIndex)
 }
 
-// Sends a province trend plot and text with related buttons
-func (b *bot) sendAndamentoProvinciale(cq *echotron.CallbackQuery, provinceIndex int) {
-	dirPath := workingDirectory + imageFolder
-	title := "Totale Contagi " + provincesData[provinceIndex].Denominazione_provincia
+// Returns the trend plot filename of the province at the given index without counting the request
+func (d *dataSnapshot) renderPlotProvinciale(provinceIndex int) (string, error) {
+	title := "Totale Contagi " + d.provinces[provinceIndex].Denominazione_provincia
 	var filename string
 	var err error
 
-	filename = dirPath + covidgraphs.FilenameCreator(title)
-	if !covidgraphs.IsGraphExisting(filename) {
-		provinceIndexes := covidgraphs.GetProvinceIndexesByName(&provincesData, provincesData[provinceIndex].Denominazione_provincia)
-		err, filename = covidgraphs.TotalePositiviProvincia(&provincesData, provinceIndexes, title, filename)
+	filename = d.getPlotFilename(title)
+	filename, err = renderPlot(filename, func(filename string) (error, string) {
+		provinceIndexes := covidgraphs.GetProvinceIndexesByName(&d.provinces, d.provinces[provinceIndex].Denominazione_provincia)
+		return covidgraphs.TotalePositiviProvincia(&d.provinces, provinceIndexes, title, filename)
+	})
 
-		if err != nil {
-			b.SendMessage("Impossibile reperire il grafico al momento.\nRiprova più tardi.", cq.Message.Chat.ID)
-			return
-		}
+	return filename, err
+}
+
+// Sends national trend plot and text with related buttons
+func (b *bot) sendAndamentoNazionale(d *dataSnapshot, chatId int64) {
+	stopRendering := b.showPlotRendering(chatId)
+	defer stopRendering()
+
+	filename, err := d.getPlotAndamentoNazionale()
+	if err != nil {
+		log.Println(err)
+		b.SendMessage("Impossibile reperire il grafico al momento.\nRiprova più tardi.", chatId)
+		return
+	}
+
+	buttons, err := b.exportButtons(exportAreaNation, "")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	b.sendPlotWithKeyboard(filename, d.setCaptionAndamentoNazionale(), chatId, buttons, echotron.PARSE_HTML)
+}
+
+// Sends a region trend plot and text with related buttons
+func (b *bot) sendAndamentoRegionale(d *dataSnapshot, chatId int64, regionIndex int) {
+	stopRendering := b.showPlotRendering(chatId)
+	defer stopRendering()
+
+	filename, err := d.getPlotAndamentoRegionale(regionIndex)
+	if err != nil {
+		b.SendMessage("Impossibile reperire il grafico al momento.\nRiprova più tardi.", chatId)
+		return
+	}
+
+	buttons, err := b.exportButtons(exportAreaRegion, d.regions[regionIndex].Denominazione_regione)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	b.sendPlotWithKeyboard(filename, d.setCaptionRegion(regionIndex), chatId, buttons, echotron.PARSE_HTML)
+}
+
+// Sends a province trend plot and text
+func (b *bot) sendPlotProvinciale(d *dataSnapshot, chatId int64, provinceIndex int) error {
+	stopRendering := b.showPlotRendering(chatId)
+	defer stopRendering()
+
+	filename, err := d.getPlotProvinciale(provinceIndex)
+	if err != nil {
+		b.SendMessage("Impossibile reperire il grafico al momento.\nRiprova più tardi.", chatId)
+		return err
+	}
+
+	buttons, err := b.exportButtons(exportAreaProvince, d.provinces[provinceIndex].Denominazione_provincia)
+	if err != nil {
+		return err
 	}
+	b.sendPlotWithKeyboard(filename, d.setCaptionProvince(provinceIndex), chatId, buttons, echotron.PARSE_HTML)
+	return nil
+}
 
+// Sends a province trend plot and text with related buttons
+func (b *bot) sendAndamentoProvinciale(d *dataSnapshot, cq *echotron.CallbackQuery, provinceIndex int) {
 	buttonsNames := []string{"Torna alla regione", "Torna alla home"}
 	callbackNames := []string{b.lastRegion, "home"}
 	buttons, err := b.makeButtons(buttonsNames, callbackNames, 1)
@@ -84,24 +147,30 @@ func (b *bot) sendAndamentoProvinciale(cq *echotron.CallbackQuery, provinceIndex
 		return
 	}
 
-	b.SendPhoto(filename, setCaptionProvince(provinceIndex), cq.Message.Chat.ID, echotron.PARSE_HTML)
+	err = b.sendPlotProvinciale(d, cq.Message.Chat.ID, provinceIndex)
+	if err != nil {
+		return
+	}
 	if cq.Message.Chat.Type == "private" {
 		b.SendMessageWithKeyboard("Opzioni disponibili:", cq.Message.Chat.ID, buttons)
 	}
-	b.AnswerCallbackQuery(cq.ID, "Regione "+provincesData[provinceIndex].Denominazione_regione, false)
+	b.AnswerCallbackQuery(cq.ID, "Regione "+d.provinces[provinceIndex].Denominazione_regione, false)
 	b.lastButton = cq.Data
 	b.lastProvince = cq.Data
 }
 
 // Sends a plot with a caption containing a comparison with the selected regional fields
-func (b *bot) sendConfrontoDatiRegione(cq *echotron.CallbackQuery) {
+func (b *bot) sendConfrontoDatiRegione(d *dataSnapshot, cq *echotron.CallbackQuery) {
+	stopRendering := b.showPlotRendering(cq.Message.Chat.ID)
+	defer stopRendering()
+
 	snakeCaseChoices := make([]string, 0)
 	for _, v := range b.choicesConfrontoRegione {
 		snakeCaseChoices = append(snakeCaseChoices, strings.Replace(v, " ", "_", -1))
 	}
 	b.choicesConfrontoRegione = snakeCaseChoices
 
-	regionId, err := covidgraphs.FindFirstOccurrenceRegion(&regionsData, "denominazione_regione", b.lastRegion)
+	regionId, err := covidgraphs.FindFirstOccurrenceRegion(&d.regions, "denominazione_regione", b.lastRegion)
 	if err != nil {
 		log.Println(err)
 		return
@@ -118,18 +187,16 @@ func (b *bot) sendConfrontoDatiRegione(cq *echotron.CallbackQuery) {
 		}
 	}
 
-	dirPath := workingDirectory + imageFolder
-	titleForFilename := "Regione" + regionsData[regionId].Denominazione_regione + fmt.Sprintf("%s_%s_%s", titleAttributes[0], titleAttributes[1], titleAttributes[2])
+	titleForFilename := "Regione" + d.regions[regionId].Denominazione_regione + fmt.Sprintf("%s_%s_%s", titleAttributes[0], titleAttributes[1], titleAttributes[2])
 	var filename string
-	title := "Confronto dati regione " + regionsData[regionId].Denominazione_regione
-
-	filename = dirPath + covidgraphs.FilenameCreator(titleForFilename)
-	if !covidgraphs.IsGraphExisting(filename) {
-		err, filename = covidgraphs.VociRegione(&regionsData, b.choicesConfrontoRegione, 0, regionId, title, filename)
+	title := "Confronto dati regione " + d.regions[regionId].Denominazione_regione
 
-		if err != nil {
-			log.Println(err)
-		}
+	filename = d.getPlotFilename(titleForFilename)
+	filename, err = renderPlot(filename, func(filename string) (error, string) {
+		return covidgraphs.VociRegione(&d.regions, b.choicesConfrontoRegione, 0, regionId, title, filename)
+	})
+	if err != nil {
+		log.Println(err)
 	}
 
 	buttonsNames := []string{"Torna alla regione", "Torna alla home"}
@@ -139,13 +206,18 @@ func (b *bot) sendConfrontoDatiRegione(cq *echotron.CallbackQuery) {
 		log.Println(err)
 		return
 	}
-	regionLastId, err := covidgraphs.FindLastOccurrenceRegion(&regionsData, "denominazione_regione", regionsData[regionId].Denominazione_regione)
+	regionLastId, err := covidgraphs.FindLastOccurrenceRegion(&d.regions, "denominazione_regione", d.regions[regionId].Denominazione_regione)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 
-	b.SendPhoto(filename, setCaptionConfrontoRegione(regionLastId, b.choicesConfrontoRegione), cq.Message.Chat.ID, echotron.PARSE_HTML)
+	exportButtons, err := b.exportButtons(exportAreaRegion, d.regions[regionLastId].Denominazione_regione)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	b.sendPlotWithKeyboard(filename, d.setCaptionConfrontoRegione(regionLastId, b.choicesConfrontoRegione), cq.Message.Chat.ID, exportButtons, echotron.PARSE_HTML)
 	if cq.Message.Chat.Type == "private" {
 		b.SendMessageWithKeyboard("Opzioni disponibili:", cq.Message.Chat.ID, buttons)
 	}
@@ -153,7 +225,10 @@ func (b *bot) sendConfrontoDatiRegione(cq *echotron.CallbackQuery) {
 }
 
 // Sends a plot with a caption containing a comparison with the selected national fields
-func (b *bot) sendConfrontoDatiNazione(cq *echotron.CallbackQuery) {
+func (b *bot) sendConfrontoDatiNazione(d *dataSnapshot, cq *echotron.CallbackQuery) {
+	stopRendering := b.showPlotRendering(cq.Message.Chat.ID)
+	defer stopRendering()
+
 	snakeCaseChoices := make([]string, 0)
 	for _, v := range b.choicesConfrontoNazione {
 		snakeCaseChoices = append(snakeCaseChoices, strings.Replace(v, " ", "_", -1))
@@ -171,19 +246,17 @@ func (b *bot) sendConfrontoDatiNazione(cq *echotron.CallbackQuery) {
 		}
 	}
 
-	dirPath := workingDirectory + imageFolder
 	titleForFilename := "Nazione" + fmt.Sprintf("%s_%s_%s", titleAttributes[0], titleAttributes[1], titleAttributes[2])
 	var filename string
 	var err error
 	title := "Confronto dati nazione"
 
-	filename = dirPath + covidgraphs.FilenameCreator(titleForFilename)
-	if !covidgraphs.IsGraphExisting(filename) {
-		err, filename = covidgraphs.VociNazione(&nationData, b.choicesConfrontoNazione, 0, title, filename)
-
-		if err != nil {
-			log.Println(err)
-		}
+	filename = d.getPlotFilename(titleForFilename)
+	filename, err = renderPlot(filename, func(filename string) (error, string) {
+		return covidgraphs.VociNazione(&d.nation, b.choicesConfrontoNazione, 0, title, filename)
+	})
+	if err != nil {
+		log.Println(err)
 	}
 
 	buttonsNames := []string{"Torna alla home"}
@@ -194,7 +267,12 @@ func (b *bot) sendConfrontoDatiNazione(cq *echotron.CallbackQuery) {
 		return
 	}
 
-	b.SendPhoto(filename, setCaptionConfrontoNazione(len(nationData)-1, b.choicesConfrontoNazione), cq.Message.Chat.ID, echotron.PARSE_HTML)
+	exportButtons, err := b.exportButtons(exportAreaNation, "")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	b.sendPlotWithKeyboard(filename, d.setCaptionConfrontoNazione(len(d.nation)-1, b.choicesConfrontoNazione), cq.Message.Chat.ID, exportButtons, echotron.PARSE_HTML)
 	if cq.Message.Chat.Type == "private" {
 		b.SendMessageWithKeyboard("Opzioni disponibili:", cq.Message.Chat.ID, buttons)
 	}
@@ -226,7 +304,7 @@ Questi comandi possono sempre tornarti utili! Prova ad <b>aggiungere il bot in u
 Cominciamo!`
 
 		b.SendMessageWithKeyboard(fmt.Sprintf(messageText, update.Message.User.FirstName, strings.Join(natregAttributes, ","),
-			strings.Join(natregAttributes, ","), strings.Join(reports, ","), helpMsg), update.Message.Chat.ID, buttons, echotron.PARSE_HTML)
+			strings.Join(natregAttributes, ","), strings.Join(getReportsNames(), ","), helpMsg), update.Message.Chat.ID, buttons, echotron.PARSE_HTML)
 	} else {
 		msg := `
 Questo comando non è disponibile nei gruppi.
@@ -237,9 +315,9 @@ Digita /help per scoprire i comandi disponibili.
 }
 
 // Handles "home" command
-func (b *bot) sendHome(update *echotron.Update) {
+func (b *bot) sendHome(d *dataSnapshot, update *echotron.Update) {
 	if update.Message.Chat.Type == "private" {
-		b.sendAndamentoNazionale(update.Message)
+		b.sendAndamentoNazionale(d, update.Message.Chat.ID)
 		buttons, err := b.mainMenuButtons()
 		if err != nil {
 			log.Println(err)
@@ -257,3 +335,35 @@ func (b *bot) sendCredits(chatId int64) {
 	b.SendMessageWithKeyboard("🤖 Bot creato da @GiovanniRanaTortello\n😺 GitHub: https://github.com/DarkFighterLuke\n"+
 		"\n🌐 Proudly hosted on Raspberry Pi 3", chatId, buttons, echotron.PARSE_HTML)
 }
+
+// Checks if the user is the one creating the alert with the wizard
+func (b *bot) isAlertDraftUser(user *echotron.User) bool {
+	return user != nil && b.alertDraftUser != 0 && user.ID == b.alertDraftUser
+}
+
+// Saves the alert being created with the wizard and sends a confirmation
+func (b *bot) saveAlertDraft(d *dataSnapshot, chatId int64) {
+	rule := b.alertDraft
+	b.alertDraft = alertRule{}
+	b.alertDraftUser = 0
+	b.awaitingAlertValue = false
+	b.saveAlert(d, chatId, rule)
+}
+
+// Saves a new alert of the chat and sends a confirmation
+func (b *bot) saveAlert(d *dataSnapshot, chatId int64, rule alertRule) {
+	rule.ChatId = chatId
+	err := validateAlert(d, &rule)
+	if err != nil {
+		b.SendMessage("Impossibile creare l'avviso: "+err.Error()+".\nDigita /avviso per riprovare.", chatId)
+		return
+	}
+	rule, err = addAlert(rule)
+	if err != nil {
+		log.Println(err)
+		b.SendMessage("Impossibile salvare l'avviso al momento.\nRiprova più tardi.", chatId)
+		return
+	}
+
+	b.SendMessage("✅ <b>Avviso creato</b>\n"+rule.describe()+"\n\nRiceverai un messaggio dopo l'aggiornamento dei dati in cui la condizione si verifica.", chatId, echotron.PARSE_HTML)
+}
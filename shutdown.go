@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var shutdownTimeout = getEnvDuration("CovidBotShutdownTimeout", 30*time.Second) // Time given to running jobs and handlers to finish
+
+var shutdownStarted = make(chan struct{}) // Closed when the bot starts shutting down
+
+// Waits for SIGINT or SIGTERM and stops the bot, letting running work finish and saving its state
+func waitForShutdown(server *http.Server, dispatcher *webhookDispatcher) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	log.Println("Received " + sig.String() + ", shutting down...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// No new updates are accepted, Telegram will send them again after the restart
+	err := server.Shutdown(ctx)
+	if err != nil {
+		log.Println("error stopping the webhook server:", err)
+	}
+
+	// The update checker runs as a cron job, so it must be stopped before waiting for the jobs.
+	// Closing the channel reaches it even if it's not running yet and ends the retries of a failed data update
+	close(shutdownStarted)
+
+	log.Println("Stopping cron scheduler...")
+	select {
+	case <-cronjob.Stop().Done():
+	case <-ctx.Done():
+		log.Println("timeout waiting for running cron jobs")
+	}
+
+	log.Println("Waiting for running update handlers...")
+	if !dispatcher.wait(ctx.Done()) {
+		log.Println("timeout waiting for running update handlers")
+	}
+
+	// Waits for a data update in progress, which may still be sending alerts and editing live messages.
+	// The lock is never released, so no other update can start
+	updated := make(chan struct{})
+	go func() {
+		updateMutex.Lock()
+		close(updated)
+	}()
+	select {
+	case <-updated:
+	case <-ctx.Done():
+		log.Println("timeout waiting for the data update in progress")
+	}
+	persistState()
+	closeInteractionsLog()
+	log.Println("Bye")
+}
+
+// Saves alerts, scheduled posts, live messages and usage counters in the bot data folder
+func persistState() {
+	alertsMutex.Lock()
+	err := saveJSON(alertsFile, alertRules)
+	alertsMutex.Unlock()
+	if err != nil {
+		log.Println("errore nel salvataggio degli avvisi:", err)
+	}
+
+	schedulesMutex.Lock()
+	err = saveJSON(schedulesFile, scheduledPosts)
+	schedulesMutex.Unlock()
+	if err != nil {
+		log.Println("errore nel salvataggio delle programmazioni:", err)
+	}
+
+	liveMutex.Lock()
+	err = saveLiveMessages()
+	liveMutex.Unlock()
+	if err != nil {
+		log.Println("errore nel salvataggio dei messaggi live:", err)
+	}
+
+	usageMutex.Lock()
+	err = saveUsageStats()
+	usageMutex.Unlock()
+	if err != nil {
+		log.Println("errore nel salvataggio delle statistiche:", err)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Runs the update checker and returns a channel closed when it stops
+func runUpdateChecker(stop chan bool, done chan struct{}) chan struct{} {
+	stopped := make(chan struct{})
+	go func() {
+		checkUpdate(time.Hour, stop, done)
+		close(stopped)
+	}()
+	return stopped
+}
+
+func TestCheckUpdateStops(t *testing.T) {
+	// Nothing is listening outside the update window
+	stop := make(chan bool)
+	stopUpdateChecker(stop)
+
+	done := make(chan struct{})
+	stopped := runUpdateChecker(stop, done)
+	timeout := time.After(time.Second)
+	for running := true; running; {
+		stopUpdateChecker(stop)
+		select {
+		case <-stopped:
+			running = false
+		case <-timeout:
+			t.Fatal("the daily stop didn't reach the update checker")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	// The shutdown reaches the checker started after it, too
+	close(done)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-runUpdateChecker(stop, done):
+		case <-time.After(time.Second):
+			t.Fatal("the shutdown didn't stop the update checker")
+		}
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"fmt"
+	"github.com/DarkFighterLuke/covidgraphs"
+	"github.com/NicoNex/echotron"
+	"github.com/wcharczuk/go-chart"
+	"github.com/wcharczuk/go-chart/drawing"
+	"html"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	usageStatsFile      = "/stats.json"
+	usageStatsDays      = 30 // Days of counters kept
+	usageCallbackWords  = 3  // Words of the callback data used as counter name
+	statsReportDays     = 7  // Days summed up by the stats command
+	statsTopItems       = 5
+	statsChartDays      = 14
+	statsChartWidth     = 800
+	statsChartHeight    = 300
+	statsRegionAction   = "regione"
+	statsProvinceAction = "provincia"
+)
+
+// Anonymous usage counters of one day
+type usageDay struct {
+	Data       string         `json:"data"`
+	Comandi    map[string]int `json:"comandi"`
+	Pulsanti   map[string]int `json:"pulsanti"`
+	Regioni    map[string]int `json:"regioni"`
+	Province   map[string]int `json:"province"`
+	Privati    int            `json:"privati"`     // Updates coming from private chats
+	Gruppi     int            `json:"gruppi"`      // Updates coming from groups and supergroups
+	ChatAttive int            `json:"chat_attive"` // Chats that sent at least one command or callback
+}
+
+// Content of the stats file. The anonymised ids of the chats active today are kept
+// only to count each of them once, and are dropped when the day changes
+type usageStats struct {
+	Giorni   []*usageDay `json:"giorni"`
+	ChatOggi []string    `json:"chat_oggi"`
+}
+
+var usageDays = make([]*usageDay, 0) // Oldest first, the last one is today
+var usageTodayChats = make(map[string]bool)
+var usageMutex = &sync.Mutex{}
+
+// Returns empty counters for the given day
+func newUsageDay(date string) *usageDay {
+	return &usageDay{
+		Data:     date,
+		Comandi:  make(map[string]int),
+		Pulsanti: make(map[string]int),
+		Regioni:  make(map[string]int),
+		Province: make(map[string]int),
+	}
+}
+
+// Returns a copy of the counters, with all the maps allocated even if they were missing in the file
+func (d *usageDay) copy() *usageDay {
+	day := newUsageDay(d.Data)
+	mergeCounters(day.Comandi, d.Comandi)
+	mergeCounters(day.Pulsanti, d.Pulsanti)
+	mergeCounters(day.Regioni, d.Regioni)
+	mergeCounters(day.Province, d.Province)
+	day.Privati, day.Gruppi, day.ChatAttive = d.Privati, d.Gruppi, d.ChatAttive
+	return day
+}
+
+// Loads usage counters from the bot data folder
+func loadUsageStats() {
+	usageMutex.Lock()
+	defer usageMutex.Unlock()
+
+	var stats usageStats
+	err := loadJSON(usageStatsFile, &stats)
+	if err != nil {
+		log.Println("errore nel caricamento delle statistiche:", err)
+		return
+	}
+	for _, v := range stats.Giorni {
+		usageDays = append(usageDays, v.copy())
+	}
+	if len(usageDays) > 0 && usageDays[len(usageDays)-1].Data == time.Now().In(romeLocation()).Format("2006-01-02") {
+		for _, v := range stats.ChatOggi {
+			usageTodayChats[v] = true
+		}
+	}
+}
+
+// Saves usage counters in the bot data folder. Must be called with usageMutex held
+func saveUsageStats() error {
+	stats := usageStats{Giorni: usageDays, ChatOggi: make([]string, 0, len(usageTodayChats))}
+	for k := range usageTodayChats {
+		stats.ChatOggi = append(stats.ChatOggi, k)
+	}
+	return saveJSON(usageStatsFile, stats)
+}
+
+// Adds the counters of src to dst
+func mergeCounters(dst, src map[string]int) {
+	for k, v := range src {
+		dst[k] += v
+	}
+}
+
+// Returns the counters of today, starting a new day and dropping the oldest ones when the date changes.
+// Must be called with usageMutex held
+func getUsageToday() *usageDay {
+	today := time.Now().In(romeLocation()).Format("2006-01-02")
+	if len(usageDays) > 0 && usageDays[len(usageDays)-1].Data == today {
+		return usageDays[len(usageDays)-1]
+	}
+
+	usageDays = append(usageDays, newUsageDay(today))
+	if len(usageDays) > usageStatsDays {
+		usageDays = usageDays[len(usageDays)-usageStatsDays:]
+	}
+	usageTodayChats = make(map[string]bool)
+	err := saveUsageStats()
+	if err != nil {
+		log.Println("errore nel salvataggio delle statistiche:", err)
+	}
+	return usageDays[len(usageDays)-1]
+}
+
+// Returns the name used to count a callback: its first words, stopping at the first one with a digit
+// so that ids and values don't create a counter each
+func getCallbackCounterName(data string) string {
+	words := strings.Fields(strings.ToLower(data))
+	name := make([]string, 0, usageCallbackWords)
+	for _, v := range words {
+		if len(name) == usageCallbackWords || strings.IndexFunc(v, unicode.IsDigit) >= 0 {
+			break
+		}
+		name = append(name, v)
+	}
+	return strings.Join(name, " ")
+}
+
+// Counts a command or callback update. Chats that opted out of the interactions log aren't counted.
+func recordUsage(d *dataSnapshot, update *echotron.Update) {
+	chat, kind, action, ok := getInteraction(update)
+	if !ok || isInteractionsOptOut(chat.ID) {
+		return
+	}
+
+	var region, province string
+	if kind == interactionCommand {
+		if !metricsCommands[action] {
+			action = metricsOtherValue
+		}
+		tokens := strings.Fields(update.Message.Text)
+		if len(tokens) > 1 && (action == "/regione" || action == "/provincia") {
+			name := strings.Replace(tokens[1], "_", " ", -1)
+			if action == "/regione" {
+				region = d.getRegionName(name)
+			} else {
+				province = d.getProvinceName(name)
+			}
+		}
+	} else if region = d.getRegionName(action); region != "" {
+		action = statsRegionAction
+	} else if province = d.getProvinceName(action); province != "" {
+		action = statsProvinceAction
+	} else {
+		action = getCallbackCounterName(action)
+	}
+
+	usageMutex.Lock()
+	defer usageMutex.Unlock()
+
+	day := getUsageToday()
+	if kind == interactionCommand {
+		day.Comandi[action]++
+	} else {
+		day.Pulsanti[action]++
+	}
+	if region != "" {
+		day.Regioni[region]++
+	}
+	if province != "" {
+		day.Province[province]++
+	}
+	if chat.Type == "private" {
+		day.Privati++
+	} else if chat.Type == "group" || chat.Type == "supergroup" {
+		day.Gruppi++
+	}
+
+	hash := hashChatId(chat.ID)
+	if !usageTodayChats[hash] {
+		usageTodayChats[hash] = true
+		day.ChatAttive++
+	}
+}
+
+// Returns the name of the region as written in data, or an empty string if it doesn't exist
+func (d *dataSnapshot) getRegionName(name string) string {
+	index, err := covidgraphs.FindFirstOccurrenceRegion(&d.regions, "denominazione_regione", name)
+	if err != nil {
+		return ""
+	}
+	return d.regions[index].Denominazione_regione
+}
+
+// Returns the name of the province as written in data, or an empty string if it doesn't exist
+func (d *dataSnapshot) getProvinceName(name string) string {
+	index, err := covidgraphs.FindFirstOccurrenceProvince(&d.provinces, "denominazione_provincia", name)
+	if err != nil {
+		return ""
+	}
+	return d.provinces[index].Denominazione_provincia
+}
+
+// Forgets that the chat has been active today, used when its data are deleted
+func forgetUsageChat(chatId int64) {
+	usageMutex.Lock()
+	delete(usageTodayChats, hashChatId(chatId))
+	usageMutex.Unlock()
+}
+
+// Returns a copy of the counters of the last days, oldest first
+func getUsageDays(days int) []usageDay {
+	usageMutex.Lock()
+	defer usageMutex.Unlock()
+
+	start := len(usageDays) - days
+	if start < 0 {
+		start = 0
+	}
+	copied := make([]usageDay, 0, days)
+	for _, v := range usageDays[start:] {
+		copied = append(copied, *v.copy())
+	}
+	return copied
+}
+
+// Returns the counters with the highest values as text lines, at most n
+func getTopCounters(counters map[string]int, n int) string {
+	names := make([]string, 0, len(counters))
+	for k := range counters {
+		names = append(names, k)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counters[names[i]] != counters[names[j]] {
+			return counters[names[i]] > counters[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > n {
+		names = names[:n]
+	}
+	if len(names) == 0 {
+		return "-\n"
+	}
+
+	var text string
+	for _, v := range names {
+		text += "<code>" + strconv.Itoa(counters[v]) + "</code> " + html.EscapeString(v) + "\n"
+	}
+	return text
+}
+
+// Returns the text of the stats command, summing up the last days
+func getStatsText() string {
+	days := getUsageDays(statsReportDays)
+	total := *newUsageDay("")
+	var activeChats, maxActiveChats int
+	for _, v := range days {
+		mergeCounters(total.Comandi, v.Comandi)
+		mergeCounters(total.Pulsanti, v.Pulsanti)
+		mergeCounters(total.Regioni, v.Regioni)
+		mergeCounters(total.Province, v.Province)
+		total.Privati += v.Privati
+		total.Gruppi += v.Gruppi
+		activeChats += v.ChatAttive
+		if v.ChatAttive > maxActiveChats {
+			maxActiveChats = v.ChatAttive
+		}
+	}
+
+	var today int
+	if len(days) > 0 && days[len(days)-1].Data == time.Now().In(romeLocation()).Format("2006-01-02") {
+		today = days[len(days)-1].ChatAttive
+	}
+	var average float64
+	if len(days) > 0 {
+		average = float64(activeChats) / float64(len(days))
+	}
+
+	return fmt.Sprintf("📊 <b>Statistiche degli ultimi %d giorni</b>\n\n"+
+		"Richieste da chat private: <b>%d</b>\nRichieste da gruppi: <b>%d</b>\n"+
+		"Chat attive oggi: <b>%d</b>\nChat attive al giorno: <b>%.1f</b> in media, <b>%d</b> al massimo\n\n"+
+		"<b>Comandi</b>\n%s\n<b>Pulsanti</b>\n%s\n<b>Regioni</b>\n%s\n<b>Province</b>\n%s",
+		statsReportDays, total.Privati, total.Gruppi, today, average, maxActiveChats,
+		getTopCounters(total.Comandi, statsTopItems), getTopCounters(total.Pulsanti, statsTopItems),
+		getTopCounters(total.Regioni, statsTopItems), getTopCounters(total.Province, statsTopItems))
+}
+
+// Draws the daily active chats of the last days and returns the file path, days without counters are drawn as zero
+func renderStatsChart() (string, error) {
+	active := make(map[string]int)
+	for _, v := range getUsageDays(statsChartDays) {
+		active[v.Data] = v.ChatAttive
+	}
+
+	today := time.Now().In(romeLocation())
+	bars := make([]chart.Value, 0, statsChartDays)
+	maxValue := 1
+	for i := statsChartDays - 1; i >= 0; i-- {
+		date := today.AddDate(0, 0, -i)
+		value := active[date.Format("2006-01-02")]
+		bars = append(bars, chart.Value{
+			Label: date.Format("02/01"),
+			Value: float64(value),
+			Style: chart.Style{FillColor: drawing.ColorFromHex("0074d9"), StrokeColor: drawing.ColorFromHex("0074d9")},
+		})
+		if value > maxValue {
+			maxValue = value
+		}
+	}
+
+	// Integer ticks only, five at most
+	step := (maxValue + 4) / 5
+	ticks := make([]chart.Tick, 0)
+	for v := 0; v <= maxValue+step-1; v += step {
+		ticks = append(ticks, chart.Tick{Value: float64(v), Label: strconv.Itoa(v)})
+	}
+
+	graph := chart.BarChart{
+		Title:      "Chat attive al giorno",
+		TitleStyle: chart.Style{FontSize: 11},
+		Width:      statsChartWidth,
+		Height:     statsChartHeight,
+		Background: chart.Style{
+			Padding: chart.Box{Top: 40, Left: 10, Right: 10, Bottom: 25},
+		},
+		XAxis: chart.Style{FontSize: 8},
+		YAxis: chart.YAxis{
+			Range: &chart.ContinuousRange{Min: 0, Max: ticks[len(ticks)-1].Value},
+			Ticks: ticks,
+		},
+		BarWidth: statsChartWidth / (2 * statsChartDays),
+		Bars:     bars,
+	}
+
+	return createTempOutput("statistiche-*.png", func(w io.Writer) error {
+		return graph.Render(chart.PNG, w)
+	})
+}
+
+// Handles "stats" textual command, reserved to the bot administrators
+func (b *bot) textStats(update *echotron.Update) {
+	chatId := update.Message.Chat.ID
+	if !isBotAdmin(update.Message.Chat) {
+		b.SendMessage("Comando riservato agli amministratori del bot.", chatId)
+		return
+	}
+
+	text := getStatsText()
+	filename, err := renderStatsChart()
+	if err != nil {
+		log.Println(err)
+		b.SendMessage(text, chatId, echotron.PARSE_HTML)
+		return
+	}
+
+	b.SendPhoto(filename, "📊 Chat attive negli ultimi "+strconv.Itoa(statsChartDays)+" giorni", chatId)
+	b.SendMessage(text, chatId, echotron.PARSE_HTML)
+	err = os.Remove(filename)
+	if err != nil {
+		log.Println("can't delete file " + filename)
+	}
+}
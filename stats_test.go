@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"github.com/NicoNex/echotron"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Starts the test with no usage counters, restoring the previous ones at the end
+func useEmptyUsageStats(t *testing.T) {
+	usageMutex.Lock()
+	previousDays, previousChats := usageDays, usageTodayChats
+	usageDays, usageTodayChats = make([]*usageDay, 0), make(map[string]bool)
+	usageMutex.Unlock()
+	t.Cleanup(func() {
+		usageMutex.Lock()
+		usageDays, usageTodayChats = previousDays, previousChats
+		usageMutex.Unlock()
+	})
+}
+
+func TestGetCallbackCounterName(t *testing.T) {
+	for data, want := range map[string]string{
+		"nuovi casi nazione":            "nuovi casi nazione",
+		"Avviso elimina 12":             "avviso elimina",
+		"programma regione 18:00 Lazio": "programma regione",
+		"confronto regione nuovi casi":  "confronto regione nuovi",
+	} {
+		if got := getCallbackCounterName(data); got != want {
+			t.Errorf("counter of %q: %q, want %q", data, got, want)
+		}
+	}
+}
+
+func TestGetTopCounters(t *testing.T) {
+	counters := map[string]int{"/home": 3, "/zone": 5, "/avviso": 3, "<b>": 1}
+	if got := getTopCounters(counters, 3); got != "<code>5</code> /zone\n<code>3</code> /avviso\n<code>3</code> /home\n" {
+		t.Errorf("top counters:\n%s", got)
+	}
+	if got := getTopCounters(counters, 4); !strings.Contains(got, "&lt;b&gt;") {
+		t.Errorf("counter names not escaped:\n%s", got)
+	}
+	if got := getTopCounters(nil, 3); got != "-\n" {
+		t.Errorf("empty counters %q", got)
+	}
+}
+
+func TestRecordUsage(t *testing.T) {
+	useEmptyUsageStats(t)
+	d := getData()
+	group := testChat(testGroupId)
+	for _, update := range []*echotron.Update{
+		commandUpdate(testChatId, "/regione emilia_romagna"),
+		commandUpdate(testChatId, "/provincia Bari"),
+		commandUpdate(testChatId, "/sconosciuto"),
+		commandUpdate(testChatId, "Puglia"),
+		{CallbackQuery: &echotron.CallbackQuery{Data: "Puglia", Message: &echotron.Message{Chat: group}}},
+		{CallbackQuery: &echotron.CallbackQuery{Data: "avviso elimina 3", Message: &echotron.Message{Chat: group}}},
+	} {
+		recordUsage(d, update)
+	}
+
+	days := getUsageDays(1)
+	if len(days) != 1 {
+		t.Fatalf("%d days of counters", len(days))
+	}
+	day := days[0]
+	if day.Comandi["/regione"] != 1 || day.Comandi[metricsOtherValue] != 1 || day.Pulsanti[statsRegionAction] != 1 || day.Pulsanti["avviso elimina"] != 1 {
+		t.Errorf("commands %v buttons %v", day.Comandi, day.Pulsanti)
+	}
+	if day.Regioni["Emilia-Romagna"] != 1 || day.Regioni["Puglia"] != 1 || day.Province["Bari"] != 1 {
+		t.Errorf("regions %v provinces %v", day.Regioni, day.Province)
+	}
+	if day.Privati != 3 || day.Gruppi != 2 || day.ChatAttive != 2 {
+		t.Errorf("%d private and %d group requests from %d chats, want 3 and 2 from 2", day.Privati, day.Gruppi, day.ChatAttive)
+	}
+
+	// The usage of opted out chats isn't counted
+	if err := setInteractionsOptOut(testChatId, true); err != nil {
+		t.Fatal(err)
+	}
+	defer setInteractionsOptOut(testChatId, false)
+	recordUsage(d, commandUpdate(testChatId, "/home"))
+	if day = getUsageDays(1)[0]; day.Comandi["/home"] != 0 {
+		t.Error("opted out chat counted")
+	}
+}
+
+func TestRenderStatsChart(t *testing.T) {
+	useEmptyUsageStats(t)
+	recordUsage(getData(), commandUpdate(testChatId, "/home"))
+
+	first, err := renderStatsChart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(first)
+	second, err := renderStatsChart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(second)
+
+	if first == second || !strings.HasPrefix(filepath.Base(first), "statistiche-") || filepath.Ext(first) != ".png" {
+		t.Errorf("charts saved in %s and %s", first, second)
+	}
+	content, err := ioutil.ReadFile(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(content, []byte("\x89PNG")) {
+		t.Error("chart isn't a PNG picture")
+	}
+}
+
+func TestStats(t *testing.T) {
+	useEmptyUsageStats(t)
+	b := newTestBot(testChatId)
+	calls := b.send("/stats")
+	expectMethods(t, calls, "sendMessage")
+	expectText(t, calls[0], "Comando riservato agli amministratori del bot.")
+
+	defer func(admins map[int64]bool) { botAdmins = admins }(botAdmins)
+	botAdmins = map[int64]bool{testChatId: true}
+	calls = b.send("/stats")
+	expectMethods(t, calls, "sendPhoto", "sendMessage")
+	expectText(t, calls[1], "Statistiche degli ultimi 7 giorni", "Chat attive oggi: <b>1</b>", "<code>2</code> /stats")
+	if files, _ := filepath.Glob(workingDirectory + reportsFolder + "statistiche-*"); len(files) != 0 {
+		t.Errorf("charts left in the reports folder: %v", files)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/NicoNex/echotron"
+	neturl "net/url"
+	"strings"
+)
+
+// Bot API methods not provided by echotron
+
+// Information about one member of a chat
+type chatMember struct {
+	User   *echotron.User `json:"user"`
+	Status string         `json:"status"`
+}
+
+// Response of the getChatMember method
+type apiResponseChatMember struct {
+	echotron.APIResponseBase
+	Result *chatMember `json:"result,omitempty"`
+}
+
+// Returns information about a member of a chat
+func (b *bot) getChatMember(chatId int64, userId int) (response apiResponseChatMember) {
+	var url = fmt.Sprintf(
+		"%sgetChatMember?chat_id=%d&user_id=%d",
+		string(b.Api),
+		chatId,
+		userId,
+	)
+
+	content := echotron.SendGetRequest(url)
+	json.Unmarshal(content, &response)
+	return
+}
+
+// Checks if a user can configure the bot in a chat, everyone can in private chats
+func (b *bot) isChatAdmin(chat *echotron.Chat, user *echotron.User) bool {
+	if chat.Type == "private" {
+		return true
+	}
+	if user == nil {
+		return false
+	}
+
+	response := b.getChatMember(chat.ID, user.ID)
+	if !response.Ok || response.Result == nil {
+		return false
+	}
+	return response.Result.Status == "creator" || response.Result.Status == "administrator"
+}
+
+// Photo replacing the media of a message
+type inputMediaPhoto struct {
+	Type      string `json:"type"`
+	Media     string `json:"media"`
+	Caption   string `json:"caption,omitempty"`
+	ParseMode string `json:"parse_mode,omitempty"`
+}
+
+// Pins a message in a chat without notifying members
+func (b *bot) pinChatMessage(chatId int64, messageId int) (response echotron.APIResponseBase) {
+	var url = fmt.Sprintf(
+		"%spinChatMessage?chat_id=%d&message_id=%d&disable_notification=true",
+		string(b.Api),
+		chatId,
+		messageId,
+	)
+
+	content := echotron.SendGetRequest(url)
+	json.Unmarshal(content, &response)
+	return
+}
+
+// Unpins a message in a chat
+func (b *bot) unpinChatMessage(chatId int64, messageId int) (response echotron.APIResponseBase) {
+	var url = fmt.Sprintf(
+		"%sunpinChatMessage?chat_id=%d&message_id=%d",
+		string(b.Api),
+		chatId,
+		messageId,
+	)
+
+	content := echotron.SendGetRequest(url)
+	json.Unmarshal(content, &response)
+	return
+}
+
+// Replaces the photo and the caption of a message, reusing the Telegram file of the plot if it has already been uploaded
+func (b *bot) editMessagePhoto(chatId int64, messageId int, filename, caption string) (response echotron.APIResponseMessage) {
+	if fileId, ok := getPlotFileId(filename); ok {
+		response = b.editMessageMedia(chatId, messageId, fileId, "", caption)
+		if !isInvalidFileId(response.APIResponseBase) {
+			return
+		}
+		forgetPlotFileId(filename)
+	}
+
+	response = b.editMessageMedia(chatId, messageId, "attach://photo", filename, caption)
+	setPlotFileId(filename, response)
+	return
+}
+
+// Replaces the media of a message with the given photo, the file is uploaded if the filename isn't empty
+func (b *bot) editMessageMedia(chatId int64, messageId int, photo, filename, caption string) (response echotron.APIResponseMessage) {
+	media, err := json.Marshal(inputMediaPhoto{
+		Type:      "photo",
+		Media:     photo,
+		Caption:   caption,
+		ParseMode: "HTML",
+	})
+	if err != nil {
+		response.Description = err.Error()
+		return
+	}
+
+	var url = fmt.Sprintf(
+		"%seditMessageMedia?chat_id=%d&message_id=%d&media=%s",
+		string(b.Api),
+		chatId,
+		messageId,
+		neturl.QueryEscape(string(media)),
+	)
+
+	var content []byte
+	if filename != "" {
+		content = echotron.SendPostRequest(url, filename, "photo")
+	} else {
+		content = echotron.SendGetRequest(url)
+	}
+	json.Unmarshal(content, &response)
+	return
+}
+
+// Sends a plot, reusing the Telegram file if it has already been uploaded
+func (b *bot) sendPlot(filename, caption string, chatId int64, opts ...echotron.Option) echotron.APIResponseMessage {
+	return b.sendPlotWithKeyboard(filename, caption, chatId, nil, opts...)
+}
+
+// Sends a plot with an inline keyboard, reusing the Telegram file if it has already been uploaded
+func (b *bot) sendPlotWithKeyboard(filename, caption string, chatId int64, keyboard []byte, opts ...echotron.Option) (response echotron.APIResponseMessage) {
+	if keyboard != nil {
+		opts = append(opts, echotron.Option("&reply_markup="+neturl.QueryEscape(string(keyboard))))
+	}
+
+	if fileId, ok := getPlotFileId(filename); ok {
+		response = b.SendPhotoByID(fileId, caption, chatId, opts...)
+		if !isInvalidFileId(response.APIResponseBase) {
+			return
+		}
+		forgetPlotFileId(filename)
+	}
+
+	response = b.SendPhoto(filename, caption, chatId, opts...)
+	setPlotFileId(filename, response)
+	return
+}
+
+// Returns true if Telegram refused a request because the file identifier is no longer valid
+func isInvalidFileId(response echotron.APIResponseBase) bool {
+	return !response.Ok && response.ErrorCode == 400 && strings.Contains(strings.ToLower(response.Description), "file")
+}
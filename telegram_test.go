@@ -0,0 +1,86 @@
+package main
+
+import (
+	"github.com/NicoNex/echotron"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// Writes a plot file in the plots folder, forgetting its file_id at the end of the test
+func writeTestPlot(t *testing.T, name string) string {
+	filename := workingDirectory + imageFolder + name
+	if err := ioutil.WriteFile(filename, []byte("\x89PNG prova"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		forgetPlotFileId(filename)
+		os.Remove(filename)
+	})
+	return filename
+}
+
+func TestSendPlotReusesFileId(t *testing.T) {
+	b := newTestBot(testChatId)
+	filename := writeTestPlot(t, "prova-file-id.png")
+
+	telegram.reset()
+	b.sendPlot(filename, "Primo invio", testChatId)
+	b.sendPlot(filename, "Secondo invio", testChatId)
+	calls := telegram.requests()
+	expectMethods(t, calls, "sendPhoto", "sendPhoto")
+	if calls[0].Params.Get("photo") != "" || calls[1].Params.Get("photo") != "file-prova-file-id.png" {
+		t.Errorf("photos %q and %q, want an upload and then its file_id", calls[0].Params.Get("photo"), calls[1].Params.Get("photo"))
+	}
+	if fileId, ok := getPlotFileId(filename); !ok || fileId != "file-prova-file-id.png" {
+		t.Errorf("file_id %q saved", fileId)
+	}
+}
+
+func TestSendPlotExpiredFileId(t *testing.T) {
+	b := newTestBot(testChatId)
+	filename := writeTestPlot(t, "prova-scaduto.png")
+	plotCacheMutex.Lock()
+	plotFileIds[filename] = "scaduto"
+	plotCacheMutex.Unlock()
+
+	// The refused request isn't recorded, the plot is uploaded again
+	telegram.reset()
+	b.sendPlot(filename, "Grafico", testChatId)
+	calls := telegram.requests()
+	expectMethods(t, calls, "sendPhoto")
+	if calls[0].Params.Get("photo") != "" || calls[0].Photo != "prova-scaduto.png" || !calls[0].PNG {
+		t.Errorf("photo %+v, want the upload of the plot", calls[0])
+	}
+	if fileId, _ := getPlotFileId(filename); fileId != "file-prova-scaduto.png" {
+		t.Errorf("file_id %q saved, want the new one", fileId)
+	}
+}
+
+func TestEditMessagePhotoReusesFileId(t *testing.T) {
+	b := newTestBot(testChatId)
+	filename := writeTestPlot(t, "prova-modifica.png")
+
+	telegram.reset()
+	b.editMessagePhoto(testChatId, testMessageId, filename, "Prima modifica")
+	b.editMessagePhoto(testChatId, testMessageId, filename, "Seconda modifica")
+	calls := telegram.requests()
+	expectMethods(t, calls, "editMessageMedia", "editMessageMedia")
+	if calls[0].Photo != "prova-modifica.png" || calls[1].Photo != "prova-modifica.png" {
+		t.Errorf("photos %q and %q", calls[0].Photo, calls[1].Photo)
+	}
+	expectText(t, calls[1], "Seconda modifica")
+}
+
+func TestIsInvalidFileId(t *testing.T) {
+	for response, invalid := range map[echotron.APIResponseBase]bool{
+		{Ok: false, ErrorCode: 400, Description: "Bad Request: wrong file identifier/HTTP URL specified"}: true,
+		{Ok: false, ErrorCode: 400, Description: "Bad Request: chat not found"}:                           false,
+		{Ok: false, ErrorCode: 429, Description: "Too Many Requests: retry after 5"}:                      false,
+		{Ok: true}: false,
+	} {
+		if isInvalidFileId(response) != invalid {
+			t.Errorf("%+v: invalid file_id %v, want %v", response, !invalid, invalid)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+const (
+	templatesFolder    = "/templates/"
+	templatesExtension = ".tmpl"
+)
+
+// Default layouts of captions and reports, a file with the same name in the data templates folder replaces them
+//
+//go:embed templates/*.tmpl
+var defaultTemplates embed.FS
+
+var captionTemplates *template.Template
+
+var templateFuncs = template.FuncMap{
+	"inc":         func(i int) int { return i + 1 },
+	"formatDelta": formatDelta,
+}
+
+// Value of a field with its daily variation and its anomaly mark
+type captionValue struct {
+	Value   int
+	Delta   string
+	Anomaly string
+}
+
+// Trend of the nation, a region or a province
+type captionAndamento struct {
+	Name      string // Region or province name, empty for the nation
+	Date      string
+	Anomaly   string                  // Mark of the anomalies concerning the whole row
+	Values    map[string]captionValue // Values indexed by pcm-dpc field name
+	Zone      string                  // Risk zone caption, regions only
+	Note      string                  // pcm-dpc note of the day
+	Anomalies []string                // Explanations of the anomalies found in data
+}
+
+// Selected fields of the nation, a region or a province compared in a plot
+type captionConfronto struct {
+	captionAndamento
+	Fields map[string]bool // Whether each field available for the area has been selected, indexed by pcm-dpc field name
+}
+
+// Item of a list, such as a ranking
+type captionItem struct {
+	Name  string
+	Value int
+	Delta int
+}
+
+// National data of one day
+type captionDay struct {
+	Date          string
+	Cases         int
+	Deaths        int
+	Tests         int
+	IntensiveCare int
+}
+
+// Last week of an area compared with the previous one
+type captionWeek struct {
+	Area         string
+	Cases        int
+	CasesChange  string
+	Deaths       int
+	DeathsChange string
+	Tests        int
+	TestsChange  string
+}
+
+// Data passed to each template when validating them, every list has an item so that all branches are executed
+var templatesSamples = map[string]func() interface{}{
+	"andamento_nazionale": func() interface{} { return sampleCaptionAndamento(natregAttributes) },
+	"andamento_regione":   func() interface{} { return sampleCaptionAndamento(natregAttributes) },
+	"andamento_provincia": func() interface{} { return sampleCaptionAndamento(provincesAttributes) },
+	"confronto_nazionale": func() interface{} { return sampleCaptionConfronto(natregAttributes) },
+	"confronto_regione":   func() interface{} { return sampleCaptionConfronto(natregAttributes) },
+	"confronto_provincia": func() interface{} { return sampleCaptionConfronto(provincesAttributes) },
+	"top_regioni":         func() interface{} { return []captionItem{{}} },
+	"top_province":        func() interface{} { return []captionItem{{}} },
+	"report_generale": func() interface{} {
+		return struct {
+			Nation       captionAndamento
+			TopRegions   []captionItem
+			TopProvinces []captionItem
+		}{sampleCaptionAndamento(natregAttributes), []captionItem{{}}, []captionItem{{}}}
+	},
+	"report_settimanale": func() interface{} {
+		return struct {
+			Days  []captionDay
+			Total captionDay
+		}{[]captionDay{{}}, captionDay{}}
+	},
+	"report_regione": func() interface{} {
+		return struct {
+			Region    captionAndamento
+			Provinces []captionItem
+		}{sampleCaptionAndamento(natregAttributes), []captionItem{{}}}
+	},
+	"report_provincia": func() interface{} { return sampleCaptionAndamento(provincesAttributes) },
+	"report_riepilogo": func() interface{} {
+		return struct {
+			From    string
+			To      string
+			Nation  captionWeek
+			Best    []captionWeek
+			Worst   []captionWeek
+			Regions []captionWeek
+		}{"", "", captionWeek{}, []captionWeek{{}}, []captionWeek{{}}, []captionWeek{{}}}
+	},
+}
+
+// Returns trend data with the given fields, the ones available for the area, used to validate templates
+func sampleCaptionAndamento(fields []string) captionAndamento {
+	values := make(map[string]captionValue)
+	for _, v := range fields {
+		values[v] = captionValue{}
+	}
+	return captionAndamento{Values: values, Zone: "-", Note: "-", Anomalies: []string{"-"}}
+}
+
+// Returns comparison data with every field of the area selected, used to validate templates
+func sampleCaptionConfronto(fields []string) captionConfronto {
+	confronto := captionConfronto{captionAndamento: sampleCaptionAndamento(fields), Fields: make(map[string]bool)}
+	for _, v := range fields {
+		confronto.Fields[v] = true
+	}
+	return confronto
+}
+
+// Loads the embedded templates, replacing them with the ones found in the data templates folder, and validates them
+func loadTemplates() error {
+	t := template.New("").Funcs(templateFuncs).Option("missingkey=error")
+
+	names, err := fs.Glob(defaultTemplates, "templates/*"+templatesExtension)
+	if err != nil {
+		return err
+	}
+	customNames, err := filepath.Glob(workingDirectory + templatesFolder + "*" + templatesExtension)
+	if err != nil {
+		return err
+	}
+	for _, v := range customNames {
+		if _, err := fs.Stat(defaultTemplates, "templates/"+filepath.Base(v)); err != nil {
+			names = append(names, "templates/"+filepath.Base(v))
+		}
+	}
+
+	for _, v := range names {
+		filename := path.Base(v)
+		content, err := os.ReadFile(workingDirectory + templatesFolder + filename)
+		if os.IsNotExist(err) {
+			content, err = defaultTemplates.ReadFile(v)
+		} else if err == nil {
+			log.Println("using custom template " + filename)
+		}
+		if err != nil {
+			return err
+		}
+
+		// The final newline of the files is dropped so templates can be nested
+		_, err = t.New(strings.TrimSuffix(filename, templatesExtension)).Parse(strings.TrimSuffix(string(content), "\n"))
+		if err != nil {
+			return fmt.Errorf("invalid template %s: %v", filename, err)
+		}
+	}
+
+	for name, sample := range templatesSamples {
+		err = t.ExecuteTemplate(io.Discard, name, sample())
+		if err != nil {
+			return fmt.Errorf("invalid template %s: %v", name+templatesExtension, err)
+		}
+	}
+
+	captionTemplates = t
+	return nil
+}
+
+// Returns the text produced by the template with the given name
+func executeTemplate(name string, data interface{}) (string, error) {
+	buffer := &bytes.Buffer{}
+	err := captionTemplates.ExecuteTemplate(buffer, name, data)
+	if err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
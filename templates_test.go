@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReportRegioneDelta(t *testing.T) {
+	msg, err := executeTemplate("report_regione", struct {
+		Region    captionAndamento
+		Provinces []captionItem
+	}{sampleCaptionAndamento(natregAttributes), []captionItem{{Name: "Bari", Value: 100, Delta: 5}, {Name: "Lecce", Value: 90, Delta: -3}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(msg, "Bari: <code>100</code> (<i>+5</i>)") || !strings.Contains(msg, "Lecce: <code>90</code> (<i>-3</i>)") {
+		t.Errorf("unexpected report:\n%s", msg)
+	}
+}
+
+func TestSetCaptionConfronto(t *testing.T) {
+	d := getData()
+	msg := d.setCaptionConfrontoNazione(len(d.nation)-1, []string{"tamponi", "attualmente_positivi"})
+	want := "<b>Andamento nazione 2022-03-05</b>\n\n<b>Attualmente positivi: </b>563667 (<i>+4444</i>)\n<b>Tamponi effettuati: </b>47426556 (<i>+345339</i>)"
+	if msg != want {
+		t.Errorf("caption %q, want %q", msg, want)
+	}
+
+	msg = d.setCaptionConfrontoProvincia(len(d.provinces)-1, []string{"nuovi_positivi", "deceduti"})
+	if !strings.HasPrefix(msg, "<b>Andamento provincia di ") || !strings.Contains(msg, "</b>\n\n<b>Nuovi positivi: </b>") || strings.Contains(msg, "Totale positivi") {
+		t.Errorf("unexpected caption:\n%s", msg)
+	}
+
+	// Only the anomalies of the selected fields are explained
+	regionId := len(d.regions) - 1
+	snapshot := *d
+	snapshot.regionsAnomalies = map[int][]dataAnomaly{regionId: {
+		{field: "deceduti", kind: anomalyKindNegative, explanation: "morti corretti"},
+		{field: "tamponi", kind: anomalyKindOutlier, explanation: "tamponi arretrati"},
+	}}
+	msg = snapshot.setCaptionConfrontoRegione(regionId, []string{"deceduti", "terapia_intensiva"})
+	if !strings.Contains(msg, "(<i>"+formatDelta(d.regions[regionId].Deceduti-d.regions[regionId-21].Deceduti)+"</i>)"+anomalyMark) ||
+		!strings.Contains(msg, "<i>morti corretti</i>") || strings.Contains(msg, "tamponi") {
+		t.Errorf("unexpected caption:\n%s", msg)
+	}
+}
+
+func TestCustomTemplates(t *testing.T) {
+	filename := workingDirectory + templatesFolder + "top_regioni" + templatesExtension
+	defer loadTemplates()
+	defer os.Remove(filename)
+
+	err := ioutil.WriteFile(filename, []byte("Classifica: {{range .}}{{.Name}} {{end}}\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = loadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	if msg := getData().setCaptionTopRegions(); !strings.HasPrefix(msg, "Classifica: ") || strings.Contains(msg, "<b>") {
+		t.Errorf("custom template not used:\n%s", msg)
+	}
+
+	err = ioutil.WriteFile(filename, []byte("{{range .}}{{.Regione}}{{end}}"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = loadTemplates(); err == nil {
+		t.Error("invalid custom template accepted")
+	}
+	os.Remove(filename)
+
+	// Province captions have only the provincial fields
+	filename = workingDirectory + templatesFolder + "andamento_provincia" + templatesExtension
+	defer os.Remove(filename)
+	err = ioutil.WriteFile(filename, []byte("{{.Name}}: {{.Values.nuovi_positivi.Value}} nuovi casi, {{.Values.deceduti.Value}} deceduti"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = loadTemplates(); err == nil {
+		t.Error("province template with a regional field accepted")
+	}
+}
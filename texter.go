@@ -5,81 +5,60 @@ import (
 	"github.com/DarkFighterLuke/covidgraphs"
 	"github.com/NicoNex/echotron"
 	"log"
-	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
-// TODO: Use inline keyboards instead of handwritten command
 // Handles "report" textual command
-func (b *bot) textReport(update *echotron.Update) {
-	usageMessage := "<b>Uso Corretto del Comando:</b>\n/reports <code>[file] nome_report</code>\nReport disponibili:{<code>" +
-		strings.Join(reports, ", ") + "</code>}\nDigita /help per visualizzare il manuale."
+func (b *bot) textReport(d *dataSnapshot, update *echotron.Update) {
+	usageMessage := "<b>Uso Corretto del Comando:</b>\n/reports <code>[file] nome_report [argomento]</code>\nReport disponibili:" +
+		getReportsHelp() + "\nDigita /help per visualizzare il manuale."
 
 	tokens := strings.Fields(update.Message.Text)
 	tokens = tokens[1:]
 
-	var fieldNames []string
 	var flagFile bool = false
 
 	if len(tokens) < 1 {
 		b.SendMessage(usageMessage, update.Message.Chat.ID, echotron.PARSE_HTML)
 		return
 	}
-	for i, _ := range tokens {
+	for i := range tokens {
 		tokens[i] = strings.ToLower(tokens[i])
 	}
-	if tokens[0] == "file" {
+	if tokens[0] == "file" || tokens[0] == reportFormatPDF {
 		flagFile = true
+		tokens = tokens[1:]
 	}
-
-	i := 0
-	if flagFile {
-		i = 1
+	if len(tokens) < 1 {
+		b.SendMessage(usageMessage, update.Message.Chat.ID, echotron.PARSE_HTML)
+		return
 	}
-	for ; i < len(tokens); i++ {
-		if res := sort.SearchStrings(reports, tokens[i]); res < len(reports) {
-			fieldNames = append(fieldNames, tokens[i])
-		} else {
-			b.SendMessage(usageMessage, update.Message.Chat.ID, echotron.PARSE_HTML)
-			return
-		}
+
+	report, ok := getReport(tokens[0])
+	if !ok || (report.argument != reportArgumentNone && len(tokens) < 2) {
+		b.SendMessage(usageMessage, update.Message.Chat.ID, echotron.PARSE_HTML)
+		return
 	}
-	for _, v := range fieldNames {
-		if v == "generale" {
-			msg := setCaptionAndamentoNazionale() + "\n\n\n" + setCaptionTopRegions() + "\n" + setCaptionTopProvinces()
-			b.SendMessage(msg, update.Message.Chat.ID, echotron.PARSE_HTML)
-			if flagFile {
-				filename := "report generale-" + time.Now().Format("20060102T150405") + ".txt"
-				f, err := os.Create(filename)
-				if err != nil {
-					log.Println(err)
-				}
-				_, err = f.WriteString(msg)
-				if err != nil {
-					log.Println(err)
-					f.Close()
-				}
-				err = f.Close()
-				if err != nil {
-					log.Println(err)
-				}
+	argument := strings.Join(tokens[1:], " ")
 
-				b.DeleteMessage(update.Message.Chat.ID, update.Message.ID)
-				b.SendDocument(filename, "", update.Message.Chat.ID)
-				err = os.Remove(filename)
-				if err != nil {
-					log.Println("can't delete file " + filename)
-				}
-			}
-		}
+	if _, ok := report.builders[reportFormatText]; ok {
+		b.sendReport(d, update.Message.Chat.ID, report, argument, reportFormatText)
+	}
+	if flagFile {
+		b.DeleteMessage(update.Message.Chat.ID, update.Message.ID)
+		b.sendReport(d, update.Message.Chat.ID, report, argument, reportFormatPDF)
 	}
 }
 
 // TODO: Use inline keyboards instead of handwritten command
 // Handles "nazione" textual command
-func (b *bot) textNation(update *echotron.Update) {
+func (b *bot) textNation(d *dataSnapshot, update *echotron.Update) {
+	stopRendering := b.showPlotRendering(update.Message.Chat.ID)
+	defer stopRendering()
+
 	usageMessage := "<b>Uso Corretto del Comando:\n</b>/nazione <code>andamento</code>\nper ottenere l'andamento della nazione\n" +
 		"/nazione <code>nome_dei_campi</code>\nper ottenere un confronto tra campi a tua scelta\n" +
 		"Dati nazione disponibili:\n{<code>" + strings.Join(natregAttributes, ", ") + "</code>}\nDigita /help per visualizzare il manuale."
@@ -96,7 +75,7 @@ func (b *bot) textNation(update *echotron.Update) {
 
 	sort.Strings(natregAttributes)
 	if tokens[0] == "andamento" {
-		b.sendAndamentoNazionale(update.Message)
+		b.sendAndamentoNazionale(d, update.Message.Chat.ID)
 	} else {
 		for i := 0; i < len(tokens); i++ {
 			if res := sort.SearchStrings(natregAttributes, tokens[i]); res < len(natregAttributes) {
@@ -116,36 +95,37 @@ func (b *bot) textNation(update *echotron.Update) {
 			}
 		}
 
-		dirPath := workingDirectory + imageFolder
 		titleForFilename := "Nazione" + fmt.Sprintf("%s_%s_%s", titleAttributes[0], titleAttributes[1], titleAttributes[2])
 		title := "Confronto dati nazione"
 		var filename string
 		var err error
 
-		filename = dirPath + covidgraphs.FilenameCreator(titleForFilename)
-		if !covidgraphs.IsGraphExisting(filename) {
-			err, filename = covidgraphs.VociNazione(&nationData, fieldNames, 0, title, filename)
-
-			if err != nil {
-				log.Println(err)
-			}
-		}
-
+		filename = d.getPlotFilename(titleForFilename)
+		filename, err = renderPlot(filename, func(filename string) (error, string) {
+			return covidgraphs.VociNazione(&d.nation, fieldNames, 0, title, filename)
+		})
 		if err != nil {
 			log.Println(err)
 			b.SendMessage(usageMessage, update.Message.Chat.ID, echotron.PARSE_HTML)
 			return
 		}
 
-		b.SendPhoto(filename, setCaptionConfrontoNazione(len(nationData)-1, fieldNames), update.Message.Chat.ID, echotron.PARSE_HTML)
+		exportButtons, err := b.exportButtons(exportAreaNation, "")
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		b.sendPlotWithKeyboard(filename, d.setCaptionConfrontoNazione(len(d.nation)-1, fieldNames), update.Message.Chat.ID, exportButtons, echotron.PARSE_HTML)
 	}
 	b.DeleteMessage(update.Message.Chat.ID, update.Message.ID)
 }
 
 // TODO: Use inline keyboards instead of handwritten command
 // Handles "regione" textual command
-func (b *bot) textRegion(update *echotron.Update) {
-	dirPath := workingDirectory + imageFolder
+func (b *bot) textRegion(d *dataSnapshot, update *echotron.Update) {
+	stopRendering := b.showPlotRendering(update.Message.Chat.ID)
+	defer stopRendering()
+
 	usageMessage := "<b>Uso Corretto del Comando:\n</b>/regione <code>nome_regione andamento</code>\nper ottenere l'andamento della regione scelta\n" +
 		"/regione <code>nome_regione nome_dei_campi</code>\nper ottenere un confronto tra campi a tua scelta sulla desiderata\n" +
 		"Dati regione disponibili:\n{<code>" + strings.Join(natregAttributes, ", ") + "</code>}\nDigita /help per visualizzare il manuale."
@@ -162,13 +142,13 @@ func (b *bot) textRegion(update *echotron.Update) {
 	sort.Strings(natregAttributes)
 	tokens[0] = strings.Replace(tokens[0], "_", " ", -1)
 
-	regionId, err := covidgraphs.FindLastOccurrenceRegion(&regionsData, "denominazione_regione", tokens[0])
+	regionId, err := covidgraphs.FindLastOccurrenceRegion(&d.regions, "denominazione_regione", tokens[0])
 	if err != nil {
 		log.Println(err)
 		return
 	}
 	if tokens[1] == "andamento" {
-		b.sendAndamentoRegionale(update.Message, regionId)
+		b.sendAndamentoRegionale(d, update.Message.Chat.ID, regionId)
 	} else {
 		for i := 1; i < len(tokens); i++ {
 			if res := sort.SearchStrings(natregAttributes, tokens[i]); res < len(natregAttributes) {
@@ -178,7 +158,7 @@ func (b *bot) textRegion(update *echotron.Update) {
 			}
 		}
 
-		regionCode, err := covidgraphs.FindFirstOccurrenceRegion(&regionsData, "denominazione_regione", tokens[0])
+		regionCode, err := covidgraphs.FindFirstOccurrenceRegion(&d.regions, "denominazione_regione", tokens[0])
 		if err != nil {
 			log.Println(err)
 			b.SendMessage(usageMessage, update.Message.Chat.ID, echotron.PARSE_HTML)
@@ -194,34 +174,36 @@ func (b *bot) textRegion(update *echotron.Update) {
 			}
 		}
 
-		titleForFilename := "Regione" + regionsData[regionCode].Denominazione_regione + fmt.Sprintf("%s_%s_%s", titleAttributes[0], titleAttributes[1], titleAttributes[2])
+		titleForFilename := "Regione" + d.regions[regionCode].Denominazione_regione + fmt.Sprintf("%s_%s_%s", titleAttributes[0], titleAttributes[1], titleAttributes[2])
 		title := "Confronto dati regione"
 		var filename string
 
-		filename = dirPath + covidgraphs.FilenameCreator(titleForFilename)
-		if !covidgraphs.IsGraphExisting(filename) {
-			err, filename = covidgraphs.VociRegione(&regionsData, fieldNames, 0, regionCode, title, filename)
-
-			if err != nil {
-				log.Println(err)
-			}
-		}
-
+		filename = d.getPlotFilename(titleForFilename)
+		filename, err = renderPlot(filename, func(filename string) (error, string) {
+			return covidgraphs.VociRegione(&d.regions, fieldNames, 0, regionCode, title, filename)
+		})
 		if err != nil {
 			log.Println(err)
 			b.SendMessage(usageMessage, update.Message.Chat.ID, echotron.PARSE_HTML)
 			return
 		}
 
-		b.SendPhoto(filename, setCaptionConfrontoRegione(regionId, fieldNames), update.Message.Chat.ID, echotron.PARSE_HTML)
+		exportButtons, err := b.exportButtons(exportAreaRegion, d.regions[regionId].Denominazione_regione)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		b.sendPlotWithKeyboard(filename, d.setCaptionConfrontoRegione(regionId, fieldNames), update.Message.Chat.ID, exportButtons, echotron.PARSE_HTML)
 	}
 	b.DeleteMessage(update.Message.Chat.ID, update.Message.ID)
 }
 
 // TODO: Use inline keyboards instead of handwritten command
 // Handles "provincia" textual command
-func (b *bot) textProvince(update *echotron.Update) {
-	dirPath := workingDirectory + imageFolder
+func (b *bot) textProvince(d *dataSnapshot, update *echotron.Update) {
+	stopRendering := b.showPlotRendering(update.Message.Chat.ID)
+	defer stopRendering()
+
 	usageMessage := "<b>Uso Corretto del Comando:\n</b>/provincia <code>nome_provincia totale_casi</code>" +
 		"\nper ottenere informazioni sul totale dei casi della provincia scelta\n" +
 		"/provincia <code>nome_provincia nuovi_positivi</code>\nper ottenere informazioni sui nuovi positivi della provincia scelta\nDigita /help per visualizzare il manuale."
@@ -236,67 +218,391 @@ func (b *bot) textProvince(update *echotron.Update) {
 
 	tokens[0] = strings.Replace(tokens[0], "_", " ", -1)
 
-	provinceId, err := covidgraphs.FindFirstOccurrenceProvince(&provincesData, "denominazione_provincia", tokens[0])
+	provinceId, err := covidgraphs.FindFirstOccurrenceProvince(&d.provinces, "denominazione_provincia", tokens[0])
 	if err != nil {
 		log.Println(err)
 		return
 	}
 	if tokens[1] == "totale_casi" {
-		title := "Totale Contagi " + provincesData[provinceId].Denominazione_provincia
+		title := "Totale Contagi " + d.provinces[provinceId].Denominazione_provincia
 		var filename string
 
-		filename = dirPath + covidgraphs.FilenameCreator(title)
-		if !covidgraphs.IsGraphExisting(filename) {
-			provinceIndexes := covidgraphs.GetProvinceIndexesByName(&provincesData, tokens[0])
-			err, filename = covidgraphs.TotalePositiviProvincia(&provincesData, provinceIndexes, title, filename)
+		filename = d.getPlotFilename(title)
+		filename, err = renderPlot(filename, func(filename string) (error, string) {
+			provinceIndexes := covidgraphs.GetProvinceIndexesByName(&d.provinces, tokens[0])
+			return covidgraphs.TotalePositiviProvincia(&d.provinces, provinceIndexes, title, filename)
+		})
+		if err != nil {
+			log.Println(err)
+			b.SendMessage(usageMessage, update.Message.Chat.ID, echotron.PARSE_HTML)
+			return
+		}
 
-			if err != nil {
-				log.Println(err)
-			}
+		provinceLastId, err := covidgraphs.FindLastOccurrenceProvince(&d.provinces, "denominazione_provincia", tokens[0])
+		if err != nil {
+			log.Println(err)
+			b.SendMessage(usageMessage, update.Message.Chat.ID, echotron.PARSE_HTML)
+			return
+		}
+
+		exportButtons, err := b.exportButtons(exportAreaProvince, d.provinces[provinceLastId].Denominazione_provincia)
+		if err != nil {
+			log.Println(err)
+			return
 		}
+		b.sendPlotWithKeyboard(filename, d.setCaptionConfrontoProvincia(provinceLastId, []string{tokens[1]}), update.Message.Chat.ID, exportButtons, echotron.PARSE_HTML)
+	} else if tokens[1] == "nuovi_positivi" {
+		title := "Nuovi Positivi " + d.provinces[provinceId].Denominazione_provincia
+		var filename string
 
+		filename = d.getPlotFilename(title)
+		filename, err = renderPlot(filename, func(filename string) (error, string) {
+			provinceIndexes := covidgraphs.GetProvinceIndexesByName(&d.provinces, tokens[0])
+			return covidgraphs.NuoviPositiviProvincia(&d.provinces, provinceIndexes, true, title, filename)
+		})
 		if err != nil {
 			log.Println(err)
 			b.SendMessage(usageMessage, update.Message.Chat.ID, echotron.PARSE_HTML)
 			return
 		}
 
-		provinceLastId, err := covidgraphs.FindLastOccurrenceProvince(&provincesData, "denominazione_provincia", tokens[0])
+		provinceLastId, err := covidgraphs.FindLastOccurrenceProvince(&d.provinces, "denominazione_provincia", tokens[0])
 		if err != nil {
 			log.Println(err)
 			b.SendMessage(usageMessage, update.Message.Chat.ID, echotron.PARSE_HTML)
 			return
 		}
 
-		b.SendPhoto(filename, setCaptionConfrontoProvincia(provinceLastId, []string{tokens[1]}), update.Message.Chat.ID, echotron.PARSE_HTML)
-	} else if tokens[1] == "nuovi_positivi" {
-		title := "Nuovi Positivi " + provincesData[provinceId].Denominazione_provincia
-		var filename string
+		exportButtons, err := b.exportButtons(exportAreaProvince, d.provinces[provinceLastId].Denominazione_provincia)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		b.sendPlotWithKeyboard(filename, d.setCaptionConfrontoProvincia(provinceLastId, []string{tokens[1]}), update.Message.Chat.ID, exportButtons, echotron.PARSE_HTML)
+	}
+	b.DeleteMessage(update.Message.Chat.ID, update.Message.ID)
+}
 
-		filename = dirPath + covidgraphs.FilenameCreator(title)
-		if !covidgraphs.IsGraphExisting(filename) {
-			provinceIndexes := covidgraphs.GetProvinceIndexesByName(&provincesData, tokens[0])
-			err, filename = covidgraphs.NuoviPositiviProvincia(&provincesData, provinceIndexes, true, title, filename)
+// Handles "zone" textual command
+func (b *bot) textZone(d *dataSnapshot, update *echotron.Update) {
+	b.SendMessage(d.setCaptionZones(), update.Message.Chat.ID, echotron.PARSE_HTML)
+}
 
-			if err != nil {
-				log.Println(err)
-			}
+// Handles "avviso" textual command
+func (b *bot) textAvviso(d *dataSnapshot, update *echotron.Update) {
+	usageMessage := "<b>Uso Corretto del Comando:</b>\n/avviso\nper creare un avviso guidato o gestire quelli esistenti\n" +
+		"/avviso <code>lista</code>\nper visualizzare i tuoi avvisi\n" +
+		"/avviso <code>nazione|nome_regione metrica sopra|sotto soglia</code>\n/avviso <code>nazione|nome_regione metrica crescita|calo giorni</code>\n" +
+		"per creare direttamente un avviso\nMetriche disponibili:\n{<code>" + strings.Join(alertMetrics, ", ") + "</code>}\nDigita /help per visualizzare il manuale."
+
+	tokens := strings.Fields(update.Message.Text)
+	tokens = tokens[1:]
+	for i := range tokens {
+		tokens[i] = strings.ToLower(tokens[i])
+	}
+
+	if len(tokens) == 0 {
+		buttons, err := b.alertsMenuButtons()
+		if err != nil {
+			log.Println(err)
+			return
 		}
+		b.SendMessageWithKeyboard("🔔 <b>Avvisi</b>\nRicevi un messaggio quando i dati superano una soglia o cambiano tendenza.", update.Message.Chat.ID, buttons, echotron.PARSE_HTML)
+		return
+	}
 
+	if tokens[0] == "lista" {
+		rules := getChatAlerts(update.Message.Chat.ID)
+		buttons, err := b.alertListButtons(rules)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		b.SendMessageWithKeyboard(setCaptionAlerts(rules), update.Message.Chat.ID, buttons, echotron.PARSE_HTML)
+		return
+	}
+
+	if len(tokens) != 4 {
+		b.SendMessage(usageMessage, update.Message.Chat.ID, echotron.PARSE_HTML)
+		return
+	}
+	value, err := strconv.ParseFloat(strings.Replace(tokens[3], ",", ".", -1), 64)
+	if err != nil {
+		b.SendMessage(usageMessage, update.Message.Chat.ID, echotron.PARSE_HTML)
+		return
+	}
+	if !b.isChatAdmin(update.Message.Chat, update.Message.User) {
+		b.SendMessage("Solo gli amministratori del gruppo possono creare gli avvisi.", update.Message.Chat.ID)
+		return
+	}
+
+	b.saveAlert(d, update.Message.Chat.ID, alertRule{
+		Area:      strings.Replace(tokens[0], "_", " ", -1),
+		Metric:    tokens[1],
+		Condition: tokens[2],
+		Value:     value,
+	})
+}
+
+// Handles the threshold typed during the alert wizard
+func (b *bot) textAlertValue(d *dataSnapshot, update *echotron.Update) {
+	value, err := strconv.ParseFloat(strings.Replace(strings.TrimSpace(update.Message.Text), ",", ".", -1), 64)
+	if err != nil {
+		b.SendMessage("Valore non valido, scrivi un numero oppure usa i pulsanti.", update.Message.Chat.ID)
+		return
+	}
+
+	b.alertDraft.Value = value
+	b.awaitingAlertValue = false
+	b.saveAlertDraft(d, update.Message.Chat.ID)
+}
+
+// Handles "programma" textual command
+func (b *bot) textProgramma(d *dataSnapshot, update *echotron.Update) {
+	usageMessage := "<b>Uso Corretto del Comando:</b>\n/programma <code>[giorno] HH:MM nazione|zone</code>\n" +
+		"/programma <code>[giorno] HH:MM regione nome_regione</code>\n/programma <code>[giorno] HH:MM provincia nome_provincia</code>\n" +
+		"/programma <code>[giorno] HH:MM report nome_report</code>\nper ricevere l'aggiornamento scelto all'orario indicato, " +
+		"ogni giorno oppure solo nel giorno della settimana scelto (es. <code>lunedi</code>)\n" +
+		"/programma <code>lista</code>\nper visualizzare ed eliminare gli aggiornamenti programmati\nDigita /help per visualizzare il manuale."
+
+	tokens := strings.Fields(update.Message.Text)
+	tokens = tokens[1:]
+	for i := range tokens {
+		tokens[i] = strings.ToLower(tokens[i])
+	}
+
+	if len(tokens) == 0 {
+		b.SendMessage(usageMessage, update.Message.Chat.ID, echotron.PARSE_HTML)
+		return
+	}
+
+	if tokens[0] == "lista" {
+		posts := getChatSchedules(update.Message.Chat.ID)
+		buttons, err := b.scheduleListButtons(posts)
 		if err != nil {
 			log.Println(err)
-			b.SendMessage(usageMessage, update.Message.Chat.ID, echotron.PARSE_HTML)
 			return
 		}
+		b.SendMessageWithKeyboard(setCaptionSchedules(posts), update.Message.Chat.ID, buttons, echotron.PARSE_HTML)
+		return
+	}
 
-		provinceLastId, err := covidgraphs.FindLastOccurrenceProvince(&provincesData, "denominazione_provincia", tokens[0])
+	days := scheduleAllDays
+	if day, ok := scheduleWeekdays[tokens[0]]; ok {
+		days = day
+		tokens = tokens[1:]
+	}
+	if len(tokens) < 2 {
+		b.SendMessage(usageMessage, update.Message.Chat.ID, echotron.PARSE_HTML)
+		return
+	}
+	if !b.isChatAdmin(update.Message.Chat, update.Message.User) {
+		b.SendMessage("Solo gli amministratori del gruppo possono programmare gli aggiornamenti.", update.Message.Chat.ID)
+		return
+	}
+
+	post := scheduledPost{
+		ChatId: update.Message.Chat.ID,
+		Days:   days,
+		Time:   tokens[0],
+		Kind:   tokens[1],
+		Target: strings.Replace(strings.Join(tokens[2:], " "), "_", " ", -1),
+	}
+	err := validateSchedule(d, &post)
+	if err != nil {
+		b.SendMessage("Impossibile programmare l'aggiornamento: "+err.Error()+".\n\n"+usageMessage, update.Message.Chat.ID, echotron.PARSE_HTML)
+		return
+	}
+	post, err = addSchedule(post)
+	if err != nil {
+		log.Println(err)
+		b.SendMessage("Impossibile salvare l'aggiornamento al momento.\nRiprova più tardi.", update.Message.Chat.ID)
+		return
+	}
+
+	b.SendMessage("✅ <b>Aggiornamento programmato</b>\n"+post.describe()+"\n\nDigita /programma <code>lista</code> per gestire gli aggiornamenti.", update.Message.Chat.ID, echotron.PARSE_HTML)
+}
+
+// Handles "live" textual command
+func (b *bot) textLive(d *dataSnapshot, update *echotron.Update) {
+	usageMessage := "<b>Uso Corretto del Comando:</b>\n/live <code>nazione</code>\n/live <code>regione nome_regione</code>\n" +
+		"/live <code>provincia nome_provincia</code>\nper fissare un messaggio che si aggiorna da solo ad ogni nuovo bollettino\n" +
+		"/live <code>stop</code>\nper smettere di aggiornarlo\nDigita /help per visualizzare il manuale."
+
+	tokens := strings.Fields(update.Message.Text)
+	tokens = tokens[1:]
+	for i := range tokens {
+		tokens[i] = strings.ToLower(tokens[i])
+	}
+	chatId := update.Message.Chat.ID
+
+	if len(tokens) == 0 {
+		msg := usageMessage
+		if message, ok := getLiveMessage(chatId); ok {
+			msg = "📌 <b>Messaggio live attivo:</b> " + message.describe() + "\n\n" + usageMessage
+		}
+		b.SendMessage(msg, chatId, echotron.PARSE_HTML)
+		return
+	}
+	if !b.isChatAdmin(update.Message.Chat, update.Message.User) {
+		b.SendMessage("Solo gli amministratori del gruppo possono configurare il messaggio live.", chatId)
+		return
+	}
+
+	if tokens[0] == "stop" {
+		message, ok := getLiveMessage(chatId)
+		if !ok {
+			b.SendMessage("Non c'è nessun messaggio live attivo.", chatId)
+			return
+		}
+		err := deleteLiveMessage(chatId)
 		if err != nil {
 			log.Println(err)
+		}
+		b.unpinChatMessage(chatId, message.MessageId)
+		b.SendMessage("Il messaggio live non verrà più aggiornato.", chatId)
+		return
+	}
+
+	message := liveMessage{
+		ChatId: chatId,
+		Kind:   tokens[0],
+		Target: strings.Replace(strings.Join(tokens[1:], " "), "_", " ", -1),
+	}
+	stopRendering := b.showPlotRendering(chatId)
+	defer stopRendering()
+	filename, caption, err := getLiveContent(d, &message)
+	if err != nil {
+		log.Println(err)
+		b.SendMessage("Impossibile creare il messaggio live: "+err.Error()+".\n\n"+usageMessage, chatId, echotron.PARSE_HTML)
+		return
+	}
+
+	response := b.sendPlot(filename, caption, chatId, echotron.PARSE_HTML)
+	if !response.Ok || response.Result == nil {
+		log.Println("errore nell'invio del messaggio live:", response.Description)
+		b.SendMessage("Impossibile inviare il messaggio live al momento.\nRiprova più tardi.", chatId)
+		return
+	}
+	message.MessageId = response.Result.ID
+
+	if previous, ok := getLiveMessage(chatId); ok {
+		b.unpinChatMessage(chatId, previous.MessageId)
+	}
+	err = setLiveMessage(message)
+	if err != nil {
+		log.Println(err)
+	}
+	pinResponse := b.pinChatMessage(chatId, message.MessageId)
+	if !pinResponse.Ok {
+		b.SendMessage("Non ho i permessi per fissare il messaggio: rendimi amministratore con il permesso di fissare i messaggi. "+
+			"Il messaggio verrà comunque aggiornato ad ogni nuovo bollettino.", chatId)
+	}
+	b.DeleteMessage(chatId, update.Message.ID)
+}
+
+// Handles "esporta" textual command
+func (b *bot) textEsporta(d *dataSnapshot, update *echotron.Update) {
+	usageMessage := "<b>Uso Corretto del Comando:</b>\n/esporta <code>nazione [campi] [da] [a] [csv|xlsx]</code>\n" +
+		"/esporta <code>regione nome_regione [campi] [da] [a] [csv|xlsx]</code>\n/esporta <code>provincia nome_provincia [campi] [da] [a] [csv|xlsx]</code>\n" +
+		"I campi vanno separati da virgole e le date scritte come <code>AAAA-MM-GG</code>.\nPer ogni campo vengono aggiunte la variazione giornaliera e la media mobile a 7 giorni.\n" +
+		"Dati provincia disponibili:\n{<code>" + strings.Join(provincesAttributes, ", ") + "</code>}\nDigita /help per visualizzare il manuale."
+
+	tokens := strings.Fields(update.Message.Text)
+	tokens = tokens[1:]
+	for i := range tokens {
+		tokens[i] = strings.ToLower(tokens[i])
+	}
+
+	if len(tokens) == 0 {
+		b.SendMessage(usageMessage, update.Message.Chat.ID, echotron.PARSE_HTML)
+		return
+	}
+
+	request := exportRequest{area: tokens[0], format: exportFormatCSV}
+	tokens = tokens[1:]
+	if request.area == exportAreaRegion || request.area == exportAreaProvince {
+		if len(tokens) == 0 {
 			b.SendMessage(usageMessage, update.Message.Chat.ID, echotron.PARSE_HTML)
 			return
 		}
+		request.name = strings.Replace(tokens[0], "_", " ", -1)
+		tokens = tokens[1:]
+	} else if request.area != exportAreaNation {
+		b.SendMessage(usageMessage, update.Message.Chat.ID, echotron.PARSE_HTML)
+		return
+	}
 
-		b.SendPhoto(filename, setCaptionConfrontoProvincia(provinceLastId, []string{tokens[1]}), update.Message.Chat.ID, echotron.PARSE_HTML)
+	for _, v := range tokens {
+		if v == exportFormatCSV || v == exportFormatXLSX {
+			request.format = v
+		} else if date, err := time.Parse(exportDateLayout, v); err == nil {
+			if request.from.IsZero() {
+				request.from = date
+			} else {
+				request.to = date
+			}
+		} else {
+			for _, field := range strings.Split(v, ",") {
+				if field == "" {
+					continue
+				}
+				found := false
+				for _, attribute := range getExportFields(request.area) {
+					if field == attribute {
+						found = true
+					}
+				}
+				if !found {
+					b.SendMessage("Il campo <code>"+field+"</code> non è disponibile.\n\n"+usageMessage, update.Message.Chat.ID, echotron.PARSE_HTML)
+					return
+				}
+				request.fields = append(request.fields, field)
+			}
+		}
+	}
+	b.sendExport(d, update.Message.Chat.ID, request)
+}
+
+// Handles "registro" textual command
+func (b *bot) textRegistro(update *echotron.Update) {
+	usageMessage := "<b>Uso Corretto del Comando:</b>\n/registro\nper sapere se i tuoi comandi vengono registrati\n" +
+		"/registro <code>no</code>\nper non registrarli più\n/registro <code>si</code>\nper registrarli di nuovo\n" +
+		"Vengono registrati solo il comando, un identificativo anonimo della chat e il tempo di risposta.\nDigita /help per visualizzare il manuale."
+
+	tokens := strings.Fields(strings.ToLower(update.Message.Text))
+	tokens = tokens[1:]
+	chatId := update.Message.Chat.ID
+
+	if len(tokens) == 0 {
+		status := "I comandi di questa chat vengono registrati in forma anonima."
+		if !interactionsEnabled {
+			status = "Il registro delle interazioni è disattivato."
+		} else if isInteractionsOptOut(chatId) {
+			status = "I comandi di questa chat non vengono registrati."
+		}
+		b.SendMessage(status+"\n\n"+usageMessage, chatId, echotron.PARSE_HTML)
+		return
+	}
+	if tokens[0] != "no" && tokens[0] != "si" && tokens[0] != "sì" {
+		b.SendMessage(usageMessage, chatId, echotron.PARSE_HTML)
+		return
+	}
+	if !b.isChatAdmin(update.Message.Chat, update.Message.User) {
+		b.SendMessage("Solo gli amministratori del gruppo possono modificare la registrazione dei comandi.", chatId)
+		return
+	}
+
+	optOut := tokens[0] == "no"
+	err := setInteractionsOptOut(chatId, optOut)
+	if err != nil {
+		log.Println(err)
+		b.SendMessage("Impossibile salvare la preferenza al momento.\nRiprova più tardi.", chatId)
+		return
+	}
+	if optOut {
+		b.SendMessage("I comandi di questa chat non verranno più registrati.", chatId)
+	} else {
+		b.SendMessage("I comandi di questa chat verranno di nuovo registrati in forma anonima.", chatId)
 	}
-	b.DeleteMessage(update.Message.Chat.ID, update.Message.ID)
 }
@@ -2,12 +2,11 @@ package main
 
 import (
 	"encoding/json"
-	"github.com/NicoNex/echotron"
-	"log"
+	"io"
+	"io/ioutil"
 	"os"
 	"sort"
 	"strings"
-	"time"
 )
 
 // Creates bot data folders if they don't exist
@@ -17,6 +16,8 @@ func initFolders() {
 	os.MkdirAll(workingDirectory, 0755)
 	os.MkdirAll(workingDirectory+imageFolder, 0755)
 	os.MkdirAll(workingDirectory+logsFolder, 0755)
+	os.MkdirAll(workingDirectory+reportsFolder, 0755)
+	os.MkdirAll(workingDirectory+templatesFolder, 0755)
 }
 
 // Checks if a string is found in national fields selected for comparison
@@ -39,59 +40,6 @@ func (b *bot) isStringFoundInNationChoices(str string) bool {
 	return false
 }
 
-// Logs operations to a file named with telegram username/name_surname
-func writeOperation(update *echotron.Update, folder string) {
-	data, err := json.Marshal(update)
-	if err != nil {
-		log.Println("Error marshaling logs: ", err)
-		return
-	}
-
-	var filename string
-
-	if update.CallbackQuery != nil {
-		if update.CallbackQuery.Message.Chat.Type == "private" {
-			if update.CallbackQuery.Message.Chat.Username == "" {
-				filename = folder + update.CallbackQuery.Message.Chat.FirstName + "_" + update.CallbackQuery.Message.Chat.LastName + ".txt"
-			} else {
-				filename = folder + update.CallbackQuery.Message.Chat.Username + ".txt"
-			}
-		} else {
-			filename = folder + update.CallbackQuery.Message.Chat.Title + ".txt"
-		}
-
-	} else if update.Message != nil {
-		if update.Message.Chat.Type == "private" {
-			if update.Message.Chat.Username == "" {
-				filename = folder + update.Message.Chat.FirstName + "_" + update.Message.Chat.LastName + ".txt"
-			} else {
-				filename = folder + update.Message.Chat.Username + ".txt"
-			}
-		} else {
-			filename = folder + update.Message.Chat.Title + ".txt"
-		}
-
-	}
-
-	f, err := os.OpenFile(filename, os.O_APPEND|os.O_RDWR|os.O_CREATE, 0666)
-	if err != nil {
-		log.Println(err)
-		return
-	}
-
-	dataString := time.Now().Format("2006-01-02T15:04:05") + string(data[:])
-	_, err = f.WriteString(dataString + "\n")
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	err = f.Close()
-	if err != nil {
-		log.Println(err)
-		return
-	}
-}
-
 // Sorts regional fields selected for comparison
 func (b *bot) getSortedChoicesConfrontoRegione() []string {
 	tempChoices := make([]string, len(b.choicesConfrontoRegione))
@@ -109,3 +57,50 @@ func (b *bot) getSortedChoicesConfrontoNazione() []string {
 
 	return tempChoices
 }
+
+// Loads a JSON file from the bot data folder into v, a missing file is not an error
+func loadJSON(filename string, v interface{}) error {
+	data, err := ioutil.ReadFile(workingDirectory + filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// Saves v as a JSON file in the bot data folder replacing the previous content atomically
+func saveJSON(filename string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmpFilename := workingDirectory + filename + ".tmp"
+	err = ioutil.WriteFile(tmpFilename, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFilename, workingDirectory+filename)
+}
+
+// Writes a new file of the reports folder with a unique name made from pattern, as in ioutil.TempFile,
+// so that files requested at the same time by different chats never overwrite each other.
+// Returns the file path, the file is removed if it can't be written
+func createTempOutput(pattern string, write func(w io.Writer) error) (string, error) {
+	file, err := ioutil.TempFile(workingDirectory+reportsFolder, pattern)
+	if err != nil {
+		return "", err
+	}
+	err = write(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
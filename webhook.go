@@ -0,0 +1,163 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/NicoNex/echotron"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	webhookURL  = "https://hiddenfile.ml:443/bot/CovidBot"
+	webhookPort = 40987
+)
+
+// Timeouts of the HTTP servers, writes are given longer since API plots are rendered on request
+const (
+	httpReadTimeout  = 10 * time.Second
+	httpWriteTimeout = time.Minute
+	httpIdleTimeout  = 2 * time.Minute
+)
+
+// Receives updates from the webhook and hands them to one bot instance per chat, like echotron's dispatcher,
+// keeping track of the handlers still running so they can be drained on shutdown
+type webhookDispatcher struct {
+	newBot   echotron.NewBotFn
+	sessions map[int64]echotron.Bot
+	mutex    sync.Mutex
+	handlers sync.WaitGroup
+}
+
+// Creates a dispatcher using newBot to create the chat sessions
+func newWebhookDispatcher(newBot echotron.NewBotFn) *webhookDispatcher {
+	return &webhookDispatcher{
+		newBot:   newBot,
+		sessions: make(map[int64]echotron.Bot),
+	}
+}
+
+// Returns the chat the update belongs to
+func getUpdateChatId(update *echotron.Update) (int64, bool) {
+	switch {
+	case update.Message != nil:
+		return update.Message.Chat.ID, true
+	case update.EditedMessage != nil:
+		return update.EditedMessage.Chat.ID, true
+	case update.ChannelPost != nil:
+		return update.ChannelPost.Chat.ID, true
+	case update.EditedChannelPost != nil:
+		return update.EditedChannelPost.Chat.ID, true
+	case update.CallbackQuery != nil && update.CallbackQuery.Message != nil:
+		return update.CallbackQuery.Message.Chat.ID, true
+	default:
+		return 0, false
+	}
+}
+
+// Returns the session of the chat, creating it if needed
+func (d *webhookDispatcher) getSession(chatId int64) echotron.Bot {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	bot, ok := d.sessions[chatId]
+	if !ok {
+		bot = d.newBot(chatId)
+		d.sessions[chatId] = bot
+	}
+	return bot
+}
+
+// Handles an update sent by Telegram to the webhook
+func (d *webhookDispatcher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Telegram retries the update later if data aren't loaded yet
+	if !getData().isReady() {
+		http.Error(w, "dati non ancora disponibili", http.StatusServiceUnavailable)
+		return
+	}
+
+	reader := r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gzipReader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	var update echotron.Update
+	err := json.NewDecoder(reader).Decode(&update)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	chatId, ok := getUpdateChatId(&update)
+	if !ok {
+		return
+	}
+
+	bot := d.getSession(chatId)
+	d.handlers.Add(1)
+	go func() {
+		defer d.handlers.Done()
+		bot.Update(&update)
+	}()
+}
+
+// Waits for the running update handlers until done is closed, returns false if it wasn't possible to wait for all of them
+func (d *webhookDispatcher) wait(done <-chan struct{}) bool {
+	finished := make(chan struct{})
+	go func() {
+		d.handlers.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// Starts the HTTP server used by the webhook, the API, the metrics and the health endpoints
+func startWebhookServer(dispatcher *webhookDispatcher) *http.Server {
+	http.Handle("/", dispatcher)
+	server := newHTTPServer(":"+strconv.Itoa(webhookPort), nil)
+	go func() {
+		err := server.ListenAndServe()
+		if err != http.ErrServerClosed {
+			log.Fatalln(err)
+		}
+	}()
+	return server
+}
+
+// Returns an HTTP server with timeouts, so that slow clients can't hold connections forever
+func newHTTPServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: httpReadTimeout,
+		ReadTimeout:       httpReadTimeout,
+		WriteTimeout:      httpWriteTimeout,
+		IdleTimeout:       httpIdleTimeout,
+	}
+}
+
+// Sets the webhook on Telegram servers
+func setWebhook() error {
+	response := echotron.NewApi(TOKEN).SetWebhook(webhookURL)
+	if !response.Ok {
+		return fmt.Errorf("could not set the webhook: %s", response.Description)
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/DarkFighterLuke/covidgraphs"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Risk colours a region can be classified in
+const (
+	zonaBianca    = "bianca"
+	zonaGialla    = "gialla"
+	zonaArancione = "arancione"
+	zonaRossa     = "rossa"
+)
+
+const zonesOverrideFile = "/zone.json" // Manually maintained official colours, relative to the bot data folder
+
+var zonesOrder = []string{zonaRossa, zonaArancione, zonaGialla, zonaBianca}
+
+var zonesEmoji = map[string]string{
+	zonaBianca:    "⚪️",
+	zonaGialla:    "🟡",
+	zonaArancione: "🟠",
+	zonaRossa:     "🔴",
+}
+
+// Static region parameters needed to compute the risk colour
+type regionParameters struct {
+	population int // Resident population (ISTAT, 1st January 2021)
+	icuBeds    int // Available intensive care beds
+	wardBeds   int // Available non critical care (area medica) beds
+}
+
+// Region parameters indexed by pcm-dpc codice_regione
+var regionsParameters = map[int]regionParameters{
+	1:  {4274945, 628, 5960},
+	2:  {124089, 20, 159},
+	3:  {9981554, 1530, 8800},
+	5:  {4869830, 1000, 3700},
+	6:  {1201510, 175, 1140},
+	7:  {1509227, 220, 1480},
+	8:  {4438937, 887, 4000},
+	9:  {3692865, 570, 3000},
+	10: {865452, 97, 560},
+	11: {1498236, 230, 970},
+	12: {5730399, 945, 4880},
+	13: {1281012, 178, 900},
+	14: {294294, 39, 160},
+	15: {5679759, 656, 3300},
+	16: {3922941, 475, 2600},
+	17: {545130, 73, 300},
+	18: {1877728, 152, 830},
+	19: {4833705, 850, 3300},
+	20: {1590044, 204, 1400},
+	21: {533715, 100, 450},
+	22: {544745, 90, 500},
+}
+
+// Risk colour of a region with the parameters it has been computed from
+type regionZone struct {
+	region         string
+	colour         string  // Colour shown to users, the official one if present
+	computed       string  // Colour computed from data
+	official       bool    // Whether colour comes from the override file
+	incidence      float64 // Weekly new cases per 100k inhabitants
+	icuOccupation  float64 // Intensive care occupation ratio
+	wardOccupation float64 // Non critical care occupation ratio
+}
+
+var zonesOverrides = make(map[string]string) // Official colours indexed by normalized region name
+var zonesOverridesModTime time.Time
+var zonesMutex = &sync.Mutex{}
+
+// Normalizes a region name to be used as a key
+func normalizeRegionName(name string) string {
+	return strings.Replace(strings.ToLower(strings.TrimSpace(name)), "-", " ", -1)
+}
+
+// Returns the official colour of a region if set in the override file, reloading it when it changes
+func getZoneOverride(regionName string) (string, bool) {
+	zonesMutex.Lock()
+	defer zonesMutex.Unlock()
+
+	filename := workingDirectory + zonesOverrideFile
+	info, err := os.Stat(filename)
+	if err != nil {
+		zonesOverrides = make(map[string]string)
+		zonesOverridesModTime = time.Time{}
+		return "", false
+	}
+
+	if !info.ModTime().Equal(zonesOverridesModTime) {
+		overrides, err := loadZonesOverrides(filename)
+		if err != nil {
+			log.Println("errore nella lettura del file delle zone:", err)
+		} else {
+			zonesOverrides = overrides
+		}
+		zonesOverridesModTime = info.ModTime()
+	}
+
+	colour, ok := zonesOverrides[normalizeRegionName(regionName)]
+	return colour, ok
+}
+
+// Parses the override file, a JSON object mapping region names to colours
+func loadZonesOverrides(filename string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]string
+	err = json.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, err
+	}
+
+	overrides := make(map[string]string)
+	for k, v := range raw {
+		colour := strings.ToLower(strings.TrimSpace(v))
+		if _, ok := zonesEmoji[colour]; !ok {
+			return nil, fmt.Errorf("colore non valido per %s: %s", k, v)
+		}
+		overrides[normalizeRegionName(k)] = colour
+	}
+
+	return overrides, nil
+}
+
+// Computes the colour according to the thresholds in force since DL 105/2021
+func classifyZone(incidence, icuOccupation, wardOccupation float64) string {
+	switch {
+	case incidence < 50:
+		return zonaBianca
+	case incidence < 150 && (icuOccupation <= 0.10 || wardOccupation <= 0.15):
+		return zonaBianca
+	case incidence >= 150 && icuOccupation > 0.30 && wardOccupation > 0.40:
+		return zonaRossa
+	case incidence >= 150 && icuOccupation > 0.20 && wardOccupation > 0.30:
+		return zonaArancione
+	default:
+		return zonaGialla
+	}
+}
+
+// Returns the risk colour of the region at the given index of the regions data array
+func (d *dataSnapshot) getRegionZone(regionIndex int) (regionZone, error) {
+	if regionIndex < 0 || regionIndex >= len(d.regions) {
+		return regionZone{}, fmt.Errorf("regionIndex out of range")
+	}
+	today := d.regions[regionIndex]
+	weekAgoIndex := regionIndex - 21*7
+	if weekAgoIndex < 0 {
+		return regionZone{}, fmt.Errorf("not enough data to compute the weekly incidence")
+	}
+	weekAgo := d.regions[weekAgoIndex]
+	if weekAgo.Codice_regione != today.Codice_regione {
+		return regionZone{}, fmt.Errorf("inconsistent regions data order")
+	}
+
+	params, ok := regionsParameters[today.Codice_regione]
+	if !ok {
+		return regionZone{}, fmt.Errorf("missing parameters for region %s", today.Denominazione_regione)
+	}
+
+	zone := regionZone{
+		region:         today.Denominazione_regione,
+		incidence:      float64(today.Totale_casi-weekAgo.Totale_casi) * 100000 / float64(params.population),
+		icuOccupation:  float64(today.Terapia_intensiva) / float64(params.icuBeds),
+		wardOccupation: float64(today.Ricoverati_con_sintomi) / float64(params.wardBeds),
+	}
+	zone.computed = classifyZone(zone.incidence, zone.icuOccupation, zone.wardOccupation)
+	zone.colour = zone.computed
+	if colour, ok := getZoneOverride(today.Denominazione_regione); ok {
+		zone.colour = colour
+		zone.official = true
+	}
+
+	return zone, nil
+}
+
+// Returns the risk colour of the region with the given name
+func (d *dataSnapshot) getRegionZoneByName(regionName string) (regionZone, error) {
+	regionIndex, err := covidgraphs.FindLastOccurrenceRegion(&d.regions, "denominazione_regione", regionName)
+	if err != nil {
+		return regionZone{}, err
+	}
+
+	return d.getRegionZone(regionIndex)
+}
+
+// Returns the emoji of the region colour, or an empty string if it can't be computed
+func (d *dataSnapshot) getZoneEmoji(regionName string) string {
+	zone, err := d.getRegionZoneByName(regionName)
+	if err != nil {
+		return ""
+	}
+
+	return zonesEmoji[zone.colour]
+}
+
+// Formats a ratio as a percentage
+func formatPercentage(ratio float64) string {
+	return strconv.FormatFloat(ratio*100, 'f', 0, 64) + "%"
+}
+
+// Returns the caption line describing the region colour
+func setCaptionZone(zone regionZone) string {
+	msg := "<b>Zona: </b>" + zonesEmoji[zone.colour] + " " + zone.colour
+	if zone.official && zone.computed != zone.colour {
+		msg += " (<i>stimata dai dati: " + zonesEmoji[zone.computed] + " " + zone.computed + "</i>)"
+	}
+	msg += "\n<i>Incidenza settimanale: " + strconv.FormatFloat(zone.incidence, 'f', 1, 64) + "/100k, " +
+		"terapie intensive: " + formatPercentage(zone.icuOccupation) + ", area medica: " + formatPercentage(zone.wardOccupation) + "</i>"
+
+	return msg
+}
+
+// Returns the caption with the colours of all the regions
+func (d *dataSnapshot) setCaptionZones() string {
+	if len(d.regions) < 21 {
+		return "I dati delle regioni non sono ancora disponibili."
+	}
+	zonesByColour := make(map[string][]regionZone)
+	latestData := d.regions[len(d.regions)-21:]
+	for i := range latestData {
+		zone, err := d.getRegionZone(len(d.regions) - 21 + i)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		zonesByColour[zone.colour] = append(zonesByColour[zone.colour], zone)
+	}
+
+	data, err := time.Parse("2006-01-02T15:04:05", d.regions[len(d.regions)-1].Data)
+	if err != nil {
+		log.Println("error parsing data in setCaptionZones()")
+	}
+
+	msg := "<b>Zone di rischio " + data.Format("2006-01-02") + "</b>\n"
+	for _, colour := range zonesOrder {
+		if len(zonesByColour[colour]) == 0 {
+			continue
+		}
+		msg += "\n" + zonesEmoji[colour] + " <b>Zona " + colour + "</b>\n"
+		for _, zone := range zonesByColour[colour] {
+			msg += zone.region + " (<code>" + strconv.FormatFloat(zone.incidence, 'f', 1, 64) + "</code>)"
+			if zone.official {
+				msg += " ✔️"
+			}
+			msg += "\n"
+		}
+	}
+	msg += "\n<i>Tra parentesi l'incidenza settimanale ogni 100.000 abitanti.\n✔️ indica il colore ufficiale da decreto, " +
+		"negli altri casi il colore è stimato dai parametri di incidenza e occupazione dei posti letto.</i>"
+
+	return msg
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestClassifyZone(t *testing.T) {
+	for _, v := range []struct {
+		incidence, icu, ward float64
+		want                 string
+	}{
+		{30, 0.50, 0.50, zonaBianca},
+		{100, 0.05, 0.50, zonaBianca},
+		{100, 0.50, 0.10, zonaBianca},
+		{100, 0.15, 0.20, zonaGialla},
+		{200, 0.05, 0.10, zonaGialla},
+		{200, 0.25, 0.35, zonaArancione},
+		{200, 0.25, 0.45, zonaArancione},
+		{200, 0.35, 0.45, zonaRossa},
+		{100, 0.35, 0.45, zonaGialla},
+	} {
+		if got := classifyZone(v.incidence, v.icu, v.ward); got != v.want {
+			t.Errorf("classifyZone(%v, %v, %v) = %s, want %s", v.incidence, v.icu, v.ward, got, v.want)
+		}
+	}
+}
+
+func TestGetRegionZone(t *testing.T) {
+	d := getData()
+	zone, err := d.getRegionZoneByName("Puglia")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zone.region != "Puglia" || zone.incidence <= 0 || zone.icuOccupation <= 0 || zone.wardOccupation <= 0 {
+		t.Errorf("zone %+v has missing parameters", zone)
+	}
+	if zone.computed != classifyZone(zone.incidence, zone.icuOccupation, zone.wardOccupation) || zone.colour != zone.computed || zone.official {
+		t.Errorf("zone %+v isn't the computed one", zone)
+	}
+
+	if _, err = d.getRegionZone(len(d.regions)); err == nil {
+		t.Error("zone computed for an index out of range")
+	}
+	if _, err = d.getRegionZone(21*7 - 1); err == nil {
+		t.Error("zone computed without a week of data")
+	}
+}
+
+func TestZoneOverride(t *testing.T) {
+	filename := workingDirectory + zonesOverrideFile
+	err := ioutil.WriteFile(filename, []byte(`{"Puglia": "Rossa", "Friuli-Venezia Giulia": "gialla"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(filename)
+
+	zone, err := getData().getRegionZoneByName("Puglia")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zone.colour != zonaRossa || !zone.official {
+		t.Errorf("colour %s official %v, want the official %s", zone.colour, zone.official, zonaRossa)
+	}
+	if colour, ok := getZoneOverride("friuli venezia giulia"); !ok || colour != zonaGialla {
+		t.Errorf("override of Friuli Venezia Giulia %q, want %s", colour, zonaGialla)
+	}
+
+	if _, err = loadZonesOverrides(writeTempFile(t, `{"Puglia": "viola"}`)); err == nil {
+		t.Error("invalid colour accepted")
+	}
+}
+
+func TestSetCaptionZones(t *testing.T) {
+	msg := getData().setCaptionZones()
+	if !strings.Contains(msg, "<b>Zone di rischio 2022-03-05</b>") || !strings.Contains(msg, "Puglia (<code>") {
+		t.Errorf("unexpected caption:\n%s", msg)
+	}
+
+	if msg := (&dataSnapshot{}).setCaptionZones(); !strings.Contains(msg, "non sono ancora disponibili") {
+		t.Errorf("unexpected caption without data:\n%s", msg)
+	}
+}
+
+// Writes the content in a temporary file removed at the end of the test
+func writeTempFile(t *testing.T, content string) string {
+	file, err := ioutil.TempFile(workingDirectory, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	t.Cleanup(func() { os.Remove(file.Name()) })
+
+	_, err = file.WriteString(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file.Name()
+}